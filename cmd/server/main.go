@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -8,13 +9,20 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"raven"
 	"raven/internal/core/domain"
+	"raven/internal/core/ports"
 	"raven/internal/handler"
+	"raven/internal/infrastructure/imapd"
+	"raven/internal/infrastructure/smtpd"
+	"raven/internal/infrastructure/smtprelay"
 	"raven/internal/infrastructure/storage"
 	"raven/internal/repository"
 	"raven/internal/service"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/glebarez/sqlite"
@@ -25,10 +33,44 @@ func main() {
 	var port int
 	var ooHost string
 	var defUser string
+	var scenarioDir string
+	var smtpCfg smtpd.Config
+	var relayCfg smtprelay.Config
+	var imapCfg imapd.Config
+	var eventLog string
+	var eventBus string
+	var busPoll time.Duration
+	var eventLogSize int
+	var hubCfg ports.HubConfig
+	var presenceGrace, presenceIdle time.Duration
+	var chatEditWindow time.Duration
+	var wsOrigins string
 
 	flag.IntVar(&port, "port", 8080, "web服务端口")
 	flag.StringVar(&ooHost, "oo-host", os.Getenv("ONLYOFFICE_HOST"), "OnlyOffice 服务地址 (例如 192.168.1.100:8090)")
 	flag.StringVar(&defUser, "default-user", os.Getenv("DEFAULT_USER_ID"), "模拟环境下的默认用户 ID")
+	flag.StringVar(&scenarioDir, "scenario-dir", "./scenarios", "演练脚本附件素材目录")
+	flag.StringVar(&smtpCfg.Addr, "smtp-addr", os.Getenv("SMTP_ADDR"), "SMTP 入站网关监听地址 (例如 127.0.0.1:2525)，为空时不启用")
+	flag.StringVar(&smtpCfg.Domain, "smtp-domain", "raven.local", "SMTP 网关收件域名，收件地址形如 user+session@域名")
+	flag.StringVar(&smtpCfg.Username, "smtp-user", os.Getenv("SMTP_USER"), "SMTP 网关 AUTH 用户名，为空时不要求认证")
+	flag.StringVar(&smtpCfg.Password, "smtp-pass", os.Getenv("SMTP_PASS"), "SMTP 网关 AUTH 密码")
+	flag.Int64Var(&smtpCfg.MaxMessageBytes, "smtp-max-size", 10<<20, "SMTP 网关单封邮件大小上限 (字节)")
+	flag.StringVar(&relayCfg.Addr, "relay-host", os.Getenv("RELAY_HOST"), "外部邮箱转发 smarthost 地址 (例如 smtp.example.com:587)，为空时不转发")
+	flag.StringVar(&relayCfg.Username, "relay-user", os.Getenv("RELAY_USER"), "smarthost AUTH 用户名")
+	flag.StringVar(&relayCfg.Password, "relay-pass", os.Getenv("RELAY_PASS"), "smarthost AUTH 密码")
+	flag.StringVar(&relayCfg.From, "relay-from", "raven@raven.local", "转发邮件的发件地址")
+	flag.StringVar(&imapCfg.Addr, "imap-addr", os.Getenv("IMAP_ADDR"), "IMAP 只读服务监听地址 (例如 127.0.0.1:1143)，为空时不启用")
+	flag.StringVar(&imapCfg.Password, "imap-pass", os.Getenv("IMAP_PASS"), "IMAP 登录口令 (所有账户共用)，启用 IMAP 时必须设置")
+	flag.StringVar(&eventLog, "event-log", os.Getenv("EVENT_LOG"), "SSE 重放事件日志存储: memory (默认) 或 sqlite")
+	flag.StringVar(&eventBus, "event-bus", os.Getenv("EVENT_BUS"), "推送事件总线: memory (默认，单实例) 或 shared (多实例共享数据库表)")
+	flag.DurationVar(&busPoll, "event-bus-poll", service.DefaultBusPollInterval, "shared 总线轮询其他实例事件的间隔")
+	flag.IntVar(&eventLogSize, "event-log-size", service.DefaultEventLogSize, "每个场次保留的推送事件数")
+	flag.DurationVar(&presenceGrace, "presence-grace", service.DefaultPresenceGrace, "推送连接全部断开后等待重连的时间，超时视为离线")
+	flag.DurationVar(&presenceIdle, "presence-idle", service.DefaultPresenceIdle, "在线用户无操作超过该时间视为空闲")
+	flag.DurationVar(&chatEditWindow, "chat-edit-window", service.DefaultChatEditWindow, "消息发出后允许编辑、撤回的时长，0 表示不限制")
+	flag.StringVar(&wsOrigins, "ws-origins", os.Getenv("WS_ORIGINS"), "允许建立 WebSocket 的跨站来源，逗号分隔 (例如 https://ops.example.org)，* 表示不限制；同源请求始终允许")
+	flag.IntVar(&hubCfg.QueueSize, "sse-queue", service.DefaultSubscriberQueue, "每个 SSE/WebSocket 连接缓冲的事件数")
+	flag.StringVar(&hubCfg.Overflow, "sse-overflow", ports.OverflowDisconnect, "连接缓冲写满时的策略: disconnect 或 drop-oldest")
 	flag.Parse()
 
 	if ooHost == "" {
@@ -37,17 +79,36 @@ func main() {
 	if defUser == "" {
 		defUser = "guest"
 	}
+	if hubCfg.Overflow != ports.OverflowDisconnect && hubCfg.Overflow != ports.OverflowDropOldest {
+		log.Fatalf("未知的溢出策略: %s", hubCfg.Overflow)
+	}
+	if eventLogSize <= 0 {
+		eventLogSize = service.DefaultEventLogSize
+	}
+	if imapCfg.Addr != "" && imapCfg.Password == "" {
+		log.Fatalf("启用 IMAP 服务时必须通过 -imap-pass 设置登录口令")
+	}
 
 	// 1. 初始化 SQLite 数据库
-	db, err := gorm.Open(sqlite.Open("raven.db"), &gorm.Config{})
+	// busy_timeout: 多个实例共用数据库文件时等待写锁而不是立即报错
+	db, err := gorm.Open(sqlite.Open("raven.db?_pragma=busy_timeout(5000)"), &gorm.Config{})
 	if err != nil {
 		log.Fatalf("数据库连接失败: %v", err)
 	}
 
 	// 自动迁移表结构
-	if err := db.AutoMigrate(&domain.Mail{}, &domain.MailRecipient{}, &domain.Attachment{}, &domain.ChatMessage{}); err != nil {
+	if err := repository.DedupeDirectConversations(db); err != nil {
+		log.Fatalf("单聊去重失败: %v", err)
+	}
+	if err := db.AutoMigrate(&domain.Mail{}, &domain.MailRecipient{}, &domain.Attachment{}, &domain.ChatMessage{}, &domain.Session{}, &domain.SessionSnapshot{}, &domain.Scenario{}, &domain.ScenarioRun{}, &domain.AuditLog{}, &domain.Webhook{}, &domain.WebhookDelivery{}, &domain.NotificationEvent{}, &domain.UserPresence{}, &domain.PresenceConnection{}, &domain.Conversation{}, &domain.ConversationMember{}, &domain.ChatMessageEdit{}); err != nil {
 		log.Fatalf("数据库迁移失败: %v", err)
 	}
+	if err := repository.InstallAuditGuards(db); err != nil {
+		log.Fatalf("审计表保护初始化失败: %v", err)
+	}
+	if err := repository.InstallChatSearchIndex(db); err != nil {
+		log.Fatalf("即时消息检索索引初始化失败: %v", err)
+	}
 
 	// 2. 初始化存储层
 	store, err := storage.NewLocalStorage("./uploads")
@@ -57,8 +118,79 @@ func main() {
 
 	// 3. 初始化应用层依赖
 	mailRepo := repository.NewMailRepository(db)
-	mailService := service.NewMailService(mailRepo, store)
-	mailHandler := handler.NewMailHandler(mailService, store, ooHost, defUser)
+	sessionRepo := repository.NewSessionRepository(db)
+	sessionService := service.NewSessionService(sessionRepo, mailRepo)
+	if err := sessionService.Bootstrap(context.Background()); err != nil {
+		log.Fatalf("场次初始化失败: %v", err)
+	}
+	clockService := service.NewClockService(sessionRepo)
+	mailService := service.NewMailService(mailRepo, sessionRepo, clockService, store)
+	switch {
+	case eventBus == "shared":
+		bus, err := service.NewSharedEventBus(context.Background(), repository.NewEventLogRepository(db, eventLogSize), busPoll)
+		if err != nil {
+			log.Fatalf("事件总线初始化失败: %v", err)
+		}
+		mailService.SetEventBus(bus)
+	case eventBus != "" && eventBus != "memory":
+		log.Fatalf("未知的事件总线: %s", eventBus)
+	case eventLog == "sqlite":
+		mailService.SetEventBus(service.NewMemoryEventBus(repository.NewEventLogRepository(db, eventLogSize)))
+	case eventLog == "" || eventLog == "memory":
+		mailService.SetEventBus(service.NewMemoryEventBus(service.NewMemoryEventLog(eventLogSize)))
+	default:
+		log.Fatalf("未知的事件日志存储: %s", eventLog)
+	}
+	mailService.SetHubConfig(hubCfg)
+	mailService.SetChatEditWindow(chatEditWindow)
+	auditService := service.NewAuditService(repository.NewAuditRepository(db))
+	mailHandler := handler.NewMailHandler(mailService, store, auditService, ooHost, defUser)
+	presenceService := service.NewPresenceService(repository.NewPresenceRepository(db), mailService, presenceGrace, presenceIdle)
+	go presenceService.Run(context.Background())
+	mailHandler.SetPresence(presenceService)
+	for _, origin := range strings.Split(wsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			mailHandler.AllowedOrigins = append(mailHandler.AllowedOrigins, origin)
+		}
+	}
+	presenceHandler := handler.NewPresenceHandler(presenceService)
+	scenarioRepo := repository.NewScenarioRepository(db)
+	scenarioService := service.NewScenarioService(scenarioRepo, mailRepo, mailService, clockService, scenarioDir)
+	if err := scenarioService.RestoreRuns(context.Background()); err != nil {
+		log.Fatalf("演练脚本执行状态恢复失败: %v", err)
+	}
+	go scenarioService.Run(context.Background())
+	scenarioHandler := handler.NewScenarioHandler(scenarioService)
+	sessionHandler := handler.NewSessionHandler(sessionService, clockService)
+	reportHandler := handler.NewReportHandler(service.NewReportService(mailRepo, sessionRepo))
+	statsHandler := handler.NewStatsHandler(service.NewStatsService(mailRepo, sessionRepo))
+	auditHandler := handler.NewAuditHandler(auditService)
+	webhookService := service.NewWebhookService(repository.NewWebhookRepository(db), sessionRepo, 0)
+	mailService.SetWebhooks(webhookService)
+	go webhookService.Run(context.Background())
+	webhookHandler := handler.NewWebhookHandler(webhookService)
+
+	if relayCfg.Addr != "" {
+		relayService := service.NewRelayService(mailRepo, store, smtprelay.New(relayCfg), 0)
+		go relayService.Run(context.Background())
+	}
+	if smtpCfg.Addr != "" {
+		smtpServer := smtpd.NewServer(smtpCfg, mailService, auditService)
+		go func() {
+			if err := smtpServer.ListenAndServe(); err != nil {
+				log.Printf("SMTP 网关启动失败: %v", err)
+			}
+		}()
+	}
+	if imapCfg.Addr != "" {
+		imapCfg.Domain = smtpCfg.Domain
+		imapServer := imapd.NewServer(imapCfg, mailService, sessionRepo, store, auditService)
+		go func() {
+			if err := imapServer.ListenAndServe(); err != nil {
+				log.Printf("IMAP 服务启动失败: %v", err)
+			}
+		}()
+	}
 
 	// 4. 配置 Gin 路由
 	r := gin.Default()
@@ -80,7 +212,7 @@ func main() {
 
 	api := r.Group("/api/v1")
 	{
-		mails := api.Group("/mails")
+		mails := api.Group("/mails", sessionHandler.RequireSession, presenceHandler.TrackActivity)
 		{
 			mails.POST("/send", mailHandler.SendMail)
 			mails.GET("/inbox", mailHandler.GetInbox)
@@ -90,18 +222,77 @@ func main() {
 			mails.GET("/download", mailHandler.DownloadAttachment)
 			mails.GET("/events", mailHandler.StreamNotifications)
 		}
-		im := api.Group("/im")
+		im := api.Group("/im", sessionHandler.RequireSession, presenceHandler.TrackActivity)
 		{
 			im.POST("/send", mailHandler.SendChatMessage)
 			im.GET("/history", mailHandler.GetChatHistory)
+			im.GET("/conversations", mailHandler.ListChatThreads)
+			im.GET("/search", mailHandler.SearchChat)
+			im.POST("/escalate", mailHandler.EscalateChat)
 			im.POST("/read", mailHandler.MarkChatAsRead)
+			im.POST("/typing", mailHandler.NotifyTyping)
+			im.PUT("/messages/:id", mailHandler.EditChatMessage)
+			im.DELETE("/messages/:id", mailHandler.RetractChatMessage)
+			im.GET("/messages/:id/edits", mailHandler.GetChatMessageEdits)
+		}
+		conversations := api.Group("/conversations", sessionHandler.RequireSession, presenceHandler.TrackActivity)
+		{
+			conversations.POST("", mailHandler.CreateConversation)
+			conversations.GET("", mailHandler.ListConversations)
+			conversations.GET("/:id", mailHandler.GetConversation)
+			conversations.POST("/:id/members", mailHandler.AddConversationMembers)
+			conversations.PUT("/:id/members/:user_id", mailHandler.SetConversationMemberRole)
+			conversations.DELETE("/:id/members/:user_id", mailHandler.RemoveConversationMember)
 		}
 		api.GET("/onlyoffice/template", mailHandler.ServeOnlyOfficeTemplate)
 		api.POST("/onlyoffice/callback", mailHandler.OnlyOfficeCallback)
 		api.POST("/onlyoffice/forcesave", mailHandler.OnlyOfficeForceSave)
+		api.POST("/sessions", sessionHandler.CreateSession)
+		api.GET("/sessions", sessionHandler.ListSessions)
+		api.GET("/sessions/:id", sessionHandler.GetSession)
+		api.PUT("/sessions/:id", sessionHandler.UpdateSession)
+		api.PUT("/sessions/:id/status", sessionHandler.ChangeStatus)
+		api.GET("/sessions/:id/clock", sessionHandler.GetClock)
+		api.PUT("/sessions/:id/clock", sessionHandler.SetClock)
+		api.POST("/sessions/:id/clock/pause", sessionHandler.PauseClock)
+		api.POST("/sessions/:id/clock/resume", sessionHandler.ResumeClock)
 		api.DELETE("/sessions/:id", mailHandler.DeleteSession)
 		api.POST("/sessions/sync", mailHandler.SyncSessions)
+		api.POST("/sessions/:id/clone", mailHandler.CloneSession)
+		api.GET("/sessions/:id/report", reportHandler.GetSessionReport)
+		api.GET("/sessions/:id/stats/volume", statsHandler.GetVolume)
+		api.GET("/sessions/:id/stats/matrix", statsHandler.GetCommunicationMatrix)
+		api.GET("/sessions/:id/stats/attachments", statsHandler.GetAttachmentStats)
+		api.GET("/sessions/:id/stats/backlog", statsHandler.GetUnreadBacklog)
+		api.GET("/sessions/:id/stats/latency", statsHandler.GetLatencyDistribution)
+		api.GET("/sessions/:id/snapshots", mailHandler.ListSnapshots)
+		api.POST("/sessions/:id/snapshots", mailHandler.CreateSnapshot)
+		api.POST("/sessions/:id/snapshots/:snapshot_id/restore", mailHandler.RestoreSnapshot)
+		api.DELETE("/sessions/:id/snapshots/:snapshot_id", mailHandler.DeleteSnapshot)
+		api.GET("/sessions/:id/scenario", scenarioHandler.GetRunStatus)
+		api.POST("/sessions/:id/scenario/start", scenarioHandler.StartRun)
+		api.POST("/sessions/:id/scenario/pause", scenarioHandler.PauseRun)
+		api.POST("/sessions/:id/scenario/resume", scenarioHandler.ResumeRun)
+		api.POST("/sessions/:id/scenario/stop", scenarioHandler.StopRun)
+		api.GET("/audit", auditHandler.QueryAudit)
+		api.GET("/audit/export", auditHandler.ExportAudit)
+		api.GET("/ws", sessionHandler.RequireSession, mailHandler.ServeWebSocket)
+		api.POST("/webhooks", webhookHandler.CreateWebhook)
+		api.GET("/webhooks", webhookHandler.ListWebhooks)
+		api.DELETE("/webhooks/:id", webhookHandler.DeleteWebhook)
+		api.GET("/webhooks/:id/deliveries", webhookHandler.ListDeliveries)
+		api.GET("/webhooks/dead-letters", webhookHandler.ListDeadLetters)
+		api.POST("/webhooks/deliveries/:id/redeliver", webhookHandler.Redeliver)
+		scenarios := api.Group("/scenarios")
+		{
+			scenarios.POST("", scenarioHandler.CreateScenario)
+			scenarios.GET("", scenarioHandler.ListScenarios)
+			scenarios.GET("/:id", scenarioHandler.GetScenario)
+			scenarios.DELETE("/:id", scenarioHandler.DeleteScenario)
+		}
 		api.GET("/user/summary", mailHandler.GetUserSummary)
+		api.GET("/notifications/stats", mailHandler.GetNotificationStats)
+		api.GET("/presence", sessionHandler.RequireSession, presenceHandler.GetPresence)
 	}
 
 	// --- 5. 静态前端资源托管 (内嵌) ---
@@ -151,8 +342,25 @@ func main() {
 	}
 
 	addr := fmt.Sprintf(":%d", port)
-	log.Printf("服务启动于 %s\n", addr)
-	if err := r.Run(addr); err != nil {
-		log.Fatal(err)
+	srv := &http.Server{Addr: addr, Handler: r}
+	go func() {
+		log.Printf("服务启动于 %s\n", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// 优雅退出: 先关闭推送使 SSE/WebSocket 长连接结束，再等待其余请求完成
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Println("服务关闭中...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mailService.Shutdown(shutdownCtx); err != nil {
+		log.Printf("推送关闭超时: %v", err)
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("服务关闭超时: %v", err)
 	}
 }
@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 审计动作
+const (
+	AuditActionMailSend           = "MAIL_SEND"
+	AuditActionMailRead           = "MAIL_READ"
+	AuditActionMailDelete         = "MAIL_DELETE"
+	AuditActionAttachmentDownload = "ATTACHMENT_DOWNLOAD"
+	AuditActionChatSend           = "CHAT_SEND"
+	AuditActionChatRead           = "CHAT_READ"
+	AuditActionChatEdit           = "CHAT_EDIT"
+	AuditActionChatRetract        = "CHAT_RETRACT"
+	AuditActionChatEscalate       = "CHAT_ESCALATE" // 聊天记录转为正式文电
+	AuditActionConversationCreate = "CONVERSATION_CREATE"
+	AuditActionConversationUpdate = "CONVERSATION_UPDATE" // 成员及角色变更
+	AuditActionSessionDelete      = "SESSION_DELETE"
+	AuditActionSessionSync        = "SESSION_SYNC"
+	AuditActionOnlyOfficeSave     = "ONLYOFFICE_SAVE"
+)
+
+// ErrAuditImmutable 审计记录只允许追加，任何修改或删除都会返回该错误
+var ErrAuditImmutable = errors.New("audit log is append-only")
+
+// AuditLog 一条审计记录。记录在场次删除后仍保留，CreatedAt 为真实时间
+type AuditLog struct {
+	ID        string    `gorm:"primaryKey;type:uuid" json:"id"`
+	SessionID string    `gorm:"index" json:"session_id"`
+	ActorID   string    `gorm:"index" json:"actor_id"`
+	Action    string    `gorm:"index;not null" json:"action"`
+	TargetID  string    `gorm:"index" json:"target_id"`
+	Detail    string    `json:"detail,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+func (a *AuditLog) BeforeCreate(tx *gorm.DB) (err error) {
+	if a.ID == "" {
+		a.ID = uuid.New().String()
+	}
+	return
+}
+
+func (a *AuditLog) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditImmutable
+}
+
+func (a *AuditLog) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditImmutable
+}
@@ -0,0 +1,100 @@
+package domain
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 会话类型
+const (
+	ConversationDirect  = "direct"  // 单聊，固定两名成员
+	ConversationGroup   = "group"   // 群聊，按成员列表投递
+	ConversationChannel = "channel" // 场次频道，场次内全部用户可见
+)
+
+// 会话成员角色
+const (
+	MemberRoleAdmin  = "admin"
+	MemberRoleMember = "member"
+)
+
+// Conversation 即时消息会话
+type Conversation struct {
+	ID            string    `gorm:"primaryKey;type:uuid" json:"id"`
+	SessionID     string    `gorm:"index;not null;uniqueIndex:idx_conversations_direct" json:"session_id"`
+	Type          string    `gorm:"type:varchar(10);not null" json:"type"`
+	Name          string    `json:"name,omitempty"`
+	DirectKey     string    `gorm:"uniqueIndex:idx_conversations_direct,where:direct_key <> ''" json:"-"` // 单聊双方 ID 排序后拼接，同一场次内唯一
+	CreatedBy     string    `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`      // 演练时间
+	RealCreatedAt time.Time `json:"real_created_at"` // 真实创建时间
+
+	Members []ConversationMember `gorm:"foreignKey:ConversationID" json:"members"`
+}
+
+// ConversationMember 会话成员及其阅读位置。频道成员在首次发言或阅读时登记
+type ConversationMember struct {
+	ConversationID    string     `gorm:"primaryKey" json:"conversation_id"`
+	UserID            string     `gorm:"primaryKey" json:"user_id"`
+	SessionID         string     `gorm:"index;not null" json:"session_id"`
+	Role              string     `gorm:"type:varchar(10);default:'member'" json:"role"`
+	LastReadMessageID string     `json:"last_read_message_id,omitempty"`
+	LastReadMessageAt *time.Time `json:"-"`                      // 已读到的消息的真实发送时间，用于比较先后
+	LastReadAt        *time.Time `json:"last_read_at,omitempty"` // 演练时间
+	JoinedAt          time.Time  `json:"joined_at"`              // 演练时间
+}
+
+// DirectKey 返回两名用户的单聊标识，与顺序无关
+func DirectKey(userA, userB string) string {
+	pair := []string{userA, userB}
+	sort.Strings(pair)
+	return strings.Join(pair, "\x00")
+}
+
+// Member 返回用户的成员记录，不是成员时返回 nil
+func (c *Conversation) Member(userID string) *ConversationMember {
+	for i := range c.Members {
+		if c.Members[i].UserID == userID {
+			return &c.Members[i]
+		}
+	}
+	return nil
+}
+
+// IsAdmin 频道与群聊的管理员可管理成员
+func (c *Conversation) IsAdmin(userID string) bool {
+	m := c.Member(userID)
+	return m != nil && m.Role == MemberRoleAdmin
+}
+
+// Peer 返回单聊中的另一方
+func (c *Conversation) Peer(userID string) string {
+	for _, m := range c.Members {
+		if m.UserID != userID {
+			return m.UserID
+		}
+	}
+	return ""
+}
+
+// MemberIDs 返回除 exclude 外的成员 ID
+func (c *Conversation) MemberIDs(exclude string) []string {
+	ids := make([]string, 0, len(c.Members))
+	for _, m := range c.Members {
+		if m.UserID != exclude {
+			ids = append(ids, m.UserID)
+		}
+	}
+	return ids
+}
+
+func (c *Conversation) BeforeCreate(tx *gorm.DB) (err error) {
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	return
+}
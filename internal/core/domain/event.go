@@ -0,0 +1,25 @@
+package domain
+
+import "time"
+
+// NotificationEvent 一条推送事件。ID 单调递增，SSE 断线重连时据 Last-Event-ID 重放
+type NotificationEvent struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	SessionID string    `gorm:"index" json:"session_id"`
+	Targets   []string  `gorm:"serializer:json" json:"targets"` // nil 表示投递给场次内全部订阅者
+	Data      string    `gorm:"type:text" json:"data"`          // 推送给客户端的 JSON 事件体
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// VisibleTo 判断事件是否投递给该用户
+func (e *NotificationEvent) VisibleTo(userID string) bool {
+	if e.Targets == nil {
+		return true
+	}
+	for _, t := range e.Targets {
+		if t == userID {
+			return true
+		}
+	}
+	return false
+}
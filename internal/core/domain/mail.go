@@ -9,17 +9,19 @@ import (
 
 // Mail 代表文电实体
 type Mail struct {
-	ID           string         `gorm:"primaryKey;type:uuid" json:"id"`
-	SessionID    string         `gorm:"index;not null;default:'default'" json:"session_id"`
-	SenderID     string         `gorm:"index;not null" json:"sender_id"`
-	SenderStatus string         `gorm:"type:varchar(20);default:'normal'" json:"-"` // normal, deleted
-	Subject      string         `gorm:"not null" json:"subject"`
-	Content      string         `gorm:"type:text" json:"content"`
-	ContentType  string         `gorm:"type:varchar(32);default:'text'" json:"content_type"`
-	ParentID     *string        `gorm:"index" json:"parent_id,omitempty"` // 用于会话/回复
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	ID              string         `gorm:"primaryKey;type:uuid" json:"id"`
+	SessionID       string         `gorm:"index;not null;default:'default'" json:"session_id"`
+	SenderID        string         `gorm:"index;not null" json:"sender_id"`
+	SenderStatus    string         `gorm:"type:varchar(20);default:'normal'" json:"-"` // normal, deleted
+	SenderDeletedAt *time.Time     `json:"-"`                                          // 发件人删除时间 (演练时间)
+	Subject         string         `gorm:"not null" json:"subject"`
+	Content         string         `gorm:"type:text" json:"content"`
+	ContentType     string         `gorm:"type:varchar(32);default:'text'" json:"content_type"`
+	ParentID        *string        `gorm:"index" json:"parent_id,omitempty"` // 用于会话/回复
+	CreatedAt       time.Time      `json:"created_at"`                       // 演练时间 (想定时间)
+	RealCreatedAt   time.Time      `json:"real_created_at"`                  // 真实发送时间
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
 
 	Attachments []Attachment    `gorm:"foreignKey:MailID" json:"attachments"`
 	Recipients  []MailRecipient `gorm:"foreignKey:MailID" json:"recipients"`
@@ -33,9 +35,25 @@ type MailRecipient struct {
 	RecipientID string     `gorm:"index;not null" json:"recipient_id"`                 // 用户 ID 或组 ID
 	Type        string     `gorm:"type:varchar(10);default:'to'" json:"type"`          // to (收件人), cc (抄送), bcc (密送)
 	Status      string     `gorm:"type:varchar(20);default:'unread'" json:"status"`    // unread, read, deleted
-	ReadAt      *time.Time `json:"read_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`                                  // 演练时间
+	RemovedAt   *time.Time `json:"-"`                                                  // 收件人删除时间 (演练时间)
+
+	// 外部邮箱转发状态，仅映射了外部地址的收件人使用
+	RelayAddress  string     `gorm:"type:varchar(255)" json:"-"`
+	RelayStatus   string     `gorm:"type:varchar(20);index" json:"relay_status,omitempty"` // pending, sent, failed
+	RelayAttempts int        `json:"relay_attempts,omitempty"`
+	RelayNextAt   *time.Time `json:"-"` // 下次重试时间 (真实时间)
+	RelayError    string     `json:"relay_error,omitempty"`
+	RelayedAt     *time.Time `json:"relayed_at,omitempty"` // 转发成功时间 (真实时间)
 }
 
+// 外部邮箱转发状态
+const (
+	RelayStatusPending = "pending"
+	RelayStatusSent    = "sent"
+	RelayStatusFailed  = "failed"
+)
+
 // Attachment 代表附件文件
 type Attachment struct {
 	ID            string    `gorm:"primaryKey;type:uuid" json:"id"`
@@ -50,14 +68,50 @@ type Attachment struct {
 }
 
 type ChatMessage struct {
-	ID          string       `gorm:"primaryKey" json:"id"`
-	SessionID   string       `gorm:"index" json:"session_id"`
-	SenderID    string       `gorm:"index" json:"sender_id"`
-	ReceiverID  string       `gorm:"index" json:"receiver_id"` // 接收用户 ID
-	Content     string       `json:"content"`
-	IsRead      bool         `gorm:"default:false" json:"is_read"`
-	CreatedAt   time.Time    `json:"created_at"`
-	Attachments []Attachment `gorm:"foreignKey:ChatMessageID" json:"attachments"`
+	ID             string        `gorm:"primaryKey" json:"id"`
+	SessionID      string        `gorm:"index" json:"session_id"`
+	SenderID       string        `gorm:"index" json:"sender_id"`
+	ConversationID string        `gorm:"index" json:"conversation_id,omitempty"`
+	ReceiverID     string        `gorm:"index" json:"receiver_id"` // 接收用户 ID，仅单聊使用
+	Content        string        `json:"content"`
+	IsRead         bool          `gorm:"default:false" json:"is_read"`              // 仅单聊使用，群聊与频道按成员阅读位置计算
+	ReadAt         *time.Time    `json:"read_at,omitempty"`                         // 演练时间
+	CreatedAt      time.Time     `json:"created_at"`                                // 演练时间 (想定时间)
+	RealCreatedAt  time.Time     `json:"real_created_at"`                           // 真实发送时间
+	EditedAt       *time.Time    `json:"edited_at,omitempty"`                       // 最后编辑时间 (演练时间)
+	RetractedAt    *time.Time    `json:"retracted_at,omitempty"`                    // 撤回时间 (演练时间)，撤回后正文清空、附件删除
+	ReplyToID      string        `gorm:"index" json:"reply_to_id,omitempty"`        // 引用回复的消息
+	Mentions       []ChatMention `gorm:"serializer:json" json:"mentions,omitempty"` // 撤回时一并清除
+	Attachments    []Attachment  `gorm:"foreignKey:ChatMessageID" json:"attachments"`
+
+	ReplyTo *ChatMessageRef `gorm:"-" json:"reply_to,omitempty"` // 查询时填入的被引用消息摘要
+}
+
+// ChatMention 消息中对用户的提及，Offset / Length 为 "@名称" 在正文中的位置 (按字符计)，
+// Length 为 0 表示不标注位置
+type ChatMention struct {
+	UserID string `json:"user_id"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+}
+
+// ChatMessageRef 被引用消息的精简副本
+type ChatMessageRef struct {
+	ID        string    `json:"id"`
+	SenderID  string    `json:"sender_id"`
+	Content   string    `json:"content"` // 正文摘要，已撤回时为空
+	Retracted bool      `json:"retracted"`
+	CreatedAt time.Time `json:"created_at"` // 演练时间
+}
+
+// ChatMessageEdit 即时消息的编辑历史，保存每次编辑前的正文
+type ChatMessageEdit struct {
+	ID           string    `gorm:"primaryKey;type:uuid" json:"id"`
+	MessageID    string    `gorm:"index;not null" json:"message_id"`
+	SessionID    string    `gorm:"index;not null" json:"session_id"`
+	Content      string    `gorm:"type:text" json:"content"`
+	EditedAt     time.Time `json:"edited_at"`      // 演练时间
+	RealEditedAt time.Time `json:"real_edited_at"` // 真实编辑时间
 }
 
 // BeforeCreate 钩子：生成 UUID
@@ -88,3 +142,10 @@ func (cm *ChatMessage) BeforeCreate(tx *gorm.DB) (err error) {
 	}
 	return
 }
+
+func (e *ChatMessageEdit) BeforeCreate(tx *gorm.DB) (err error) {
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	return
+}
@@ -0,0 +1,31 @@
+package domain
+
+import "time"
+
+// 在线状态
+const (
+	PresenceOnline  = "online"  // 有推送连接且近期有操作
+	PresenceIdle    = "idle"    // 有推送连接但长时间无操作
+	PresenceOffline = "offline" // 无推送连接 (超过重连宽限期)
+)
+
+// PresenceEvent 在线状态变化的推送事件类型
+const PresenceEvent = "PRESENCE"
+
+// UserPresence 用户在场次中的最后在线时间
+type UserPresence struct {
+	SessionID  string    `gorm:"primaryKey" json:"session_id"`
+	UserID     string    `gorm:"primaryKey" json:"user_id"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// PresenceConnection 某个实例上用户的连接数与本地状态。实例定期刷新 HeartbeatAt，
+// 多实例部署时据此合并各实例的在线状态，心跳过期的记录 (实例已退出) 不再计入
+type PresenceConnection struct {
+	InstanceID  string    `gorm:"primaryKey;type:varchar(36)" json:"instance_id"`
+	SessionID   string    `gorm:"primaryKey" json:"session_id"`
+	UserID      string    `gorm:"primaryKey" json:"user_id"`
+	Connections int       `json:"connections"`
+	Status      string    `gorm:"type:varchar(10)" json:"status"`
+	HeartbeatAt time.Time `gorm:"index" json:"heartbeat_at"`
+}
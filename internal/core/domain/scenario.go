@@ -0,0 +1,115 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Scenario 代表一份演练脚本，Definition 保存原始 JSON 定义
+type Scenario struct {
+	ID          string    `gorm:"primaryKey;type:uuid" json:"id"`
+	Name        string    `gorm:"not null" json:"name"`
+	Description string    `json:"description"`
+	Definition  string    `gorm:"type:text;not null" json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (s *Scenario) BeforeCreate(tx *gorm.DB) (err error) {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	return
+}
+
+// ScenarioDefinition 演练脚本的结构化定义
+type ScenarioDefinition struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Injects     []ScenarioInject `json:"injects"`
+}
+
+// ScenarioInject 代表脚本中的一条注入 (定时文电或即时消息)
+//
+// 未设置 After 时，Offset 相对于脚本启动时刻；设置 After 时，Offset 相对于被引用注入的发出时刻。
+// IfUnanswered 为 true 时，若被引用注入在此期间已得到回复，则跳过本条注入 (用于催办/跟进)。
+type ScenarioInject struct {
+	ID           string               `json:"id"`
+	Type         string               `json:"type"` // mail, chat
+	Offset       Duration             `json:"offset"`
+	After        string               `json:"after,omitempty"`
+	IfUnanswered bool                 `json:"if_unanswered,omitempty"`
+	SenderID     string               `json:"sender_id"`
+	To           []string             `json:"to"`
+	Cc           []string             `json:"cc,omitempty"`
+	Subject      string               `json:"subject,omitempty"`
+	Content      string               `json:"content"`
+	ContentType  string               `json:"content_type,omitempty"`
+	Attachments  []ScenarioAttachment `json:"attachments,omitempty"`
+}
+
+// ScenarioAttachment 注入附件，Path 相对于脚本素材目录
+type ScenarioAttachment struct {
+	FileName string `json:"file_name"`
+	Path     string `json:"path"`
+}
+
+// Duration 支持以 "10m" 字符串或秒数表示的时长
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(value * float64(time.Second)))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration: %s", string(b))
+	}
+	return nil
+}
+
+// ScenarioRun 场次中脚本的执行状态，每次状态变化时保存，服务重启后据此恢复执行。
+// Definition 保存启动时的脚本定义副本，脚本删除后仍可查看历史执行结果。
+//
+// 多实例共用数据库时，只有 Owner 实例驱动脚本执行，并在 LeaseUntil 前续租；租约过期后由其他实例接管。
+// 其他实例收到的暂停、继续、停止请求写入 Command，由 Owner 在下一次推进时执行
+type ScenarioRun struct {
+	SessionID  string                         `gorm:"primaryKey" json:"session_id"`
+	ScenarioID string                         `gorm:"index" json:"scenario_id"`
+	Definition string                         `gorm:"type:text" json:"-"`
+	State      string                         `json:"state"` // running, paused, stopped, completed
+	StartedAt  time.Time                      `json:"started_at"`
+	Elapsed    time.Duration                  `json:"elapsed"`  // 截至 LastSim 的累计运行时长
+	LastSim    time.Time                      `json:"last_sim"` // 最近一次推进时的演练时间
+	Injects    map[string]ScenarioInjectState `gorm:"serializer:json" json:"injects"`
+	Owner      string                         `gorm:"type:varchar(36);index" json:"-"`
+	LeaseUntil time.Time                      `json:"-"`
+	Command    string                         `gorm:"type:varchar(10)" json:"-"` // pause, resume, stop
+	UpdatedAt  time.Time                      `json:"updated_at"`
+}
+
+// ScenarioInjectState 单条注入的执行结果
+type ScenarioInjectState struct {
+	State    string        `json:"state"` // pending, sent, skipped, failed
+	At       time.Duration `json:"at"`
+	FiredAt  time.Time     `json:"fired_at"`
+	TargetID string        `json:"target_id,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
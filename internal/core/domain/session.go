@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 场次生命周期状态
+const (
+	SessionStatusDraft    = "draft"
+	SessionStatusRunning  = "running"
+	SessionStatusPaused   = "paused"
+	SessionStatusEnded    = "ended"
+	SessionStatusArchived = "archived"
+)
+
+// 场次来源。仅宿主平台管理的场次参与 /sessions/sync 的孤儿清理
+const (
+	SessionOriginLocal = "local" // 本服务创建: 默认场次、接口创建、克隆生成
+	SessionOriginHost  = "host"  // 曾出现在宿主同步名单中，或由历史数据补建
+)
+
+// Session 代表一场演练，ID 通常由宿主平台指定
+type Session struct {
+	ID           string     `gorm:"primaryKey" json:"id"`
+	Name         string     `gorm:"not null" json:"name"`
+	Description  string     `gorm:"type:text" json:"description"`
+	Status       string     `gorm:"type:varchar(20);index;default:'draft'" json:"status"`
+	Origin       string     `gorm:"type:varchar(20);default:'local'" json:"origin"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	EndedAt      *time.Time `json:"ended_at,omitempty"`
+	Participants []string   `gorm:"serializer:json" json:"participants"`
+	// RelayAddresses 收件人 ID -> 外部邮箱，发往这些收件人的文电会同时转发一份到外部邮箱
+	RelayAddresses map[string]string `gorm:"serializer:json" json:"relay_addresses,omitempty"`
+	Clock          SessionClock      `gorm:"embedded;embeddedPrefix:clock_" json:"clock"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+func (s *Session) BeforeCreate(tx *gorm.DB) (err error) {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	return
+}
+
+// SessionClock 场次演练时钟 (想定时间)
+//
+// 演练时间 = SimAnchor + (真实时间 - RealAnchor) * Speed；暂停时固定为 SimAnchor。
+// 未设置锚点时与真实时间一致。
+type SessionClock struct {
+	SimAnchor  time.Time `json:"sim_anchor"`
+	RealAnchor time.Time `json:"real_anchor"`
+	Speed      float64   `gorm:"default:1" json:"speed"`
+	Paused     bool      `gorm:"default:false" json:"paused"`
+}
+
+// At 返回真实时刻 real 对应的演练时间
+func (c SessionClock) At(real time.Time) time.Time {
+	if c.RealAnchor.IsZero() {
+		return real
+	}
+	if c.Paused {
+		return c.SimAnchor
+	}
+	elapsed := real.Sub(c.RealAnchor)
+	return c.SimAnchor.Add(time.Duration(float64(elapsed) * c.Speed))
+}
+
+// SessionSnapshot 代表场次的命名还原点
+// 快照数据以隐藏场次 (BackupSessionID) 的形式完整保存，还原时再克隆回原场次
+type SessionSnapshot struct {
+	ID              string    `gorm:"primaryKey;type:uuid" json:"id"`
+	SessionID       string    `gorm:"index;not null" json:"session_id"`
+	Name            string    `gorm:"not null" json:"name"`
+	BackupSessionID string    `gorm:"uniqueIndex;not null" json:"-"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func (s *SessionSnapshot) BeforeCreate(tx *gorm.DB) (err error) {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	return
+}
@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Webhook 事件类型，与 SSE 推送的 type 字段一致
+const (
+	WebhookEventMail           = "MAIL"
+	WebhookEventChat           = "CHAT"
+	WebhookEventRead           = "READ"      // 文电已读
+	WebhookEventChatRead       = "CHAT_READ" // 即时消息已读回执，与文电已读分开订阅
+	WebhookEventChatEdited     = "CHAT_EDITED"
+	WebhookEventChatRetracted  = "CHAT_RETRACTED"
+	WebhookEventMention        = "MENTION" // 即时消息中提及用户
+	WebhookEventDelete         = "DELETE"
+	WebhookEventConversation   = "CONVERSATION" // 会话创建及成员变更
+	WebhookEventSessionDeleted = "SESSION_DELETED"
+)
+
+// WebhookEvents 可订阅的全部事件类型
+var WebhookEvents = []string{WebhookEventMail, WebhookEventChat, WebhookEventRead, WebhookEventChatRead, WebhookEventChatEdited, WebhookEventChatRetracted, WebhookEventMention, WebhookEventConversation, WebhookEventDelete, WebhookEventSessionDeleted}
+
+// Webhook 投递状态
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusDead      = "dead" // 超过重试次数，进入死信列表
+)
+
+// Webhook 服务端事件订阅，SessionID 为空时订阅所有场次的事件
+type Webhook struct {
+	ID        string    `gorm:"primaryKey;type:uuid" json:"id"`
+	SessionID string    `gorm:"index" json:"session_id"`
+	URL       string    `gorm:"not null" json:"url"`
+	Events    []string  `gorm:"serializer:json" json:"events"` // 为空时订阅全部事件
+	Secret    string    `json:"secret,omitempty"`              // HMAC 签名密钥，仅在创建时返回
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (w *Webhook) BeforeCreate(tx *gorm.DB) (err error) {
+	if w.ID == "" {
+		w.ID = uuid.New().String()
+	}
+	return
+}
+
+// Matches 判断订阅是否包含该事件类型
+func (w *Webhook) Matches(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery 一次事件投递，既是投递队列也是投递日志
+type WebhookDelivery struct {
+	ID           string          `gorm:"primaryKey;type:uuid" json:"id"`
+	WebhookID    string          `gorm:"index;not null" json:"webhook_id"`
+	SessionID    string          `gorm:"index" json:"session_id"`
+	Event        string          `gorm:"type:varchar(32)" json:"event"`
+	Payload      json.RawMessage `gorm:"type:text" json:"payload"`
+	Status       string          `gorm:"type:varchar(20);index" json:"status"`
+	Attempts     int             `json:"attempts"`
+	NextAt       *time.Time      `gorm:"index" json:"next_at,omitempty"`
+	ResponseCode int             `json:"response_code,omitempty"`
+	LastError    string          `gorm:"type:text" json:"last_error,omitempty"`
+	DeliveredAt  *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+func (d *WebhookDelivery) BeforeCreate(tx *gorm.DB) (err error) {
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	return
+}
@@ -1,6 +1,9 @@
 package ports
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 type ErrorType string
 
@@ -36,3 +39,10 @@ func NewInvalidInputError(msg string, err error) *AppError {
 func NewInternalError(msg string, err error) *AppError {
 	return &AppError{Type: ErrorTypeInternal, Message: msg, Err: err}
 }
+
+func NewForbiddenError(msg string, err error) *AppError {
+	return &AppError{Type: ErrorTypeForbidden, Message: msg, Err: err}
+}
+
+// ErrRelayRejected 外部邮件服务器永久拒收 (5xx)，转发不再重试
+var ErrRelayRejected = errors.New("relay rejected by remote server")
@@ -3,25 +3,215 @@ package ports
 import (
 	"context"
 	"raven/internal/core/domain"
+	"time"
 )
 
+// CloneOptions 控制场次克隆时的数据处理方式
+type CloneOptions struct {
+	ResetRead   bool                // 将已读状态重置为未读
+	MapFilePath func(string) string // 将源场次的附件路径映射为目标场次路径
+	Replace     bool                // 在同一事务内先清空目标场次的已有记录
+}
+
 type MailRepository interface {
 	Create(ctx context.Context, mail *domain.Mail) error
 	GetByID(ctx context.Context, sessionID, id string) (*domain.Mail, error)
 	GetInbox(ctx context.Context, sessionID, recipientID string, page, pageSize int, query string) ([]domain.Mail, int64, error)
 	GetSent(ctx context.Context, sessionID, senderID string, page, pageSize int, query string) ([]domain.Mail, int64, error)
-	UpdateStatus(ctx context.Context, mailID, recipientID, status string) error
-	DeleteForSender(ctx context.Context, mailID string) error
+	UpdateStatus(ctx context.Context, mailID, recipientID, status string, at time.Time) error
+	DeleteForSender(ctx context.Context, mailID string, at time.Time) error
 	DeleteSession(ctx context.Context, sessionID string) error
 	GetAttachmentByID(ctx context.Context, sessionID, id string) (*domain.Attachment, error)
+	// AttachmentFileInUse 是否仍有附件记录引用该存储路径 (聊天记录转文电时文电与消息共用文件)
+	AttachmentFileInUse(ctx context.Context, filePath string) (bool, error)
 	// Chat / IM
 	CreateChatMessage(ctx context.Context, msg *domain.ChatMessage) error
-	GetChatHistory(ctx context.Context, sessionID, userA, userB string, limit int) ([]domain.ChatMessage, error)
-	MarkChatAsRead(ctx context.Context, sessionID, senderID, receiverID string) error
+	GetChatHistory(ctx context.Context, sessionID, userA, userB string, page ChatHistoryPage) ([]domain.ChatMessage, error)
+	// MarkChatAsRead 将 sender 发给 receiver 的未读消息标记为已读，返回被标记的消息 ID
+	MarkChatAsRead(ctx context.Context, sessionID, senderID, receiverID string, at time.Time) ([]string, error)
+	GetChatMessage(ctx context.Context, sessionID, id string) (*domain.ChatMessage, error)
+	// EditChatMessage 写入编辑历史并更新正文、提及及编辑时间
+	EditChatMessage(ctx context.Context, msg *domain.ChatMessage, edit *domain.ChatMessageEdit) error
+	// RetractChatMessage 清空正文、记录撤回时间，并删除附件记录与编辑历史
+	RetractChatMessage(ctx context.Context, msg *domain.ChatMessage) error
+	ListChatMessageEdits(ctx context.Context, messageID string) ([]domain.ChatMessageEdit, error)
+	// ListChatMessagesByIDs 按 ID 批量加载消息 (不含附件)，用于填充引用回复
+	ListChatMessagesByIDs(ctx context.Context, sessionID string, ids []string) ([]domain.ChatMessage, error)
+	// SearchChatMessages 在用户所在的会话中按正文与附件名检索，结果由新到旧排列
+	SearchChatMessages(ctx context.Context, sessionID, userID string, query ChatSearchQuery) ([]domain.ChatMessage, error)
+	HasReply(ctx context.Context, sessionID, parentID string) (bool, error)
+	HasChatReply(ctx context.Context, sessionID, fromID, toID string, since time.Time) (bool, error)
+
+	// Conversations
+	CreateConversation(ctx context.Context, conv *domain.Conversation) error
+	GetConversation(ctx context.Context, sessionID, id string) (*domain.Conversation, error)
+	// FindDirectConversation 按 domain.DirectKey 查找单聊
+	FindDirectConversation(ctx context.Context, sessionID, directKey string) (*domain.Conversation, error)
+	// ListConversations 返回用户所在的会话及场次内全部频道
+	ListConversations(ctx context.Context, sessionID, userID string) ([]domain.Conversation, error)
+	ListSessionConversations(ctx context.Context, sessionID string) ([]domain.Conversation, error)
+	// AddConversationMembers 登记成员，已是成员的保持不变
+	AddConversationMembers(ctx context.Context, members []domain.ConversationMember) error
+	UpdateConversationMember(ctx context.Context, member *domain.ConversationMember) error
+	RemoveConversationMember(ctx context.Context, conversationID, userID string) error
+	GetConversationHistory(ctx context.Context, sessionID, conversationID string, page ChatHistoryPage) ([]domain.ChatMessage, error)
+	// MarkConversationRead 将用户的阅读位置移到会话最新一条他人消息，返回新读到的消息
+	MarkConversationRead(ctx context.Context, sessionID, conversationID, userID string, at time.Time) ([]domain.ChatMessage, error)
+	// GetConversationUnreadCounts 返回用户在群聊与频道中的未读数，按会话 ID
+	GetConversationUnreadCounts(ctx context.Context, sessionID, userID string) (map[string]int, error)
+
+	// Reporting
+	ListSessionMails(ctx context.Context, sessionID string) ([]domain.Mail, error)
+	ListSessionChats(ctx context.Context, sessionID string) ([]domain.ChatMessage, error)
+
+	// External relay
+	// ClaimDueRelays 认领到期的转发记录并将其租约延至 leaseUntil，已被其他实例认领的记录不返回
+	ClaimDueRelays(ctx context.Context, now, leaseUntil time.Time, limit int) ([]domain.MailRecipient, error)
+	UpdateRelayState(ctx context.Context, recipient *domain.MailRecipient) error
+
+	// Statistics
+	GetVolumeStats(ctx context.Context, sessionID string, bucketSeconds int64) ([]VolumeBucket, error)
+	GetCommunicationMatrix(ctx context.Context, sessionID string) ([]MatrixCell, error)
+	GetAttachmentStats(ctx context.Context, sessionID string) ([]AttachmentStat, error)
+	GetUnreadBacklog(ctx context.Context, sessionID string) ([]BacklogEntry, error)
+	GetReplyLatencyBuckets(ctx context.Context, sessionID string, bounds []int) ([]LatencyBucket, error)
+	GetReadLatencyBuckets(ctx context.Context, sessionID string, bounds []int) ([]LatencyBucket, error)
 
 	// Summary / Initialization
 	GetUnreadMailCount(ctx context.Context, sessionID, userID string) (int64, error)
 	GetIMUnreadCounts(ctx context.Context, sessionID, userID string) (map[string]int, error)
+	// ListChatThreads 一次分组查询得出用户各会话的最后一条消息与未读数
+	ListChatThreads(ctx context.Context, sessionID, userID string) ([]ChatThread, error)
 	// System / Admin
 	GetOrphanSessionIDs(ctx context.Context, activeSessionIDs []string) ([]string, error)
+	ListDataSessionIDs(ctx context.Context) ([]string, error)
+	SessionHasData(ctx context.Context, sessionID string) (bool, error)
+	CloneSession(ctx context.Context, srcSessionID, dstSessionID string, opts CloneOptions) error
+
+	// Snapshots
+	CreateSnapshot(ctx context.Context, snapshot *domain.SessionSnapshot) error
+	GetSnapshot(ctx context.Context, sessionID, id string) (*domain.SessionSnapshot, error)
+	ListSnapshots(ctx context.Context, sessionID string) ([]domain.SessionSnapshot, error)
+	DeleteSnapshot(ctx context.Context, id string) error
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *domain.Session) error
+	GetByID(ctx context.Context, id string) (*domain.Session, error)
+	List(ctx context.Context, status string) ([]domain.Session, error)
+	Update(ctx context.Context, session *domain.Session, columns ...string) error
+	MarkHostManaged(ctx context.Context, ids []string) error
+	Delete(ctx context.Context, id string) error
+}
+
+type ScenarioRepository interface {
+	Create(ctx context.Context, scenario *domain.Scenario) error
+	GetByID(ctx context.Context, id string) (*domain.Scenario, error)
+	List(ctx context.Context) ([]domain.Scenario, error)
+	Delete(ctx context.Context, id string) error
+
+	// Runs
+	// CreateRun 保存新启动的执行记录，场次中已有未结束的脚本时返回 false
+	CreateRun(ctx context.Context, run *domain.ScenarioRun) (bool, error)
+	GetRun(ctx context.Context, sessionID string) (*domain.ScenarioRun, error)
+	ListRuns(ctx context.Context) ([]domain.ScenarioRun, error)
+	// ClaimRun 在原租约过期或本就属于 owner 时接管未结束的脚本，返回是否接管成功
+	ClaimRun(ctx context.Context, sessionID, owner string, now, leaseUntil time.Time) (bool, error)
+	// UpdateRun 保存执行进度与租约，仅在 run.Owner 仍持有该记录时生效，记录被删除或被接管时返回 false
+	UpdateRun(ctx context.Context, run *domain.ScenarioRun) (bool, error)
+	// SetRunCommand 为未结束的脚本登记控制指令，脚本已结束时返回 false
+	SetRunCommand(ctx context.Context, sessionID, command string) (bool, error)
+	// ClearRunCommand 清除已执行的控制指令，其间登记的新指令保留
+	ClearRunCommand(ctx context.Context, sessionID, command string) error
+}
+
+// ChatHistoryPage 聊天记录分页条件。Before / After 为消息 ID 游标，至多指定一个；
+// 均未指定时返回最新的一页。游标不属于该会话时返回 gorm.ErrRecordNotFound
+type ChatHistoryPage struct {
+	Before string
+	After  string
+	Limit  int
+}
+
+// ChatSearchQuery 即时消息检索条件。PeerID 限定与某人的单聊，ConversationID 限定某个群聊或频道，
+// From / To 为演练时间范围，空字段表示不过滤
+type ChatSearchQuery struct {
+	Query          string
+	PeerID         string
+	ConversationID string
+	From           *time.Time
+	To             *time.Time
+	Limit          int
+}
+
+// AuditFilter 审计记录查询条件，空字段表示不过滤
+type AuditFilter struct {
+	SessionID string
+	ActorID   string
+	Action    string
+	TargetID  string
+	From      *time.Time
+	To        *time.Time
+}
+
+type WebhookDeliveryFilter struct {
+	WebhookID string
+	SessionID string
+	Status    string
+	Event     string
+}
+
+type PresenceRepository interface {
+	// Touch 更新用户的最后在线时间，记录不存在时创建
+	Touch(ctx context.Context, sessionID, userID string, at time.Time) error
+	List(ctx context.Context, sessionID string) ([]domain.UserPresence, error)
+	// SaveConnection 写入本实例上用户的连接状态，状态为离线时删除记录
+	SaveConnection(ctx context.Context, conn *domain.PresenceConnection) error
+	// Heartbeat 刷新实例全部记录的心跳时间，并清理心跳早于 staleBefore 的记录
+	Heartbeat(ctx context.Context, instanceID string, at, staleBefore time.Time) error
+	// ListConnections 返回场次内心跳不早于 since 的连接记录，userID 非空时只返回该用户
+	ListConnections(ctx context.Context, sessionID, userID string, since time.Time) ([]domain.PresenceConnection, error)
+}
+
+// EventLog 按场次保留最近的推送事件，用于断线重放
+type EventLog interface {
+	// Append 为事件分配递增 ID 并写入日志，超出容量的旧事件被淘汰
+	Append(ctx context.Context, event *domain.NotificationEvent) error
+	// ListSince 返回场次内 ID 大于 afterID 的事件，按 ID 升序
+	ListSince(ctx context.Context, sessionID string, afterID uint64) ([]domain.NotificationEvent, error)
+	// LastID 返回当前最大的事件 ID
+	LastID(ctx context.Context) (uint64, error)
+}
+
+// SharedEventLog 可由多个实例共同读写的事件日志 (共享数据库表)
+type SharedEventLog interface {
+	EventLog
+	// ListAfter 返回所有场次中 ID 大于 afterID 的事件，按 ID 升序
+	ListAfter(ctx context.Context, afterID uint64, limit int) ([]domain.NotificationEvent, error)
+}
+
+type WebhookRepository interface {
+	Create(ctx context.Context, hook *domain.Webhook) error
+	GetByID(ctx context.Context, id string) (*domain.Webhook, error)
+	// List 返回订阅列表，sessionID 为空时返回全部
+	List(ctx context.Context, sessionID string) ([]domain.Webhook, error)
+	// ListForSession 返回该场次的订阅及全局订阅
+	ListForSession(ctx context.Context, sessionID string) ([]domain.Webhook, error)
+	// Delete 删除订阅及其投递记录
+	Delete(ctx context.Context, id string) error
+
+	CreateDeliveries(ctx context.Context, deliveries []domain.WebhookDelivery) error
+	GetDelivery(ctx context.Context, id string) (*domain.WebhookDelivery, error)
+	// ClaimDueDeliveries 认领到期的投递记录，next_at 推迟到 leaseUntil 作为租约
+	ClaimDueDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]domain.WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error
+	ListDeliveries(ctx context.Context, filter WebhookDeliveryFilter, page, pageSize int) ([]domain.WebhookDelivery, int64, error)
+}
+
+// AuditRepository 只提供追加与查询，不提供修改和删除
+type AuditRepository interface {
+	Append(ctx context.Context, entry *domain.AuditLog) error
+	List(ctx context.Context, filter AuditFilter, page, pageSize int) ([]domain.AuditLog, int64, error)
+	// Each 按时间顺序分批遍历匹配的记录，用于导出
+	Each(ctx context.Context, filter AuditFilter, fn func([]domain.AuditLog) error) error
 }
@@ -4,41 +4,219 @@ import (
 	"context"
 	"io"
 	"raven/internal/core/domain"
+	"time"
 )
 
+// 订阅者缓冲写满时的处理策略
+const (
+	OverflowDropOldest = "drop-oldest" // 丢弃最旧的事件
+	OverflowDisconnect = "disconnect"  // 断开连接，由客户端凭 Last-Event-ID 重连补齐
+)
+
+// EventBus 推送事件总线。进程内实现直接转发，共享实现让多个实例互相收到对方发布的事件
+type EventBus interface {
+	// Publish 发布事件，由总线分配递增 ID
+	Publish(ctx context.Context, event domain.NotificationEvent) error
+	// Run 按 ID 顺序把总线上的事件 (包括其他实例发布的) 交给 deliver，直到 ctx 取消
+	Run(ctx context.Context, deliver func(domain.NotificationEvent)) error
+	// ListSince 返回场次内 ID 大于 afterID 的事件，用于断线重放
+	ListSince(ctx context.Context, sessionID string, afterID uint64) ([]domain.NotificationEvent, error)
+	// LastID 返回日志中最大的事件 ID
+	LastID(ctx context.Context) (uint64, error)
+}
+
+// EventPublisher 向场次推送自定义事件，targets 为 nil 时推送给场次内全部用户
+type EventPublisher interface {
+	PublishEvent(ctx context.Context, sessionID, event string, targets []string, data interface{})
+}
+
+// PresenceInfo 用户在线状态
+type PresenceInfo struct {
+	UserID      string     `json:"user_id"`
+	Status      string     `json:"status"`
+	Connections int        `json:"connections"` // 本实例上的 SSE/WebSocket 连接数
+	LastSeenAt  *time.Time `json:"last_seen_at,omitempty"`
+}
+
+type PresenceService interface {
+	// Connect 登记一条 SSE/WebSocket 连接，返回的函数在连接断开时调用
+	Connect(ctx context.Context, sessionID, userID string) func()
+	// Touch 记录用户操作，idle 用户恢复为 online
+	Touch(ctx context.Context, sessionID, userID string)
+	List(ctx context.Context, sessionID string) ([]PresenceInfo, error)
+}
+
+// HubConfig 推送分发配置
+type HubConfig struct {
+	QueueSize int    // 每个订阅者缓冲的事件数
+	Overflow  string // OverflowDropOldest / OverflowDisconnect
+}
+
+// HubStats 推送分发统计，计数自进程启动起累计
+type HubStats struct {
+	Subscribers  int    `json:"subscribers"`
+	QueueSize    int    `json:"queue_size"`
+	Overflow     string `json:"overflow"`
+	Published    uint64 `json:"published"` // 已完成分发的事件数
+	Delivered    uint64 `json:"delivered"`
+	Dropped      uint64 `json:"dropped"`      // 因缓冲溢出未送达的事件数
+	Disconnected uint64 `json:"disconnected"` // 因缓冲溢出被断开的订阅数
+}
+
 type MailService interface {
 	SendMail(ctx context.Context, senderID string, req SendMailRequest) (*domain.Mail, error)
 	GetInbox(ctx context.Context, sessionID, userID string, page, pageSize int, query string) ([]domain.Mail, int64, error)
 	GetSent(ctx context.Context, sessionID, userID string, page, pageSize int, query string) ([]domain.Mail, int64, error)
 	ReadMail(ctx context.Context, sessionID, userID, mailID string) (*domain.Mail, error)
+	SetMailRead(ctx context.Context, sessionID, userID, mailID string, read bool) error
 	DeleteMail(ctx context.Context, sessionID, userID, mailID string) error
 	DeleteSession(ctx context.Context, sessionID string) error
 	GetAttachment(ctx context.Context, sessionID, attachmentID string) (*domain.Attachment, error)
-	// Notification stream
-	Subscribe() chan string
-	Unsubscribe(chan string)
+	// Notification stream，按场次与用户过滤
+	Subscribe(sessionID, userID string) chan domain.NotificationEvent
+	Unsubscribe(chan domain.NotificationEvent)
+	// ReplayEvents 返回该用户在场次内 ID 大于 afterID 的事件，用于断线重放。
+	// afterID 大于日志中最大 ID 时 (日志已重置) 视为从头重放保留的事件
+	ReplayEvents(ctx context.Context, sessionID, userID string, afterID uint64) ([]domain.NotificationEvent, error)
+	NotificationStats() HubStats
 
 	// Chat / IM
 	SendChatMessage(ctx context.Context, senderID string, req SendChatMessageRequest) (*domain.ChatMessage, error)
-	GetChatHistory(ctx context.Context, sessionID, userA, userB string) ([]domain.ChatMessage, error)
+	// GetChatHistory 按游标分页，每页由新到旧排列
+	GetChatHistory(ctx context.Context, sessionID, userA, userB string, page ChatHistoryPage) ([]domain.ChatMessage, error)
 	MarkChatAsRead(ctx context.Context, sessionID, senderID, receiverID string) error
+	// EditChatMessage / RetractChatMessage 仅限发送方在编辑时限内操作
+	// EditChatMessage 的 mentions 替换原有提及，须符合新正文
+	EditChatMessage(ctx context.Context, sessionID, userID, messageID, content string, mentions []domain.ChatMention) (*domain.ChatMessage, error)
+	RetractChatMessage(ctx context.Context, sessionID, userID, messageID string) (*domain.ChatMessage, error)
+	GetChatMessageEdits(ctx context.Context, sessionID, userID, messageID string) ([]domain.ChatMessageEdit, error)
+
+	// Conversations: 单聊沿用逐条已读标记，群聊与频道按成员阅读位置计算未读
+	CreateConversation(ctx context.Context, sessionID, creatorID string, req CreateConversationRequest) (*domain.Conversation, error)
+	ListConversations(ctx context.Context, sessionID, userID string) ([]domain.Conversation, error)
+	GetConversation(ctx context.Context, sessionID, userID, conversationID string) (*domain.Conversation, error)
+	AddConversationMembers(ctx context.Context, sessionID, actorID, conversationID string, userIDs []string) (*domain.Conversation, error)
+	// RemoveConversationMember 管理员移除成员，或成员自行退出
+	RemoveConversationMember(ctx context.Context, sessionID, actorID, conversationID, userID string) (*domain.Conversation, error)
+	SetConversationMemberRole(ctx context.Context, sessionID, actorID, conversationID, userID, role string) (*domain.Conversation, error)
+	GetConversationHistory(ctx context.Context, sessionID, userID, conversationID string, page ChatHistoryPage) ([]domain.ChatMessage, error)
+	MarkConversationRead(ctx context.Context, sessionID, userID, conversationID string) error
+	// NotifyTyping 向会话对方推送输入状态，不落库
+	NotifyTyping(ctx context.Context, sessionID, senderID, receiverID string, typing bool) error
 
 	// Summary
 	GetUserSummary(ctx context.Context, sessionID, userID string) (*UserSummary, error)
+	// ListChatThreads 返回用户有过消息往来的单聊与所在群聊、频道，按最近消息由新到旧排列
+	ListChatThreads(ctx context.Context, sessionID, userID string) ([]ChatThread, error)
+	// EscalateChat 将一段聊天记录整理为富文本文电发出，聊天附件以引用方式带入
+	EscalateChat(ctx context.Context, sessionID, userID string, req EscalateChatRequest) (*domain.Mail, error)
+	// SearchChat 在用户所在的会话中检索消息，命中结果由新到旧排列并附带上下文
+	SearchChat(ctx context.Context, sessionID, userID string, query ChatSearchQuery) ([]ChatSearchHit, error)
 	// System / Admin
 	SyncSessions(ctx context.Context, activeSessionIDs []string) (int64, error)
+
+	// Clone / Snapshot
+	CloneSession(ctx context.Context, sessionID string, req CloneSessionRequest) error
+	CreateSnapshot(ctx context.Context, sessionID, name string) (*domain.SessionSnapshot, error)
+	ListSnapshots(ctx context.Context, sessionID string) ([]domain.SessionSnapshot, error)
+	RestoreSnapshot(ctx context.Context, sessionID, snapshotID string) error
+	DeleteSnapshot(ctx context.Context, sessionID, snapshotID string) error
+}
+
+type CloneSessionRequest struct {
+	TargetSessionID string
+	ResetRead       bool
+}
+
+type SessionService interface {
+	CreateSession(ctx context.Context, req SessionRequest) (*domain.Session, error)
+	GetSession(ctx context.Context, id string) (*domain.Session, error)
+	ListSessions(ctx context.Context, status string) ([]domain.Session, error)
+	UpdateSession(ctx context.Context, id string, req SessionRequest) (*domain.Session, error)
+	ChangeStatus(ctx context.Context, id, status string) (*domain.Session, error)
+}
+
+// SessionClock 提供场次的演练时间 (想定时间)
+type SessionClock interface {
+	Now(ctx context.Context, sessionID string) time.Time
+}
+
+type ClockService interface {
+	SessionClock
+	GetClock(ctx context.Context, sessionID string) (*ClockState, error)
+	SetClock(ctx context.Context, sessionID string, req SetClockRequest) (*ClockState, error)
+	PauseClock(ctx context.Context, sessionID string) (*ClockState, error)
+	ResumeClock(ctx context.Context, sessionID string) (*ClockState, error)
 }
 
+type ClockState struct {
+	SessionID     string    `json:"session_id"`
+	RealTime      time.Time `json:"real_time"`
+	SimulatedTime time.Time `json:"simulated_time"`
+	Speed         float64   `json:"speed"`
+	Paused        bool      `json:"paused"`
+}
+
+// SetClockRequest 未提供的字段保持当前值
+type SetClockRequest struct {
+	SimulatedTime *time.Time `json:"simulated_time"`
+	Speed         *float64   `json:"speed"`
+}
+
+// SessionRequest 更新场次时未提供的字段保持不变：Name 为空、Description / Participants 为 nil 时不修改
+type SessionRequest struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Description  *string  `json:"description"`
+	Participants []string `json:"participants"`
+	// RelayAddresses 为 nil 时保持不变，空 map 表示清除全部外部邮箱映射
+	RelayAddresses map[string]string `json:"relay_addresses"`
+}
+
+// SendChatMessageRequest ConversationID 与 ReceiverID 二选一，仅指定 ReceiverID 时发往单聊。
+// ReplyToID 须为同一会话中未撤回的消息，Mentions 须为会话成员
 type SendChatMessageRequest struct {
-	SessionID   string
-	ReceiverID  string
-	Content     string
-	Attachments []AttachmentRequest
+	SessionID      string
+	ConversationID string
+	ReceiverID     string
+	Content        string
+	ReplyToID      string
+	Mentions       []domain.ChatMention
+	Attachments    []AttachmentRequest
+}
+
+type CreateConversationRequest struct {
+	Type    string   `json:"type"` // direct, group, channel
+	Name    string   `json:"name"`
+	Members []string `json:"members"` // 不含创建者；单聊为对方 ID，频道无需指定
+}
+
+// ChatThread 最近会话列表中的一项: 单聊以 PeerID 标识，群聊与频道以 ConversationID 标识
+type ChatThread struct {
+	Type           string    `json:"type"`
+	PeerID         string    `json:"peer_id,omitempty"`
+	ConversationID string    `json:"conversation_id,omitempty"`
+	Name           string    `json:"name,omitempty"`
+	LastMessageID  string    `json:"last_message_id"`
+	LastSenderID   string    `json:"last_sender_id"`
+	LastMessage    string    `json:"last_message"`    // 正文预览，已撤回时为空
+	LastRetracted  bool      `json:"last_retracted"`  // 最后一条消息已撤回
+	LastMessageAt  time.Time `json:"last_message_at"` // 演练时间
+	UnreadCount    int       `json:"unread_count"`
+}
+
+// ChatSearchHit 检索命中的消息及其前后的上下文消息，上下文按时间先后排列
+type ChatSearchHit struct {
+	Message domain.ChatMessage   `json:"message"`
+	Before  []domain.ChatMessage `json:"before"`
+	After   []domain.ChatMessage `json:"after"`
 }
 
 type UserSummary struct {
 	UnreadMailCount int64          `json:"unread_mail_count"`
-	IMUnreadCounts  map[string]int `json:"im_unread_counts"`
+	IMUnreadCounts  map[string]int `json:"im_unread_counts"` // 单聊未读，按发送方
+	// ConversationUnreadCounts 群聊与频道未读，按会话 ID
+	ConversationUnreadCounts map[string]int `json:"conversation_unread_counts"`
 }
 
 type StorageService interface {
@@ -46,10 +224,37 @@ type StorageService interface {
 	GetFile(ctx context.Context, path string) (io.ReadCloser, error)
 	DeleteFile(ctx context.Context, path string) error
 	DeleteSessionDir(ctx context.Context, sessionID string) error
+	CopySessionDir(ctx context.Context, srcSessionID, dstSessionID string) error
+	ReplaceSessionDir(ctx context.Context, srcSessionID, dstSessionID string) error
+	SessionDirExists(ctx context.Context, sessionID string) (bool, error)
+}
+
+// MailRelay 将文电副本以 MIME 邮件形式转发到外部邮箱 (SMTP smarthost)
+type MailRelay interface {
+	Relay(ctx context.Context, msg RelayMessage) error
+}
+
+type RelayMessage struct {
+	MessageID   string
+	SessionID   string
+	SenderID    string
+	To          string // 外部邮箱地址
+	Subject     string
+	Content     string
+	ContentType string
+	Date        time.Time
+	Attachments []RelayAttachment
+}
+
+type RelayAttachment struct {
+	FileName string
+	MimeType string
+	Data     []byte
 }
 
 type SendMailRequest struct {
 	SessionID   string
+	ParentID    *string // 回复的原文电 ID
 	Subject     string
 	Content     string
 	ContentType string
@@ -57,6 +262,21 @@ type SendMailRequest struct {
 	Cc          []string
 	Bcc         []string
 	Attachments []AttachmentRequest
+	// SharedAttachments 引用已存储的文件，不重新上传，发送失败时也不删除
+	SharedAttachments []domain.Attachment
+}
+
+// EscalateChatRequest 将一段聊天记录转为文电。PeerID 与 ConversationID 二选一；
+// 记录范围为 FromMessageID 至 ToMessageID (含两端)，ToMessageID 为空时截至最新消息
+type EscalateChatRequest struct {
+	PeerID         string   `json:"peer_id"`
+	ConversationID string   `json:"conversation_id"`
+	FromMessageID  string   `json:"from_id"`
+	ToMessageID    string   `json:"to_id"`
+	Subject        string   `json:"subject"`
+	Note           string   `json:"note"` // 附在记录前的说明
+	To             []string `json:"to"`
+	Cc             []string `json:"cc"`
 }
 
 type AttachmentRequest struct {
@@ -65,3 +285,163 @@ type AttachmentRequest struct {
 	Size     int64
 	MimeType string
 }
+
+type ScenarioService interface {
+	CreateScenario(ctx context.Context, definition []byte) (*domain.Scenario, error)
+	GetScenario(ctx context.Context, id string) (*domain.Scenario, *domain.ScenarioDefinition, error)
+	ListScenarios(ctx context.Context) ([]domain.Scenario, error)
+	DeleteScenario(ctx context.Context, id string) error
+
+	// Run control (per session)
+	StartRun(ctx context.Context, sessionID, scenarioID string) (*ScenarioRunStatus, error)
+	PauseRun(ctx context.Context, sessionID string) (*ScenarioRunStatus, error)
+	ResumeRun(ctx context.Context, sessionID string) (*ScenarioRunStatus, error)
+	StopRun(ctx context.Context, sessionID string) (*ScenarioRunStatus, error)
+	GetRunStatus(ctx context.Context, sessionID string) (*ScenarioRunStatus, error)
+}
+
+type ScenarioRunStatus struct {
+	SessionID  string               `json:"session_id"`
+	ScenarioID string               `json:"scenario_id"`
+	Status     string               `json:"status"` // running, paused, stopped, completed
+	StartedAt  time.Time            `json:"started_at"`
+	Elapsed    string               `json:"elapsed"`
+	Injects    []ScenarioInjectStat `json:"injects"`
+}
+
+type ScenarioInjectStat struct {
+	ID       string     `json:"id"`
+	State    string     `json:"state"` // pending, sent, skipped, failed
+	FiredAt  *time.Time `json:"fired_at,omitempty"`
+	TargetID string     `json:"target_id,omitempty"` // 生成的文电或消息 ID
+	Error    string     `json:"error,omitempty"`
+}
+
+type ReportService interface {
+	BuildSessionReport(ctx context.Context, sessionID string) (*SessionReport, error)
+}
+
+// SessionReport 场次复盘报告
+type SessionReport struct {
+	Session     *domain.Session `json:"session"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Timeline    []TimelineEvent `json:"timeline"`
+	Users       []UserMetrics   `json:"users"`
+}
+
+// TimelineEvent 复盘时间线中的一条事件，Time 为演练时间
+type TimelineEvent struct {
+	Time     time.Time  `json:"time"`
+	RealTime *time.Time `json:"real_time,omitempty"`
+	Type     string     `json:"type"` // MAIL_SENT, MAIL_READ, MAIL_DELETED, CHAT_SENT, CHAT_READ, CHAT_RETRACTED
+	ActorID  string     `json:"actor_id"`
+	Targets  []string   `json:"targets,omitempty"`
+	RefID    string     `json:"ref_id"` // 文电或消息 ID
+	Summary  string     `json:"summary"`
+}
+
+type UserMetrics struct {
+	UserID           string           `json:"user_id"`
+	MailsSent        int              `json:"mails_sent"`
+	MailsReceived    int              `json:"mails_received"`
+	ChatsSent        int              `json:"chats_sent"`
+	ChatsReceived    int              `json:"chats_received"`
+	MedianTimeToRead *float64         `json:"median_time_to_read_seconds"` // 无已读记录时为 null
+	UnansweredCount  int              `json:"unanswered_count"`
+	UnansweredItems  []UnansweredItem `json:"unanswered_items"`
+}
+
+type UnansweredItem struct {
+	Type       string    `json:"type"` // mail, chat
+	ID         string    `json:"id"`
+	FromID     string    `json:"from_id"`
+	ReceivedAt time.Time `json:"received_at"`
+	Summary    string    `json:"summary"`
+}
+
+type StatsService interface {
+	GetVolume(ctx context.Context, sessionID string, bucket time.Duration) ([]VolumeBucket, error)
+	GetCommunicationMatrix(ctx context.Context, sessionID string, top int) (*CommunicationStats, error)
+	GetAttachmentStats(ctx context.Context, sessionID string) ([]AttachmentStat, error)
+	GetUnreadBacklog(ctx context.Context, sessionID string) ([]BacklogEntry, error)
+	GetLatencyDistribution(ctx context.Context, sessionID string, bounds []int) (*LatencyDistribution, error)
+}
+
+// VolumeBucket 某一时间桶内的文电与消息数量，BucketStart 为演练时间
+type VolumeBucket struct {
+	BucketStart time.Time `json:"bucket_start"`
+	Mails       int64     `json:"mails"`
+	Chats       int64     `json:"chats"`
+}
+
+// MatrixCell 通信矩阵中 发送方 -> 接收方 的通信量
+type MatrixCell struct {
+	SenderID    string `json:"sender_id"`
+	RecipientID string `json:"recipient_id"`
+	Mails       int64  `json:"mails"`
+	Chats       int64  `json:"chats"`
+}
+
+type UserVolume struct {
+	UserID string `json:"user_id"`
+	Total  int64  `json:"total"`
+}
+
+type CommunicationStats struct {
+	Matrix        []MatrixCell `json:"matrix"`
+	TopSenders    []UserVolume `json:"top_senders"`
+	TopRecipients []UserVolume `json:"top_recipients"`
+}
+
+type AttachmentStat struct {
+	MimeType  string `json:"mime_type"`
+	Count     int64  `json:"count"`
+	TotalSize int64  `json:"total_size"`
+}
+
+type BacklogEntry struct {
+	UserID      string `json:"user_id"`
+	UnreadMails int64  `json:"unread_mails"`
+	UnreadChats int64  `json:"unread_chats"`
+}
+
+// LatencyBucket 耗时分布中的一个桶，LeSeconds 为桶上界 (秒)，-1 表示超出最大上界
+type LatencyBucket struct {
+	LeSeconds int   `json:"le_seconds"`
+	Mails     int64 `json:"mails"`
+	Chats     int64 `json:"chats"`
+}
+
+// LatencyDistribution 响应耗时分布: Reply 为收到文电/消息到作出回复，Read 为送达到阅读
+type LatencyDistribution struct {
+	Reply []LatencyBucket `json:"reply"`
+	Read  []LatencyBucket `json:"read"`
+}
+
+type WebhookRequest struct {
+	SessionID string   `json:"session_id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Secret    string   `json:"secret"` // 为空时自动生成
+}
+
+// WebhookPublisher 接收 MailService 广播的事件，排入 webhook 投递队列
+type WebhookPublisher interface {
+	Publish(ctx context.Context, event, sessionID string, payload []byte) error
+}
+
+type WebhookService interface {
+	WebhookPublisher
+	CreateWebhook(ctx context.Context, req WebhookRequest) (*domain.Webhook, error)
+	ListWebhooks(ctx context.Context, sessionID string) ([]domain.Webhook, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	ListDeliveries(ctx context.Context, filter WebhookDeliveryFilter, page, pageSize int) ([]domain.WebhookDelivery, int64, error)
+	// Redeliver 将死信重新排入投递队列
+	Redeliver(ctx context.Context, deliveryID string) (*domain.WebhookDelivery, error)
+}
+
+type AuditService interface {
+	Record(ctx context.Context, entry domain.AuditLog) error
+	Query(ctx context.Context, filter AuditFilter, page, pageSize int) ([]domain.AuditLog, int64, error)
+	Export(ctx context.Context, filter AuditFilter, fn func([]domain.AuditLog) error) error
+}
@@ -0,0 +1,79 @@
+package fsutil
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// CopyDir 递归复制目录 src 到 dst，源目录不存在时视为空目录直接返回
+func CopyDir(src, dst string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// ReplaceDir 以 src 目录整体替换 dst 目录。先将 dst 改名留作备份，src 改名失败时恢复原目录；
+// src 不存在时视为空目录，仅删除 dst
+func ReplaceDir(src, dst string) error {
+	backup := dst + ".replaced"
+	if err := os.RemoveAll(backup); err != nil {
+		return err
+	}
+	if err := os.Rename(dst, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil && !os.IsNotExist(err) {
+		_ = os.Rename(backup, dst)
+		return err
+	}
+	return os.RemoveAll(backup)
+}
+
+// DirExists 判断目录是否存在
+func DirExists(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	service ports.AuditService
+}
+
+func NewAuditHandler(service ports.AuditService) *AuditHandler {
+	return &AuditHandler{service: service}
+}
+
+// recordAudit 补全请求来源后写入审计记录。审计写入失败只记录日志，不影响业务请求
+func recordAudit(c *gin.Context, audit ports.AuditService, entry domain.AuditLog) {
+	if audit == nil {
+		return
+	}
+	entry.IP = c.ClientIP()
+	entry.UserAgent = c.Request.UserAgent()
+	if err := audit.Record(c.Request.Context(), entry); err != nil {
+		fmt.Printf("[Audit] Failed to record %s by %s: %v\n", entry.Action, entry.ActorID, err)
+	}
+}
+
+// QueryAudit 分页查询审计记录，支持 session_id、actor_id、action、target_id、from、to (RFC3339) 过滤
+func (h *AuditHandler) QueryAudit(c *gin.Context) {
+	filter, ok := parseAuditFilter(c)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+
+	logs, total, err := h.service.Query(c.Request.Context(), filter, page, pageSize)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": logs, "total": total, "page": page, "page_size": pageSize})
+}
+
+// ExportAudit 导出全部匹配的审计记录，format=csv (默认) 或 json (每行一条记录)
+func (h *AuditHandler) ExportAudit(c *gin.Context) {
+	filter, ok := parseAuditFilter(c)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported format"})
+		return
+	}
+
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().Format("20060102-150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	var write func([]domain.AuditLog) error
+	if format == "json" {
+		c.Header("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(c.Writer)
+		write = func(batch []domain.AuditLog) error {
+			for _, entry := range batch {
+				if err := enc.Encode(entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"time", "session_id", "actor_id", "action", "target_id", "detail", "ip", "user_agent"})
+		write = func(batch []domain.AuditLog) error {
+			for _, e := range batch {
+				w.Write([]string{e.CreatedAt.Format(time.RFC3339), e.SessionID, e.ActorID, e.Action, e.TargetID, e.Detail, e.IP, e.UserAgent})
+			}
+			w.Flush()
+			return w.Error()
+		}
+	}
+
+	if err := h.service.Export(c.Request.Context(), filter, write); err != nil {
+		// 响应头可能已发送，只能记录日志
+		fmt.Printf("[Audit] Export failed: %v\n", err)
+		if !c.Writer.Written() {
+			respondError(c, err)
+		}
+	}
+}
+
+func parseAuditFilter(c *gin.Context) (ports.AuditFilter, bool) {
+	filter := ports.AuditFilter{
+		SessionID: c.Query("session_id"),
+		ActorID:   c.Query("actor_id"),
+		Action:    c.Query("action"),
+		TargetID:  c.Query("target_id"),
+	}
+	var ok bool
+	if filter.From, ok = parseTimeQuery(c, "from"); !ok {
+		return filter, false
+	}
+	filter.To, ok = parseTimeQuery(c, "to")
+	return filter, ok
+}
+
+// parseTimeQuery 解析 RFC3339 格式的查询参数，缺省时返回 nil，格式错误时直接响应 400
+func parseTimeQuery(c *gin.Context, key string) (*time.Time, bool) {
+	raw := c.Query(key)
+	if raw == "" {
+		return nil, true
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + key + " (RFC3339 expected)"})
+		return nil, false
+	}
+	return &t, true
+}
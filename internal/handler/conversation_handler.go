@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Conversation Handlers: 群聊与频道的建立及成员管理，消息收发仍走 /im 接口
+
+func (h *MailHandler) CreateConversation(c *gin.Context) {
+	var req ports.CreateConversationRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	sessionID, userID := requestSessionID(c), h.requestUserID(c)
+
+	conv, err := h.service.CreateConversation(c.Request.Context(), sessionID, userID, req)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: userID, Action: domain.AuditActionConversationCreate, TargetID: conv.ID,
+		Detail: conv.Type + " " + strings.Join(conv.MemberIDs(""), ","),
+	})
+	c.JSON(http.StatusCreated, conv)
+}
+
+func (h *MailHandler) ListConversations(c *gin.Context) {
+	convs, err := h.service.ListConversations(c.Request.Context(), requestSessionID(c), h.requestUserID(c))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, convs)
+}
+
+func (h *MailHandler) GetConversation(c *gin.Context) {
+	conv, err := h.service.GetConversation(c.Request.Context(), requestSessionID(c), h.requestUserID(c), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, conv)
+}
+
+func (h *MailHandler) AddConversationMembers(c *gin.Context) {
+	var req struct {
+		UserIDs []string `json:"user_ids"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	sessionID, userID := requestSessionID(c), h.requestUserID(c)
+
+	conv, err := h.service.AddConversationMembers(c.Request.Context(), sessionID, userID, c.Param("id"), req.UserIDs)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: userID, Action: domain.AuditActionConversationUpdate, TargetID: conv.ID,
+		Detail: "add " + strings.Join(req.UserIDs, ","),
+	})
+	c.JSON(http.StatusOK, conv)
+}
+
+// RemoveConversationMember 成员可移除自己 (退出群聊)
+func (h *MailHandler) RemoveConversationMember(c *gin.Context) {
+	sessionID, userID := requestSessionID(c), h.requestUserID(c)
+
+	conv, err := h.service.RemoveConversationMember(c.Request.Context(), sessionID, userID, c.Param("id"), c.Param("user_id"))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: userID, Action: domain.AuditActionConversationUpdate, TargetID: conv.ID,
+		Detail: "remove " + c.Param("user_id"),
+	})
+	c.JSON(http.StatusOK, conv)
+}
+
+func (h *MailHandler) SetConversationMemberRole(c *gin.Context) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	sessionID, userID := requestSessionID(c), h.requestUserID(c)
+
+	conv, err := h.service.SetConversationMemberRole(c.Request.Context(), sessionID, userID, c.Param("id"), c.Param("user_id"), req.Role)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: userID, Action: domain.AuditActionConversationUpdate, TargetID: conv.ID,
+		Detail: req.Role + " " + c.Param("user_id"),
+	})
+	c.JSON(http.StatusOK, conv)
+}
+
+// requestUserID 当前用户，未指定时使用默认用户
+func (h *MailHandler) requestUserID(c *gin.Context) string {
+	if userID := c.Query("user_id"); userID != "" {
+		return userID
+	}
+	return h.DefaultSenderID
+}
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"raven/internal/core/domain"
 	"raven/internal/core/ports"
 
 	"github.com/gin-gonic/gin"
@@ -21,19 +22,36 @@ import (
 type MailHandler struct {
 	service         ports.MailService
 	storage         ports.StorageService
+	audit           ports.AuditService
+	presence        ports.PresenceService
 	OnlyOfficeHost  string
 	DefaultSenderID string
+	AllowedOrigins  []string // 允许建立 WebSocket 的跨站来源，同源请求始终允许
 }
 
-func NewMailHandler(service ports.MailService, storage ports.StorageService, onlyOfficeHost string, defaultSenderID string) *MailHandler {
+func NewMailHandler(service ports.MailService, storage ports.StorageService, audit ports.AuditService, onlyOfficeHost string, defaultSenderID string) *MailHandler {
 	return &MailHandler{
 		service:         service,
 		storage:         storage,
+		audit:           audit,
 		OnlyOfficeHost:  onlyOfficeHost,
 		DefaultSenderID: defaultSenderID,
 	}
 }
 
+// SetPresence 启用在线状态跟踪，SSE/WebSocket 连接会登记为用户在线
+func (h *MailHandler) SetPresence(presence ports.PresenceService) {
+	h.presence = presence
+}
+
+// trackConnection 登记推送连接，返回断开时调用的函数
+func (h *MailHandler) trackConnection(c *gin.Context, sessionID, userID string) func() {
+	if h.presence == nil {
+		return func() {}
+	}
+	return h.presence.Connect(c.Request.Context(), sessionID, userID)
+}
+
 // SendMail handles sending a new mail with attachments
 func (h *MailHandler) SendMail(c *gin.Context) {
 	// Multipart form
@@ -80,6 +98,9 @@ func (h *MailHandler) SendMail(c *gin.Context) {
 		Bcc:         filterEmpty(bcc),
 		Attachments: attachmentReqs,
 	}
+	if parentID := c.PostForm("parent_id"); parentID != "" {
+		req.ParentID = &parentID
+	}
 
 	senderID := c.Query("user_id") // Temporary for simulation
 	if senderID == "" {
@@ -91,6 +112,9 @@ func (h *MailHandler) SendMail(c *gin.Context) {
 		h.respondError(c, err)
 		return
 	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: senderID, Action: domain.AuditActionMailSend, TargetID: mail.ID, Detail: mail.Subject,
+	})
 
 	c.JSON(http.StatusOK, mail)
 }
@@ -147,6 +171,9 @@ func (h *MailHandler) DeleteMail(c *gin.Context) {
 		h.respondError(c, err)
 		return
 	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: userID, Action: domain.AuditActionMailDelete, TargetID: id,
+	})
 
 	c.Status(http.StatusNoContent)
 }
@@ -164,6 +191,9 @@ func (h *MailHandler) GetMail(c *gin.Context) {
 		h.respondError(c, err)
 		return
 	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: userID, Action: domain.AuditActionMailRead, TargetID: id,
+	})
 	c.JSON(http.StatusOK, mail)
 }
 
@@ -198,6 +228,9 @@ func (h *MailHandler) DownloadAttachment(c *gin.Context) {
 		return
 	}
 	defer f.Close()
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: c.Query("user_id"), Action: domain.AuditActionAttachmentDownload, TargetID: att.ID, Detail: att.FileName,
+	})
 
 	// Use original filename
 	filename := att.FileName
@@ -232,6 +265,9 @@ func (h *MailHandler) downloadByPath(c *gin.Context, path string) {
 		return
 	}
 	defer f.Close()
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: c.GetHeader("X-Session-ID"), ActorID: c.Query("user_id"), Action: domain.AuditActionAttachmentDownload, Detail: path,
+	})
 
 	fileName := filepath.Base(path)
 	// Legacy fallback, simple disposition
@@ -351,6 +387,15 @@ func (h *MailHandler) OnlyOfficeCallback(c *gin.Context) {
 
 		io.Copy(out, resp.Body)
 		fmt.Printf("[OnlyOffice] Document %s (status %v) saved successfully to %s\n", key, status, filePath)
+
+		// ONLYOFFICE 回调的 users 字段为参与编辑的用户列表
+		var actorID string
+		if users, ok := body["users"].([]interface{}); ok && len(users) > 0 {
+			actorID, _ = users[0].(string)
+		}
+		recordAudit(c, h.audit, domain.AuditLog{
+			SessionID: sessionID, ActorID: actorID, Action: domain.AuditActionOnlyOfficeSave, TargetID: key, Detail: fmt.Sprintf("status=%v", status),
+		})
 	}
 
 	c.JSON(200, gin.H{"error": 0})
@@ -371,18 +416,59 @@ func (h *MailHandler) DeleteSession(c *gin.Context) {
 		h.respondError(c, err)
 		return
 	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: c.Query("user_id"), Action: domain.AuditActionSessionDelete, TargetID: sessionID,
+	})
 
 	c.JSON(http.StatusOK, gin.H{"message": "session deleted successfully"})
 }
 
+// StreamNotifications SSE 推送。重连时携带 Last-Event-ID 头 (或 last_event_id 参数) 可补齐断线期间的事件
 func (h *MailHandler) StreamNotifications(c *gin.Context) {
+	sessionID := requestSessionID(c)
+	userID := c.Query("user_id")
+	if userID == "" {
+		userID = h.DefaultSenderID
+	}
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	var resumeID uint64
+	if lastID != "" {
+		id, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Last-Event-ID"})
+			return
+		}
+		resumeID = id
+	}
+
+	// 先订阅再读取日志，衔接处重复的事件按本连接已发送的 ID 跳过。
+	// 不以客户端的 Last-Event-ID 过滤实时事件：日志重置后新事件的 ID 可能更小
+	ch := h.service.Subscribe(sessionID, userID)
+	defer h.service.Unsubscribe(ch)
+	defer h.trackConnection(c, sessionID, userID)()
+	var backlog []domain.NotificationEvent
+	if resumeID > 0 {
+		var err error
+		if backlog, err = h.service.ReplayEvents(c.Request.Context(), sessionID, userID, resumeID); err != nil {
+			h.respondError(c, err)
+			return
+		}
+	}
+	var sent uint64
+
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 	c.Header("Access-Control-Allow-Origin", "*")
-
-	ch := h.service.Subscribe()
-	defer h.service.Unsubscribe(ch)
+	c.Status(http.StatusOK)
+	for _, ev := range backlog {
+		writeSSEEvent(c.Writer, ev)
+		sent = ev.ID
+	}
+	c.Writer.Flush()
 
 	// Ping to keep connection alive
 	ticker := time.NewTicker(30 * time.Second)
@@ -390,11 +476,17 @@ func (h *MailHandler) StreamNotifications(c *gin.Context) {
 
 	c.Stream(func(w io.Writer) bool {
 		select {
-		case msg, ok := <-ch:
+		case ev, ok := <-ch:
 			if !ok {
 				return false
 			}
-			c.SSEvent("message", msg)
+			if ev.ID != 0 && ev.ID <= sent {
+				return true
+			}
+			writeSSEEvent(w, ev)
+			if ev.ID != 0 {
+				sent = ev.ID
+			}
 			return true
 		case <-ticker.C:
 			c.SSEvent("ping", "keep-alive")
@@ -405,13 +497,35 @@ func (h *MailHandler) StreamNotifications(c *gin.Context) {
 	})
 }
 
+// GetNotificationStats 推送分发统计 (订阅数、丢弃与断开计数)
+func (h *MailHandler) GetNotificationStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.NotificationStats())
+}
+
+// writeSSEEvent 输出带 id 的 message 事件，浏览器据此维护 Last-Event-ID
+func writeSSEEvent(w io.Writer, ev domain.NotificationEvent) {
+	if ev.ID != 0 {
+		fmt.Fprintf(w, "id:%d\n", ev.ID)
+	}
+	fmt.Fprintf(w, "event:message\ndata:%s\n\n", ev.Data)
+}
+
 // Chat / IM Handlers
 
 func (h *MailHandler) SendChatMessage(c *gin.Context) {
-	// Multipart form
+	// Multipart form，conversation_id 与 receiver_id 二选一；mentions 为 JSON 数组
+	conversationID := c.PostForm("conversation_id")
 	receiverID := c.PostForm("receiver_id")
 	content := c.PostForm("content")
 
+	var mentions []domain.ChatMention
+	if raw := c.PostForm("mentions"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mentions); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid mentions"})
+			return
+		}
+	}
+
 	// Attachments
 	form, _ := c.MultipartForm()
 	files := form.File["attachments"]
@@ -444,10 +558,13 @@ func (h *MailHandler) SendChatMessage(c *gin.Context) {
 	}
 
 	req := ports.SendChatMessageRequest{
-		SessionID:   sessionID,
-		ReceiverID:  receiverID,
-		Content:     content,
-		Attachments: attachmentReqs,
+		SessionID:      sessionID,
+		ConversationID: conversationID,
+		ReceiverID:     receiverID,
+		Content:        content,
+		ReplyToID:      c.PostForm("reply_to_id"),
+		Mentions:       mentions,
+		Attachments:    attachmentReqs,
 	}
 
 	msg, err := h.service.SendChatMessage(c.Request.Context(), senderID, req)
@@ -455,14 +572,26 @@ func (h *MailHandler) SendChatMessage(c *gin.Context) {
 		h.respondError(c, err)
 		return
 	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: senderID, Action: domain.AuditActionChatSend, TargetID: msg.ID, Detail: chatAuditDetail(msg),
+	})
 
 	c.JSON(http.StatusOK, msg)
 }
 
+// chatAuditDetail 单聊记录接收方，群聊与频道记录会话 ID
+func chatAuditDetail(msg *domain.ChatMessage) string {
+	if msg.ReceiverID == "" {
+		return "to conversation " + msg.ConversationID
+	}
+	return "to " + msg.ReceiverID
+}
+
 func (h *MailHandler) GetChatHistory(c *gin.Context) {
+	conversationID := c.Query("conversation_id")
 	otherID := c.Query("other_id")
-	if otherID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "other_id required"})
+	if otherID == "" && conversationID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "other_id or conversation_id required"})
 		return
 	}
 
@@ -476,7 +605,17 @@ func (h *MailHandler) GetChatHistory(c *gin.Context) {
 		userID = h.DefaultSenderID
 	}
 
-	msgs, err := h.service.GetChatHistory(c.Request.Context(), sessionID, userID, otherID)
+	// 游标为消息 ID：before 向更早翻页，after 取更新的消息
+	page := ports.ChatHistoryPage{Before: c.Query("before"), After: c.Query("after")}
+	page.Limit, _ = strconv.Atoi(c.Query("limit"))
+
+	var msgs []domain.ChatMessage
+	var err error
+	if conversationID != "" {
+		msgs, err = h.service.GetConversationHistory(c.Request.Context(), sessionID, userID, conversationID, page)
+	} else {
+		msgs, err = h.service.GetChatHistory(c.Request.Context(), sessionID, userID, otherID, page)
+	}
 	if err != nil {
 		h.respondError(c, err)
 		return
@@ -486,9 +625,10 @@ func (h *MailHandler) GetChatHistory(c *gin.Context) {
 }
 
 func (h *MailHandler) MarkChatAsRead(c *gin.Context) {
+	conversationID := c.Query("conversation_id")
 	senderID := c.Query("sender_id")
-	if senderID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "sender_id required"})
+	if senderID == "" && conversationID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sender_id or conversation_id required"})
 		return
 	}
 
@@ -502,7 +642,95 @@ func (h *MailHandler) MarkChatAsRead(c *gin.Context) {
 		receiverID = h.DefaultSenderID
 	}
 
-	if err := h.service.MarkChatAsRead(c.Request.Context(), sessionID, senderID, receiverID); err != nil {
+	var err error
+	if conversationID != "" {
+		err = h.service.MarkConversationRead(c.Request.Context(), sessionID, receiverID, conversationID)
+	} else {
+		err = h.service.MarkChatAsRead(c.Request.Context(), sessionID, senderID, receiverID)
+	}
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	target := senderID
+	if conversationID != "" {
+		target = conversationID
+	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: receiverID, Action: domain.AuditActionChatRead, TargetID: target,
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// EditChatMessage 修改本人发出的消息正文及提及 (PUT /im/messages/:id)
+func (h *MailHandler) EditChatMessage(c *gin.Context) {
+	var req struct {
+		Content  string               `json:"content"`
+		Mentions []domain.ChatMention `json:"mentions"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	sessionID, userID := requestSessionID(c), h.requestUserID(c)
+
+	msg, err := h.service.EditChatMessage(c.Request.Context(), sessionID, userID, c.Param("id"), req.Content, req.Mentions)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: userID, Action: domain.AuditActionChatEdit, TargetID: msg.ID,
+	})
+	c.JSON(http.StatusOK, msg)
+}
+
+// RetractChatMessage 撤回本人发出的消息 (DELETE /im/messages/:id)
+func (h *MailHandler) RetractChatMessage(c *gin.Context) {
+	sessionID, userID := requestSessionID(c), h.requestUserID(c)
+
+	msg, err := h.service.RetractChatMessage(c.Request.Context(), sessionID, userID, c.Param("id"))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: userID, Action: domain.AuditActionChatRetract, TargetID: msg.ID,
+	})
+	c.JSON(http.StatusOK, msg)
+}
+
+func (h *MailHandler) GetChatMessageEdits(c *gin.Context) {
+	edits, err := h.service.GetChatMessageEdits(c.Request.Context(), requestSessionID(c), h.requestUserID(c), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, edits)
+}
+
+// NotifyTyping 上报输入状态，typing=false 表示停止输入。服务端节流并在长时间未上报时自动推送停止输入
+func (h *MailHandler) NotifyTyping(c *gin.Context) {
+	receiverID := c.Query("receiver_id")
+	if receiverID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "receiver_id required"})
+		return
+	}
+	typing := true
+	if v := c.Query("typing"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid typing"})
+			return
+		}
+		typing = parsed
+	}
+	userID := c.Query("user_id")
+	if userID == "" {
+		userID = h.DefaultSenderID
+	}
+
+	if err := h.service.NotifyTyping(c.Request.Context(), requestSessionID(c), userID, receiverID, typing); err != nil {
 		h.respondError(c, err)
 		return
 	}
@@ -529,6 +757,57 @@ func (h *MailHandler) GetUserSummary(c *gin.Context) {
 	c.JSON(http.StatusOK, summary)
 }
 
+// EscalateChat 将一段聊天记录转为正式文电
+func (h *MailHandler) EscalateChat(c *gin.Context) {
+	var req ports.EscalateChatRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	sessionID, userID := requestSessionID(c), h.requestUserID(c)
+
+	mail, err := h.service.EscalateChat(c.Request.Context(), sessionID, userID, req)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		SessionID: sessionID, ActorID: userID, Action: domain.AuditActionChatEscalate, TargetID: mail.ID,
+		Detail: fmt.Sprintf("messages %s..%s to %s", req.FromMessageID, req.ToMessageID, strings.Join(req.To, ",")),
+	})
+	c.JSON(http.StatusOK, mail)
+}
+
+// SearchChat 检索用户所在会话的消息，支持 peer_id、conversation_id、from、to (RFC3339)、limit 过滤
+func (h *MailHandler) SearchChat(c *gin.Context) {
+	query := ports.ChatSearchQuery{Query: c.Query("q"), PeerID: c.Query("peer_id"), ConversationID: c.Query("conversation_id")}
+	query.Limit, _ = strconv.Atoi(c.Query("limit"))
+	var ok bool
+	if query.From, ok = parseTimeQuery(c, "from"); !ok {
+		return
+	}
+	if query.To, ok = parseTimeQuery(c, "to"); !ok {
+		return
+	}
+
+	hits, err := h.service.SearchChat(c.Request.Context(), requestSessionID(c), h.requestUserID(c), query)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, hits)
+}
+
+// ListChatThreads 最近会话列表，供聊天窗口启动时恢复联系人
+func (h *MailHandler) ListChatThreads(c *gin.Context) {
+	threads, err := h.service.ListChatThreads(c.Request.Context(), requestSessionID(c), h.requestUserID(c))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, threads)
+}
+
 func (h *MailHandler) SyncSessions(c *gin.Context) {
 	var req struct {
 		ActiveIDs []string `json:"active_ids"`
@@ -543,6 +822,9 @@ func (h *MailHandler) SyncSessions(c *gin.Context) {
 		h.respondError(c, err)
 		return
 	}
+	recordAudit(c, h.audit, domain.AuditLog{
+		ActorID: c.Query("user_id"), Action: domain.AuditActionSessionSync, Detail: fmt.Sprintf("active=%d deleted=%d", len(req.ActiveIDs), count),
+	})
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":       "sync completed",
@@ -551,7 +833,81 @@ func (h *MailHandler) SyncSessions(c *gin.Context) {
 	})
 }
 
+func (h *MailHandler) CloneSession(c *gin.Context) {
+	var req struct {
+		TargetID  string `json:"target_id"`
+		ResetRead bool   `json:"reset_read"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	sessionID := c.Param("id")
+	err := h.service.CloneSession(c.Request.Context(), sessionID, ports.CloneSessionRequest{
+		TargetSessionID: req.TargetID,
+		ResetRead:       req.ResetRead,
+	})
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session cloned successfully", "session_id": req.TargetID})
+}
+
+func (h *MailHandler) CreateSnapshot(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	snapshot, err := h.service.CreateSnapshot(c.Request.Context(), c.Param("id"), req.Name)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+func (h *MailHandler) ListSnapshots(c *gin.Context) {
+	snapshots, err := h.service.ListSnapshots(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshots)
+}
+
+func (h *MailHandler) RestoreSnapshot(c *gin.Context) {
+	if err := h.service.RestoreSnapshot(c.Request.Context(), c.Param("id"), c.Param("snapshot_id")); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "snapshot restored successfully"})
+}
+
+func (h *MailHandler) DeleteSnapshot(c *gin.Context) {
+	if err := h.service.DeleteSnapshot(c.Request.Context(), c.Param("id"), c.Param("snapshot_id")); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 func (h *MailHandler) respondError(c *gin.Context, err error) {
+	respondError(c, err)
+}
+
+// respondError 将 AppError 映射为对应的 HTTP 状态码，供各 Handler 共用
+func respondError(c *gin.Context, err error) {
 	if appErr, ok := err.(*ports.AppError); ok {
 		var status int
 		switch appErr.Type {
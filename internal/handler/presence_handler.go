@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+
+	"raven/internal/core/ports"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PresenceHandler struct {
+	service ports.PresenceService
+}
+
+func NewPresenceHandler(service ports.PresenceService) *PresenceHandler {
+	return &PresenceHandler{service: service}
+}
+
+// GetPresence 场次内用户的在线状态与最后在线时间
+func (h *PresenceHandler) GetPresence(c *gin.Context) {
+	list, err := h.service.List(c.Request.Context(), requestSessionID(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// TrackActivity 中间件: 带 user_id 的请求视为用户操作，空闲用户恢复为在线
+func (h *PresenceHandler) TrackActivity(c *gin.Context) {
+	if userID := c.Query("user_id"); userID != "" {
+		h.service.Touch(c.Request.Context(), requestSessionID(c), userID)
+	}
+	c.Next()
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"raven/internal/core/ports"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReportHandler struct {
+	service ports.ReportService
+}
+
+func NewReportHandler(service ports.ReportService) *ReportHandler {
+	return &ReportHandler{service: service}
+}
+
+// GetSessionReport 返回场次复盘报告，format=html 时以可打印的 HTML 文档下载
+func (h *ReportHandler) GetSessionReport(c *gin.Context) {
+	sessionID := c.Param("id")
+	report, err := h.service.BuildSessionReport(c.Request.Context(), sessionID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if c.Query("format") != "html" {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"report-%s.html\"", sessionID))
+	if err := reportTemplate.Execute(c.Writer, report); err != nil {
+		log.Printf("[Report] Render failed: %v", err)
+	}
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"fmtTime": func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+	"fmtSeconds": func(v *float64) string {
+		if v == nil {
+			return "-"
+		}
+		return time.Duration(*v * float64(time.Second)).Round(time.Second).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>演练复盘报告 - {{.Session.Name}}</title>
+<style>
+  body { font-family: "PingFang SC", "Microsoft YaHei", sans-serif; margin: 32px; color: #222; }
+  h1 { font-size: 22px; margin-bottom: 4px; }
+  h2 { font-size: 17px; margin-top: 28px; border-bottom: 2px solid #333; padding-bottom: 4px; }
+  .meta { color: #666; font-size: 13px; }
+  table { width: 100%; border-collapse: collapse; font-size: 13px; }
+  th, td { border: 1px solid #ccc; padding: 4px 6px; text-align: left; vertical-align: top; }
+  th { background: #f2f2f2; }
+  tr { page-break-inside: avoid; }
+  @media print { body { margin: 0; } h2 { page-break-after: avoid; } }
+</style>
+</head>
+<body>
+<h1>演练复盘报告：{{.Session.Name}}</h1>
+<div class="meta">
+  场次 ID：{{.Session.ID}} ｜ 状态：{{.Session.Status}}
+  {{with .Session.StartedAt}} ｜ 开始：{{fmtTime .}}{{end}}
+  {{with .Session.EndedAt}} ｜ 结束：{{fmtTime .}}{{end}}
+  ｜ 生成时间：{{fmtTime .GeneratedAt}}
+</div>
+
+<h2>人员统计</h2>
+<table>
+  <tr><th>用户</th><th>发文</th><th>收文</th><th>发送消息</th><th>接收消息</th><th>阅读耗时中位数</th><th>未回复</th></tr>
+  {{range .Users}}
+  <tr><td>{{.UserID}}</td><td>{{.MailsSent}}</td><td>{{.MailsReceived}}</td><td>{{.ChatsSent}}</td><td>{{.ChatsReceived}}</td><td>{{fmtSeconds .MedianTimeToRead}}</td><td>{{.UnansweredCount}}</td></tr>
+  {{end}}
+</table>
+
+<h2>未回复事项</h2>
+<table>
+  <tr><th>用户</th><th>类型</th><th>来自</th><th>接收时间</th><th>摘要</th></tr>
+  {{range $u := .Users}}{{range .UnansweredItems}}
+  <tr><td>{{$u.UserID}}</td><td>{{.Type}}</td><td>{{.FromID}}</td><td>{{fmtTime .ReceivedAt}}</td><td>{{.Summary}}</td></tr>
+  {{end}}{{end}}
+</table>
+
+<h2>事件时间线</h2>
+<table>
+  <tr><th>演练时间</th><th>事件</th><th>操作人</th><th>对象</th><th>摘要</th></tr>
+  {{range .Timeline}}
+  <tr><td>{{fmtTime .Time}}</td><td>{{.Type}}</td><td>{{.ActorID}}</td><td>{{range $i, $t := .Targets}}{{if $i}}, {{end}}{{$t}}{{end}}</td><td>{{.Summary}}</td></tr>
+  {{end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+
+	"raven/internal/core/ports"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ScenarioHandler struct {
+	service ports.ScenarioService
+}
+
+func NewScenarioHandler(service ports.ScenarioService) *ScenarioHandler {
+	return &ScenarioHandler{service: service}
+}
+
+// CreateScenario 上传脚本定义 (JSON 请求体)
+func (h *ScenarioHandler) CreateScenario(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	scenario, err := h.service.CreateScenario(c.Request.Context(), body)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, scenario)
+}
+
+func (h *ScenarioHandler) ListScenarios(c *gin.Context) {
+	scenarios, err := h.service.ListScenarios(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, scenarios)
+}
+
+func (h *ScenarioHandler) GetScenario(c *gin.Context) {
+	scenario, def, err := h.service.GetScenario(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":         scenario.ID,
+		"name":       scenario.Name,
+		"created_at": scenario.CreatedAt,
+		"definition": def,
+	})
+}
+
+func (h *ScenarioHandler) DeleteScenario(c *gin.Context) {
+	if err := h.service.DeleteScenario(c.Request.Context(), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *ScenarioHandler) StartRun(c *gin.Context) {
+	var req struct {
+		ScenarioID string `json:"scenario_id"`
+	}
+	if err := c.BindJSON(&req); err != nil || req.ScenarioID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scenario_id required"})
+		return
+	}
+
+	status, err := h.service.StartRun(c.Request.Context(), c.Param("id"), req.ScenarioID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *ScenarioHandler) PauseRun(c *gin.Context) {
+	status, err := h.service.PauseRun(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *ScenarioHandler) ResumeRun(c *gin.Context) {
+	status, err := h.service.ResumeRun(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *ScenarioHandler) StopRun(c *gin.Context) {
+	status, err := h.service.StopRun(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *ScenarioHandler) GetRunStatus(c *gin.Context) {
+	status, err := h.service.GetRunStatus(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"net/http"
+
+	"raven/internal/core/ports"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionHandler struct {
+	service ports.SessionService
+	clock   ports.ClockService
+}
+
+func NewSessionHandler(service ports.SessionService, clock ports.ClockService) *SessionHandler {
+	return &SessionHandler{service: service, clock: clock}
+}
+
+// RequireSession 拒绝针对未知场次 (X-Session-ID) 的请求
+func (h *SessionHandler) RequireSession(c *gin.Context) {
+	sessionID := requestSessionID(c)
+
+	if _, err := h.service.GetSession(c.Request.Context(), sessionID); err != nil {
+		respondError(c, err)
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+// requestSessionID 读取请求的场次: X-Session-ID 请求头，其次为 session_id 查询参数 (WebSocket 等无法设置请求头的场景)
+func requestSessionID(c *gin.Context) string {
+	if id := c.GetHeader("X-Session-ID"); id != "" {
+		return id
+	}
+	if id := c.Query("session_id"); id != "" {
+		return id
+	}
+	return "default"
+}
+
+func (h *SessionHandler) CreateSession(c *gin.Context) {
+	var req ports.SessionRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	session, err := h.service.CreateSession(c.Request.Context(), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+func (h *SessionHandler) ListSessions(c *gin.Context) {
+	sessions, err := h.service.ListSessions(c.Request.Context(), c.Query("status"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (h *SessionHandler) GetSession(c *gin.Context) {
+	session, err := h.service.GetSession(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+func (h *SessionHandler) UpdateSession(c *gin.Context) {
+	var req ports.SessionRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	session, err := h.service.UpdateSession(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// ChangeStatus 迁移场次生命周期状态: draft -> running <-> paused -> ended -> archived
+func (h *SessionHandler) ChangeStatus(c *gin.Context) {
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := c.BindJSON(&req); err != nil || req.Status == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status required"})
+		return
+	}
+
+	session, err := h.service.ChangeStatus(c.Request.Context(), c.Param("id"), req.Status)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+func (h *SessionHandler) GetClock(c *gin.Context) {
+	state, err := h.clock.GetClock(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
+
+// SetClock 设定演练时间 (simulated_time, RFC3339) 和/或倍速 (speed)
+func (h *SessionHandler) SetClock(c *gin.Context) {
+	var req ports.SetClockRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	state, err := h.clock.SetClock(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
+
+func (h *SessionHandler) PauseClock(c *gin.Context) {
+	state, err := h.clock.PauseClock(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
+
+func (h *SessionHandler) ResumeClock(c *gin.Context) {
+	state, err := h.clock.ResumeClock(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
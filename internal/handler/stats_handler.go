@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"raven/internal/core/ports"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StatsHandler struct {
+	service ports.StatsService
+}
+
+func NewStatsHandler(service ports.StatsService) *StatsHandler {
+	return &StatsHandler{service: service}
+}
+
+// GetVolume 通信量时间分布，bucket 为时间桶宽度 (如 1m、15m、1h)
+func (h *StatsHandler) GetVolume(c *gin.Context) {
+	var bucket time.Duration
+	if raw := c.Query("bucket"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bucket"})
+			return
+		}
+		bucket = d
+	}
+
+	buckets, err := h.service.GetVolume(c.Request.Context(), c.Param("id"), bucket)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, buckets)
+}
+
+func (h *StatsHandler) GetCommunicationMatrix(c *gin.Context) {
+	top, _ := strconv.Atoi(c.DefaultQuery("top", "10"))
+
+	stats, err := h.service.GetCommunicationMatrix(c.Request.Context(), c.Param("id"), top)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+func (h *StatsHandler) GetAttachmentStats(c *gin.Context) {
+	stats, err := h.service.GetAttachmentStats(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+func (h *StatsHandler) GetUnreadBacklog(c *gin.Context) {
+	backlog, err := h.service.GetUnreadBacklog(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, backlog)
+}
+
+// GetLatencyDistribution 回复与阅读耗时分布，bounds 为逗号分隔的桶上界 (秒)
+func (h *StatsHandler) GetLatencyDistribution(c *gin.Context) {
+	var bounds []int
+	if raw := c.Query("bounds"); raw != "" {
+		for _, part := range strings.Split(raw, ",") {
+			b, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bounds"})
+				return
+			}
+			bounds = append(bounds, b)
+		}
+	}
+
+	dist, err := h.service.GetLatencyDistribution(c.Request.Context(), c.Param("id"), bounds)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dist)
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookHandler struct {
+	service ports.WebhookService
+}
+
+func NewWebhookHandler(service ports.WebhookService) *WebhookHandler {
+	return &WebhookHandler{service: service}
+}
+
+// CreateWebhook 创建订阅，响应中包含签名密钥 (仅此一次)
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req ports.WebhookRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	hook, err := h.service.CreateWebhook(c.Request.Context(), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hook)
+}
+
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	hooks, err := h.service.ListWebhooks(c.Request.Context(), c.Query("session_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hooks)
+}
+
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	if err := h.service.DeleteWebhook(c.Request.Context(), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ListDeliveries 订阅的投递日志，支持 status、event 过滤
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	h.listDeliveries(c, ports.WebhookDeliveryFilter{
+		WebhookID: c.Param("id"),
+		Status:    c.Query("status"),
+		Event:     c.Query("event"),
+	})
+}
+
+// ListDeadLetters 超过重试次数的投递，支持 session_id、webhook_id 过滤
+func (h *WebhookHandler) ListDeadLetters(c *gin.Context) {
+	h.listDeliveries(c, ports.WebhookDeliveryFilter{
+		WebhookID: c.Query("webhook_id"),
+		SessionID: c.Query("session_id"),
+		Status:    domain.DeliveryStatusDead,
+	})
+}
+
+func (h *WebhookHandler) listDeliveries(c *gin.Context, filter ports.WebhookDeliveryFilter) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+
+	deliveries, total, err := h.service.ListDeliveries(c.Request.Context(), filter, page, pageSize)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": deliveries, "total": total, "page": page, "page_size": pageSize})
+}
+
+// Redeliver 将死信重新排入投递队列
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	delivery, err := h.service.Redeliver(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
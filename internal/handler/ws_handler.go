@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+	"raven/internal/infrastructure/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	wsPingInterval = 30 * time.Second
+	wsReadTimeout  = 2 * wsPingInterval
+)
+
+// socketRequest 客户端经 WebSocket 发送的指令，id 由客户端生成，用于匹配 ACK
+type socketRequest struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// socketAck 对 socketRequest 的逐条确认
+type socketAck struct {
+	Type  string      `json:"type"` // 固定为 ACK
+	ID    string      `json:"id"`
+	OK    bool        `json:"ok"`
+	Data  interface{} `json:"data,omitempty"`
+	Error string      `json:"error,omitempty"`
+	Code  string      `json:"code,omitempty"`
+}
+
+// ServeWebSocket 提供与 SSE 相同事件格式的双向通道 (GET /api/v1/ws)。
+// 浏览器无法为 WebSocket 设置请求头，场次可通过 session_id 查询参数传入。
+// 客户端指令: CHAT_SEND {receiver_id | conversation_id, content, reply_to_id, mentions}、CHAT_READ {sender_id | conversation_id}、
+// CHAT_EDIT {id, content, mentions}、CHAT_RETRACT {id}、TYPING {receiver_id, typing}、PING
+func (h *MailHandler) ServeWebSocket(c *gin.Context) {
+	sessionID := requestSessionID(c)
+	userID := c.Query("user_id")
+	if userID == "" {
+		userID = h.DefaultSenderID
+	}
+
+	conn, err := websocket.Upgrade(c.Writer, c.Request, h.AllowedOrigins)
+	if errors.Is(err, websocket.ErrOriginForbidden) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "websocket origin not allowed"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "websocket upgrade required"})
+		return
+	}
+	defer conn.Close()
+
+	ch := h.service.Subscribe(sessionID, userID)
+	defer h.service.Unsubscribe(ch)
+	defer h.trackConnection(c, sessionID, userID)()
+
+	acks := make(chan []byte, 16)
+	done := make(chan struct{})
+	defer close(done)
+
+	// 写协程: 推送事件、ACK 与保活 ping；写失败时关闭连接使读循环退出
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			var err error
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					conn.Close()
+					return
+				}
+				err = conn.WriteText([]byte(msg.Data))
+			case ack := <-acks:
+				err = conn.WriteText(ack)
+			case <-ticker.C:
+				err = conn.Ping()
+			case <-done:
+				return
+			}
+			if err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		ack, _ := json.Marshal(h.handleSocketRequest(c, sessionID, userID, data))
+		select {
+		case acks <- ack:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (h *MailHandler) handleSocketRequest(c *gin.Context, sessionID, userID string, data []byte) socketAck {
+	var req socketRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return socketAck{Type: "ACK", Error: "invalid message", Code: string(ports.ErrorTypeInvalidInput)}
+	}
+	ack := socketAck{Type: "ACK", ID: req.ID}
+	ctx := c.Request.Context()
+	if h.presence != nil && req.Type != "PING" {
+		h.presence.Touch(ctx, sessionID, userID)
+	}
+
+	var result interface{}
+	var err error
+	switch req.Type {
+	case "PING":
+	case "CHAT_SEND":
+		var body struct {
+			ConversationID string               `json:"conversation_id"`
+			ReceiverID     string               `json:"receiver_id"`
+			Content        string               `json:"content"`
+			ReplyToID      string               `json:"reply_to_id"`
+			Mentions       []domain.ChatMention `json:"mentions"`
+		}
+		if err = decodeSocketData(req.Data, &body); err != nil {
+			break
+		}
+		var msg *domain.ChatMessage
+		msg, err = h.service.SendChatMessage(ctx, userID, ports.SendChatMessageRequest{
+			SessionID: sessionID, ConversationID: body.ConversationID, ReceiverID: body.ReceiverID, Content: body.Content,
+			ReplyToID: body.ReplyToID, Mentions: body.Mentions,
+		})
+		if err == nil {
+			result = msg
+			recordAudit(c, h.audit, domain.AuditLog{
+				SessionID: sessionID, ActorID: userID, Action: domain.AuditActionChatSend, TargetID: msg.ID, Detail: chatAuditDetail(msg),
+			})
+		}
+	case "CHAT_READ":
+		var body struct {
+			ConversationID string `json:"conversation_id"`
+			SenderID       string `json:"sender_id"`
+		}
+		if err = decodeSocketData(req.Data, &body); err != nil {
+			break
+		}
+		target := body.SenderID
+		switch {
+		case body.ConversationID != "":
+			target = body.ConversationID
+			err = h.service.MarkConversationRead(ctx, sessionID, userID, body.ConversationID)
+		case body.SenderID != "":
+			err = h.service.MarkChatAsRead(ctx, sessionID, body.SenderID, userID)
+		default:
+			err = ports.NewInvalidInputError("sender_id or conversation_id required", nil)
+		}
+		if err == nil {
+			recordAudit(c, h.audit, domain.AuditLog{
+				SessionID: sessionID, ActorID: userID, Action: domain.AuditActionChatRead, TargetID: target,
+			})
+		}
+	case "CHAT_EDIT", "CHAT_RETRACT":
+		var body struct {
+			ID       string               `json:"id"`
+			Content  string               `json:"content"`
+			Mentions []domain.ChatMention `json:"mentions"`
+		}
+		if err = decodeSocketData(req.Data, &body); err != nil {
+			break
+		}
+		var msg *domain.ChatMessage
+		action := domain.AuditActionChatEdit
+		if req.Type == "CHAT_EDIT" {
+			msg, err = h.service.EditChatMessage(ctx, sessionID, userID, body.ID, body.Content, body.Mentions)
+		} else {
+			action = domain.AuditActionChatRetract
+			msg, err = h.service.RetractChatMessage(ctx, sessionID, userID, body.ID)
+		}
+		if err == nil {
+			result = msg
+			recordAudit(c, h.audit, domain.AuditLog{
+				SessionID: sessionID, ActorID: userID, Action: action, TargetID: msg.ID,
+			})
+		}
+	case "TYPING":
+		var body struct {
+			ReceiverID string `json:"receiver_id"`
+			Typing     bool   `json:"typing"`
+		}
+		if err = decodeSocketData(req.Data, &body); err != nil {
+			break
+		}
+		err = h.service.NotifyTyping(ctx, sessionID, userID, body.ReceiverID, body.Typing)
+	default:
+		err = ports.NewInvalidInputError(fmt.Sprintf("unknown message type %q", req.Type), nil)
+	}
+
+	if err != nil {
+		ack.Error, ack.Code = socketError(err)
+		return ack
+	}
+	ack.OK = true
+	ack.Data = result
+	return ack
+}
+
+func decodeSocketData(raw json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(raw, v); err != nil {
+		return ports.NewInvalidInputError("invalid payload", err)
+	}
+	return nil
+}
+
+// socketError 将错误转换为 ACK 中的错误信息，与 respondError 的 JSON 字段一致
+func socketError(err error) (string, string) {
+	if appErr, ok := err.(*ports.AppError); ok {
+		return appErr.Message, string(appErr.Type)
+	}
+	fmt.Printf("[WebSocket] Request failed: %v\n", err)
+	return "Internal Server Error", string(ports.ErrorTypeInternal)
+}
@@ -0,0 +1,437 @@
+package imapd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// part 报文或 multipart 子部分，header 含结尾空行，body 为原始 (未解码) 内容
+type part struct {
+	header      []byte
+	body        []byte
+	mediaType   string
+	subType     string
+	params      map[string]string
+	encoding    string
+	disposition string
+	dispParams  map[string]string
+	children    []*part
+}
+
+func parsePart(raw []byte) *part {
+	p := &part{header: raw}
+	if idx := bytes.Index(raw, []byte("\r\n\r\n")); idx >= 0 {
+		p.header, p.body = raw[:idx+4], raw[idx+4:]
+	}
+
+	h, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(p.header))).ReadMIMEHeader()
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{"charset": "us-ascii"}
+	}
+	p.mediaType, p.subType, _ = strings.Cut(strings.ToUpper(mediaType), "/")
+	p.params = params
+	p.encoding = strings.ToUpper(h.Get("Content-Transfer-Encoding"))
+	if p.encoding == "" {
+		p.encoding = "7BIT"
+	}
+	if d, dp, err := mime.ParseMediaType(h.Get("Content-Disposition")); err == nil {
+		p.disposition, p.dispParams = strings.ToUpper(d), dp
+	}
+
+	if p.mediaType == "MULTIPART" {
+		for _, child := range splitMultipart(p.body, params["boundary"]) {
+			p.children = append(p.children, parsePart(child))
+		}
+	}
+	return p
+}
+
+func splitMultipart(body []byte, boundary string) [][]byte {
+	if boundary == "" {
+		return nil
+	}
+	delim := []byte("\r\n--" + boundary)
+	b := append([]byte("\r\n"), body...)
+	idx := bytes.Index(b, delim)
+	if idx < 0 {
+		return nil
+	}
+
+	var parts [][]byte
+	for {
+		rest := b[idx+len(delim):]
+		if bytes.HasPrefix(rest, []byte("--")) {
+			return parts
+		}
+		nl := bytes.Index(rest, []byte("\r\n"))
+		if nl < 0 {
+			return parts
+		}
+		content := rest[nl+2:]
+		next := bytes.Index(content, delim)
+		if next < 0 {
+			return append(parts, content)
+		}
+		parts = append(parts, content[:next])
+		b, idx = content, next
+	}
+}
+
+// bodyStructure 生成 BODYSTRUCTURE (ext=true) 或 BODY 响应
+func (p *part) bodyStructure(ext bool) string {
+	var sb strings.Builder
+	sb.WriteString("(")
+	if p.mediaType == "MULTIPART" {
+		for _, c := range p.children {
+			sb.WriteString(c.bodyStructure(ext))
+		}
+		sb.WriteString(" " + quote(p.subType))
+		if ext {
+			sb.WriteString(" " + paramList(p.params) + " NIL NIL")
+		}
+		sb.WriteString(")")
+		return sb.String()
+	}
+
+	fmt.Fprintf(&sb, "%s %s %s NIL NIL %s %d", quote(p.mediaType), quote(p.subType), paramList(p.params), quote(p.encoding), len(p.body))
+	if p.mediaType == "TEXT" {
+		fmt.Fprintf(&sb, " %d", bytes.Count(p.body, []byte("\n")))
+	}
+	if ext {
+		sb.WriteString(" NIL ")
+		if p.disposition != "" {
+			sb.WriteString("(" + quote(p.disposition) + " " + paramList(p.dispParams) + ")")
+		} else {
+			sb.WriteString("NIL")
+		}
+		sb.WriteString(" NIL")
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+func paramList(params map[string]string) string {
+	if len(params) == 0 {
+		return "NIL"
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := make([]string, 0, len(keys)*2)
+	for _, k := range keys {
+		items = append(items, quote(strings.ToUpper(k)), quote(params[k]))
+	}
+	return "(" + strings.Join(items, " ") + ")"
+}
+
+// section 返回 BODY[section] 对应的原始内容，section 不含方括号
+func (p *part) section(spec string) ([]byte, bool) {
+	target := p
+	rest := spec
+	// 数字路径，如 2、1.MIME
+	for rest != "" {
+		head, tail, _ := strings.Cut(rest, ".")
+		n, err := strconv.Atoi(head)
+		if err != nil {
+			break
+		}
+		if target.mediaType == "MULTIPART" {
+			if n < 1 || n > len(target.children) {
+				return nil, false
+			}
+			target = target.children[n-1]
+		} else if n != 1 {
+			return nil, false
+		}
+		rest = tail
+		if rest == "" {
+			// 子部分正文
+			return target.body, true
+		}
+	}
+
+	upper := strings.ToUpper(rest)
+	switch {
+	case rest == "":
+		return append(append([]byte{}, target.header...), target.body...), true
+	case upper == "HEADER" || (upper == "MIME" && target != p):
+		return target.header, true
+	case upper == "TEXT":
+		return target.body, true
+	case strings.HasPrefix(upper, "HEADER.FIELDS.NOT"):
+		return filterHeader(target.header, fieldNames(rest), false), true
+	case strings.HasPrefix(upper, "HEADER.FIELDS"):
+		return filterHeader(target.header, fieldNames(rest), true), true
+	}
+	return nil, false
+}
+
+// fieldNames 解析 HEADER.FIELDS (From To) 中的字段名
+func fieldNames(spec string) map[string]bool {
+	names := make(map[string]bool)
+	open, close := strings.Index(spec, "("), strings.LastIndex(spec, ")")
+	if open < 0 || close < open {
+		return names
+	}
+	for _, f := range strings.Fields(spec[open+1 : close]) {
+		names[strings.ToLower(strings.Trim(f, `"`))] = true
+	}
+	return names
+}
+
+func filterHeader(header []byte, names map[string]bool, include bool) []byte {
+	var out bytes.Buffer
+	var keep bool
+	for _, line := range bytes.SplitAfter(header, []byte("\r\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			name, _, _ := bytes.Cut(line, []byte(":"))
+			keep = names[strings.ToLower(string(bytes.TrimSpace(name)))] == include
+		}
+		if keep {
+			out.Write(line)
+		}
+	}
+	out.WriteString("\r\n")
+	return out.Bytes()
+}
+
+// envelope 由报文头生成 ENVELOPE 结构
+func envelope(raw []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return "(NIL NIL NIL NIL NIL NIL NIL NIL NIL NIL)"
+	}
+	h := msg.Header
+	from := addressList(h.Get("From"))
+	fields := []string{
+		nstring(h.Get("Date")),
+		nstring(h.Get("Subject")),
+		from, from, from,
+		addressList(h.Get("To")),
+		addressList(h.Get("Cc")),
+		addressList(h.Get("Bcc")),
+		nstring(h.Get("In-Reply-To")),
+		nstring(h.Get("Message-ID")),
+	}
+	return "(" + strings.Join(fields, " ") + ")"
+}
+
+func addressList(value string) string {
+	if value == "" {
+		return "NIL"
+	}
+	list, err := mail.ParseAddressList(value)
+	if err != nil || len(list) == 0 {
+		return "NIL"
+	}
+	var sb strings.Builder
+	sb.WriteString("(")
+	for _, a := range list {
+		local, host, _ := strings.Cut(a.Address, "@")
+		fmt.Fprintf(&sb, "(%s NIL %s %s)", nstring(mime.QEncoding.Encode("utf-8", a.Name)), quote(local), quote(host))
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+var fetchMacros = map[string][]string{
+	"ALL":  {"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE"},
+	"FAST": {"FLAGS", "INTERNALDATE", "RFC822.SIZE"},
+	"FULL": {"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE", "BODY"},
+}
+
+// fetchItem 解析后的 FETCH 数据项
+type fetchItem struct {
+	name    string // 大写的数据项名，BODY[...] 统一为 BODY[]
+	section string // BODY[section] 中的 section，保留客户端原文用于回显
+	peek    bool
+	partial bool
+	start   int
+	length  int
+}
+
+// setsSeen 非 PEEK 的正文读取会隐式设置 \Seen
+func (it fetchItem) setsSeen() bool {
+	return (it.name == "BODY[]" && !it.peek) || it.name == "RFC822" || it.name == "RFC822.TEXT"
+}
+
+func parseFetchItems(s string) ([]fetchItem, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		if list, ok := tokens[0].([]interface{}); ok {
+			tokens = list
+		}
+	}
+
+	var names []string
+	for _, t := range tokens {
+		name, ok := t.(string)
+		if !ok {
+			return nil, errors.New("invalid fetch item")
+		}
+		if macro, ok := fetchMacros[strings.ToUpper(name)]; ok && len(tokens) == 1 {
+			names = append(names, macro...)
+			continue
+		}
+		names = append(names, name)
+	}
+
+	items := make([]fetchItem, 0, len(names))
+	for _, name := range names {
+		upper := strings.ToUpper(name)
+		switch upper {
+		case "UID", "FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE", "BODYSTRUCTURE", "BODY", "RFC822", "RFC822.HEADER", "RFC822.TEXT":
+			items = append(items, fetchItem{name: upper})
+			continue
+		}
+
+		var it fetchItem
+		switch {
+		case strings.HasPrefix(upper, "BODY.PEEK["):
+			it.peek = true
+			name = name[len("BODY.PEEK["):]
+		case strings.HasPrefix(upper, "BODY["):
+			name = name[len("BODY["):]
+		default:
+			return nil, fmt.Errorf("unknown fetch item %s", name)
+		}
+		end := strings.LastIndex(name, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("invalid fetch item %s", name)
+		}
+		it.name, it.section = "BODY[]", name[:end]
+		if rest := name[end+1:]; rest != "" {
+			if _, err := fmt.Sscanf(rest, "<%d.%d>", &it.start, &it.length); err != nil || it.start < 0 || it.length <= 0 {
+				return nil, fmt.Errorf("invalid partial %s", rest)
+			}
+			it.partial = true
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+func (c *conn) fetch(ctx context.Context, tag, cmd, args string) {
+	setStr, itemStr, _ := strings.Cut(args, " ")
+	set, err := parseSeqSet(setStr)
+	if err != nil {
+		c.tagged(tag, "BAD", "Invalid sequence set")
+		return
+	}
+	items, err := parseFetchItems(itemStr)
+	if err != nil || len(items) == 0 {
+		c.tagged(tag, "BAD", "Invalid fetch items")
+		return
+	}
+
+	uidMode := cmd == "UID FETCH"
+	for _, i := range c.match(set, uidMode) {
+		msg := c.box.msgs[i]
+		resp, err := c.fetchMessage(ctx, msg, items, uidMode)
+		if err != nil {
+			log.Printf("[IMAP] Failed to fetch mail %s: %v", msg.mail.ID, err)
+			c.tagged(tag, "NO", "Failed to fetch message")
+			return
+		}
+		c.w.WriteString(fmt.Sprintf("* %d FETCH (", i+1))
+		c.w.WriteString(resp)
+		c.w.WriteString(")\r\n")
+	}
+	c.tagged(tag, "OK", "%s completed", cmd)
+}
+
+func (c *conn) fetchMessage(ctx context.Context, msg *message, items []fetchItem, uidMode bool) (string, error) {
+	needRaw, setSeen, hasUID, hasFlags := false, false, false, false
+	for _, it := range items {
+		switch it.name {
+		case "UID":
+			hasUID = true
+		case "FLAGS":
+			hasFlags = true
+		case "INTERNALDATE":
+		default:
+			needRaw = true
+		}
+		setSeen = setSeen || it.setsSeen()
+	}
+	if needRaw {
+		if err := c.srv.render(ctx, c.user, c.box.name, msg); err != nil {
+			return "", err
+		}
+	}
+	flagsChanged := false
+	if setSeen && !msg.seen && !c.box.readOnly {
+		if err := c.setSeen(ctx, msg, true); err != nil {
+			return "", err
+		}
+		flagsChanged = true
+	}
+
+	var out []string
+	if uidMode && !hasUID {
+		out = append(out, fmt.Sprintf("UID %d", msg.uid))
+	}
+	for _, it := range items {
+		switch it.name {
+		case "UID":
+			out = append(out, fmt.Sprintf("UID %d", msg.uid))
+		case "FLAGS":
+			out = append(out, "FLAGS "+flags(msg))
+		case "INTERNALDATE":
+			out = append(out, `INTERNALDATE "`+msg.mail.CreatedAt.Format("02-Jan-2006 15:04:05 -0700")+`"`)
+		case "RFC822.SIZE":
+			out = append(out, fmt.Sprintf("RFC822.SIZE %d", len(msg.raw)))
+		case "ENVELOPE":
+			out = append(out, "ENVELOPE "+envelope(msg.raw))
+		case "BODYSTRUCTURE":
+			out = append(out, "BODYSTRUCTURE "+msg.root.bodyStructure(true))
+		case "BODY":
+			out = append(out, "BODY "+msg.root.bodyStructure(false))
+		case "RFC822":
+			out = append(out, "RFC822 "+literal(msg.raw))
+		case "RFC822.HEADER":
+			out = append(out, "RFC822.HEADER "+literal(msg.root.header))
+		case "RFC822.TEXT":
+			out = append(out, "RFC822.TEXT "+literal(msg.root.body))
+		case "BODY[]":
+			data, _ := msg.root.section(it.section)
+			name := "BODY[" + it.section + "]"
+			if it.partial {
+				if it.start >= len(data) {
+					data = nil
+				} else {
+					data = data[it.start:min(it.start+it.length, len(data))]
+				}
+				name += fmt.Sprintf("<%d>", it.start)
+			}
+			out = append(out, name+" "+literal(data))
+		}
+	}
+	if flagsChanged && !hasFlags {
+		out = append(out, "FLAGS "+flags(msg))
+	}
+	return strings.Join(out, " "), nil
+}
+
+func literal(data []byte) string {
+	return fmt.Sprintf("{%d}\r\n%s", len(data), data)
+}
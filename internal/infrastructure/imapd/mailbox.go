@@ -0,0 +1,210 @@
+package imapd
+
+import (
+	"context"
+	"io"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+
+	"raven/internal/core/domain"
+	"raven/internal/infrastructure/mimemail"
+)
+
+const (
+	mailboxInbox = "INBOX"
+	mailboxSent  = "Sent"
+)
+
+// uidTable 为某个用户邮箱分配 UID。UID 只在进程生命周期内有效，
+// UIDVALIDITY 取进程启动时间，重启后客户端会重新同步
+type uidTable struct {
+	next uint32
+	ids  map[string]uint32
+}
+
+type uidRegistry struct {
+	mu     sync.Mutex
+	tables map[string]*uidTable
+}
+
+// assign 为文电分配 UID，新文电按发送顺序获得递增的 UID
+func (r *uidRegistry) assign(key string, mails []domain.Mail) []uint32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.tables[key]
+	if !ok {
+		t = &uidTable{next: 1, ids: make(map[string]uint32)}
+		r.tables[key] = t
+	}
+	uids := make([]uint32, len(mails))
+	for i, m := range mails {
+		uid, ok := t.ids[m.ID]
+		if !ok {
+			uid = t.next
+			t.next++
+			t.ids[m.ID] = uid
+		}
+		uids[i] = uid
+	}
+	return uids
+}
+
+func (r *uidRegistry) uidNext(key string) uint32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.tables[key]; ok {
+		return t.next
+	}
+	return 1
+}
+
+type message struct {
+	uid  uint32
+	mail domain.Mail
+	seen bool
+	raw  []byte // 渲染后的 MIME 报文，按需生成
+	root *part
+}
+
+// mailboxView 某次加载时的邮箱内容，按 UID 升序
+type mailboxView struct {
+	name     string
+	readOnly bool
+	msgs     []*message
+}
+
+func (v *mailboxView) unseen() int {
+	n := 0
+	for _, m := range v.msgs {
+		if !m.seen {
+			n++
+		}
+	}
+	return n
+}
+
+func (v *mailboxView) firstUnseen() int {
+	for i, m := range v.msgs {
+		if !m.seen {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (v *mailboxView) maxUID() uint32 {
+	if len(v.msgs) == 0 {
+		return 0
+	}
+	return v.msgs[len(v.msgs)-1].uid
+}
+
+// normalizeMailbox 邮箱名大小写规则: INBOX 不区分大小写，其余按原样匹配
+func normalizeMailbox(name string) (string, bool) {
+	if strings.EqualFold(name, mailboxInbox) {
+		return mailboxInbox, true
+	}
+	if name == mailboxSent {
+		return mailboxSent, true
+	}
+	return "", false
+}
+
+// loadMailbox 通过 GetInbox / GetSent 加载用户邮箱
+func (s *Server) loadMailbox(ctx context.Context, u *user, name string) (*mailboxView, error) {
+	var mails []domain.Mail
+	var err error
+	if name == mailboxInbox {
+		mails, _, err = s.mails.GetInbox(ctx, u.sessionID, u.userID, 1, s.cfg.MaxMessages, "")
+	} else {
+		mails, _, err = s.mails.GetSent(ctx, u.sessionID, u.userID, 1, s.cfg.MaxMessages, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(mails, func(i, j int) bool {
+		if !mails[i].RealCreatedAt.Equal(mails[j].RealCreatedAt) {
+			return mails[i].RealCreatedAt.Before(mails[j].RealCreatedAt)
+		}
+		return mails[i].ID < mails[j].ID
+	})
+	uids := s.uids.assign(u.key(name), mails)
+
+	view := &mailboxView{name: name, readOnly: name != mailboxInbox}
+	for i, m := range mails {
+		msg := &message{uid: uids[i], mail: m, seen: name == mailboxSent}
+		if name == mailboxInbox {
+			for _, r := range m.Recipients {
+				if r.RecipientID == u.userID && r.Status == "read" {
+					msg.seen = true
+				}
+			}
+		}
+		view.msgs = append(view.msgs, msg)
+	}
+	sort.Slice(view.msgs, func(i, j int) bool { return view.msgs[i].uid < view.msgs[j].uid })
+	return view, nil
+}
+
+// render 生成文电的 MIME 报文。收件箱视图不展示密送人，发件箱视图展示全部收件人
+func (s *Server) render(ctx context.Context, u *user, box string, msg *message) error {
+	if msg.raw != nil {
+		return nil
+	}
+	m := msg.mail
+
+	mm := mimemail.Message{
+		From:        s.address(m.SessionID, m.SenderID),
+		Subject:     m.Subject,
+		Date:        m.CreatedAt,
+		MessageID:   m.ID + "@" + s.cfg.Domain,
+		Headers:     [][2]string{{"X-Raven-Session", m.SessionID}},
+		Content:     m.Content,
+		ContentType: m.ContentType,
+	}
+	if m.ParentID != nil {
+		mm.InReplyTo = *m.ParentID + "@" + s.cfg.Domain
+	}
+	for _, r := range m.Recipients {
+		addr := s.address(m.SessionID, r.RecipientID)
+		switch r.Type {
+		case "to":
+			mm.To = append(mm.To, addr)
+		case "cc":
+			mm.Cc = append(mm.Cc, addr)
+		case "bcc":
+			if box == mailboxSent {
+				mm.Bcc = append(mm.Bcc, addr)
+			}
+		}
+	}
+	for _, att := range m.Attachments {
+		f, err := s.storage.GetFile(ctx, att.FilePath)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		mm.Attachments = append(mm.Attachments, mimemail.Attachment{FileName: att.FileName, MimeType: att.MimeType, Data: data})
+	}
+
+	raw, err := mimemail.Render(mm)
+	if err != nil {
+		return err
+	}
+	msg.raw = raw
+	msg.root = parsePart(raw)
+	return nil
+}
+
+// address 将用户映射为 user+session@domain，与 SMTP 网关的收件地址一致
+func (s *Server) address(sessionID, userID string) mail.Address {
+	return mail.Address{Address: userID + "+" + sessionID + "@" + s.cfg.Domain}
+}
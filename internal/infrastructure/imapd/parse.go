@@ -0,0 +1,227 @@
+package imapd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxLineLength    = 64 << 10
+	maxLiteralLength = 1 << 20
+)
+
+var (
+	errLineTooLong = errors.New("line too long")
+	literalSuffix  = regexp.MustCompile(`\{(\d+)(\+?)\}$`)
+)
+
+// readCommand 读取一条完整命令，字面量 ({n} / {n+}) 原样拼接在命令中，由 tokenize 解析
+func readCommand(r *bufio.Reader, cont func() error) (string, error) {
+	var sb strings.Builder
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(line)
+
+		m := literalSuffix.FindStringSubmatch(line)
+		if m == nil {
+			return sb.String(), nil
+		}
+		n, _ := strconv.Atoi(m[1])
+		if n > maxLiteralLength {
+			return "", errLineTooLong
+		}
+		if m[2] == "" {
+			if err := cont(); err != nil {
+				return "", err
+			}
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		sb.WriteString("\r\n")
+		sb.Write(buf)
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	var buf []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, chunk...)
+		if len(buf) > maxLineLength {
+			return "", errLineTooLong
+		}
+		if !isPrefix {
+			return string(buf), nil
+		}
+	}
+}
+
+// tokenize 将命令参数解析为 string (atom / quoted / literal) 与 []interface{} (括号列表)。
+// 方括号内的空格与括号属于同一个 atom，例如 BODY.PEEK[HEADER.FIELDS (From To)]<0.100>
+func tokenize(s string) ([]interface{}, error) {
+	tokens, rest, err := tokenizeList(s, false)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return nil, fmt.Errorf("unexpected %q", rest)
+	}
+	return tokens, nil
+}
+
+func tokenizeList(s string, nested bool) ([]interface{}, string, error) {
+	var tokens []interface{}
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			if nested {
+				return nil, "", errors.New("unterminated list")
+			}
+			return tokens, "", nil
+		}
+
+		switch s[0] {
+		case ')':
+			if !nested {
+				return nil, "", errors.New("unexpected )")
+			}
+			return tokens, s[1:], nil
+		case '(':
+			list, rest, err := tokenizeList(s[1:], true)
+			if err != nil {
+				return nil, "", err
+			}
+			if list == nil {
+				list = []interface{}{}
+			}
+			tokens = append(tokens, list)
+			s = rest
+		case '"':
+			var sb strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				sb.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, "", errors.New("unterminated quoted string")
+			}
+			tokens = append(tokens, sb.String())
+			s = s[i+1:]
+		case '{':
+			end := strings.Index(s, "}\r\n")
+			if end < 0 {
+				return nil, "", errors.New("invalid literal")
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(s[1:end], "+"))
+			if err != nil || end+3+n > len(s) {
+				return nil, "", errors.New("invalid literal")
+			}
+			tokens = append(tokens, s[end+3:end+3+n])
+			s = s[end+3+n:]
+		default:
+			depth, i := 0, 0
+			for ; i < len(s); i++ {
+				c := s[i]
+				if c == '[' {
+					depth++
+				} else if c == ']' {
+					depth--
+				} else if depth == 0 && (c == ' ' || c == '(' || c == ')') {
+					break
+				}
+			}
+			tokens = append(tokens, s[:i])
+			s = s[i:]
+		}
+	}
+}
+
+// quote 将字符串编码为 IMAP quoted string；含 8bit 或换行字符时使用字面量
+func quote(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 || s[i] == '\r' || s[i] == '\n' {
+			return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+		}
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func nstring(s string) string {
+	if s == "" {
+		return "NIL"
+	}
+	return quote(s)
+}
+
+// seqSet 序号/UID 集合，如 1:4,7,9:*
+type seqSet []seqRange
+
+type seqRange struct {
+	from, to uint32 // 0 表示 *
+}
+
+func parseSeqSet(s string) (seqSet, error) {
+	var set seqSet
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, ":")
+		a, err := parseSeqNumber(from)
+		if err != nil {
+			return nil, err
+		}
+		b := a
+		if isRange {
+			if b, err = parseSeqNumber(to); err != nil {
+				return nil, err
+			}
+		}
+		set = append(set, seqRange{from: a, to: b})
+	}
+	return set, nil
+}
+
+func parseSeqNumber(s string) (uint32, error) {
+	if s == "*" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid sequence number %q", s)
+	}
+	return uint32(n), nil
+}
+
+// contains 判断 n 是否在集合中，max 为 * 代表的值
+func (set seqSet) contains(n, max uint32) bool {
+	for _, r := range set {
+		from, to := r.from, r.to
+		if from == 0 {
+			from = max
+		}
+		if to == 0 {
+			to = max
+		}
+		if from > to {
+			from, to = to, from
+		}
+		if n >= from && n <= to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package imapd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// matcher 判断第 seq 封 (从 1 开始) 消息是否满足检索条件
+type matcher func(seq int, msg *message) bool
+
+var errSearchSyntax = errors.New("invalid search criteria")
+
+func (c *conn) search(ctx context.Context, tag, cmd, args string) {
+	tokens, err := tokenize(args)
+	if err != nil {
+		c.tagged(tag, "BAD", "Invalid search criteria")
+		return
+	}
+	if len(tokens) >= 2 {
+		if s, _ := tokens[0].(string); strings.EqualFold(s, "CHARSET") {
+			tokens = tokens[2:]
+		}
+	}
+
+	var criteria []matcher
+	for len(tokens) > 0 {
+		m, rest, err := c.parseSearchKey(ctx, tokens)
+		if err != nil {
+			c.tagged(tag, "BAD", "%v", err)
+			return
+		}
+		criteria, tokens = append(criteria, m), rest
+	}
+
+	uidMode := cmd == "UID SEARCH"
+	var hits []string
+	for i, msg := range c.box.msgs {
+		if allOf(criteria)(i+1, msg) {
+			if uidMode {
+				hits = append(hits, strconv.FormatUint(uint64(msg.uid), 10))
+			} else {
+				hits = append(hits, strconv.Itoa(i+1))
+			}
+		}
+	}
+	if len(hits) == 0 {
+		c.untagged("SEARCH")
+	} else {
+		c.untagged("SEARCH %s", strings.Join(hits, " "))
+	}
+	c.tagged(tag, "OK", "%s completed", cmd)
+}
+
+func allOf(criteria []matcher) matcher {
+	return func(seq int, msg *message) bool {
+		for _, m := range criteria {
+			if !m(seq, msg) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// parseSearchKey 解析一个检索条件，返回剩余的 token
+func (c *conn) parseSearchKey(ctx context.Context, tokens []interface{}) (matcher, []interface{}, error) {
+	if list, ok := tokens[0].([]interface{}); ok {
+		var criteria []matcher
+		for len(list) > 0 {
+			m, rest, err := c.parseSearchKey(ctx, list)
+			if err != nil {
+				return nil, nil, err
+			}
+			criteria, list = append(criteria, m), rest
+		}
+		return allOf(criteria), tokens[1:], nil
+	}
+
+	key, _ := tokens[0].(string)
+	rest := tokens[1:]
+	arg := func() (string, error) {
+		if len(rest) == 0 {
+			return "", fmt.Errorf("missing argument for %s", key)
+		}
+		s, ok := rest[0].(string)
+		if !ok {
+			return "", errSearchSyntax
+		}
+		rest = rest[1:]
+		return s, nil
+	}
+	constant := func(v bool) matcher {
+		return func(int, *message) bool { return v }
+	}
+
+	switch upper := strings.ToUpper(key); upper {
+	case "ALL", "OLD", "UNANSWERED", "UNDELETED", "UNDRAFT", "UNFLAGGED":
+		return constant(true), rest, nil
+	case "RECENT", "ANSWERED", "DELETED", "DRAFT", "FLAGGED":
+		return constant(false), rest, nil
+	case "SEEN":
+		return func(_ int, m *message) bool { return m.seen }, rest, nil
+	case "UNSEEN", "NEW":
+		return func(_ int, m *message) bool { return !m.seen }, rest, nil
+	case "NOT":
+		if len(rest) == 0 {
+			return nil, nil, errSearchSyntax
+		}
+		inner, remaining, err := c.parseSearchKey(ctx, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(seq int, m *message) bool { return !inner(seq, m) }, remaining, nil
+	case "OR":
+		if len(rest) == 0 {
+			return nil, nil, errSearchSyntax
+		}
+		a, remaining, err := c.parseSearchKey(ctx, rest)
+		if err != nil || len(remaining) == 0 {
+			return nil, nil, errSearchSyntax
+		}
+		b, remaining, err := c.parseSearchKey(ctx, remaining)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(seq int, m *message) bool { return a(seq, m) || b(seq, m) }, remaining, nil
+	case "SUBJECT", "BODY", "TEXT", "FROM", "TO", "CC", "BCC":
+		value, err := arg()
+		if err != nil {
+			return nil, nil, err
+		}
+		return c.textMatcher(upper, value), rest, nil
+	case "SINCE", "BEFORE", "ON", "SENTSINCE", "SENTBEFORE", "SENTON":
+		value, err := arg()
+		if err != nil {
+			return nil, nil, err
+		}
+		day, err := time.Parse("2-Jan-2006", value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid date %q", value)
+		}
+		op := strings.TrimPrefix(upper, "SENT")
+		return func(_ int, m *message) bool {
+			t := m.mail.CreatedAt
+			d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+			switch op {
+			case "SINCE":
+				return !d.Before(day)
+			case "BEFORE":
+				return d.Before(day)
+			}
+			return d.Equal(day)
+		}, rest, nil
+	case "LARGER", "SMALLER":
+		value, err := arg()
+		if err != nil {
+			return nil, nil, err
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid size %q", value)
+		}
+		return func(_ int, m *message) bool {
+			if c.srv.render(ctx, c.user, c.box.name, m) != nil {
+				return false
+			}
+			if upper == "LARGER" {
+				return len(m.raw) > n
+			}
+			return len(m.raw) < n
+		}, rest, nil
+	case "UID":
+		value, err := arg()
+		if err != nil {
+			return nil, nil, err
+		}
+		set, err := parseSeqSet(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(_ int, m *message) bool { return set.contains(m.uid, c.box.maxUID()) }, rest, nil
+	default:
+		set, err := parseSeqSet(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unsupported search key %s", key)
+		}
+		return func(seq int, _ *message) bool { return set.contains(uint32(seq), uint32(len(c.box.msgs))) }, rest, nil
+	}
+}
+
+// textMatcher 子串匹配 (不区分大小写)，地址类条件同时匹配用户 ID 与映射后的邮件地址
+func (c *conn) textMatcher(key, value string) matcher {
+	needle := strings.ToLower(value)
+	has := func(s string) bool { return strings.Contains(strings.ToLower(s), needle) }
+	hasUser := func(sessionID, userID string) bool {
+		return has(userID) || has(c.srv.address(sessionID, userID).Address)
+	}
+	hasRecipient := func(m *message, kind string) bool {
+		for _, r := range m.mail.Recipients {
+			if (kind == "" || r.Type == kind) && hasUser(m.mail.SessionID, r.RecipientID) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(_ int, m *message) bool {
+		switch key {
+		case "SUBJECT":
+			return has(m.mail.Subject)
+		case "BODY":
+			return has(m.mail.Content)
+		case "FROM":
+			return hasUser(m.mail.SessionID, m.mail.SenderID)
+		case "TO":
+			return hasRecipient(m, "to")
+		case "CC":
+			return hasRecipient(m, "cc")
+		case "BCC":
+			return c.box.name == mailboxSent && hasRecipient(m, "bcc")
+		}
+		// TEXT
+		return has(m.mail.Subject) || has(m.mail.Content) || hasUser(m.mail.SessionID, m.mail.SenderID) || hasRecipient(m, "to") || hasRecipient(m, "cc")
+	}
+}
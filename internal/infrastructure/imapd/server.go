@@ -0,0 +1,693 @@
+// Package imapd 提供只读的 IMAP4rev1 服务，将 user+session 映射为邮箱账户：
+// INBOX 对应 GetInbox，Sent 对应 GetSent。客户端设置或清除 \Seen 标记时同步更新文电阅读状态，
+// 其余写操作 (APPEND、COPY、EXPUNGE、建删邮箱等) 一律拒绝。
+package imapd
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+)
+
+const (
+	defaultMaxMessages = 1000
+	defaultReadTimeout = 30 * time.Minute
+	maxAuthFailures    = 3
+	capabilities       = "IMAP4rev1 LITERAL+ AUTH=PLAIN SASL-IR ID UNSELECT NAMESPACE"
+)
+
+// MailStore IMAP 服务读取邮箱与同步阅读状态所需的能力，由 MailService 实现
+type MailStore interface {
+	GetInbox(ctx context.Context, sessionID, userID string, page, pageSize int, query string) ([]domain.Mail, int64, error)
+	GetSent(ctx context.Context, sessionID, userID string, page, pageSize int, query string) ([]domain.Mail, int64, error)
+	// SetMailRead 更新阅读状态，标记已读时通知发件人
+	SetMailRead(ctx context.Context, sessionID, userID, mailID string, read bool) error
+}
+
+// ErrPasswordRequired 未配置登录口令时拒绝启动服务
+var ErrPasswordRequired = errors.New("imapd: password is required")
+
+type Config struct {
+	Addr           string // 监听地址，如 127.0.0.1:1143
+	Domain         string // 地址域名，与 SMTP 网关一致，如 raven.local
+	DefaultSession string // 登录名未带 +session 时使用的场次
+	Password       string // 所有账户共用的登录口令，必须配置
+	MaxMessages    int    // 每个邮箱最多加载的文电数
+	ReadTimeout    time.Duration
+}
+
+type Server struct {
+	cfg      Config
+	mails    MailStore
+	sessions ports.SessionRepository
+	storage  ports.StorageService
+	audit    ports.AuditService
+	uids     *uidRegistry
+	validity uint32
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer 创建 IMAP 服务，audit 可为 nil
+func NewServer(cfg Config, mails MailStore, sessions ports.SessionRepository, storage ports.StorageService, audit ports.AuditService) *Server {
+	if cfg.Domain == "" {
+		cfg.Domain = "raven.local"
+	}
+	if cfg.DefaultSession == "" {
+		cfg.DefaultSession = "default"
+	}
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = defaultMaxMessages
+	}
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = defaultReadTimeout
+	}
+	return &Server{
+		cfg:      cfg,
+		mails:    mails,
+		sessions: sessions,
+		storage:  storage,
+		audit:    audit,
+		uids:     &uidRegistry{tables: make(map[string]*uidTable)},
+		validity: uint32(time.Now().Unix()),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+func (s *Server) ListenAndServe() error {
+	if s.cfg.Password == "" {
+		return ErrPasswordRequired
+	}
+	l, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	if s.cfg.Password == "" {
+		l.Close()
+		return ErrPasswordRequired
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	log.Printf("[IMAP] Server listening on %s (domain %s)", l.Addr(), s.cfg.Domain)
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, c)
+				s.mu.Unlock()
+				c.Close()
+			}()
+			newConn(s, c).serve()
+		}()
+	}
+}
+
+// Close 停止监听并断开所有连接
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// user 登录后的账户，对应某场次中的一个用户
+type user struct {
+	sessionID string
+	userID    string
+}
+
+func (u *user) key(box string) string {
+	return u.sessionID + "/" + u.userID + "/" + box
+}
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// authenticate 登录名形如 user+session 或 user+session@domain
+func (s *Server) authenticate(ctx context.Context, name, pass string) (*user, error) {
+	if s.cfg.Password == "" || subtle.ConstantTimeCompare([]byte(pass), []byte(s.cfg.Password)) != 1 {
+		return nil, errInvalidCredentials
+	}
+	local, host, hasDomain := strings.Cut(strings.TrimSpace(name), "@")
+	if hasDomain && !strings.EqualFold(host, s.cfg.Domain) {
+		return nil, errInvalidCredentials
+	}
+	userID, sessionID, hasSession := strings.Cut(local, "+")
+	if !hasSession {
+		sessionID = s.cfg.DefaultSession
+	}
+	if userID == "" || sessionID == "" {
+		return nil, errInvalidCredentials
+	}
+	if _, err := s.sessions.GetByID(ctx, sessionID); err != nil {
+		return nil, errInvalidCredentials
+	}
+	return &user{sessionID: sessionID, userID: userID}, nil
+}
+
+// conn 单个 IMAP 连接的状态
+type conn struct {
+	srv          *Server
+	conn         net.Conn
+	r            *bufio.Reader
+	w            *bufio.Writer
+	user         *user
+	box          *mailboxView
+	authFailures int
+}
+
+func newConn(srv *Server, c net.Conn) *conn {
+	return &conn{srv: srv, conn: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+}
+
+func (c *conn) serve() {
+	c.untagged("OK [CAPABILITY %s] Raven IMAP ready", capabilities)
+	c.w.Flush()
+
+	for {
+		c.conn.SetDeadline(time.Now().Add(c.srv.cfg.ReadTimeout))
+		line, err := readCommand(c.r, func() error {
+			c.w.WriteString("+ Ready for literal data\r\n")
+			return c.w.Flush()
+		})
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				c.untagged("BYE Line too long")
+				c.w.Flush()
+			}
+			return
+		}
+
+		tag, rest, _ := strings.Cut(line, " ")
+		if tag == "" || rest == "" {
+			c.untagged("BAD Missing tag or command")
+			c.w.Flush()
+			continue
+		}
+		cmd, args, _ := strings.Cut(rest, " ")
+		cmd = strings.ToUpper(cmd)
+		if cmd == "UID" {
+			var sub string
+			sub, args, _ = strings.Cut(args, " ")
+			cmd += " " + strings.ToUpper(sub)
+		}
+		if !c.handle(tag, cmd, args) {
+			return
+		}
+	}
+}
+
+// handle 执行一条命令，返回 false 表示关闭连接
+func (c *conn) handle(tag, cmd, args string) bool {
+	ctx := context.Background()
+
+	switch cmd {
+	case "CAPABILITY":
+		c.untagged("CAPABILITY %s", capabilities)
+		c.tagged(tag, "OK", "CAPABILITY completed")
+		return true
+	case "NOOP", "CHECK":
+		if c.box != nil {
+			if err := c.refresh(ctx); err != nil {
+				log.Printf("[IMAP] Refresh failed: %v", err)
+			}
+		}
+		c.tagged(tag, "OK", "%s completed", cmd)
+		return true
+	case "LOGOUT":
+		c.untagged("BYE Raven IMAP logging out")
+		c.tagged(tag, "OK", "LOGOUT completed")
+		return false
+	case "ID":
+		c.untagged(`ID ("name" "Raven")`)
+		c.tagged(tag, "OK", "ID completed")
+		return true
+	case "LOGIN":
+		return c.login(ctx, tag, args)
+	case "AUTHENTICATE":
+		return c.authenticatePlain(ctx, tag, args)
+	}
+
+	if c.user == nil {
+		c.tagged(tag, "BAD", "Command invalid in current state")
+		return true
+	}
+
+	switch cmd {
+	case "SELECT", "EXAMINE":
+		c.selectMailbox(ctx, tag, cmd, args)
+	case "LIST", "LSUB":
+		c.list(tag, cmd, args)
+	case "STATUS":
+		c.status(ctx, tag, args)
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		c.tagged(tag, "OK", "%s completed", cmd)
+	case "NAMESPACE":
+		c.untagged(`NAMESPACE (("" "/")) NIL NIL`)
+		c.tagged(tag, "OK", "NAMESPACE completed")
+	case "CREATE", "DELETE", "RENAME", "APPEND", "COPY", "UID COPY", "MOVE", "UID MOVE", "EXPUNGE", "UID EXPUNGE":
+		c.tagged(tag, "NO", "[CANNOT] Mailboxes are read-only")
+	case "CLOSE", "UNSELECT":
+		if c.box == nil {
+			c.tagged(tag, "BAD", "No mailbox selected")
+			return true
+		}
+		c.box = nil
+		c.tagged(tag, "OK", "%s completed", cmd)
+	case "SEARCH", "UID SEARCH":
+		c.withMailbox(tag, func() { c.search(ctx, tag, cmd, args) })
+	case "FETCH", "UID FETCH":
+		c.withMailbox(tag, func() { c.fetch(ctx, tag, cmd, args) })
+	case "STORE", "UID STORE":
+		c.withMailbox(tag, func() { c.store(ctx, tag, cmd, args) })
+	default:
+		c.tagged(tag, "BAD", "Unknown command")
+	}
+	return true
+}
+
+func (c *conn) withMailbox(tag string, fn func()) {
+	if c.box == nil {
+		c.tagged(tag, "BAD", "No mailbox selected")
+		return
+	}
+	fn()
+}
+
+func (c *conn) login(ctx context.Context, tag, args string) bool {
+	tokens, err := tokenize(args)
+	if err != nil || len(tokens) != 2 {
+		c.tagged(tag, "BAD", "Syntax: LOGIN user password")
+		return true
+	}
+	name, _ := tokens[0].(string)
+	pass, _ := tokens[1].(string)
+	return c.finishAuth(ctx, tag, name, pass)
+}
+
+func (c *conn) authenticatePlain(ctx context.Context, tag, args string) bool {
+	mechanism, resp, _ := strings.Cut(args, " ")
+	if !strings.EqualFold(mechanism, "PLAIN") {
+		c.tagged(tag, "NO", "Unsupported authentication mechanism")
+		return true
+	}
+	if resp == "" {
+		c.w.WriteString("+ \r\n")
+		c.w.Flush()
+		line, err := readLine(c.r)
+		if err != nil {
+			return false
+		}
+		if line == "*" {
+			c.tagged(tag, "BAD", "Authentication cancelled")
+			return true
+		}
+		resp = line
+	}
+	decoded, err := base64.StdEncoding.DecodeString(resp)
+	if err != nil {
+		c.tagged(tag, "BAD", "Invalid base64")
+		return true
+	}
+	parts := strings.Split(string(decoded), "\x00")
+	if len(parts) != 3 {
+		c.tagged(tag, "BAD", "Invalid PLAIN response")
+		return true
+	}
+	return c.finishAuth(ctx, tag, parts[1], parts[2])
+}
+
+func (c *conn) finishAuth(ctx context.Context, tag, name, pass string) bool {
+	if c.user != nil {
+		c.tagged(tag, "BAD", "Already authenticated")
+		return true
+	}
+	u, err := c.srv.authenticate(ctx, name, pass)
+	if err != nil {
+		c.authFailures++
+		c.tagged(tag, "NO", "[AUTHENTICATIONFAILED] Invalid credentials")
+		if c.authFailures >= maxAuthFailures {
+			log.Printf("[IMAP] Too many login failures from %s", c.conn.RemoteAddr())
+			return false
+		}
+		return true
+	}
+	c.user = u
+	c.tagged(tag, "OK", "[CAPABILITY %s] Logged in", capabilities)
+	return true
+}
+
+func (c *conn) selectMailbox(ctx context.Context, tag, cmd, args string) {
+	c.box = nil
+	tokens, err := tokenize(args)
+	if err != nil || len(tokens) != 1 {
+		c.tagged(tag, "BAD", "Syntax: %s mailbox", cmd)
+		return
+	}
+	arg, _ := tokens[0].(string)
+	name, ok := normalizeMailbox(arg)
+	if !ok {
+		c.tagged(tag, "NO", "[NONEXISTENT] No such mailbox")
+		return
+	}
+	view, err := c.srv.loadMailbox(ctx, c.user, name)
+	if err != nil {
+		log.Printf("[IMAP] Failed to load %s for %s: %v", name, c.user.key(name), err)
+		c.tagged(tag, "NO", "[UNAVAILABLE] Failed to load mailbox")
+		return
+	}
+	if cmd == "EXAMINE" {
+		view.readOnly = true
+	}
+	c.box = view
+
+	c.untagged("%d EXISTS", len(view.msgs))
+	c.untagged("0 RECENT")
+	c.untagged(`FLAGS (\Seen)`)
+	if view.readOnly {
+		c.untagged("OK [PERMANENTFLAGS ()] Read-only mailbox")
+	} else {
+		c.untagged(`OK [PERMANENTFLAGS (\Seen)] Only \Seen is stored`)
+	}
+	if n := view.firstUnseen(); n > 0 {
+		c.untagged("OK [UNSEEN %d] First unseen message", n)
+	}
+	c.untagged("OK [UIDVALIDITY %d] UIDs valid", c.srv.validity)
+	c.untagged("OK [UIDNEXT %d] Predicted next UID", c.srv.uids.uidNext(c.user.key(name)))
+	if view.readOnly {
+		c.tagged(tag, "OK", "[READ-ONLY] %s completed", cmd)
+	} else {
+		c.tagged(tag, "OK", "[READ-WRITE] %s completed", cmd)
+	}
+}
+
+// refresh 重新加载已选邮箱，向客户端推送 EXPUNGE / EXISTS / FLAGS 变化
+func (c *conn) refresh(ctx context.Context) error {
+	old := c.box
+	view, err := c.srv.loadMailbox(ctx, c.user, old.name)
+	if err != nil {
+		return err
+	}
+	view.readOnly = old.readOnly
+
+	current := make(map[uint32]*message, len(view.msgs))
+	for _, m := range view.msgs {
+		current[m.uid] = m
+	}
+	remaining := len(old.msgs)
+	for i := len(old.msgs) - 1; i >= 0; i-- {
+		if _, ok := current[old.msgs[i].uid]; !ok {
+			c.untagged("%d EXPUNGE", i+1)
+			remaining--
+		}
+	}
+	if len(view.msgs) != remaining {
+		c.untagged("%d EXISTS", len(view.msgs))
+	}
+
+	previous := make(map[uint32]*message, len(old.msgs))
+	for _, m := range old.msgs {
+		previous[m.uid] = m
+	}
+	for i, m := range view.msgs {
+		if p, ok := previous[m.uid]; ok {
+			m.raw, m.root = p.raw, p.root
+			if p.seen != m.seen {
+				c.untagged("%d FETCH (FLAGS %s)", i+1, flags(m))
+			}
+		}
+	}
+	c.box = view
+	return nil
+}
+
+func (c *conn) list(tag, cmd, args string) {
+	tokens, err := tokenize(args)
+	if err != nil || len(tokens) != 2 {
+		c.tagged(tag, "BAD", "Syntax: %s reference mailbox", cmd)
+		return
+	}
+	ref, _ := tokens[0].(string)
+	pattern, _ := tokens[1].(string)
+	if pattern == "" && cmd == "LIST" {
+		c.untagged(`LIST (\Noselect) "/" ""`)
+		c.tagged(tag, "OK", "LIST completed")
+		return
+	}
+
+	re := listPattern(ref + pattern)
+	for _, name := range []string{mailboxInbox, mailboxSent} {
+		if !re.MatchString(name) && !(name == mailboxInbox && re.MatchString(strings.ToLower(name))) {
+			continue
+		}
+		attrs := `\HasNoChildren`
+		if name == mailboxSent && cmd == "LIST" {
+			attrs += ` \Sent`
+		}
+		c.untagged(`%s (%s) "/" %s`, cmd, attrs, quote(name))
+	}
+	c.tagged(tag, "OK", "%s completed", cmd)
+}
+
+// listPattern 将 LIST 通配符 (* 匹配任意字符，% 不跨层级) 转换为正则
+func listPattern(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '%':
+			sb.WriteString("[^/]*")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+func (c *conn) status(ctx context.Context, tag, args string) {
+	tokens, err := tokenize(args)
+	if err != nil || len(tokens) != 2 {
+		c.tagged(tag, "BAD", "Syntax: STATUS mailbox (items)")
+		return
+	}
+	arg, _ := tokens[0].(string)
+	items, ok := tokens[1].([]interface{})
+	name, exists := normalizeMailbox(arg)
+	if !ok {
+		c.tagged(tag, "BAD", "Syntax: STATUS mailbox (items)")
+		return
+	}
+	if !exists {
+		c.tagged(tag, "NO", "[NONEXISTENT] No such mailbox")
+		return
+	}
+	view, err := c.srv.loadMailbox(ctx, c.user, name)
+	if err != nil {
+		c.tagged(tag, "NO", "[UNAVAILABLE] Failed to load mailbox")
+		return
+	}
+
+	var out []string
+	for _, item := range items {
+		s, _ := item.(string)
+		switch strings.ToUpper(s) {
+		case "MESSAGES":
+			out = append(out, fmt.Sprintf("MESSAGES %d", len(view.msgs)))
+		case "RECENT":
+			out = append(out, "RECENT 0")
+		case "UIDNEXT":
+			out = append(out, fmt.Sprintf("UIDNEXT %d", c.srv.uids.uidNext(c.user.key(name))))
+		case "UIDVALIDITY":
+			out = append(out, fmt.Sprintf("UIDVALIDITY %d", c.srv.validity))
+		case "UNSEEN":
+			out = append(out, fmt.Sprintf("UNSEEN %d", view.unseen()))
+		default:
+			c.tagged(tag, "BAD", "Unknown status item %s", s)
+			return
+		}
+	}
+	c.untagged("STATUS %s (%s)", quote(name), strings.Join(out, " "))
+	c.tagged(tag, "OK", "STATUS completed")
+}
+
+// store 仅处理 \Seen 标记，映射为文电阅读状态；其他标记被忽略
+func (c *conn) store(ctx context.Context, tag, cmd, args string) {
+	tokens, err := tokenize(args)
+	if err != nil || len(tokens) < 3 {
+		c.tagged(tag, "BAD", "Syntax: STORE set item flags")
+		return
+	}
+	setStr, _ := tokens[0].(string)
+	item, _ := tokens[1].(string)
+	set, err := parseSeqSet(setStr)
+	if err != nil {
+		c.tagged(tag, "BAD", "Invalid sequence set")
+		return
+	}
+	item = strings.ToUpper(item)
+	silent := strings.HasSuffix(item, ".SILENT")
+	item = strings.TrimSuffix(item, ".SILENT")
+	if item != "FLAGS" && item != "+FLAGS" && item != "-FLAGS" {
+		c.tagged(tag, "BAD", "Unknown STORE item")
+		return
+	}
+	if c.box.readOnly {
+		c.tagged(tag, "NO", "[READ-ONLY] Mailbox is read-only")
+		return
+	}
+
+	hasSeen := false
+	for _, t := range tokens[2:] {
+		list, ok := t.([]interface{})
+		if !ok {
+			list = []interface{}{t}
+		}
+		for _, f := range list {
+			if s, _ := f.(string); strings.EqualFold(s, `\Seen`) {
+				hasSeen = true
+			}
+		}
+	}
+
+	uidMode := cmd == "UID STORE"
+	for _, i := range c.match(set, uidMode) {
+		msg := c.box.msgs[i]
+		seen := msg.seen
+		switch item {
+		case "FLAGS":
+			seen = hasSeen
+		case "+FLAGS":
+			seen = seen || hasSeen
+		case "-FLAGS":
+			seen = seen && !hasSeen
+		}
+		if seen != msg.seen {
+			if err := c.setSeen(ctx, msg, seen); err != nil {
+				log.Printf("[IMAP] Failed to update mail %s: %v", msg.mail.ID, err)
+				c.tagged(tag, "NO", "Failed to update flags")
+				return
+			}
+		}
+		if !silent {
+			if uidMode {
+				c.untagged("%d FETCH (UID %d FLAGS %s)", i+1, msg.uid, flags(msg))
+			} else {
+				c.untagged("%d FETCH (FLAGS %s)", i+1, flags(msg))
+			}
+		}
+	}
+	c.tagged(tag, "OK", "%s completed", cmd)
+}
+
+// setSeen 通过 MailService 同步阅读状态，与网页端阅读一样触发 READ 通知与 webhook
+func (c *conn) setSeen(ctx context.Context, msg *message, seen bool) error {
+	sessionID := c.user.sessionID
+	if err := c.srv.mails.SetMailRead(ctx, sessionID, c.user.userID, msg.mail.ID, seen); err != nil {
+		return err
+	}
+	msg.seen = seen
+
+	if seen && c.srv.audit != nil {
+		entry := domain.AuditLog{
+			SessionID: sessionID,
+			ActorID:   c.user.userID,
+			Action:    domain.AuditActionMailRead,
+			TargetID:  msg.mail.ID,
+			IP:        hostOf(c.conn.RemoteAddr()),
+			UserAgent: "imap",
+		}
+		if err := c.srv.audit.Record(ctx, entry); err != nil {
+			log.Printf("[IMAP] Failed to record audit: %v", err)
+		}
+	}
+	return nil
+}
+
+// match 返回集合中的消息下标，uidMode 时按 UID 匹配
+func (c *conn) match(set seqSet, uidMode bool) []int {
+	var idx []int
+	for i, m := range c.box.msgs {
+		if uidMode && set.contains(m.uid, c.box.maxUID()) || !uidMode && set.contains(uint32(i+1), uint32(len(c.box.msgs))) {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+func flags(m *message) string {
+	if m.seen {
+		return `(\Seen)`
+	}
+	return "()"
+}
+
+func hostOf(addr net.Addr) string {
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+func (c *conn) untagged(format string, args ...interface{}) {
+	c.w.WriteString("* " + fmt.Sprintf(format, args...) + "\r\n")
+}
+
+func (c *conn) tagged(tag, status, format string, args ...interface{}) {
+	c.w.WriteString(tag + " " + status + " " + fmt.Sprintf(format, args...) + "\r\n")
+	c.w.Flush()
+}
@@ -0,0 +1,444 @@
+package imapd
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore 内存中的邮箱，记录 SetMailRead 调用
+type fakeStore struct {
+	mu       sync.Mutex
+	mails    []domain.Mail
+	reads    []string // mailID=read/unread
+	readFail error
+}
+
+func (f *fakeStore) GetInbox(ctx context.Context, sessionID, userID string, page, pageSize int, query string) ([]domain.Mail, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []domain.Mail
+	for _, m := range f.mails {
+		for _, r := range m.Recipients {
+			if m.SessionID == sessionID && r.RecipientID == userID && r.Status != "deleted" {
+				out = append(out, copyMail(m))
+				break
+			}
+		}
+	}
+	return out, int64(len(out)), nil
+}
+
+func (f *fakeStore) GetSent(ctx context.Context, sessionID, userID string, page, pageSize int, query string) ([]domain.Mail, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []domain.Mail
+	for _, m := range f.mails {
+		if m.SessionID == sessionID && m.SenderID == userID {
+			out = append(out, copyMail(m))
+		}
+	}
+	return out, int64(len(out)), nil
+}
+
+func (f *fakeStore) SetMailRead(ctx context.Context, sessionID, userID, mailID string, read bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.readFail != nil {
+		return f.readFail
+	}
+	status := "unread"
+	if read {
+		status = "read"
+	}
+	f.reads = append(f.reads, mailID+"="+status)
+	f.setStatus(mailID, userID, status)
+	return nil
+}
+
+func (f *fakeStore) setStatus(mailID, userID, status string) {
+	for i := range f.mails {
+		if f.mails[i].ID != mailID {
+			continue
+		}
+		for j := range f.mails[i].Recipients {
+			if f.mails[i].Recipients[j].RecipientID == userID {
+				f.mails[i].Recipients[j].Status = status
+			}
+		}
+	}
+}
+
+func (f *fakeStore) readCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.reads...)
+}
+
+func copyMail(m domain.Mail) domain.Mail {
+	m.Recipients = append([]domain.MailRecipient(nil), m.Recipients...)
+	return m
+}
+
+// fakeSessions 只实现 authenticate 用到的 GetByID
+type fakeSessions struct {
+	ports.SessionRepository
+	ids map[string]bool
+}
+
+func (f *fakeSessions) GetByID(ctx context.Context, id string) (*domain.Session, error) {
+	if !f.ids[id] {
+		return nil, errors.New("record not found")
+	}
+	return &domain.Session{ID: id}, nil
+}
+
+// fakeStorage 只实现渲染附件用到的 GetFile
+type fakeStorage struct {
+	ports.StorageService
+	files map[string]string
+}
+
+func (f *fakeStorage) GetFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	data, ok := f.files[path]
+	if !ok {
+		return nil, errors.New("no such file")
+	}
+	return io.NopCloser(strings.NewReader(data)), nil
+}
+
+const testPassword = "drill"
+
+var baseTime = time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC)
+
+func newTestStore() *fakeStore {
+	return &fakeStore{mails: []domain.Mail{
+		{
+			ID: "m1", SessionID: "s1", SenderID: "hq", Subject: "Sitrep", Content: "<p>Hold position</p>", ContentType: "rich",
+			CreatedAt: baseTime, RealCreatedAt: baseTime,
+			Recipients:  []domain.MailRecipient{{RecipientID: "alpha", Type: "to", Status: "unread"}, {RecipientID: "spy", Type: "bcc", Status: "unread"}},
+			Attachments: []domain.Attachment{{FileName: "map.png", FilePath: "s1/map.png", MimeType: "image/png"}},
+		},
+		{
+			ID: "m2", SessionID: "s1", SenderID: "bravo", Subject: "Copy", Content: "ack", ContentType: "text",
+			CreatedAt: baseTime.Add(time.Minute), RealCreatedAt: baseTime.Add(time.Minute),
+			Recipients: []domain.MailRecipient{{RecipientID: "alpha", Type: "cc", Status: "read"}},
+		},
+		{
+			ID: "m3", SessionID: "s1", SenderID: "alpha", Subject: "Report", Content: "done", ContentType: "text",
+			CreatedAt: baseTime.Add(2 * time.Minute), RealCreatedAt: baseTime.Add(2 * time.Minute),
+			Recipients: []domain.MailRecipient{{RecipientID: "hq", Type: "to", Status: "unread"}},
+		},
+	}}
+}
+
+func newTestServer(cfg Config, store *fakeStore) *Server {
+	if cfg.Password == "" {
+		cfg.Password = testPassword
+	}
+	sessions := &fakeSessions{ids: map[string]bool{"s1": true, "default": true}}
+	storage := &fakeStorage{files: map[string]string{"s1/map.png": "PNGDATA"}}
+	return NewServer(cfg, store, sessions, storage, nil)
+}
+
+// client IMAP 客户端一侧，按 tag 收集响应
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	seq  int
+}
+
+var literalTail = regexp.MustCompile(`\{(\d+)\}$`)
+
+// dial 以 net.Pipe 建立一条 IMAP 会话并读掉问候语
+func dial(t *testing.T, srv *Server) *client {
+	t.Helper()
+	c, s := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		newConn(srv, s).serve()
+		s.Close()
+	}()
+	t.Cleanup(func() {
+		c.Close()
+		<-done
+	})
+	cl := &client{t: t, conn: c, r: bufio.NewReader(c)}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	greeting := cl.line()
+	require.True(t, strings.HasPrefix(greeting, "* OK"), greeting)
+	return cl
+}
+
+// line 读取一行响应，行尾的字面量 {n} 连同后续内容一并读入
+func (cl *client) line() string {
+	cl.t.Helper()
+	var sb strings.Builder
+	for {
+		l, err := cl.r.ReadString('\n')
+		require.NoError(cl.t, err)
+		sb.WriteString(l)
+		m := literalTail.FindStringSubmatch(strings.TrimRight(l, "\r\n"))
+		if m == nil {
+			return strings.TrimRight(sb.String(), "\r\n")
+		}
+		n, _ := strconv.Atoi(m[1])
+		buf := make([]byte, n)
+		_, err = io.ReadFull(cl.r, buf)
+		require.NoError(cl.t, err)
+		sb.Write(buf)
+	}
+}
+
+// do 发送命令，返回未加标签的响应与带标签的最终状态行
+func (cl *client) do(format string, args ...interface{}) ([]string, string) {
+	cl.t.Helper()
+	cl.seq++
+	tag := fmt.Sprintf("a%d", cl.seq)
+	_, err := fmt.Fprintf(cl.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...))
+	require.NoError(cl.t, err)
+
+	var untagged []string
+	for {
+		l := cl.line()
+		if strings.HasPrefix(l, tag+" ") {
+			return untagged, strings.TrimPrefix(l, tag+" ")
+		}
+		untagged = append(untagged, l)
+	}
+}
+
+func (cl *client) ok(format string, args ...interface{}) []string {
+	cl.t.Helper()
+	untagged, status := cl.do(format, args...)
+	require.True(cl.t, strings.HasPrefix(status, "OK"), "%s: %s", fmt.Sprintf(format, args...), status)
+	return untagged
+}
+
+func (cl *client) login() {
+	cl.t.Helper()
+	cl.ok("LOGIN alpha+s1 %s", testPassword)
+}
+
+func TestServer_RequiresPassword(t *testing.T) {
+	srv := NewServer(Config{}, newTestStore(), &fakeSessions{}, &fakeStorage{}, nil)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, srv.Serve(l), ErrPasswordRequired)
+	assert.ErrorIs(t, srv.ListenAndServe(), ErrPasswordRequired)
+	_, err = srv.authenticate(context.Background(), "alpha+s1", "")
+	assert.ErrorIs(t, err, errInvalidCredentials)
+}
+
+func TestServer_Login(t *testing.T) {
+	srv := newTestServer(Config{}, newTestStore())
+
+	t.Run("Commands before login are refused", func(t *testing.T) {
+		cl := dial(t, srv)
+		_, status := cl.do("SELECT INBOX")
+		assert.Equal(t, "BAD Command invalid in current state", status)
+	})
+
+	t.Run("Wrong password, domain or unknown session", func(t *testing.T) {
+		cl := dial(t, srv)
+		_, status := cl.do("LOGIN alpha+s1 wrong")
+		assert.Equal(t, "NO [AUTHENTICATIONFAILED] Invalid credentials", status)
+		_, status = cl.do("LOGIN alpha+s1@other.org %s", testPassword)
+		assert.Equal(t, "NO [AUTHENTICATIONFAILED] Invalid credentials", status)
+		// 第三次失败后断开连接
+		_, err := fmt.Fprintf(cl.conn, "a9 LOGIN alpha+nope %s\r\n", testPassword)
+		require.NoError(t, err)
+		assert.Equal(t, "a9 NO [AUTHENTICATIONFAILED] Invalid credentials", cl.line())
+		_, err = cl.r.ReadString('\n')
+		assert.Error(t, err)
+	})
+
+	t.Run("LOGIN with quoted name and domain", func(t *testing.T) {
+		cl := dial(t, srv)
+		_, status := cl.do(`LOGIN "alpha+s1@raven.local" "%s"`, testPassword)
+		assert.True(t, strings.HasPrefix(status, "OK [CAPABILITY"), status)
+		_, status = cl.do("LOGIN alpha+s1 %s", testPassword)
+		assert.Equal(t, "BAD Already authenticated", status)
+	})
+
+	t.Run("AUTHENTICATE PLAIN with initial response", func(t *testing.T) {
+		cl := dial(t, srv)
+		resp := base64.StdEncoding.EncodeToString([]byte("\x00alpha+s1\x00" + testPassword))
+		_, status := cl.do("AUTHENTICATE PLAIN %s", resp)
+		assert.True(t, strings.HasPrefix(status, "OK"), status)
+	})
+}
+
+func TestServer_Select(t *testing.T) {
+	srv := newTestServer(Config{}, newTestStore())
+	cl := dial(t, srv)
+	cl.login()
+
+	untagged, status := cl.do("SELECT inbox")
+	assert.Equal(t, "OK [READ-WRITE] SELECT completed", status)
+	assert.Contains(t, untagged, "* 2 EXISTS")
+	assert.Contains(t, untagged, `* OK [PERMANENTFLAGS (\Seen)] Only \Seen is stored`)
+	assert.Contains(t, untagged, "* OK [UNSEEN 1] First unseen message")
+	assert.Contains(t, untagged, fmt.Sprintf("* OK [UIDVALIDITY %d] UIDs valid", srv.validity))
+	assert.Contains(t, untagged, "* OK [UIDNEXT 3] Predicted next UID")
+
+	untagged, status = cl.do("EXAMINE INBOX")
+	assert.Equal(t, "OK [READ-ONLY] EXAMINE completed", status)
+	assert.Contains(t, untagged, "* OK [PERMANENTFLAGS ()] Read-only mailbox")
+
+	untagged, status = cl.do("SELECT Sent")
+	assert.Equal(t, "OK [READ-ONLY] SELECT completed", status)
+	assert.Contains(t, untagged, "* 1 EXISTS")
+
+	_, status = cl.do("SELECT Archive")
+	assert.Equal(t, "NO [NONEXISTENT] No such mailbox", status)
+	_, status = cl.do("FETCH 1 FLAGS")
+	assert.Equal(t, "BAD No mailbox selected", status)
+}
+
+func TestServer_Fetch(t *testing.T) {
+	t.Run("BODYSTRUCTURE and header fields", func(t *testing.T) {
+		store := newTestStore()
+		cl := dial(t, newTestServer(Config{}, store))
+		cl.login()
+		cl.ok("SELECT INBOX")
+
+		untagged := cl.ok("FETCH 1 (FLAGS BODYSTRUCTURE)")
+		require.Len(t, untagged, 1)
+		assert.True(t, strings.HasPrefix(untagged[0], `* 1 FETCH (FLAGS () BODYSTRUCTURE (("TEXT" "HTML" ("CHARSET" "utf-8") NIL NIL "QUOTED-PRINTABLE" `), untagged[0])
+		assert.Contains(t, untagged[0], `("IMAGE" "PNG" ("NAME" "map.png") NIL NIL "BASE64" `)
+		assert.Contains(t, untagged[0], `("ATTACHMENT" ("FILENAME" "map.png"))`)
+		assert.True(t, strings.HasSuffix(untagged[0], `"MIXED" ("BOUNDARY" `+quote(boundaryOf(t, untagged[0]))+`) NIL NIL))`), untagged[0])
+
+		untagged = cl.ok("FETCH 1 (BODY.PEEK[HEADER.FIELDS (Subject To)])")
+		require.Len(t, untagged, 1)
+		// 字段按报文中的顺序返回
+		header := "To: <alpha+s1@raven.local>\r\nSubject: Sitrep\r\n\r\n"
+		assert.Equal(t, fmt.Sprintf("* 1 FETCH (BODY[HEADER.FIELDS (Subject To)] {%d}\r\n%s)", len(header), header), untagged[0])
+		assert.Empty(t, store.readCalls(), "PEEK must not set \\Seen")
+	})
+
+	t.Run("BODY[] sets Seen through the mail service", func(t *testing.T) {
+		store := newTestStore()
+		cl := dial(t, newTestServer(Config{}, store))
+		cl.login()
+		cl.ok("SELECT INBOX")
+
+		untagged := cl.ok("UID FETCH 1 BODY[]")
+		require.Len(t, untagged, 1)
+		msg := untagged[0]
+		assert.True(t, strings.HasPrefix(msg, "* 1 FETCH (UID 1 BODY[] {"), msg)
+		assert.Contains(t, msg, "Subject: Sitrep\r\n")
+		assert.Contains(t, msg, "X-Raven-Session: s1\r\n")
+		assert.NotContains(t, msg, "spy", "recipients must not see Bcc")
+		assert.Contains(t, msg, base64.StdEncoding.EncodeToString([]byte("PNGDATA")))
+		assert.True(t, strings.HasSuffix(msg, ` FLAGS (\Seen))`), msg)
+		assert.Equal(t, []string{"m1=read"}, store.readCalls())
+
+		// 已读邮件再次读取不重复更新
+		cl.ok("FETCH 1 BODY[TEXT]")
+		assert.Equal(t, []string{"m1=read"}, store.readCalls())
+	})
+
+	t.Run("EXAMINE never changes flags", func(t *testing.T) {
+		store := newTestStore()
+		cl := dial(t, newTestServer(Config{}, store))
+		cl.login()
+		cl.ok("EXAMINE INBOX")
+
+		untagged := cl.ok("FETCH 1 BODY[1]")
+		assert.Equal(t, "* 1 FETCH (BODY[1] {20}\r\n<p>Hold position</p>)", untagged[0])
+		assert.Empty(t, store.readCalls())
+	})
+}
+
+// boundaryOf 从 BODYSTRUCTURE 中取出 multipart 分隔符
+func boundaryOf(t *testing.T, resp string) string {
+	m := regexp.MustCompile(`"BOUNDARY" "([^"]+)"`).FindStringSubmatch(resp)
+	require.NotNil(t, m, resp)
+	return m[1]
+}
+
+func TestServer_Store(t *testing.T) {
+	t.Run("UID STORE maps Seen to read status", func(t *testing.T) {
+		store := newTestStore()
+		cl := dial(t, newTestServer(Config{}, store))
+		cl.login()
+		cl.ok("SELECT INBOX")
+
+		untagged := cl.ok(`UID STORE 1:* +FLAGS (\Seen)`)
+		assert.Equal(t, []string{`* 1 FETCH (UID 1 FLAGS (\Seen))`, `* 2 FETCH (UID 2 FLAGS (\Seen))`}, untagged)
+		assert.Equal(t, []string{"m1=read"}, store.readCalls())
+
+		untagged = cl.ok(`UID STORE 2 -FLAGS.SILENT (\Seen)`)
+		assert.Empty(t, untagged)
+		assert.Equal(t, []string{"m1=read", "m2=unread"}, store.readCalls())
+
+		// 其他标记被忽略
+		untagged = cl.ok(`STORE 1 FLAGS (\Flagged)`)
+		assert.Equal(t, []string{`* 1 FETCH (FLAGS ())`}, untagged)
+		assert.Equal(t, []string{"m1=read", "m2=unread", "m1=unread"}, store.readCalls())
+	})
+
+	t.Run("Read-only mailbox and failures", func(t *testing.T) {
+		store := newTestStore()
+		cl := dial(t, newTestServer(Config{}, store))
+		cl.login()
+		cl.ok("EXAMINE INBOX")
+		_, status := cl.do(`STORE 1 +FLAGS (\Seen)`)
+		assert.Equal(t, "NO [READ-ONLY] Mailbox is read-only", status)
+
+		cl.ok("SELECT INBOX")
+		store.readFail = errors.New("session is not running")
+		_, status = cl.do(`STORE 1 +FLAGS (\Seen)`)
+		assert.Equal(t, "NO Failed to update flags", status)
+		_, status = cl.do(`STORE x +FLAGS (\Seen)`)
+		assert.Equal(t, "BAD Invalid sequence set", status)
+	})
+}
+
+func TestServer_Refresh(t *testing.T) {
+	store := newTestStore()
+	cl := dial(t, newTestServer(Config{}, store))
+	cl.login()
+	cl.ok("SELECT INBOX")
+
+	// 其他客户端删除 m1、标记 m2 未读，并收到一封新文电
+	store.mu.Lock()
+	store.setStatus("m1", "alpha", "deleted")
+	store.setStatus("m2", "alpha", "unread")
+	store.mails = append(store.mails, domain.Mail{
+		ID: "m4", SessionID: "s1", SenderID: "hq", Subject: "New", Content: "x", ContentType: "text",
+		CreatedAt: baseTime.Add(3 * time.Minute), RealCreatedAt: baseTime.Add(3 * time.Minute),
+		Recipients: []domain.MailRecipient{{RecipientID: "alpha", Type: "to", Status: "unread"}},
+	})
+	store.mu.Unlock()
+
+	untagged := cl.ok("NOOP")
+	assert.Equal(t, []string{"* 1 EXPUNGE", "* 2 EXISTS", "* 1 FETCH (FLAGS ())"}, untagged)
+
+	untagged = cl.ok("UID FETCH 1:* (FLAGS)")
+	assert.Equal(t, []string{"* 1 FETCH (UID 2 FLAGS ())", "* 2 FETCH (UID 3 FLAGS ())"}, untagged)
+
+	// 无变化时不推送
+	assert.Empty(t, cl.ok("NOOP"))
+}
@@ -0,0 +1,167 @@
+// Package mimemail 将文电渲染为 RFC 5322 MIME 报文，供 SMTP 转发与 IMAP 读取共用
+package mimemail
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	From        mail.Address
+	To          []mail.Address
+	Cc          []mail.Address
+	Bcc         []mail.Address
+	Subject     string
+	Date        time.Time
+	MessageID   string // 不含尖括号，如 <id>@raven.local 中的 id@raven.local
+	InReplyTo   string
+	Headers     [][2]string // 附加报文头，如 X-Raven-Session
+	Content     string
+	ContentType string // 文电内容类型: text、rich 等
+	Attachments []Attachment
+}
+
+type Attachment struct {
+	FileName string
+	MimeType string
+	Data     []byte
+}
+
+// headerSanitizer 防止用户输入的 ID 或主题注入额外的报文头
+var headerSanitizer = strings.NewReplacer("\r", "", "\n", "")
+
+// Render 渲染 MIME 报文；有附件时使用 multipart/mixed。
+// 分隔符由 MessageID 派生，同一文电多次渲染结果一致 (IMAP 依赖报文大小稳定)。
+func Render(m Message) ([]byte, error) {
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var buf bytes.Buffer
+	writeHeader := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, headerSanitizer.Replace(value))
+	}
+	writeHeader("From", m.From.String())
+	if len(m.To) > 0 {
+		writeHeader("To", addressList(m.To))
+	}
+	if len(m.Cc) > 0 {
+		writeHeader("Cc", addressList(m.Cc))
+	}
+	if len(m.Bcc) > 0 {
+		writeHeader("Bcc", addressList(m.Bcc))
+	}
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader("Date", date.Format(time.RFC1123Z))
+	if m.MessageID != "" {
+		writeHeader("Message-ID", "<"+m.MessageID+">")
+	}
+	if m.InReplyTo != "" {
+		writeHeader("In-Reply-To", "<"+m.InReplyTo+">")
+	}
+	for _, h := range m.Headers {
+		writeHeader(h[0], h[1])
+	}
+	writeHeader("MIME-Version", "1.0")
+
+	bodyType := "text/plain; charset=utf-8"
+	if m.ContentType == "rich" {
+		bodyType = "text/html; charset=utf-8"
+	}
+
+	if len(m.Attachments) == 0 {
+		writeHeader("Content-Type", bodyType)
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, m.Content); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	sum := sha1.Sum([]byte(m.MessageID + date.String()))
+	if err := mw.SetBoundary("raven-" + hex.EncodeToString(sum[:12])); err != nil {
+		return nil, err
+	}
+	writeHeader("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+	buf.WriteString("\r\n")
+
+	body, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {bodyType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(body, m.Content); err != nil {
+		return nil, err
+	}
+
+	for _, att := range m.Attachments {
+		mimeType := att.MimeType
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(mimeType, map[string]string{"name": att.FileName})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": att.FileName})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(part, att.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func addressList(list []mail.Address) string {
+	parts := make([]string, 0, len(list))
+	for _, a := range list {
+		parts = append(parts, a.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64 按 76 字符折行写出 base64 编码
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := 76
+		if len(encoded) < n {
+			n = len(encoded)
+		}
+		if _, err := w.Write([]byte(encoded[:n] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
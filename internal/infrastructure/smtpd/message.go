@@ -0,0 +1,196 @@
+package smtpd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+
+	"raven/internal/core/ports"
+)
+
+// Address 信封地址映射后的用户与场次
+type Address struct {
+	UserID    string
+	SessionID string
+}
+
+// ParseAddress 将 user+session@domain 映射为用户 ID 与场次 ID，
+// 未带 +session 时使用 defaultSession；域名不匹配时返回错误
+func ParseAddress(addr, domain, defaultSession string) (Address, error) {
+	addr = strings.TrimSpace(addr)
+	at := strings.LastIndex(addr, "@")
+	if at <= 0 {
+		return Address{}, fmt.Errorf("invalid address %q", addr)
+	}
+	local, host := addr[:at], addr[at+1:]
+	if domain != "" && !strings.EqualFold(host, domain) {
+		return Address{}, fmt.Errorf("domain %q is not served here", host)
+	}
+
+	user, session := local, defaultSession
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		user, session = local[:plus], local[plus+1:]
+	}
+	if user == "" || session == "" {
+		return Address{}, fmt.Errorf("invalid address %q", addr)
+	}
+	return Address{UserID: user, SessionID: session}, nil
+}
+
+// parsedMessage 从 MIME 报文中提取的文电内容
+type parsedMessage struct {
+	Subject     string
+	Content     string
+	ContentType string // text 或 rich，与前端内容驱动一致
+	To          []string
+	Cc          []string
+	Attachments []attachmentPart
+}
+
+type attachmentPart struct {
+	FileName string
+	MimeType string
+	Data     []byte
+}
+
+// attachmentRequests 为每次投递生成独立的附件读取器
+func (pm *parsedMessage) attachmentRequests() []ports.AttachmentRequest {
+	reqs := make([]ports.AttachmentRequest, 0, len(pm.Attachments))
+	for _, a := range pm.Attachments {
+		reqs = append(reqs, ports.AttachmentRequest{
+			FileName: a.FileName,
+			Content:  bytes.NewReader(a.Data),
+			Size:     int64(len(a.Data)),
+			MimeType: a.MimeType,
+		})
+	}
+	return reqs
+}
+
+var wordDecoder = &mime.WordDecoder{}
+
+func parseMessage(raw []byte) (*parsedMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	pm := &parsedMessage{ContentType: "text"}
+	if subject, err := wordDecoder.DecodeHeader(msg.Header.Get("Subject")); err == nil {
+		pm.Subject = subject
+	} else {
+		pm.Subject = msg.Header.Get("Subject")
+	}
+	pm.To = headerAddresses(msg.Header, "To")
+	pm.Cc = headerAddresses(msg.Header, "Cc")
+
+	var plain, html string
+	err = walkPart(msg.Header, msg.Body, func(mediaType string, params map[string]string, filename string, body []byte) {
+		if filename == "" && !strings.HasPrefix(mediaType, "text/") {
+			filename = "attachment"
+		}
+		switch {
+		case filename != "":
+			pm.Attachments = append(pm.Attachments, attachmentPart{FileName: filename, MimeType: mediaType, Data: body})
+		case mediaType == "text/html" && html == "":
+			html = string(body)
+		case mediaType == "text/plain" && plain == "":
+			plain = string(body)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pm.Content = plain
+	if html != "" {
+		pm.Content = html
+		pm.ContentType = "rich"
+	}
+	return pm, nil
+}
+
+// partHeader 兼容 mail.Header 与 textproto.MIMEHeader
+type partHeader interface {
+	Get(key string) string
+}
+
+// walkPart 递归遍历 MIME 结构，对每个叶子节点回调解码后的内容
+func walkPart(header partHeader, body io.Reader, fn func(mediaType string, params map[string]string, filename string, body []byte)) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := walkPart(part.Header, part, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	decoded, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+
+	fn(mediaType, params, attachmentName(header, params), decoded)
+	return nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// attachmentName 从 Content-Disposition 或 Content-Type 的 name 参数中取附件名
+func attachmentName(header partHeader, typeParams map[string]string) string {
+	disposition, dparams, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := ""
+	if err == nil {
+		name = dparams["filename"]
+	}
+	if name == "" {
+		name = typeParams["name"]
+	}
+	if name == "" && disposition == "attachment" {
+		name = "attachment"
+	}
+	if decoded, err := wordDecoder.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	return name
+}
+
+func headerAddresses(h mail.Header, key string) []string {
+	list, err := h.AddressList(key)
+	if err != nil {
+		return nil
+	}
+	addrs := make([]string, 0, len(list))
+	for _, a := range list {
+		addrs = append(addrs, strings.ToLower(a.Address))
+	}
+	return addrs
+}
@@ -0,0 +1,591 @@
+// Package smtpd 提供内嵌的 SMTP 入站网关，将 SMTP 投递的邮件写入演练场次。
+// 仅实现网关所需的最小命令集 (EHLO/HELO、AUTH PLAIN/LOGIN、MAIL、RCPT、DATA、RSET、NOOP、QUIT)。
+package smtpd
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+)
+
+const (
+	defaultMaxMessageBytes = 10 << 20
+	defaultMaxRecipients   = 100
+	defaultReadTimeout     = 5 * time.Minute
+	maxLineLength          = 4096
+	maxAuthFailures        = 3
+)
+
+// MailSender 网关投递文电所需的能力，由 MailService 实现
+type MailSender interface {
+	SendMail(ctx context.Context, senderID string, req ports.SendMailRequest) (*domain.Mail, error)
+	// RequireRunningSession 场次不可写入时返回错误，用于在 RCPT 与 DATA 阶段预先拒绝
+	RequireRunningSession(ctx context.Context, sessionID string) error
+}
+
+type Config struct {
+	Addr            string // 监听地址，如 127.0.0.1:2525
+	Domain          string // 收件域名，如 raven.local
+	DefaultSession  string // 收件地址未带 +session 时使用的场次
+	Username        string // 非空时要求客户端先通过 AUTH
+	Password        string
+	MaxMessageBytes int64
+	MaxRecipients   int
+	ReadTimeout     time.Duration
+}
+
+type Server struct {
+	cfg   Config
+	mails MailSender
+	audit ports.AuditService
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer 创建 SMTP 网关，audit 可为 nil
+func NewServer(cfg Config, mails MailSender, audit ports.AuditService) *Server {
+	if cfg.MaxMessageBytes <= 0 {
+		cfg.MaxMessageBytes = defaultMaxMessageBytes
+	}
+	if cfg.MaxRecipients <= 0 {
+		cfg.MaxRecipients = defaultMaxRecipients
+	}
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = defaultReadTimeout
+	}
+	if cfg.Domain == "" {
+		cfg.Domain = "raven.local"
+	}
+	if cfg.DefaultSession == "" {
+		cfg.DefaultSession = "default"
+	}
+	return &Server{cfg: cfg, mails: mails, audit: audit, conns: make(map[net.Conn]struct{})}
+}
+
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	log.Printf("[SMTP] Gateway listening on %s (domain %s)", l.Addr(), s.cfg.Domain)
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, c)
+				s.mu.Unlock()
+				c.Close()
+			}()
+			newSession(s, c).serve()
+		}()
+	}
+}
+
+// Close 停止监听并断开所有连接
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// session 单个 SMTP 连接的会话状态
+type session struct {
+	srv          *Server
+	conn         net.Conn
+	r            *bufio.Reader
+	w            *bufio.Writer
+	helo         string
+	authed       bool
+	authFailures int
+
+	from  string
+	rcpts []recipient
+}
+
+type recipient struct {
+	raw  string
+	addr Address
+}
+
+func newSession(srv *Server, c net.Conn) *session {
+	return &session{
+		srv:    srv,
+		conn:   c,
+		r:      bufio.NewReader(c),
+		w:      bufio.NewWriter(c),
+		authed: srv.cfg.Username == "",
+	}
+}
+
+func (ss *session) serve() {
+	ss.reply(220, "%s ESMTP Raven gateway ready", ss.srv.cfg.Domain)
+
+	for {
+		line, err := ss.readLine()
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				ss.reply(500, "5.5.2 Line too long")
+				continue
+			}
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			ss.helo = arg
+			ss.reset()
+			ss.reply(250, "%s", ss.srv.cfg.Domain)
+		case "EHLO":
+			ss.helo = arg
+			ss.reset()
+			ext := []string{ss.srv.cfg.Domain, "8BITMIME", fmt.Sprintf("SIZE %d", ss.srv.cfg.MaxMessageBytes)}
+			if ss.srv.cfg.Username != "" {
+				ext = append(ext, "AUTH PLAIN LOGIN")
+			}
+			ss.replyLines(250, ext)
+		case "AUTH":
+			if !ss.handleAuth(arg) {
+				return
+			}
+		case "MAIL":
+			ss.handleMail(arg)
+		case "RCPT":
+			ss.handleRcpt(arg)
+		case "DATA":
+			if !ss.handleData() {
+				return
+			}
+		case "RSET":
+			ss.reset()
+			ss.reply(250, "2.0.0 OK")
+		case "NOOP":
+			ss.reply(250, "2.0.0 OK")
+		case "QUIT":
+			ss.reply(221, "2.0.0 Bye")
+			return
+		default:
+			ss.reply(502, "5.5.1 Command not implemented")
+		}
+	}
+}
+
+func (ss *session) reset() {
+	ss.from = ""
+	ss.rcpts = nil
+}
+
+func (ss *session) handleAuth(arg string) bool {
+	if ss.srv.cfg.Username == "" {
+		ss.reply(502, "5.5.1 AUTH not enabled")
+		return true
+	}
+	if ss.authed {
+		ss.reply(503, "5.5.1 Already authenticated")
+		return true
+	}
+
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	var user, pass string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		resp := initial
+		if resp == "" {
+			var err error
+			if resp, err = ss.challenge(""); err != nil {
+				return err == errAuthCancelled
+			}
+		}
+		decoded, err := base64.StdEncoding.DecodeString(resp)
+		if err != nil {
+			ss.reply(501, "5.5.2 Invalid base64")
+			return true
+		}
+		parts := strings.Split(string(decoded), "\x00")
+		if len(parts) != 3 {
+			ss.reply(501, "5.5.2 Invalid PLAIN response")
+			return true
+		}
+		user, pass = parts[1], parts[2]
+	case "LOGIN":
+		resp := initial
+		var err error
+		if resp == "" {
+			if resp, err = ss.challenge("Username:"); err != nil {
+				return err == errAuthCancelled
+			}
+		}
+		u, err := base64.StdEncoding.DecodeString(resp)
+		if err != nil {
+			ss.reply(501, "5.5.2 Invalid base64")
+			return true
+		}
+		if resp, err = ss.challenge("Password:"); err != nil {
+			return err == errAuthCancelled
+		}
+		p, err := base64.StdEncoding.DecodeString(resp)
+		if err != nil {
+			ss.reply(501, "5.5.2 Invalid base64")
+			return true
+		}
+		user, pass = string(u), string(p)
+	default:
+		ss.reply(504, "5.5.4 Unrecognized authentication type")
+		return true
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(ss.srv.cfg.Username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(ss.srv.cfg.Password)) == 1
+	if !userOK || !passOK {
+		ss.authFailures++
+		ss.reply(535, "5.7.8 Authentication credentials invalid")
+		if ss.authFailures >= maxAuthFailures {
+			log.Printf("[SMTP] Too many AUTH failures from %s", ss.conn.RemoteAddr())
+			return false
+		}
+		return true
+	}
+
+	ss.authed = true
+	ss.reply(235, "2.7.0 Authentication successful")
+	return true
+}
+
+var errAuthCancelled = errors.New("authentication cancelled")
+
+// challenge 发送 334 质询并读取客户端响应，客户端以 * 取消时回复 501 并返回 errAuthCancelled
+func (ss *session) challenge(prompt string) (string, error) {
+	ss.reply(334, "%s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+	line, err := ss.readLine()
+	if err != nil {
+		return "", err
+	}
+	if line == "*" {
+		ss.reply(501, "5.0.0 Authentication cancelled")
+		return "", errAuthCancelled
+	}
+	return line, nil
+}
+
+func (ss *session) handleMail(arg string) {
+	if ss.helo == "" {
+		ss.reply(503, "5.5.1 Send HELO/EHLO first")
+		return
+	}
+	if !ss.authed {
+		ss.reply(530, "5.7.0 Authentication required")
+		return
+	}
+	if ss.from != "" {
+		ss.reply(503, "5.5.1 Sender already specified")
+		return
+	}
+
+	path, params, ok := parsePath(arg, "FROM:")
+	if !ok || path == "" {
+		ss.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, p := range params {
+		key, value, _ := strings.Cut(p, "=")
+		if strings.EqualFold(key, "SIZE") {
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil && size > ss.srv.cfg.MaxMessageBytes {
+				ss.reply(552, "5.3.4 Message size exceeds fixed limit")
+				return
+			}
+		}
+	}
+
+	ss.from = path
+	ss.reply(250, "2.1.0 OK")
+}
+
+func (ss *session) handleRcpt(arg string) {
+	if ss.from == "" {
+		ss.reply(503, "5.5.1 Need MAIL before RCPT")
+		return
+	}
+	if len(ss.rcpts) >= ss.srv.cfg.MaxRecipients {
+		ss.reply(452, "4.5.3 Too many recipients")
+		return
+	}
+
+	path, _, ok := parsePath(arg, "TO:")
+	if !ok || path == "" {
+		ss.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+	addr, err := ParseAddress(path, ss.srv.cfg.Domain, ss.srv.cfg.DefaultSession)
+	if err != nil {
+		ss.reply(550, "5.1.1 %v", err)
+		return
+	}
+	// 场次不可写入时只拒绝该收件人，其余收件人照常投递
+	if err := ss.srv.mails.RequireRunningSession(context.Background(), addr.SessionID); err != nil {
+		code, text := replyForError(err)
+		ss.reply(code, "%s", text)
+		return
+	}
+
+	ss.rcpts = append(ss.rcpts, recipient{raw: strings.ToLower(path), addr: addr})
+	ss.reply(250, "2.1.5 OK")
+}
+
+// handleData 读取报文并投递，返回 false 表示连接已不可用
+func (ss *session) handleData() bool {
+	if len(ss.rcpts) == 0 {
+		ss.reply(503, "5.5.1 Need RCPT before DATA")
+		return true
+	}
+	ss.reply(354, "Start mail input; end with <CRLF>.<CRLF>")
+
+	ss.conn.SetReadDeadline(time.Now().Add(ss.srv.cfg.ReadTimeout))
+	dot := textproto.NewReader(ss.r).DotReader()
+	raw, err := io.ReadAll(io.LimitReader(dot, ss.srv.cfg.MaxMessageBytes+1))
+	if err != nil {
+		return false
+	}
+	if int64(len(raw)) > ss.srv.cfg.MaxMessageBytes {
+		if _, err := io.Copy(io.Discard, dot); err != nil {
+			return false
+		}
+		ss.reset()
+		ss.reply(552, "5.3.4 Message size exceeds fixed limit")
+		return true
+	}
+
+	code, msg := ss.deliver(raw)
+	ss.reset()
+	ss.reply(code, "%s", msg)
+	return true
+}
+
+// deliver 按场次分组投递，每个场次生成一份文电。
+//
+// 客户端收到失败回复后会重发整封邮件，因此投递前先校验全部场次，任一场次不可写入时整体拒绝；
+// 已有场次投递成功后，后续场次的意外失败只记录日志并仍回复成功，避免重发造成重复文电。
+func (ss *session) deliver(raw []byte) (int, string) {
+	pm, err := parseMessage(raw)
+	if err != nil {
+		return 554, "5.6.0 Malformed message: " + err.Error()
+	}
+
+	senderID, _, _ := strings.Cut(ss.from, "@")
+	senderID, _, _ = strings.Cut(senderID, "+")
+
+	inHeader := func(list []string, addr string) bool {
+		for _, a := range list {
+			if a == addr {
+				return true
+			}
+		}
+		return false
+	}
+
+	var order []string
+	groups := make(map[string]*ports.SendMailRequest)
+	for _, rc := range ss.rcpts {
+		req, ok := groups[rc.addr.SessionID]
+		if !ok {
+			req = &ports.SendMailRequest{
+				SessionID:   rc.addr.SessionID,
+				Subject:     pm.Subject,
+				Content:     pm.Content,
+				ContentType: pm.ContentType,
+			}
+			groups[rc.addr.SessionID] = req
+			order = append(order, rc.addr.SessionID)
+		}
+		// 信封收件人按报文头归类: To / Cc 中出现的为主送/抄送，否则为密送
+		switch {
+		case inHeader(pm.To, rc.raw):
+			req.To = append(req.To, rc.addr.UserID)
+		case inHeader(pm.Cc, rc.raw):
+			req.Cc = append(req.Cc, rc.addr.UserID)
+		default:
+			req.Bcc = append(req.Bcc, rc.addr.UserID)
+		}
+	}
+
+	for _, sessionID := range order {
+		if err := ss.srv.mails.RequireRunningSession(context.Background(), sessionID); err != nil {
+			return replyForError(err)
+		}
+	}
+
+	var delivered, failed []string
+	for _, sessionID := range order {
+		req := groups[sessionID]
+		req.Attachments = pm.attachmentRequests()
+
+		mail, err := ss.srv.mails.SendMail(context.Background(), senderID, *req)
+		if err != nil {
+			log.Printf("[SMTP] Delivery to session %s failed: %v", sessionID, err)
+			if len(delivered) == 0 {
+				return replyForError(err)
+			}
+			failed = append(failed, sessionID)
+			continue
+		}
+		delivered = append(delivered, sessionID)
+
+		if ss.srv.audit != nil {
+			entry := domain.AuditLog{
+				SessionID: sessionID,
+				ActorID:   senderID,
+				Action:    domain.AuditActionMailSend,
+				TargetID:  mail.ID,
+				Detail:    mail.Subject,
+				IP:        hostOf(ss.conn.RemoteAddr()),
+				UserAgent: "smtp/" + ss.helo,
+			}
+			if err := ss.srv.audit.Record(context.Background(), entry); err != nil {
+				log.Printf("[SMTP] Failed to record audit: %v", err)
+			}
+		}
+	}
+
+	text := "2.0.0 OK: delivered to " + strings.Join(delivered, ", ")
+	if len(failed) > 0 {
+		log.Printf("[SMTP] Message from %s partially delivered, failed sessions: %s", ss.from, strings.Join(failed, ", "))
+		text += "; failed: " + strings.Join(failed, ", ")
+	}
+	return 250, text
+}
+
+func replyForError(err error) (int, string) {
+	var appErr *ports.AppError
+	if errors.As(err, &appErr) {
+		switch appErr.Type {
+		case ports.ErrorTypeNotFound, ports.ErrorTypeForbidden:
+			return 550, "5.7.1 " + appErr.Message
+		case ports.ErrorTypeInvalidInput:
+			return 554, "5.6.0 " + appErr.Message
+		}
+	}
+	return 451, "4.3.0 Temporary delivery failure"
+}
+
+// parsePath 解析 "FROM:<addr> PARAM=VALUE ..." 形式的参数
+func parsePath(arg, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", nil, false
+	}
+	end := strings.Index(rest, ">")
+	if end < 0 {
+		return "", nil, false
+	}
+	return rest[1:end], strings.Fields(rest[end+1:]), true
+}
+
+var errLineTooLong = errors.New("line too long")
+
+func (ss *session) readLine() (string, error) {
+	ss.conn.SetReadDeadline(time.Now().Add(ss.srv.cfg.ReadTimeout))
+	var buf []byte
+	for {
+		chunk, isPrefix, err := ss.r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, chunk...)
+		if len(buf) > maxLineLength {
+			// 丢弃该行剩余部分
+			for isPrefix {
+				if _, isPrefix, err = ss.r.ReadLine(); err != nil {
+					return "", err
+				}
+			}
+			return "", errLineTooLong
+		}
+		if !isPrefix {
+			return strings.TrimSpace(string(buf)), nil
+		}
+	}
+}
+
+func (ss *session) reply(code int, format string, args ...interface{}) {
+	fmt.Fprintf(ss.w, "%d %s\r\n", code, fmt.Sprintf(format, args...))
+	ss.w.Flush()
+}
+
+func (ss *session) replyLines(code int, lines []string) {
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		fmt.Fprintf(ss.w, "%d%s%s\r\n", code, sep, line)
+	}
+	ss.w.Flush()
+}
+
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
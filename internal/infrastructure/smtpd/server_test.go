@@ -0,0 +1,350 @@
+package smtpd
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSender 记录网关投递的文电，可按场次模拟不可写入或投递失败
+type fakeSender struct {
+	mu       sync.Mutex
+	sent     []sentMail
+	closed   map[string]bool  // 未在进行中的场次
+	failures map[string]error // SendMail 返回的错误
+}
+
+type sentMail struct {
+	senderID    string
+	req         ports.SendMailRequest
+	attachments map[string]string
+}
+
+func newFakeSender() *fakeSender {
+	return &fakeSender{closed: map[string]bool{}, failures: map[string]error{}}
+}
+
+func (f *fakeSender) SendMail(ctx context.Context, senderID string, req ports.SendMailRequest) (*domain.Mail, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures[req.SessionID]; err != nil {
+		return nil, err
+	}
+	atts := map[string]string{}
+	for _, a := range req.Attachments {
+		data, _ := io.ReadAll(a.Content)
+		atts[a.FileName] = string(data)
+	}
+	f.sent = append(f.sent, sentMail{senderID: senderID, req: req, attachments: atts})
+	return &domain.Mail{ID: "mail-" + req.SessionID, SessionID: req.SessionID, Subject: req.Subject}, nil
+}
+
+func (f *fakeSender) RequireRunningSession(ctx context.Context, sessionID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed[sessionID] {
+		return ports.NewForbiddenError("session is not running", nil)
+	}
+	return nil
+}
+
+func (f *fakeSender) mails() []sentMail {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]sentMail(nil), f.sent...)
+}
+
+// dial 以 net.Pipe 建立一条 SMTP 会话，返回客户端一侧并读掉问候语
+func dial(t *testing.T, cfg Config, sender *fakeSender) *textproto.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	srv := NewServer(cfg, sender, nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		newSession(srv, server).serve()
+		server.Close()
+	}()
+
+	conn := textproto.NewConn(client)
+	t.Cleanup(func() {
+		conn.Close()
+		<-done
+	})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadResponse(220)
+	require.NoError(t, err)
+	return conn
+}
+
+// cmd 发送一条命令并校验回复码，返回回复文本
+func cmd(t *testing.T, conn *textproto.Conn, expect int, format string, args ...interface{}) string {
+	t.Helper()
+	id, err := conn.Cmd(format, args...)
+	require.NoError(t, err)
+	conn.StartResponse(id)
+	defer conn.EndResponse(id)
+	code, msg, err := conn.ReadResponse(0)
+	require.NoError(t, err)
+	assert.Equal(t, expect, code, "%s -> %d %s", strings.SplitN(format, " ", 2)[0], code, msg)
+	return msg
+}
+
+// send 完成一次 EHLO/MAIL/RCPT/DATA 投递，返回 DATA 结束后的回复码与文本
+func send(t *testing.T, conn *textproto.Conn, from string, rcpts []string, message string) (int, string) {
+	t.Helper()
+	cmd(t, conn, 250, "EHLO client.test")
+	cmd(t, conn, 250, "MAIL FROM:<%s>", from)
+	for _, rcpt := range rcpts {
+		cmd(t, conn, 250, "RCPT TO:<%s>", rcpt)
+	}
+	cmd(t, conn, 354, "DATA")
+
+	w := conn.DotWriter()
+	_, err := io.WriteString(w, message)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	code, msg, err := conn.ReadResponse(0)
+	require.NoError(t, err)
+	return code, msg
+}
+
+func crlf(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+func TestServer_DotStuffing(t *testing.T) {
+	sender := newFakeSender()
+	conn := dial(t, Config{}, sender)
+
+	// DotWriter 会把以 "." 开头的行转义为 ".."，服务端须还原；行尾统一为 LF
+	code, _ := send(t, conn, "hq@raven.local", []string{"alpha@raven.local"}, crlf(
+		"Subject: dots\n"+
+			"To: alpha@raven.local\n"+
+			"\n"+
+			".leading dot\n"+
+			"..two dots\n"+
+			"end\n"))
+	assert.Equal(t, 250, code)
+
+	mails := sender.mails()
+	require.Len(t, mails, 1)
+	assert.Equal(t, "hq", mails[0].senderID)
+	assert.Equal(t, "dots", mails[0].req.Subject)
+	assert.Equal(t, ".leading dot\n..two dots\nend\n", mails[0].req.Content)
+}
+
+func TestServer_MultipartAttachments(t *testing.T) {
+	sender := newFakeSender()
+	conn := dial(t, Config{}, sender)
+
+	payload := base64.StdEncoding.EncodeToString([]byte("grid 38T LN 123 456"))
+	code, _ := send(t, conn, "hq@raven.local", []string{"alpha+exercise-1@raven.local"}, crlf(
+		"Subject: =?UTF-8?B?5L2c5oiY5ZG95Luk?=\n"+
+			"To: alpha+exercise-1@raven.local\n"+
+			"MIME-Version: 1.0\n"+
+			"Content-Type: multipart/mixed; boundary=outer\n"+
+			"\n"+
+			"--outer\n"+
+			"Content-Type: multipart/alternative; boundary=inner\n"+
+			"\n"+
+			"--inner\n"+
+			"Content-Type: text/plain; charset=utf-8\n"+
+			"\n"+
+			"plain body\n"+
+			"--inner\n"+
+			"Content-Type: text/html; charset=utf-8\n"+
+			"Content-Transfer-Encoding: quoted-printable\n"+
+			"\n"+
+			"<p>rich =3D body</p>\n"+
+			"--inner--\n"+
+			"--outer\n"+
+			"Content-Type: text/plain; name=\"plan.txt\"\n"+
+			"Content-Disposition: attachment; filename=\"plan.txt\"\n"+
+			"Content-Transfer-Encoding: base64\n"+
+			"\n"+
+			payload+"\n"+
+			"--outer\n"+
+			"Content-Type: application/octet-stream\n"+
+			"\n"+
+			"raw bytes\n"+
+			"--outer--\n"))
+	assert.Equal(t, 250, code)
+
+	mails := sender.mails()
+	require.Len(t, mails, 1)
+	req := mails[0].req
+	assert.Equal(t, "exercise-1", req.SessionID)
+	assert.Equal(t, "作战命令", req.Subject)
+	assert.Equal(t, "rich", req.ContentType)
+	assert.Equal(t, "<p>rich = body</p>", req.Content)
+	assert.Equal(t, map[string]string{
+		"plan.txt":   "grid 38T LN 123 456",
+		"attachment": "raw bytes",
+	}, mails[0].attachments)
+}
+
+func TestServer_RecipientClassification(t *testing.T) {
+	sender := newFakeSender()
+	conn := dial(t, Config{}, sender)
+
+	code, msg := send(t, conn, "hq@raven.local",
+		[]string{"Alpha@raven.local", "bravo@raven.local", "charlie@raven.local", "delta+s2@raven.local"},
+		crlf("Subject: orders\n"+
+			"To: \"Alpha\" <alpha@raven.local>, delta+s2@raven.local\n"+
+			"Cc: bravo@raven.local\n"+
+			"\n"+
+			"move out\n"))
+	assert.Equal(t, 250, code, msg)
+
+	// 每个场次一份文电，未出现在 To / Cc 中的信封收件人为密送；
+	// 报文头按地址忽略大小写匹配，用户 ID 保留信封中的写法
+	mails := sender.mails()
+	require.Len(t, mails, 2)
+	assert.Equal(t, "default", mails[0].req.SessionID)
+	assert.Equal(t, []string{"Alpha"}, mails[0].req.To)
+	assert.Equal(t, []string{"bravo"}, mails[0].req.Cc)
+	assert.Equal(t, []string{"charlie"}, mails[0].req.Bcc)
+	assert.Equal(t, "s2", mails[1].req.SessionID)
+	assert.Equal(t, []string{"delta"}, mails[1].req.To)
+	assert.Empty(t, mails[1].req.Bcc)
+}
+
+func TestServer_ErrorReplies(t *testing.T) {
+	t.Run("command sequence", func(t *testing.T) {
+		conn := dial(t, Config{}, newFakeSender())
+
+		cmd(t, conn, 503, "MAIL FROM:<hq@raven.local>")
+		cmd(t, conn, 250, "HELO client.test")
+		cmd(t, conn, 503, "RCPT TO:<alpha@raven.local>")
+		cmd(t, conn, 503, "DATA")
+		cmd(t, conn, 501, "MAIL FROM:hq@raven.local")
+		cmd(t, conn, 250, "MAIL FROM:<hq@raven.local>")
+		cmd(t, conn, 503, "MAIL FROM:<hq@raven.local>")
+		cmd(t, conn, 550, "RCPT TO:<alpha@example.com>")
+		cmd(t, conn, 503, "DATA")
+		cmd(t, conn, 502, "VRFY alpha")
+		cmd(t, conn, 221, "QUIT")
+	})
+
+	t.Run("authentication", func(t *testing.T) {
+		conn := dial(t, Config{Username: "sim", Password: "secret"}, newFakeSender())
+
+		cmd(t, conn, 250, "EHLO client.test")
+		cmd(t, conn, 530, "MAIL FROM:<hq@raven.local>")
+		cmd(t, conn, 535, "AUTH PLAIN %s", base64.StdEncoding.EncodeToString([]byte("\x00sim\x00wrong")))
+		cmd(t, conn, 235, "AUTH PLAIN %s", base64.StdEncoding.EncodeToString([]byte("\x00sim\x00secret")))
+		cmd(t, conn, 250, "MAIL FROM:<hq@raven.local>")
+	})
+
+	t.Run("message too large", func(t *testing.T) {
+		sender := newFakeSender()
+		conn := dial(t, Config{MaxMessageBytes: 64}, sender)
+
+		cmd(t, conn, 250, "EHLO client.test")
+		cmd(t, conn, 552, "MAIL FROM:<hq@raven.local> SIZE=1000")
+		code, _ := send(t, conn, "hq@raven.local", []string{"alpha@raven.local"},
+			crlf("Subject: big\n\n"+strings.Repeat("x", 200)+"\n"))
+		assert.Equal(t, 552, code)
+		assert.Empty(t, sender.mails())
+
+		// 连接仍可继续使用
+		cmd(t, conn, 250, "NOOP")
+	})
+
+	t.Run("closed session rejected at RCPT", func(t *testing.T) {
+		sender := newFakeSender()
+		sender.closed["ended"] = true
+		conn := dial(t, Config{}, sender)
+
+		cmd(t, conn, 250, "EHLO client.test")
+		cmd(t, conn, 250, "MAIL FROM:<hq@raven.local>")
+		cmd(t, conn, 550, "RCPT TO:<alpha+ended@raven.local>")
+		cmd(t, conn, 250, "RCPT TO:<bravo@raven.local>")
+		cmd(t, conn, 354, "DATA")
+		w := conn.DotWriter()
+		io.WriteString(w, crlf("Subject: hi\nTo: bravo@raven.local\n\nhello\n"))
+		require.NoError(t, w.Close())
+		code, _, err := conn.ReadResponse(0)
+		require.NoError(t, err)
+		assert.Equal(t, 250, code)
+
+		mails := sender.mails()
+		require.Len(t, mails, 1)
+		assert.Equal(t, "default", mails[0].req.SessionID)
+	})
+
+	t.Run("session closed before DATA rejects whole message", func(t *testing.T) {
+		sender := newFakeSender()
+		conn := dial(t, Config{}, sender)
+
+		cmd(t, conn, 250, "EHLO client.test")
+		cmd(t, conn, 250, "MAIL FROM:<hq@raven.local>")
+		cmd(t, conn, 250, "RCPT TO:<alpha@raven.local>")
+		cmd(t, conn, 250, "RCPT TO:<bravo+s2@raven.local>")
+		sender.mu.Lock()
+		sender.closed["s2"] = true
+		sender.mu.Unlock()
+		cmd(t, conn, 354, "DATA")
+		w := conn.DotWriter()
+		io.WriteString(w, crlf("Subject: hi\n\nhello\n"))
+		require.NoError(t, w.Close())
+		code, _, err := conn.ReadResponse(0)
+		require.NoError(t, err)
+
+		// 任一场次不可写入时不投递任何副本，客户端重发不会产生重复文电
+		assert.Equal(t, 550, code)
+		assert.Empty(t, sender.mails())
+	})
+
+	t.Run("later session failure after partial delivery", func(t *testing.T) {
+		sender := newFakeSender()
+		sender.failures["s2"] = errors.New("database is locked")
+		conn := dial(t, Config{}, sender)
+
+		code, msg := send(t, conn, "hq@raven.local", []string{"alpha@raven.local", "bravo+s2@raven.local"},
+			crlf("Subject: hi\n\nhello\n"))
+
+		// 已投递的场次不能因重发而重复，整体回复成功并注明失败的场次
+		assert.Equal(t, 250, code)
+		assert.Contains(t, msg, "failed: s2")
+		assert.Len(t, sender.mails(), 1)
+	})
+
+	t.Run("temporary failure before any delivery", func(t *testing.T) {
+		sender := newFakeSender()
+		sender.failures["default"] = errors.New("database is locked")
+		conn := dial(t, Config{}, sender)
+
+		code, _ := send(t, conn, "hq@raven.local", []string{"alpha@raven.local"}, crlf("Subject: hi\n\nhello\n"))
+		assert.Equal(t, 451, code)
+	})
+}
+
+func TestParseAddress(t *testing.T) {
+	addr, err := ParseAddress("alpha+exercise-1@Raven.Local", "raven.local", "default")
+	require.NoError(t, err)
+	assert.Equal(t, Address{UserID: "alpha", SessionID: "exercise-1"}, addr)
+
+	addr, err = ParseAddress("bravo@raven.local", "raven.local", "default")
+	require.NoError(t, err)
+	assert.Equal(t, Address{UserID: "bravo", SessionID: "default"}, addr)
+
+	for _, bad := range []string{"alpha@example.com", "@raven.local", "alpha+@raven.local", "no-at-sign"} {
+		_, err := ParseAddress(bad, "raven.local", "default")
+		assert.Error(t, err, bad)
+	}
+}
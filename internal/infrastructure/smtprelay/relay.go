@@ -0,0 +1,135 @@
+// Package smtprelay 通过 SMTP smarthost 将文电副本转发到外部邮箱
+package smtprelay
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"raven/internal/core/ports"
+	"raven/internal/infrastructure/mimemail"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type Config struct {
+	Addr     string // smarthost 地址，如 smtp.example.com:587 或本地调试用的 127.0.0.1:1025
+	Username string // 非空时使用 AUTH PLAIN (net/smtp 仅允许在 TLS 或 localhost 上发送凭据)
+	Password string
+	From     string // 信封发件人及 From 地址
+	Timeout  time.Duration
+}
+
+type Relay struct {
+	cfg Config
+}
+
+func New(cfg Config) *Relay {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	return &Relay{cfg: cfg}
+}
+
+func (r *Relay) Relay(ctx context.Context, msg ports.RelayMessage) error {
+	data, err := r.render(msg)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ports.ErrRelayRejected, err)
+	}
+	return classify(r.send(ctx, msg.To, data))
+}
+
+func (r *Relay) render(msg ports.RelayMessage) ([]byte, error) {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient address %q", msg.To)
+	}
+	_, domain, _ := strings.Cut(r.cfg.From, "@")
+	if domain == "" {
+		domain = "raven.local"
+	}
+
+	m := mimemail.Message{
+		From:        mail.Address{Name: msg.SenderID + " (Raven)", Address: r.cfg.From},
+		To:          []mail.Address{*to},
+		Subject:     msg.Subject,
+		Date:        msg.Date,
+		MessageID:   msg.MessageID + "@" + domain,
+		Headers:     [][2]string{{"X-Raven-Session", msg.SessionID}, {"X-Raven-Sender", msg.SenderID}},
+		Content:     msg.Content,
+		ContentType: msg.ContentType,
+	}
+	for _, att := range msg.Attachments {
+		m.Attachments = append(m.Attachments, mimemail.Attachment{FileName: att.FileName, MimeType: att.MimeType, Data: att.Data})
+	}
+	return mimemail.Render(m)
+}
+
+func (r *Relay) send(ctx context.Context, to string, data []byte) error {
+	host, _, err := net.SplitHostPort(r.cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	dialer := net.Dialer{Timeout: r.cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", r.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(r.cfg.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if r.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", r.cfg.Username, r.cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(r.cfg.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// classify 将 5xx 永久性错误包装为 ports.ErrRelayRejected，其余错误视为可重试
+func classify(err error) error {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) && tpErr.Code >= 500 {
+		return fmt.Errorf("%w: %v", ports.ErrRelayRejected, err)
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package smtprelay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+	"raven/internal/infrastructure/smtpd"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sink 以本仓库的 SMTP 网关作为收件端，记录解析后的文电
+type sink struct {
+	mu      sync.Mutex
+	senders []string
+	reqs    []ports.SendMailRequest
+	atts    []map[string]string
+	fail    error
+}
+
+func (s *sink) SendMail(ctx context.Context, senderID string, req ports.SendMailRequest) (*domain.Mail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return nil, s.fail
+	}
+	atts := map[string]string{}
+	for _, a := range req.Attachments {
+		data, _ := io.ReadAll(a.Content)
+		atts[a.FileName+"|"+a.MimeType] = string(data)
+	}
+	s.senders = append(s.senders, senderID)
+	s.reqs = append(s.reqs, req)
+	s.atts = append(s.atts, atts)
+	return &domain.Mail{ID: "m1", SessionID: req.SessionID}, nil
+}
+
+func (s *sink) RequireRunningSession(ctx context.Context, sessionID string) error {
+	return nil
+}
+
+// startSink 在 127.0.0.1 的随机端口上启动 SMTP 网关，返回监听地址
+func startSink(t *testing.T, cfg smtpd.Config, s *sink) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := smtpd.NewServer(cfg, s, nil)
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+func TestRelay_DeliversToSMTPServer(t *testing.T) {
+	ctx := context.Background()
+	msg := ports.RelayMessage{
+		MessageID:   "m1.r1",
+		SessionID:   "s1",
+		SenderID:    "hq",
+		To:          "obs@example.org",
+		Subject:     "态势通报 Sitrep",
+		Content:     "<p>Line one</p>\n.\n<p>Line two</p>",
+		ContentType: "rich",
+		Date:        time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC),
+		Attachments: []ports.RelayAttachment{{FileName: "地图.png", MimeType: "image/png", Data: []byte("\x89PNG\r\n\x00binary")}},
+	}
+
+	t.Run("Delivered with AUTH", func(t *testing.T) {
+		s := &sink{}
+		addr := startSink(t, smtpd.Config{Domain: "example.org", Username: "relay", Password: "secret"}, s)
+		relay := New(Config{Addr: addr, Username: "relay", Password: "secret", From: "raven@raven.local", Timeout: 5 * time.Second})
+
+		require.NoError(t, relay.Relay(ctx, msg))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		require.Len(t, s.reqs, 1)
+		req := s.reqs[0]
+		assert.Equal(t, "raven", s.senders[0])
+		assert.Equal(t, "default", req.SessionID)
+		assert.Equal(t, []string{"obs"}, req.To)
+		assert.Equal(t, msg.Subject, req.Subject)
+		assert.Equal(t, "rich", req.ContentType)
+		assert.Equal(t, msg.Content, req.Content)
+		assert.Equal(t, map[string]string{"地图.png|image/png": "\x89PNG\r\n\x00binary"}, s.atts[0])
+	})
+
+	t.Run("Wrong credentials are rejected", func(t *testing.T) {
+		addr := startSink(t, smtpd.Config{Domain: "example.org", Username: "relay", Password: "secret"}, &sink{})
+		relay := New(Config{Addr: addr, Username: "relay", Password: "wrong", From: "raven@raven.local", Timeout: 5 * time.Second})
+
+		err := relay.Relay(ctx, msg)
+		assert.ErrorIs(t, err, ports.ErrRelayRejected)
+	})
+
+	t.Run("Unknown recipient domain is rejected", func(t *testing.T) {
+		addr := startSink(t, smtpd.Config{Domain: "raven.local"}, &sink{})
+		relay := New(Config{Addr: addr, From: "raven@raven.local", Timeout: 5 * time.Second})
+
+		err := relay.Relay(ctx, msg)
+		assert.ErrorIs(t, err, ports.ErrRelayRejected)
+	})
+
+	t.Run("Temporary failure is retryable", func(t *testing.T) {
+		addr := startSink(t, smtpd.Config{Domain: "example.org"}, &sink{fail: errors.New("disk full")})
+		relay := New(Config{Addr: addr, From: "raven@raven.local", Timeout: 5 * time.Second})
+
+		err := relay.Relay(ctx, msg)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ports.ErrRelayRejected)
+	})
+
+	t.Run("Invalid recipient address is rejected before dialing", func(t *testing.T) {
+		relay := New(Config{Addr: "127.0.0.1:1", From: "raven@raven.local"})
+		bad := msg
+		bad.To = "not an address"
+
+		err := relay.Relay(ctx, bad)
+		assert.ErrorIs(t, err, ports.ErrRelayRejected)
+	})
+}
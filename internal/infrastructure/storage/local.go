@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"time"
 
+	"raven/internal/fsutil"
+
 	"github.com/google/uuid"
 )
 
@@ -70,3 +72,25 @@ func (s *LocalStorage) DeleteSessionDir(ctx context.Context, sessionID string) e
 	path := filepath.Join(s.BaseDir, sessionID)
 	return os.RemoveAll(path)
 }
+
+func (s *LocalStorage) CopySessionDir(ctx context.Context, srcSessionID, dstSessionID string) error {
+	if srcSessionID == "" || dstSessionID == "" {
+		return nil
+	}
+	return fsutil.CopyDir(filepath.Join(s.BaseDir, srcSessionID), filepath.Join(s.BaseDir, dstSessionID))
+}
+
+// ReplaceSessionDir 以 src 场次的文件目录替换 dst 场次的文件目录
+func (s *LocalStorage) ReplaceSessionDir(ctx context.Context, srcSessionID, dstSessionID string) error {
+	if srcSessionID == "" || dstSessionID == "" {
+		return nil
+	}
+	return fsutil.ReplaceDir(filepath.Join(s.BaseDir, srcSessionID), filepath.Join(s.BaseDir, dstSessionID))
+}
+
+func (s *LocalStorage) SessionDirExists(ctx context.Context, sessionID string) (bool, error) {
+	if sessionID == "" {
+		return false, nil
+	}
+	return fsutil.DirExists(filepath.Join(s.BaseDir, sessionID))
+}
@@ -0,0 +1,290 @@
+// Package websocket 实现 RFC 6455 服务端的最小子集：握手、文本/二进制消息、分片、
+// ping/pong 与关闭帧。不支持扩展 (permessage-deflate) 与子协议协商。
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// 关闭状态码
+const (
+	CloseNormal        = 1000
+	CloseProtocolError = 1002
+	CloseTooBig        = 1009
+)
+
+const (
+	acceptGUID            = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	defaultMaxMessageSize = 1 << 20
+	writeTimeout          = 10 * time.Second
+)
+
+var (
+	ErrNotWebSocket    = errors.New("not a websocket handshake")
+	ErrOriginForbidden = errors.New("websocket origin not allowed")
+	ErrMessageTooLarge = errors.New("websocket message too large")
+	errProtocol        = errors.New("websocket protocol error")
+)
+
+// Conn 服务端 WebSocket 连接。读操作只能在单个 goroutine 中进行，写操作可并发
+type Conn struct {
+	conn           net.Conn
+	br             *bufio.Reader
+	wmu            sync.Mutex
+	closeOnce      sync.Once
+	MaxMessageSize int64
+}
+
+// Upgrade 校验握手请求并接管底层连接。返回错误时尚未写入响应，由调用方回复 400
+// (ErrOriginForbidden 时回复 403)。allowedOrigins 见 CheckOrigin
+func Upgrade(w http.ResponseWriter, r *http.Request, allowedOrigins []string) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, ErrNotWebSocket
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, ErrNotWebSocket
+	}
+	if !CheckOrigin(r, allowedOrigins) {
+		return nil, ErrOriginForbidden
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("response does not support hijacking")
+	}
+
+	netConn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	netConn.SetDeadline(time.Time{})
+	if _, err := netConn.Write([]byte(resp)); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return &Conn{conn: netConn, br: rw.Reader, MaxMessageSize: defaultMaxMessageSize}, nil
+}
+
+// CheckOrigin 防止跨站 WebSocket 劫持：没有 Origin 头 (非浏览器客户端) 或与请求 Host 同源时放行，
+// 否则 Origin 须出现在 allowed 中 (形如 https://ops.example.org，"*" 表示不限制)
+func CheckOrigin(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, a := range allowed {
+		a = strings.TrimSuffix(strings.TrimSpace(a), "/")
+		if a == "*" || strings.EqualFold(a, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage 读取下一条完整的数据消息，自动回复 ping 与关闭帧。
+// 对端关闭连接时返回 io.EOF
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var opcode int
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case OpPing:
+			if err := c.writeFrame(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.CloseWithCode(code, "")
+			return 0, nil, io.EOF
+		case OpText, OpBinary:
+			if msg != nil {
+				return 0, nil, c.fail(CloseProtocolError, errProtocol)
+			}
+			opcode = op
+		case OpContinuation:
+			if msg == nil {
+				return 0, nil, c.fail(CloseProtocolError, errProtocol)
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, errProtocol)
+		}
+
+		if int64(len(msg)+len(payload)) > c.MaxMessageSize {
+			return 0, nil, c.fail(CloseTooBig, ErrMessageTooLarge)
+		}
+		msg = append(msg, payload...)
+		if msg == nil {
+			msg = []byte{}
+		}
+		if fin {
+			return opcode, msg, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = int(head[0] & 0x0F)
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		// 不支持扩展位；客户端帧必须带掩码
+		err = c.fail(CloseProtocolError, errProtocol)
+		return
+	}
+
+	length := int64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if opcode >= OpClose && (length > 125 || !fin) {
+		err = c.fail(CloseProtocolError, errProtocol)
+		return
+	}
+	if length < 0 || length > c.MaxMessageSize {
+		err = c.fail(CloseTooBig, ErrMessageTooLarge)
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// WriteText 发送一条文本消息
+func (c *Conn) WriteText(data []byte) error {
+	return c.writeFrame(OpText, data)
+}
+
+// Ping 发送 ping，用于保活与探测断线
+func (c *Conn) Ping() error {
+	return c.writeFrame(OpPing, nil)
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	header := []byte{0x80 | byte(opcode)}
+	switch n := len(payload); {
+	case n <= 125:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetReadDeadline 设置读超时，配合 Ping 检测失联的客户端
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) fail(code int, err error) error {
+	c.CloseWithCode(code, err.Error())
+	return err
+}
+
+// CloseWithCode 发送关闭帧并关闭底层连接，可重复调用
+func (c *Conn) CloseWithCode(code int, reason string) error {
+	var err error
+	c.closeOnce.Do(func() {
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		if len(reason) > 123 {
+			reason = reason[:123]
+		}
+		c.writeFrame(OpClose, append(payload, reason...))
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *Conn) Close() error {
+	return c.CloseWithCode(CloseNormal, "")
+}
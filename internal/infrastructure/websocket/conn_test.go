@@ -0,0 +1,359 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// frame 构造客户端帧，masked 为 false 时生成违反协议的无掩码帧
+func frame(fin bool, op int, payload []byte, masked bool) []byte {
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	out := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		out = append(out, maskBit|byte(n))
+	case n <= 0xFFFF:
+		out = append(out, maskBit|126, byte(n>>8), byte(n))
+	default:
+		out = append(out, maskBit|127)
+		out = binary.BigEndian.AppendUint64(out, uint64(n))
+	}
+	if !masked {
+		return append(out, payload...)
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	out = append(out, mask...)
+	for i, c := range payload {
+		out = append(out, c^mask[i%4])
+	}
+	return out
+}
+
+// pipe 返回服务端连接与客户端一侧，客户端发送的帧在独立的 goroutine 中写入，
+// 避免与服务端的回复 (pong、关闭帧) 相互阻塞
+func pipe(t *testing.T, maxSize int64) (*Conn, net.Conn, *bufio.Reader) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+	deadline := time.Now().Add(5 * time.Second)
+	client.SetDeadline(deadline)
+	server.SetDeadline(deadline)
+	c := &Conn{conn: server, br: bufio.NewReader(server), MaxMessageSize: maxSize}
+	return c, client, bufio.NewReader(client)
+}
+
+func send(client net.Conn, frames ...[]byte) {
+	go client.Write(bytes.Join(frames, nil))
+}
+
+type result struct {
+	op   int
+	data []byte
+	err  error
+}
+
+func readAsync(c *Conn) chan result {
+	ch := make(chan result, 1)
+	go func() {
+		op, data, err := c.ReadMessage()
+		ch <- result{op, data, err}
+	}()
+	return ch
+}
+
+// readServerFrame 读取服务端发出的一帧，服务端帧不得带掩码
+func readServerFrame(t *testing.T, r *bufio.Reader) (int, []byte) {
+	t.Helper()
+	var head [2]byte
+	_, err := io.ReadFull(r, head[:])
+	require.NoError(t, err)
+	require.Zero(t, head[1]&0x80, "server frames must not be masked")
+	require.NotZero(t, head[0]&0x80, "server frames are never fragmented")
+	length := int(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	require.NoError(t, err)
+	return int(head[0] & 0x0F), payload
+}
+
+func closeCode(payload []byte) int {
+	if len(payload) < 2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(payload))
+}
+
+func TestConn_Fragmentation(t *testing.T) {
+	c, client, r := pipe(t, 1024)
+	res := readAsync(c)
+	send(client,
+		frame(false, OpText, []byte("Hel"), true),
+		frame(true, OpPing, []byte("p1"), true), // 控制帧可穿插在分片之间
+		frame(false, OpContinuation, []byte("lo, "), true),
+		frame(true, OpContinuation, []byte("world"), true),
+	)
+
+	op, payload := readServerFrame(t, r)
+	assert.Equal(t, OpPong, op)
+	assert.Equal(t, []byte("p1"), payload)
+
+	got := <-res
+	require.NoError(t, got.err)
+	assert.Equal(t, OpText, got.op)
+	assert.Equal(t, "Hello, world", string(got.data))
+
+	// 空的二进制消息
+	res = readAsync(c)
+	send(client, frame(true, OpBinary, nil, true))
+	got = <-res
+	require.NoError(t, got.err)
+	assert.Equal(t, OpBinary, got.op)
+	assert.Equal(t, []byte{}, got.data)
+}
+
+func TestConn_ProtocolErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"Unmasked frame", [][]byte{frame(true, OpText, []byte("hi"), false)}},
+		{"Continuation without start", [][]byte{frame(true, OpContinuation, []byte("x"), true)}},
+		{"New message inside fragments", [][]byte{frame(false, OpText, []byte("a"), true), frame(true, OpText, []byte("b"), true)}},
+		{"Fragmented control frame", [][]byte{frame(false, OpPing, nil, true)}},
+		{"Oversized control frame", [][]byte{frame(true, OpPing, bytes.Repeat([]byte("x"), 126), true)}},
+		{"Reserved bits", [][]byte{append([]byte{0xC1}, frame(true, OpText, []byte("x"), true)[1:]...)}},
+		{"Unknown opcode", [][]byte{frame(true, 0x3, nil, true)}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, client, r := pipe(t, 1024)
+			res := readAsync(c)
+			send(client, tc.frames...)
+
+			op, payload := readServerFrame(t, r)
+			assert.Equal(t, OpClose, op)
+			assert.Equal(t, CloseProtocolError, closeCode(payload))
+			got := <-res
+			assert.ErrorIs(t, got.err, errProtocol)
+		})
+	}
+}
+
+func TestConn_MessageTooLarge(t *testing.T) {
+	cases := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"Single frame", [][]byte{frame(true, OpText, bytes.Repeat([]byte("x"), 11), true)}},
+		{"Fragments add up", [][]byte{frame(false, OpText, []byte("123456"), true), frame(true, OpContinuation, []byte("789012"), true)}},
+		// 长度字段超限时不读取负载即拒绝
+		{"64-bit length", [][]byte{{0x81, 0x80 | 127, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, client, r := pipe(t, 10)
+			res := readAsync(c)
+			send(client, tc.frames...)
+
+			op, payload := readServerFrame(t, r)
+			assert.Equal(t, OpClose, op)
+			assert.Equal(t, CloseTooBig, closeCode(payload))
+			assert.ErrorIs(t, (<-res).err, ErrMessageTooLarge)
+		})
+	}
+
+	t.Run("Exactly at the limit", func(t *testing.T) {
+		c, client, _ := pipe(t, 10)
+		res := readAsync(c)
+		send(client, frame(false, OpText, []byte("12345"), true), frame(true, OpContinuation, []byte("67890"), true))
+		got := <-res
+		require.NoError(t, got.err)
+		assert.Equal(t, "1234567890", string(got.data))
+	})
+}
+
+func TestConn_Close(t *testing.T) {
+	t.Run("Peer close is echoed", func(t *testing.T) {
+		c, client, r := pipe(t, 1024)
+		res := readAsync(c)
+		send(client, frame(true, OpClose, binary.BigEndian.AppendUint16(nil, 1001), true))
+
+		op, payload := readServerFrame(t, r)
+		assert.Equal(t, OpClose, op)
+		assert.Equal(t, 1001, closeCode(payload))
+		assert.ErrorIs(t, (<-res).err, io.EOF)
+		_, err := r.ReadByte()
+		assert.Error(t, err, "connection must be closed after the close handshake")
+	})
+
+	t.Run("Close without status", func(t *testing.T) {
+		c, client, r := pipe(t, 1024)
+		res := readAsync(c)
+		send(client, frame(true, OpClose, nil, true))
+
+		_, payload := readServerFrame(t, r)
+		assert.Equal(t, CloseNormal, closeCode(payload))
+		assert.ErrorIs(t, (<-res).err, io.EOF)
+	})
+
+	t.Run("Close is sent once", func(t *testing.T) {
+		c, _, r := pipe(t, 1024)
+		go func() {
+			c.CloseWithCode(CloseNormal, strings.Repeat("r", 200))
+			c.Close()
+		}()
+		op, payload := readServerFrame(t, r)
+		assert.Equal(t, OpClose, op)
+		assert.Len(t, payload, 125, "reason is truncated to fit a control frame")
+		_, err := r.ReadByte()
+		assert.Error(t, err)
+	})
+}
+
+func TestConn_Write(t *testing.T) {
+	c, _, r := pipe(t, 1024)
+	long := bytes.Repeat([]byte("x"), 300)
+	go func() {
+		c.WriteText([]byte("hi"))
+		c.WriteText(long)
+		c.Ping()
+	}()
+
+	op, payload := readServerFrame(t, r)
+	assert.Equal(t, OpText, op)
+	assert.Equal(t, "hi", string(payload))
+	op, payload = readServerFrame(t, r)
+	assert.Equal(t, OpText, op)
+	assert.Equal(t, long, payload)
+	op, payload = readServerFrame(t, r)
+	assert.Equal(t, OpPing, op)
+	assert.Empty(t, payload)
+}
+
+func TestUpgrade(t *testing.T) {
+	allowed := []string{"https://ops.example.org/"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r, allowed)
+		switch err {
+		case nil:
+			c.WriteText([]byte("welcome"))
+			c.Close()
+		case ErrOriginForbidden:
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	handshake := func(t *testing.T, extra map[string]string) (*http.Response, *bufio.Reader) {
+		t.Helper()
+		conn, err := net.Dial("tcp", host)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		headers := map[string]string{
+			"Connection":            "keep-alive, Upgrade",
+			"Upgrade":               "websocket",
+			"Sec-WebSocket-Version": "13",
+			"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+		}
+		for k, v := range extra {
+			headers[k] = v
+		}
+		var sb strings.Builder
+		sb.WriteString("GET /ws HTTP/1.1\r\nHost: " + host + "\r\n")
+		for k, v := range headers {
+			if v != "" {
+				sb.WriteString(k + ": " + v + "\r\n")
+			}
+		}
+		sb.WriteString("\r\n")
+		_, err = conn.Write([]byte(sb.String()))
+		require.NoError(t, err)
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		return resp, br
+	}
+
+	t.Run("Accepts a valid handshake", func(t *testing.T) {
+		resp, br := handshake(t, nil)
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+		// RFC 6455 1.3 中的示例
+		assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+		op, payload := readServerFrame(t, br)
+		assert.Equal(t, OpText, op)
+		assert.Equal(t, "welcome", string(payload))
+	})
+
+	t.Run("Rejects malformed handshakes", func(t *testing.T) {
+		for _, extra := range []map[string]string{
+			{"Sec-WebSocket-Version": "8"},
+			{"Upgrade": ""},
+			{"Sec-WebSocket-Key": "c2hvcnQ="},
+		} {
+			resp, _ := handshake(t, extra)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, extra)
+		}
+	})
+
+	t.Run("Checks Origin", func(t *testing.T) {
+		cases := map[string]int{
+			"http://" + host:           http.StatusSwitchingProtocols, // 同源
+			"https://OPS.example.org":  http.StatusSwitchingProtocols,
+			"https://evil.example.org": http.StatusForbidden,
+			"null":                     http.StatusForbidden,
+		}
+		for origin, want := range cases {
+			resp, _ := handshake(t, map[string]string{"Origin": origin})
+			assert.Equal(t, want, resp.StatusCode, origin)
+		}
+	})
+}
+
+func TestCheckOrigin(t *testing.T) {
+	req := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://raven.local:8080/api/v1/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	assert.True(t, CheckOrigin(req(""), nil), "non-browser clients send no Origin")
+	assert.True(t, CheckOrigin(req("http://raven.local:8080"), nil))
+	assert.False(t, CheckOrigin(req("http://raven.local:9090"), nil))
+	assert.False(t, CheckOrigin(req("http://attacker.org"), []string{"https://attacker.org"}), "scheme must match")
+	assert.True(t, CheckOrigin(req("https://attacker.org"), []string{"https://attacker.org"}))
+	assert.True(t, CheckOrigin(req("http://anything.org"), []string{"*"}))
+}
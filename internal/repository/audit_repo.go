@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"gorm.io/gorm"
+)
+
+// auditExportBatch 导出审计记录时每批读取的条数
+const auditExportBatch = 500
+
+type AuditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+// InstallAuditGuards 在数据库层面禁止修改和删除审计记录，
+// 防止绕过 ORM 钩子的原生 SQL 篡改审计轨迹
+func InstallAuditGuards(db *gorm.DB) error {
+	for _, stmt := range []string{
+		`CREATE TRIGGER IF NOT EXISTS audit_logs_no_update BEFORE UPDATE ON audit_logs
+			BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+		`CREATE TRIGGER IF NOT EXISTS audit_logs_no_delete BEFORE DELETE ON audit_logs
+			BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *AuditRepository) Append(ctx context.Context, entry *domain.AuditLog) error {
+	return r.db.WithContext(ctx).Create(entry).Error
+}
+
+func (r *AuditRepository) List(ctx context.Context, filter ports.AuditFilter, page, pageSize int) ([]domain.AuditLog, int64, error) {
+	var logs []domain.AuditLog
+	var total int64
+
+	db := r.filtered(ctx, filter)
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	err := db.Order("created_at desc").Offset(offset).Limit(pageSize).Find(&logs).Error
+	return logs, total, err
+}
+
+func (r *AuditRepository) Each(ctx context.Context, filter ports.AuditFilter, fn func([]domain.AuditLog) error) error {
+	// 记录只追加不修改，按时间正序分页读取即可保证结果稳定
+	for offset := 0; ; offset += auditExportBatch {
+		var batch []domain.AuditLog
+		err := r.filtered(ctx, filter).Order("created_at asc, id asc").Offset(offset).Limit(auditExportBatch).Find(&batch).Error
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		if len(batch) < auditExportBatch {
+			return nil
+		}
+	}
+}
+
+func (r *AuditRepository) filtered(ctx context.Context, filter ports.AuditFilter) *gorm.DB {
+	db := r.db.WithContext(ctx).Model(&domain.AuditLog{})
+	if filter.SessionID != "" {
+		db = db.Where("session_id = ?", filter.SessionID)
+	}
+	if filter.ActorID != "" {
+		db = db.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		db = db.Where("action = ?", filter.Action)
+	}
+	if filter.TargetID != "" {
+		db = db.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.From != nil {
+		db = db.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		db = db.Where("created_at < ?", *filter.To)
+	}
+	return db
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"gorm.io/gorm"
+)
+
+// chat_search 为即时消息的 FTS5 全文索引，由触发器随 chat_messages / attachments 同步。
+// trigram 分词按三字切分，中文无需额外分词；不足三字的检索词改用 LIKE 扫描索引表
+const chatSearchMinTerm = 3
+
+// InstallChatSearchIndex 建立全文索引及同步触发器，首次建立时导入已有消息
+func InstallChatSearchIndex(db *gorm.DB) error {
+	fresh := !db.Migrator().HasTable("chat_search")
+	for _, stmt := range []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS chat_search USING fts5(
+			content, attachments, message_id UNINDEXED, session_id UNINDEXED, tokenize = 'trigram')`,
+		`CREATE TRIGGER IF NOT EXISTS chat_search_insert AFTER INSERT ON chat_messages BEGIN
+			INSERT INTO chat_search (content, attachments, message_id, session_id) VALUES (new.content, '', new.id, new.session_id);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS chat_search_update AFTER UPDATE OF content ON chat_messages BEGIN
+			UPDATE chat_search SET content = new.content WHERE message_id = new.id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS chat_search_delete AFTER DELETE ON chat_messages BEGIN
+			DELETE FROM chat_search WHERE message_id = old.id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS chat_search_attach AFTER INSERT ON attachments WHEN new.chat_message_id IS NOT NULL BEGIN
+			UPDATE chat_search SET attachments = trim(attachments || ' ' || new.file_name) WHERE message_id = new.chat_message_id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS chat_search_detach AFTER DELETE ON attachments WHEN old.chat_message_id IS NOT NULL BEGIN
+			UPDATE chat_search SET attachments = COALESCE((SELECT group_concat(file_name, ' ') FROM attachments WHERE chat_message_id = old.chat_message_id), '')
+			WHERE message_id = old.chat_message_id;
+		END`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	if !fresh {
+		return nil
+	}
+	return db.Exec(`INSERT INTO chat_search (content, attachments, message_id, session_id)
+		SELECT cm.content, COALESCE((SELECT group_concat(a.file_name, ' ') FROM attachments a WHERE a.chat_message_id = cm.id), ''), cm.id, cm.session_id
+		FROM chat_messages cm`).Error
+}
+
+func (r *MailRepository) SearchChatMessages(ctx context.Context, sessionID, userID string, query ports.ChatSearchQuery) ([]domain.ChatMessage, error) {
+	db := r.db.WithContext(ctx)
+
+	// 检索词之间为 AND 关系；任一词不足三字时整体改用 LIKE
+	terms := strings.Fields(query.Query)
+	hits := db.Table("chat_search").Select("message_id").Where("session_id = ?", sessionID)
+	if useFullText(terms) {
+		phrases := make([]string, len(terms))
+		for i, term := range terms {
+			phrases[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		}
+		hits = hits.Where("chat_search MATCH ?", strings.Join(phrases, " AND "))
+	} else {
+		for _, term := range terms {
+			pattern := "%" + escapeLike(term) + "%"
+			hits = hits.Where(`(content LIKE ? ESCAPE '\' OR attachments LIKE ? ESCAPE '\')`, pattern, pattern)
+		}
+	}
+
+	// 用户所在的会话: 自己收发的单聊、已加入的群聊及场次内全部频道
+	joined := db.Table("conversations c").Select("c.id").
+		Joins("LEFT JOIN conversation_members m ON m.conversation_id = c.id AND m.user_id = ?", userID).
+		Where("c.session_id = ? AND c.type <> ? AND (m.user_id IS NOT NULL OR c.type = ?)", sessionID, domain.ConversationDirect, domain.ConversationChannel)
+
+	q := db.Preload("Attachments").
+		Where("session_id = ? AND retracted_at IS NULL AND id IN (?)", sessionID, hits).
+		Where("((receiver_id <> '' AND (sender_id = ? OR receiver_id = ?)) OR conversation_id IN (?))", userID, userID, joined)
+	if query.PeerID != "" {
+		q = q.Where("receiver_id <> '' AND ((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?))", userID, query.PeerID, query.PeerID, userID)
+	}
+	if query.ConversationID != "" {
+		q = q.Where("conversation_id = ?", query.ConversationID)
+	}
+	if query.From != nil {
+		q = q.Where("created_at >= ?", *query.From)
+	}
+	if query.To != nil {
+		q = q.Where("created_at < ?", *query.To)
+	}
+
+	var msgs []domain.ChatMessage
+	err := q.Order("real_created_at desc, id desc").Limit(query.Limit).Find(&msgs).Error
+	return msgs, err
+}
+
+func useFullText(terms []string) bool {
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < chatSearchMinTerm {
+			return false
+		}
+	}
+	return true
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
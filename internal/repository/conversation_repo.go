@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 会话与成员和即时消息同属 MailRepository，便于在同一事务内读写
+
+// DedupeDirectConversations 处理建立单聊唯一索引之前并发产生的重复单聊：保留最早的一条，
+// 其余清空 direct_key，其消息仍可按会话 ID 访问。需在 AutoMigrate 之前调用
+func DedupeDirectConversations(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&domain.Conversation{}) {
+		return nil
+	}
+	return db.Exec(`UPDATE conversations SET direct_key = '' WHERE direct_key <> '' AND EXISTS (
+		SELECT 1 FROM conversations c WHERE c.session_id = conversations.session_id AND c.direct_key = conversations.direct_key
+		AND (c.created_at < conversations.created_at OR (c.created_at = conversations.created_at AND c.id < conversations.id)))`).Error
+}
+
+func (r *MailRepository) CreateConversation(ctx context.Context, conv *domain.Conversation) error {
+	return r.db.WithContext(ctx).Create(conv).Error
+}
+
+func (r *MailRepository) GetConversation(ctx context.Context, sessionID, id string) (*domain.Conversation, error) {
+	var conv domain.Conversation
+	err := r.db.WithContext(ctx).Preload("Members", orderMembers).
+		Where("id = ? AND session_id = ?", id, sessionID).
+		First(&conv).Error
+	if err != nil {
+		return nil, err
+	}
+	return &conv, nil
+}
+
+func (r *MailRepository) FindDirectConversation(ctx context.Context, sessionID, directKey string) (*domain.Conversation, error) {
+	var conv domain.Conversation
+	err := r.db.WithContext(ctx).Preload("Members", orderMembers).
+		Where("session_id = ? AND type = ? AND direct_key = ?", sessionID, domain.ConversationDirect, directKey).
+		Order("created_at asc").
+		First(&conv).Error
+	if err != nil {
+		return nil, err
+	}
+	return &conv, nil
+}
+
+func (r *MailRepository) ListConversations(ctx context.Context, sessionID, userID string) ([]domain.Conversation, error) {
+	var convs []domain.Conversation
+	joined := r.db.Model(&domain.ConversationMember{}).Select("conversation_id").Where("user_id = ?", userID)
+	err := r.db.WithContext(ctx).Preload("Members", orderMembers).
+		Where("session_id = ? AND (type = ? OR id IN (?))", sessionID, domain.ConversationChannel, joined).
+		Order("real_created_at asc").
+		Find(&convs).Error
+	return convs, err
+}
+
+func (r *MailRepository) ListSessionConversations(ctx context.Context, sessionID string) ([]domain.Conversation, error) {
+	var convs []domain.Conversation
+	err := r.db.WithContext(ctx).Preload("Members", orderMembers).
+		Where("session_id = ?", sessionID).
+		Order("real_created_at asc").
+		Find(&convs).Error
+	return convs, err
+}
+
+func (r *MailRepository) AddConversationMembers(ctx context.Context, members []domain.ConversationMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error
+}
+
+func (r *MailRepository) UpdateConversationMember(ctx context.Context, member *domain.ConversationMember) error {
+	return r.db.WithContext(ctx).Save(member).Error
+}
+
+func (r *MailRepository) RemoveConversationMember(ctx context.Context, conversationID, userID string) error {
+	return r.db.WithContext(ctx).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Delete(&domain.ConversationMember{}).Error
+}
+
+func (r *MailRepository) GetConversationHistory(ctx context.Context, sessionID, conversationID string, page ports.ChatHistoryPage) ([]domain.ChatMessage, error) {
+	return r.pageChatHistory(ctx, page, func(db *gorm.DB) *gorm.DB {
+		return db.Where("session_id = ? AND conversation_id = ?", sessionID, conversationID)
+	})
+}
+
+func (r *MailRepository) MarkConversationRead(ctx context.Context, sessionID, conversationID, userID string, at time.Time) ([]domain.ChatMessage, error) {
+	var msgs []domain.ChatMessage
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		member := domain.ConversationMember{ConversationID: conversationID, UserID: userID}
+		err := tx.Where(&member).First(&member).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// 频道成员在首次阅读时登记
+			member.SessionID = sessionID
+			member.Role = domain.MemberRoleMember
+			member.JoinedAt = at
+		} else if err != nil {
+			return err
+		}
+
+		q := tx.Where("session_id = ? AND conversation_id = ? AND sender_id <> ?", sessionID, conversationID, userID)
+		if member.LastReadMessageAt != nil {
+			q = q.Where("real_created_at > ?", *member.LastReadMessageAt)
+		}
+		if err := q.Order("real_created_at asc").Find(&msgs).Error; err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+
+		last := msgs[len(msgs)-1]
+		member.LastReadMessageID = last.ID
+		member.LastReadMessageAt = &last.RealCreatedAt
+		member.LastReadAt = &at
+		return tx.Save(&member).Error
+	})
+	return msgs, err
+}
+
+func (r *MailRepository) GetConversationUnreadCounts(ctx context.Context, sessionID, userID string) (map[string]int, error) {
+	type Result struct {
+		ConversationID string
+		Count          int
+	}
+	var results []Result
+	// 未登记成员的频道视为从未阅读
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT c.id AS conversation_id, COUNT(*) AS count
+		FROM conversations c
+		LEFT JOIN conversation_members m ON m.conversation_id = c.id AND m.user_id = ?
+		JOIN chat_messages cm ON cm.conversation_id = c.id AND cm.sender_id <> ?
+			AND (m.last_read_message_at IS NULL OR cm.real_created_at > m.last_read_message_at)
+		WHERE c.session_id = ? AND c.type <> ? AND (m.user_id IS NOT NULL OR c.type = ?)
+		GROUP BY c.id`,
+		userID, userID, sessionID, domain.ConversationDirect, domain.ConversationChannel,
+	).Scan(&results).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, res := range results {
+		counts[res.ConversationID] = res.Count
+	}
+	return counts, nil
+}
+
+func (r *MailRepository) ListChatThreads(ctx context.Context, sessionID, userID string) ([]ports.ChatThread, error) {
+	var threads []ports.ChatThread
+	// 单聊按对方分组 (含建立会话前的消息)，群聊与频道按会话分组；未读口径与
+	// GetIMUnreadCounts、GetConversationUnreadCounts 一致
+	err := r.db.WithContext(ctx).Raw(`
+		WITH msgs AS (
+			SELECT cm.id, cm.sender_id, cm.content, cm.created_at, cm.real_created_at, cm.retracted_at,
+				CASE WHEN cm.sender_id = @user THEN cm.receiver_id ELSE cm.sender_id END AS peer_id,
+				'' AS conversation_id,
+				CASE WHEN cm.receiver_id = @user AND cm.is_read = false THEN 1 ELSE 0 END AS unread
+			FROM chat_messages cm
+			WHERE cm.session_id = @session AND cm.receiver_id <> '' AND (cm.sender_id = @user OR cm.receiver_id = @user)
+			UNION ALL
+			SELECT cm.id, cm.sender_id, cm.content, cm.created_at, cm.real_created_at, cm.retracted_at,
+				'' AS peer_id,
+				c.id AS conversation_id,
+				CASE WHEN cm.sender_id <> @user AND (m.last_read_message_at IS NULL OR cm.real_created_at > m.last_read_message_at) THEN 1 ELSE 0 END AS unread
+			FROM chat_messages cm
+			JOIN conversations c ON c.id = cm.conversation_id AND c.type <> @direct
+			LEFT JOIN conversation_members m ON m.conversation_id = c.id AND m.user_id = @user
+			WHERE cm.session_id = @session AND (m.user_id IS NOT NULL OR c.type = @channel)
+		), ranked AS (
+			SELECT msgs.*,
+				ROW_NUMBER() OVER (PARTITION BY peer_id, conversation_id ORDER BY real_created_at DESC, id DESC) AS rn,
+				SUM(unread) OVER (PARTITION BY peer_id, conversation_id) AS unread_count
+			FROM msgs
+		)
+		SELECT COALESCE(c.type, @direct) AS type, r.peer_id, r.conversation_id, COALESCE(c.name, '') AS name,
+			r.id AS last_message_id, r.sender_id AS last_sender_id, r.content AS last_message,
+			r.retracted_at IS NOT NULL AS last_retracted, r.created_at AS last_message_at, r.unread_count
+		FROM ranked r
+		LEFT JOIN conversations c ON c.id = r.conversation_id
+		WHERE r.rn = 1
+		ORDER BY r.real_created_at DESC, r.id DESC`,
+		sql.Named("session", sessionID), sql.Named("user", userID),
+		sql.Named("direct", domain.ConversationDirect), sql.Named("channel", domain.ConversationChannel),
+	).Scan(&threads).Error
+	return threads, err
+}
+
+func orderMembers(db *gorm.DB) *gorm.DB {
+	return db.Order("joined_at asc, user_id asc")
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailRepository_DirectConversationUnique(t *testing.T) {
+	ctx := context.Background()
+	db := openReplicas(t, 1)[0]
+	require.NoError(t, db.AutoMigrate(&domain.Conversation{}, &domain.ConversationMember{}))
+	repo := NewMailRepository(db)
+	now := time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC)
+	key := domain.DirectKey("u1", "u2")
+
+	require.NoError(t, repo.CreateConversation(ctx, &domain.Conversation{SessionID: "s1", Type: domain.ConversationDirect, DirectKey: key, CreatedAt: now}))
+	assert.Error(t, repo.CreateConversation(ctx, &domain.Conversation{SessionID: "s1", Type: domain.ConversationDirect, DirectKey: key, CreatedAt: now}))
+	// 其他场次的同一对用户以及群聊不受限制
+	assert.NoError(t, repo.CreateConversation(ctx, &domain.Conversation{SessionID: "s2", Type: domain.ConversationDirect, DirectKey: key, CreatedAt: now}))
+	assert.NoError(t, repo.CreateConversation(ctx, &domain.Conversation{SessionID: "s1", Type: domain.ConversationGroup, CreatedAt: now}))
+	assert.NoError(t, repo.CreateConversation(ctx, &domain.Conversation{SessionID: "s1", Type: domain.ConversationGroup, CreatedAt: now}))
+}
+
+func TestDedupeDirectConversations(t *testing.T) {
+	ctx := context.Background()
+	db := openReplicas(t, 1)[0]
+	require.NoError(t, DedupeDirectConversations(db))
+
+	// 旧版本的表没有唯一索引，可能存在并发建立的重复单聊
+	now := time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC)
+	key := domain.DirectKey("u1", "u2")
+	require.NoError(t, db.Exec(`CREATE TABLE conversations (id text PRIMARY KEY, session_id text, type text, name text, direct_key text, created_by text, created_at datetime, real_created_at datetime)`).Error)
+	for _, c := range []domain.Conversation{
+		{ID: "c2", SessionID: "s1", Type: domain.ConversationDirect, DirectKey: key, CreatedAt: now.Add(time.Second)},
+		{ID: "c1", SessionID: "s1", Type: domain.ConversationDirect, DirectKey: key, CreatedAt: now},
+		{ID: "c3", SessionID: "s1", Type: domain.ConversationDirect, DirectKey: key, CreatedAt: now},
+	} {
+		require.NoError(t, db.Exec(`INSERT INTO conversations (id, session_id, type, direct_key, created_at) VALUES (?, ?, ?, ?, ?)`, c.ID, c.SessionID, c.Type, c.DirectKey, c.CreatedAt).Error)
+	}
+
+	require.NoError(t, DedupeDirectConversations(db))
+	require.NoError(t, db.AutoMigrate(&domain.Conversation{}, &domain.ConversationMember{}))
+
+	conv, err := NewMailRepository(db).FindDirectConversation(ctx, "s1", key)
+	require.NoError(t, err)
+	assert.Equal(t, "c1", conv.ID)
+	var count int64
+	require.NoError(t, db.Model(&domain.Conversation{}).Where("direct_key = ''").Count(&count).Error)
+	assert.Equal(t, int64(2), count)
+}
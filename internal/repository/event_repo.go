@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+
+	"raven/internal/core/domain"
+
+	"gorm.io/gorm"
+)
+
+// EventLogRepository 基于数据库的事件日志，重启后仍可重放
+type EventLogRepository struct {
+	db    *gorm.DB
+	limit int
+}
+
+func NewEventLogRepository(db *gorm.DB, limit int) *EventLogRepository {
+	return &EventLogRepository{db: db, limit: limit}
+}
+
+func (r *EventLogRepository) Append(ctx context.Context, event *domain.NotificationEvent) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+		// 只保留该场次最近 limit 条
+		newest := tx.Model(&domain.NotificationEvent{}).Select("id").
+			Where("session_id = ?", event.SessionID).Order("id desc").Offset(r.limit - 1).Limit(1)
+		return tx.Where("session_id = ? AND id < (?)", event.SessionID, newest).Delete(&domain.NotificationEvent{}).Error
+	})
+}
+
+func (r *EventLogRepository) ListSince(ctx context.Context, sessionID string, afterID uint64) ([]domain.NotificationEvent, error) {
+	var events []domain.NotificationEvent
+	err := r.db.WithContext(ctx).Where("session_id = ? AND id > ?", sessionID, afterID).Order("id asc").Find(&events).Error
+	return events, err
+}
+
+func (r *EventLogRepository) ListAfter(ctx context.Context, afterID uint64, limit int) ([]domain.NotificationEvent, error) {
+	var events []domain.NotificationEvent
+	err := r.db.WithContext(ctx).Where("id > ?", afterID).Order("id asc").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+func (r *EventLogRepository) LastID(ctx context.Context) (uint64, error) {
+	var id uint64
+	err := r.db.WithContext(ctx).Model(&domain.NotificationEvent{}).Select("COALESCE(MAX(id), 0)").Scan(&id).Error
+	return id, err
+}
@@ -5,8 +5,11 @@ import (
 	"time"
 
 	"raven/internal/core/domain"
+	"raven/internal/core/ports"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type MailRepository struct {
@@ -81,36 +84,114 @@ func (r *MailRepository) GetSent(ctx context.Context, sessionID, senderID string
 	return mails, total, nil
 }
 
-func (r *MailRepository) UpdateStatus(ctx context.Context, mailID, recipientID, status string) error {
+func (r *MailRepository) UpdateStatus(ctx context.Context, mailID, recipientID, status string, at time.Time) error {
 	updates := map[string]interface{}{"status": status}
-	if status == "read" {
-		updates["read_at"] = time.Now()
+	switch status {
+	case "read":
+		updates["read_at"] = at
+	case "unread":
+		updates["read_at"] = nil
+	case "deleted":
+		updates["removed_at"] = at
 	}
 	return r.db.WithContext(ctx).Model(&domain.MailRecipient{}).
 		Where("mail_id = ? AND recipient_id = ?", mailID, recipientID).
 		Updates(updates).Error
 }
 
-func (r *MailRepository) DeleteForSender(ctx context.Context, mailID string) error {
+// ClaimDueRelays 认领到期待转发的收件记录，按下次重试时间排序。
+// 每条记录以条件更新把 relay_next_at 推迟到 leaseUntil，只有更新成功的实例才会投递，
+// 多副本同时轮询时同一副本不会被重复发送；认领者崩溃时记录在租约到期后重新可见。
+func (r *MailRepository) ClaimDueRelays(ctx context.Context, now, leaseUntil time.Time, limit int) ([]domain.MailRecipient, error) {
+	db := r.db.WithContext(ctx)
+	var candidates []domain.MailRecipient
+	err := db.Where("relay_status = ? AND relay_next_at <= ?", domain.RelayStatusPending, now).
+		Order("relay_next_at asc").
+		Limit(limit).
+		Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := make([]domain.MailRecipient, 0, len(candidates))
+	for _, recipient := range candidates {
+		res := db.Model(&domain.MailRecipient{}).
+			Where("id = ? AND relay_status = ? AND relay_next_at <= ?", recipient.ID, domain.RelayStatusPending, now).
+			Update("relay_next_at", leaseUntil)
+		if res.Error != nil {
+			return claimed, res.Error
+		}
+		if res.RowsAffected == 1 {
+			recipient.RelayNextAt = &leaseUntil
+			claimed = append(claimed, recipient)
+		}
+	}
+	return claimed, nil
+}
+
+func (r *MailRepository) UpdateRelayState(ctx context.Context, recipient *domain.MailRecipient) error {
+	return r.db.WithContext(ctx).Model(&domain.MailRecipient{}).
+		Where("id = ?", recipient.ID).
+		Updates(map[string]interface{}{
+			"relay_status":   recipient.RelayStatus,
+			"relay_attempts": recipient.RelayAttempts,
+			"relay_next_at":  recipient.RelayNextAt,
+			"relay_error":    recipient.RelayError,
+			"relayed_at":     recipient.RelayedAt,
+		}).Error
+}
+
+func (r *MailRepository) DeleteForSender(ctx context.Context, mailID string, at time.Time) error {
 	return r.db.WithContext(ctx).Model(&domain.Mail{}).
 		Where("id = ?", mailID).
-		Update("sender_status", "deleted").Error
+		Updates(map[string]interface{}{"sender_status": "deleted", "sender_deleted_at": at}).Error
 }
 
 func (r *MailRepository) DeleteSession(ctx context.Context, sessionID string) error {
 	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		// Hard delete from all tables
-		if err := tx.Unscoped().Where("session_id = ?", sessionID).Delete(&domain.Attachment{}).Error; err != nil {
-			return err
-		}
-		if err := tx.Unscoped().Where("session_id = ?", sessionID).Delete(&domain.MailRecipient{}).Error; err != nil {
+		if err := deleteSessionRecords(tx, sessionID); err != nil {
 			return err
 		}
-		if err := tx.Unscoped().Where("session_id = ?", sessionID).Delete(&domain.Mail{}).Error; err != nil {
+		return deleteSessionState(tx, sessionID)
+	})
+}
+
+// deleteSessionState 删除场次级的运行状态：脚本执行记录、场次专属的 webhook 订阅及其投递记录、
+// 推送事件日志与在线状态。复制场次替换目标数据时保留这些状态，不调用
+func deleteSessionState(tx *gorm.DB, sessionID string) error {
+	hooks := tx.Model(&domain.Webhook{}).Select("id").Where("session_id = ?", sessionID)
+	if err := tx.Where("webhook_id IN (?)", hooks).Delete(&domain.WebhookDelivery{}).Error; err != nil {
+		return err
+	}
+	for _, model := range []interface{}{&domain.Webhook{}, &domain.ScenarioRun{}, &domain.NotificationEvent{}, &domain.UserPresence{}, &domain.PresenceConnection{}} {
+		if err := tx.Where("session_id = ?", sessionID).Delete(model).Error; err != nil {
 			return err
 		}
-		return nil
-	})
+	}
+	return nil
+}
+
+// deleteSessionRecords 硬删除场次在各数据表中的全部记录
+func deleteSessionRecords(tx *gorm.DB, sessionID string) error {
+	if err := tx.Unscoped().Where("session_id = ?", sessionID).Delete(&domain.Attachment{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("session_id = ?", sessionID).Delete(&domain.MailRecipient{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("session_id = ?", sessionID).Delete(&domain.Mail{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("session_id = ?", sessionID).Delete(&domain.ChatMessage{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("session_id = ?", sessionID).Delete(&domain.ChatMessageEdit{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("session_id = ?", sessionID).Delete(&domain.ConversationMember{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("session_id = ?", sessionID).Delete(&domain.Conversation{}).Error
 }
 
 func (r *MailRepository) GetAttachmentByID(ctx context.Context, sessionID, id string) (*domain.Attachment, error) {
@@ -121,25 +202,156 @@ func (r *MailRepository) GetAttachmentByID(ctx context.Context, sessionID, id st
 	return &att, nil
 }
 
+func (r *MailRepository) AttachmentFileInUse(ctx context.Context, filePath string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&domain.Attachment{}).Where("file_path = ?", filePath).Count(&count).Error
+	return count > 0, err
+}
+
 func (r *MailRepository) CreateChatMessage(ctx context.Context, msg *domain.ChatMessage) error {
 	return r.db.WithContext(ctx).Create(msg).Error
 }
 
-func (r *MailRepository) GetChatHistory(ctx context.Context, sessionID, userA, userB string, limit int) ([]domain.ChatMessage, error) {
+func (r *MailRepository) GetChatHistory(ctx context.Context, sessionID, userA, userB string, page ports.ChatHistoryPage) ([]domain.ChatMessage, error) {
+	return r.pageChatHistory(ctx, page, func(db *gorm.DB) *gorm.DB {
+		return db.Where("session_id = ? AND ((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?))", sessionID, userA, userB, userB, userA)
+	})
+}
+
+// pageChatHistory 在 scope 限定的消息中按 (real_created_at, id) 定位游标，结果由新到旧排列。
+// 演练时间可能暂停或回拨，排序与游标比较都使用真实发送时间
+func (r *MailRepository) pageChatHistory(ctx context.Context, page ports.ChatHistoryPage, scope func(*gorm.DB) *gorm.DB) ([]domain.ChatMessage, error) {
+	db := r.db.WithContext(ctx)
+	query := db.Preload("Attachments").Scopes(scope).Order("real_created_at desc, id desc")
+
+	cursorID := page.Before
+	if page.After != "" {
+		cursorID = page.After
+	}
+	if cursorID != "" {
+		var cursor domain.ChatMessage
+		if err := db.Scopes(scope).Select("id", "real_created_at").Where("id = ?", cursorID).First(&cursor).Error; err != nil {
+			return nil, err
+		}
+		at := cursor.RealCreatedAt
+		if page.After != "" {
+			// 取紧接游标之后的一页，查询时由旧到新，返回前再倒序
+			query = db.Preload("Attachments").Scopes(scope).Order("real_created_at asc, id asc").
+				Where("(real_created_at > ? OR (real_created_at = ? AND id > ?))", at, at, cursor.ID)
+		} else {
+			query = query.Where("(real_created_at < ? OR (real_created_at = ? AND id < ?))", at, at, cursor.ID)
+		}
+	}
+
+	var msgs []domain.ChatMessage
+	if err := query.Limit(page.Limit).Find(&msgs).Error; err != nil {
+		return nil, err
+	}
+	if page.After != "" {
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	}
+	return msgs, nil
+}
+
+func (r *MailRepository) MarkChatAsRead(ctx context.Context, sessionID, senderID, receiverID string, at time.Time) ([]string, error) {
+	var ids []string
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&domain.ChatMessage{}).
+			Where("session_id = ? AND sender_id = ? AND receiver_id = ? AND is_read = ?", sessionID, senderID, receiverID, false).
+			Order("created_at asc").
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		return tx.Model(&domain.ChatMessage{}).Where("id IN ?", ids).
+			Updates(map[string]interface{}{"is_read": true, "read_at": at}).Error
+	})
+	return ids, err
+}
+
+func (r *MailRepository) GetChatMessage(ctx context.Context, sessionID, id string) (*domain.ChatMessage, error) {
+	var msg domain.ChatMessage
+	if err := r.db.WithContext(ctx).Preload("Attachments").Where("id = ? AND session_id = ?", id, sessionID).First(&msg).Error; err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (r *MailRepository) EditChatMessage(ctx context.Context, msg *domain.ChatMessage, edit *domain.ChatMessageEdit) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(edit).Error; err != nil {
+			return err
+		}
+		return tx.Model(msg).Select("content", "mentions", "edited_at").Updates(msg).Error
+	})
+}
+
+func (r *MailRepository) RetractChatMessage(ctx context.Context, msg *domain.ChatMessage) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(msg).Select("content", "retracted_at", "mentions").Updates(msg).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("chat_message_id = ?", msg.ID).Delete(&domain.Attachment{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("message_id = ?", msg.ID).Delete(&domain.ChatMessageEdit{}).Error
+	})
+}
+
+func (r *MailRepository) ListChatMessageEdits(ctx context.Context, messageID string) ([]domain.ChatMessageEdit, error) {
+	var edits []domain.ChatMessageEdit
+	err := r.db.WithContext(ctx).Where("message_id = ?", messageID).Order("real_edited_at asc").Find(&edits).Error
+	return edits, err
+}
+
+// ListChatMessagesByIDs 按 ID 加载场次内的消息，不含附件
+func (r *MailRepository) ListChatMessagesByIDs(ctx context.Context, sessionID string, ids []string) ([]domain.ChatMessage, error) {
 	var msgs []domain.ChatMessage
-	err := r.db.WithContext(ctx).
-		Preload("Attachments").
-		Where("session_id = ? AND ((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?))", sessionID, userA, userB, userB, userA).
+	if len(ids) == 0 {
+		return msgs, nil
+	}
+	err := r.db.WithContext(ctx).Where("session_id = ? AND id IN ?", sessionID, ids).Find(&msgs).Error
+	return msgs, err
+}
+
+// ListSessionMails 返回场次内全部文电 (含收件人及附件)，按演练时间升序
+func (r *MailRepository) ListSessionMails(ctx context.Context, sessionID string) ([]domain.Mail, error) {
+	var mails []domain.Mail
+	err := r.db.WithContext(ctx).Preload("Attachments").Preload("Recipients").
+		Where("session_id = ?", sessionID).
+		Order("created_at asc").
+		Find(&mails).Error
+	return mails, err
+}
+
+// ListSessionChats 返回场次内全部即时消息，按演练时间升序
+func (r *MailRepository) ListSessionChats(ctx context.Context, sessionID string) ([]domain.ChatMessage, error) {
+	var msgs []domain.ChatMessage
+	err := r.db.WithContext(ctx).Preload("Attachments").
+		Where("session_id = ?", sessionID).
 		Order("created_at asc").
-		Limit(limit).
 		Find(&msgs).Error
 	return msgs, err
 }
 
-func (r *MailRepository) MarkChatAsRead(ctx context.Context, sessionID, senderID, receiverID string) error {
-	return r.db.WithContext(ctx).Model(&domain.ChatMessage{}).
-		Where("session_id = ? AND sender_id = ? AND receiver_id = ? AND is_read = ?", sessionID, senderID, receiverID, false).
-		Update("is_read", true).Error
+func (r *MailRepository) HasReply(ctx context.Context, sessionID, parentID string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&domain.Mail{}).
+		Where("session_id = ? AND parent_id = ?", sessionID, parentID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *MailRepository) HasChatReply(ctx context.Context, sessionID, fromID, toID string, since time.Time) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&domain.ChatMessage{}).
+		Where("session_id = ? AND sender_id = ? AND receiver_id = ? AND created_at > ?", sessionID, fromID, toID, since).
+		Count(&count).Error
+	return count > 0, err
 }
 
 func (r *MailRepository) GetUnreadMailCount(ctx context.Context, sessionID, userID string) (int64, error) {
@@ -172,22 +384,236 @@ func (r *MailRepository) GetIMUnreadCounts(ctx context.Context, sessionID, userI
 	return counts, nil
 }
 
+// GetOrphanSessionIDs 返回宿主管理的场次及仅存在于数据表中的历史场次里，不在活跃列表中的场次 ID。
+// 本服务自行创建的场次 (默认场次、接口创建、克隆生成) 不受宿主名单约束
 func (r *MailRepository) GetOrphanSessionIDs(ctx context.Context, activeSessionIDs []string) ([]string, error) {
 	var sessionsToDelete []string
 
-	// Query distinct session_ids that are NOT in the active list
-	q := r.db.WithContext(ctx).Model(&domain.Mail{}).Distinct().Pluck("session_id", &sessionsToDelete)
+	known := r.db.Raw("SELECT id AS sid FROM sessions WHERE origin = ? UNION SELECT session_id FROM mails UNION SELECT session_id FROM chat_messages", domain.SessionOriginHost)
+	local := r.db.Model(&domain.Session{}).Select("id").Where("origin <> ?", domain.SessionOriginHost)
+	// 快照的隐藏备份场次不属于孤儿场次，需排除
+	backups := r.db.Model(&domain.SessionSnapshot{}).Select("backup_session_id")
+
+	q := r.db.WithContext(ctx).Table("(?) AS known", known).Where("sid NOT IN (?) AND sid NOT IN (?)", backups, local)
 	if len(activeSessionIDs) > 0 {
-		q = r.db.WithContext(ctx).Model(&domain.Mail{}).Distinct().Where("session_id NOT IN ?", activeSessionIDs).Pluck("session_id", &sessionsToDelete)
+		q = q.Where("sid NOT IN ?", activeSessionIDs)
 	}
 
-	if q.Error != nil {
-		return nil, q.Error
+	if err := q.Pluck("sid", &sessionsToDelete).Error; err != nil {
+		return nil, err
 	}
+	return sessionsToDelete, nil
+}
 
-	// We might also want to check other tables in case there are sessions with only ChatMessages but no Mails
-	// For simplicity in this MVP, we assume a valid session usually has Mails or is created with Mails.
-	// If needed, we could UNION with ChatMessage session_ids.
+// ListDataSessionIDs 返回所有存在文电或消息数据的场次 ID (不含快照备份场次)
+func (r *MailRepository) ListDataSessionIDs(ctx context.Context) ([]string, error) {
+	var ids []string
+	known := r.db.Raw("SELECT session_id AS sid FROM mails UNION SELECT session_id FROM chat_messages")
+	backups := r.db.Model(&domain.SessionSnapshot{}).Select("backup_session_id")
+	err := r.db.WithContext(ctx).Table("(?) AS known", known).Where("sid NOT IN (?)", backups).Pluck("sid", &ids).Error
+	return ids, err
+}
 
-	return sessionsToDelete, nil
+// SessionHasData 判断场次是否已有文电、消息、附件或会话记录
+func (r *MailRepository) SessionHasData(ctx context.Context, sessionID string) (bool, error) {
+	for _, model := range []interface{}{&domain.Mail{}, &domain.ChatMessage{}, &domain.Attachment{}, &domain.Conversation{}} {
+		var count int64
+		if err := r.db.WithContext(ctx).Model(model).Where("session_id = ?", sessionID).Limit(1).Count(&count).Error; err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CloneSession 在单个事务内深拷贝源场次的全部记录到目标场次，所有记录均生成新的 UUID，
+// 并同步映射 ParentID / MailID / ChatMessageID / ConversationID / MessageID 等内部引用
+func (r *MailRepository) CloneSession(ctx context.Context, srcSessionID, dstSessionID string, opts ports.CloneOptions) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if opts.Replace {
+			if err := deleteSessionRecords(tx, dstSessionID); err != nil {
+				return err
+			}
+		}
+		var mails []domain.Mail
+		if err := tx.Where("session_id = ?", srcSessionID).Find(&mails).Error; err != nil {
+			return err
+		}
+		var recipients []domain.MailRecipient
+		if err := tx.Where("session_id = ?", srcSessionID).Find(&recipients).Error; err != nil {
+			return err
+		}
+		var chats []domain.ChatMessage
+		if err := tx.Where("session_id = ?", srcSessionID).Find(&chats).Error; err != nil {
+			return err
+		}
+		var attachments []domain.Attachment
+		if err := tx.Where("session_id = ?", srcSessionID).Find(&attachments).Error; err != nil {
+			return err
+		}
+		var edits []domain.ChatMessageEdit
+		if err := tx.Where("session_id = ?", srcSessionID).Find(&edits).Error; err != nil {
+			return err
+		}
+		var convs []domain.Conversation
+		if err := tx.Where("session_id = ?", srcSessionID).Find(&convs).Error; err != nil {
+			return err
+		}
+		var members []domain.ConversationMember
+		if err := tx.Where("session_id = ?", srcSessionID).Find(&members).Error; err != nil {
+			return err
+		}
+
+		mailIDs := make(map[string]string, len(mails))
+		for _, m := range mails {
+			mailIDs[m.ID] = uuid.New().String()
+		}
+		chatIDs := make(map[string]string, len(chats))
+		for _, cm := range chats {
+			chatIDs[cm.ID] = uuid.New().String()
+		}
+		convIDs := make(map[string]string, len(convs))
+		for _, c := range convs {
+			convIDs[c.ID] = uuid.New().String()
+		}
+
+		for i := range mails {
+			mails[i].ID = mailIDs[mails[i].ID]
+			mails[i].SessionID = dstSessionID
+			if mails[i].ParentID != nil {
+				if newID, ok := mailIDs[*mails[i].ParentID]; ok {
+					mails[i].ParentID = &newID
+				} else {
+					mails[i].ParentID = nil
+				}
+			}
+		}
+		for i := range recipients {
+			recipients[i].ID = uuid.New().String()
+			recipients[i].MailID = mailIDs[recipients[i].MailID]
+			recipients[i].SessionID = dstSessionID
+			if opts.ResetRead && recipients[i].Status == "read" {
+				recipients[i].Status = "unread"
+				recipients[i].ReadAt = nil
+			}
+			// 克隆出的文电不再转发外部邮箱
+			recipients[i].RelayAddress = ""
+			recipients[i].RelayStatus = ""
+			recipients[i].RelayAttempts = 0
+			recipients[i].RelayNextAt = nil
+			recipients[i].RelayError = ""
+			recipients[i].RelayedAt = nil
+		}
+		for i := range chats {
+			chats[i].ID = chatIDs[chats[i].ID]
+			chats[i].SessionID = dstSessionID
+			if chats[i].ConversationID != "" {
+				chats[i].ConversationID = convIDs[chats[i].ConversationID]
+			}
+			if chats[i].ReplyToID != "" {
+				chats[i].ReplyToID = chatIDs[chats[i].ReplyToID]
+			}
+			if opts.ResetRead {
+				chats[i].IsRead = false
+			}
+		}
+		for i := range edits {
+			edits[i].ID = uuid.New().String()
+			edits[i].MessageID = chatIDs[edits[i].MessageID]
+			edits[i].SessionID = dstSessionID
+		}
+		for i := range convs {
+			convs[i].ID = convIDs[convs[i].ID]
+			convs[i].SessionID = dstSessionID
+		}
+		for i := range members {
+			members[i].ConversationID = convIDs[members[i].ConversationID]
+			members[i].SessionID = dstSessionID
+			if opts.ResetRead || members[i].LastReadMessageID == "" {
+				members[i].LastReadMessageID = ""
+				members[i].LastReadMessageAt = nil
+				members[i].LastReadAt = nil
+			} else {
+				members[i].LastReadMessageID = chatIDs[members[i].LastReadMessageID]
+			}
+		}
+		for i := range attachments {
+			attachments[i].ID = uuid.New().String()
+			attachments[i].SessionID = dstSessionID
+			if attachments[i].MailID != nil {
+				newID := mailIDs[*attachments[i].MailID]
+				attachments[i].MailID = &newID
+			}
+			if attachments[i].ChatMessageID != nil {
+				newID := chatIDs[*attachments[i].ChatMessageID]
+				attachments[i].ChatMessageID = &newID
+			}
+			if opts.MapFilePath != nil {
+				attachments[i].FilePath = opts.MapFilePath(attachments[i].FilePath)
+			}
+		}
+
+		// 关联数据已单独处理，插入时忽略 Associations
+		tx = tx.Omit(clause.Associations)
+		if len(mails) > 0 {
+			if err := tx.CreateInBatches(&mails, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(recipients) > 0 {
+			if err := tx.CreateInBatches(&recipients, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(chats) > 0 {
+			if err := tx.CreateInBatches(&chats, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(edits) > 0 {
+			if err := tx.CreateInBatches(&edits, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(convs) > 0 {
+			if err := tx.CreateInBatches(&convs, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(members) > 0 {
+			if err := tx.CreateInBatches(&members, 100).Error; err != nil {
+				return err
+			}
+		}
+		if len(attachments) > 0 {
+			if err := tx.CreateInBatches(&attachments, 100).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *MailRepository) CreateSnapshot(ctx context.Context, snapshot *domain.SessionSnapshot) error {
+	return r.db.WithContext(ctx).Create(snapshot).Error
+}
+
+func (r *MailRepository) GetSnapshot(ctx context.Context, sessionID, id string) (*domain.SessionSnapshot, error) {
+	var snapshot domain.SessionSnapshot
+	if err := r.db.WithContext(ctx).Where("id = ? AND session_id = ?", id, sessionID).First(&snapshot).Error; err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (r *MailRepository) ListSnapshots(ctx context.Context, sessionID string) ([]domain.SessionSnapshot, error) {
+	var snapshots []domain.SessionSnapshot
+	err := r.db.WithContext(ctx).Where("session_id = ?", sessionID).Order("created_at desc").Find(&snapshots).Error
+	return snapshots, err
+}
+
+func (r *MailRepository) DeleteSnapshot(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Where("id = ?", id).Delete(&domain.SessionSnapshot{}).Error
 }
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openReplicas 打开指向同一数据库文件的多个连接，模拟共用数据库的多个实例
+func openReplicas(t *testing.T, n int) []*gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "raven.db") + "?_pragma=busy_timeout(5000)"
+	dbs := make([]*gorm.DB, n)
+	for i := range dbs {
+		db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		require.NoError(t, err)
+		dbs[i] = db
+	}
+	require.NoError(t, dbs[0].AutoMigrate(&domain.MailRecipient{}))
+	return dbs
+}
+
+func TestMailRepository_ClaimDueRelays(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC)
+	lease := now.Add(10 * time.Minute)
+
+	t.Run("Claimed rows are hidden until the lease expires", func(t *testing.T) {
+		db := openReplicas(t, 1)[0]
+		due, later := now.Add(-time.Minute), now.Add(time.Minute)
+		require.NoError(t, db.Create([]domain.MailRecipient{
+			{ID: "r1", MailID: "m1", RecipientID: "obs", RelayStatus: domain.RelayStatusPending, RelayNextAt: &due},
+			{ID: "r2", MailID: "m1", RecipientID: "ext", RelayStatus: domain.RelayStatusPending, RelayNextAt: &later},
+			{ID: "r3", MailID: "m1", RecipientID: "old", RelayStatus: domain.RelayStatusSent, RelayNextAt: &due},
+		}).Error)
+		repo := NewMailRepository(db)
+
+		claimed, err := repo.ClaimDueRelays(ctx, now, lease, 10)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+		assert.Equal(t, "r1", claimed[0].ID)
+		assert.True(t, claimed[0].RelayNextAt.Equal(lease))
+
+		again, err := repo.ClaimDueRelays(ctx, now, lease, 10)
+		require.NoError(t, err)
+		assert.Empty(t, again)
+
+		// 认领者未回写状态时，租约到期后重新可见
+		expired, err := repo.ClaimDueRelays(ctx, lease, lease.Add(10*time.Minute), 10)
+		require.NoError(t, err)
+		assert.Len(t, expired, 2)
+	})
+
+	t.Run("Concurrent replicas never claim the same row", func(t *testing.T) {
+		dbs := openReplicas(t, 4)
+		due := now.Add(-time.Minute)
+		var rows []domain.MailRecipient
+		for i := 0; i < 30; i++ {
+			rows = append(rows, domain.MailRecipient{
+				ID: fmt.Sprintf("r%02d", i), MailID: "m1", RecipientID: "obs", RelayStatus: domain.RelayStatusPending, RelayNextAt: &due,
+			})
+		}
+		require.NoError(t, dbs[0].Create(rows).Error)
+
+		// 所有实例都查出候选记录后才开始认领，确保认领阶段互相竞争
+		var selected sync.WaitGroup
+		selected.Add(len(dbs))
+		for _, db := range dbs {
+			require.NoError(t, db.Callback().Query().After("gorm:query").Register("test:barrier", func(*gorm.DB) {
+				selected.Done()
+				selected.Wait()
+			}))
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		seen := map[string]int{}
+		for _, db := range dbs {
+			wg.Add(1)
+			go func(repo *MailRepository) {
+				defer wg.Done()
+				claimed, err := repo.ClaimDueRelays(ctx, now, lease, 30)
+				assert.NoError(t, err)
+				mu.Lock()
+				defer mu.Unlock()
+				for _, r := range claimed {
+					seen[r.ID]++
+				}
+			}(NewMailRepository(db))
+		}
+		wg.Wait()
+
+		assert.Len(t, seen, 30)
+		for id, n := range seen {
+			assert.Equal(t, 1, n, "recipient %s claimed more than once", id)
+		}
+	})
+}
+
+func TestMailRepository_DeleteSession(t *testing.T) {
+	ctx := context.Background()
+	db := openReplicas(t, 1)[0]
+	require.NoError(t, db.AutoMigrate(&domain.Mail{}, &domain.Attachment{}, &domain.ChatMessage{}, &domain.ChatMessageEdit{},
+		&domain.Conversation{}, &domain.ConversationMember{}, &domain.Webhook{}, &domain.WebhookDelivery{}, &domain.ScenarioRun{},
+		&domain.NotificationEvent{}, &domain.UserPresence{}, &domain.PresenceConnection{}))
+	for _, sessionID := range []string{"s1", "s2"} {
+		hook := domain.Webhook{SessionID: sessionID, URL: "http://example.com"}
+		require.NoError(t, db.Create(&hook).Error)
+		require.NoError(t, db.Create(&domain.WebhookDelivery{WebhookID: hook.ID, SessionID: sessionID}).Error)
+		require.NoError(t, db.Create(&domain.ScenarioRun{SessionID: sessionID, State: "running"}).Error)
+		require.NoError(t, db.Create(&domain.NotificationEvent{SessionID: sessionID}).Error)
+		require.NoError(t, db.Create(&domain.UserPresence{SessionID: sessionID, UserID: "u1"}).Error)
+		require.NoError(t, db.Create(&domain.PresenceConnection{InstanceID: "i1", SessionID: sessionID, UserID: "u1"}).Error)
+	}
+	// 全局订阅及其投递记录保留
+	global := domain.Webhook{URL: "http://example.com"}
+	require.NoError(t, db.Create(&global).Error)
+	require.NoError(t, db.Create(&domain.WebhookDelivery{WebhookID: global.ID, SessionID: "s1"}).Error)
+
+	require.NoError(t, NewMailRepository(db).DeleteSession(ctx, "s1"))
+
+	for _, model := range []interface{}{&domain.Webhook{}, &domain.ScenarioRun{}, &domain.NotificationEvent{}, &domain.UserPresence{}, &domain.PresenceConnection{}} {
+		var left, kept int64
+		require.NoError(t, db.Model(model).Where("session_id = ?", "s1").Count(&left).Error)
+		require.NoError(t, db.Model(model).Where("session_id = ?", "s2").Count(&kept).Error)
+		assert.Zero(t, left, "%T", model)
+		assert.Equal(t, int64(1), kept, "%T", model)
+	}
+	var deliveries []domain.WebhookDelivery
+	require.NoError(t, db.Find(&deliveries).Error)
+	require.Len(t, deliveries, 2)
+	for _, d := range deliveries {
+		assert.True(t, d.WebhookID == global.ID || d.SessionID == "s2", "delivery %s should have been removed", d.ID)
+	}
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"raven/internal/core/ports"
+)
+
+// 统计查询均基于 SQLite 日期函数，时间字段以演练时间 (created_at / read_at) 为准
+
+func (r *MailRepository) GetVolumeStats(ctx context.Context, sessionID string, bucketSeconds int64) ([]ports.VolumeBucket, error) {
+	type row struct {
+		Bucket int64
+		Mails  int64
+		Chats  int64
+	}
+	var rows []row
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT bucket, SUM(is_mail) AS mails, SUM(is_chat) AS chats FROM (
+			SELECT (CAST(strftime('%s', created_at) AS INTEGER) / ?) * ? AS bucket, 1 AS is_mail, 0 AS is_chat
+			FROM mails WHERE session_id = ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT (CAST(strftime('%s', created_at) AS INTEGER) / ?) * ?, 0, 1
+			FROM chat_messages WHERE session_id = ?
+		) GROUP BY bucket ORDER BY bucket`,
+		bucketSeconds, bucketSeconds, sessionID, bucketSeconds, bucketSeconds, sessionID,
+	).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]ports.VolumeBucket, 0, len(rows))
+	for _, r := range rows {
+		buckets = append(buckets, ports.VolumeBucket{
+			BucketStart: time.Unix(r.Bucket, 0).UTC(),
+			Mails:       r.Mails,
+			Chats:       r.Chats,
+		})
+	}
+	return buckets, nil
+}
+
+// GetCommunicationMatrix 群聊与频道消息按已登记的会话成员展开
+func (r *MailRepository) GetCommunicationMatrix(ctx context.Context, sessionID string) ([]ports.MatrixCell, error) {
+	cells := []ports.MatrixCell{}
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT sender_id, recipient_id, SUM(mails) AS mails, SUM(chats) AS chats FROM (
+			SELECT mails.sender_id AS sender_id, mail_recipients.recipient_id AS recipient_id, COUNT(*) AS mails, 0 AS chats
+			FROM mail_recipients JOIN mails ON mails.id = mail_recipients.mail_id
+			WHERE mails.session_id = ? AND mails.deleted_at IS NULL
+			GROUP BY mails.sender_id, mail_recipients.recipient_id
+			UNION ALL
+			SELECT sender_id, receiver_id, 0, COUNT(*)
+			FROM chat_messages WHERE session_id = ? AND receiver_id <> ''
+			GROUP BY sender_id, receiver_id
+			UNION ALL
+			SELECT cm.sender_id, m.user_id, 0, COUNT(*)
+			FROM chat_messages cm
+			JOIN conversation_members m ON m.conversation_id = cm.conversation_id AND m.user_id <> cm.sender_id
+			WHERE cm.session_id = ? AND cm.receiver_id = ''
+			GROUP BY cm.sender_id, m.user_id
+		) GROUP BY sender_id, recipient_id
+		ORDER BY SUM(mails) + SUM(chats) DESC`,
+		sessionID, sessionID, sessionID,
+	).Scan(&cells).Error
+	return cells, err
+}
+
+func (r *MailRepository) GetAttachmentStats(ctx context.Context, sessionID string) ([]ports.AttachmentStat, error) {
+	stats := []ports.AttachmentStat{}
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT COALESCE(NULLIF(mime_type, ''), 'application/octet-stream') AS mime_type,
+			COUNT(*) AS count, COALESCE(SUM(file_size), 0) AS total_size
+		FROM attachments WHERE session_id = ?
+		GROUP BY 1 ORDER BY total_size DESC`,
+		sessionID,
+	).Scan(&stats).Error
+	return stats, err
+}
+
+// GetUnreadBacklog 消息未读只统计单聊，群聊与频道没有逐条已读标记
+func (r *MailRepository) GetUnreadBacklog(ctx context.Context, sessionID string) ([]ports.BacklogEntry, error) {
+	entries := []ports.BacklogEntry{}
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT user_id, SUM(unread_mails) AS unread_mails, SUM(unread_chats) AS unread_chats FROM (
+			SELECT recipient_id AS user_id, COUNT(*) AS unread_mails, 0 AS unread_chats
+			FROM mail_recipients WHERE session_id = ? AND status = 'unread'
+			GROUP BY recipient_id
+			UNION ALL
+			SELECT receiver_id, 0, COUNT(*)
+			FROM chat_messages WHERE session_id = ? AND is_read = ? AND receiver_id <> ''
+			GROUP BY receiver_id
+		) GROUP BY user_id
+		ORDER BY SUM(unread_mails) + SUM(unread_chats) DESC`,
+		sessionID, sessionID, false,
+	).Scan(&entries).Error
+	return entries, err
+}
+
+// latencyBucketCase 生成将 latency (秒) 归入给定上界的 CASE 表达式，超出最大上界时为 -1
+func latencyBucketCase(bounds []int) string {
+	var cases strings.Builder
+	cases.WriteString("CASE")
+	for _, b := range bounds {
+		fmt.Fprintf(&cases, " WHEN latency <= %d THEN %d", b, b)
+	}
+	cases.WriteString(" ELSE -1 END")
+	return cases.String()
+}
+
+// GetReplyLatencyBuckets 按给定上界 (秒，升序) 统计回复耗时分布。
+// 文电: 收件人以 ParentID 回复原文电，每位回复人只计首次回复；
+// 消息: 单聊中对方发来的消息之后，紧接着由本人发出的下一条消息 (连续多条只计最后一条到回复)
+func (r *MailRepository) GetReplyLatencyBuckets(ctx context.Context, sessionID string, bounds []int) ([]ports.LatencyBucket, error) {
+	var buckets []ports.LatencyBucket
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT `+latencyBucketCase(bounds)+` AS le_seconds, SUM(is_mail) AS mails, SUM(is_chat) AS chats FROM (
+			SELECT (julianday(MIN(reply.created_at)) - julianday(parent.created_at)) * 86400 AS latency, 1 AS is_mail, 0 AS is_chat
+			FROM mails reply JOIN mails parent ON parent.id = reply.parent_id
+			WHERE reply.session_id = ? AND reply.deleted_at IS NULL AND reply.sender_id <> parent.sender_id
+			GROUP BY parent.id, reply.sender_id
+			UNION ALL
+			SELECT (julianday(next_at) - julianday(created_at)) * 86400, 0, 1 FROM (
+				SELECT receiver_id, created_at,
+					LEAD(sender_id) OVER pair AS next_sender, LEAD(created_at) OVER pair AS next_at
+				FROM chat_messages WHERE session_id = ? AND receiver_id <> ''
+				WINDOW pair AS (PARTITION BY MIN(sender_id, receiver_id), MAX(sender_id, receiver_id) ORDER BY created_at, id)
+			) WHERE next_sender = receiver_id
+		) GROUP BY le_seconds`,
+		sessionID, sessionID,
+	).Scan(&buckets).Error
+	return buckets, err
+}
+
+// GetReadLatencyBuckets 按给定上界 (秒，升序) 统计文电与消息从发送到阅读的耗时分布
+func (r *MailRepository) GetReadLatencyBuckets(ctx context.Context, sessionID string, bounds []int) ([]ports.LatencyBucket, error) {
+	var buckets []ports.LatencyBucket
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT `+latencyBucketCase(bounds)+` AS le_seconds, SUM(is_mail) AS mails, SUM(is_chat) AS chats FROM (
+			SELECT (julianday(mail_recipients.read_at) - julianday(mails.created_at)) * 86400 AS latency, 1 AS is_mail, 0 AS is_chat
+			FROM mail_recipients JOIN mails ON mails.id = mail_recipients.mail_id
+			WHERE mail_recipients.session_id = ? AND mail_recipients.read_at IS NOT NULL
+			UNION ALL
+			SELECT (julianday(read_at) - julianday(created_at)) * 86400, 0, 1
+			FROM chat_messages WHERE session_id = ? AND read_at IS NOT NULL
+		) GROUP BY le_seconds`,
+		sessionID, sessionID,
+	).Scan(&buckets).Error
+	return buckets, err
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"raven/internal/core/domain"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PresenceRepository struct {
+	db *gorm.DB
+}
+
+func NewPresenceRepository(db *gorm.DB) *PresenceRepository {
+	return &PresenceRepository{db: db}
+}
+
+func (r *PresenceRepository) Touch(ctx context.Context, sessionID, userID string, at time.Time) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "session_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_seen_at"}),
+	}).Create(&domain.UserPresence{SessionID: sessionID, UserID: userID, LastSeenAt: at}).Error
+}
+
+func (r *PresenceRepository) List(ctx context.Context, sessionID string) ([]domain.UserPresence, error) {
+	var list []domain.UserPresence
+	err := r.db.WithContext(ctx).Where("session_id = ?", sessionID).Order("user_id asc").Find(&list).Error
+	return list, err
+}
+
+func (r *PresenceRepository) SaveConnection(ctx context.Context, conn *domain.PresenceConnection) error {
+	db := r.db.WithContext(ctx)
+	if conn.Status == domain.PresenceOffline {
+		return db.Where("instance_id = ? AND session_id = ? AND user_id = ?", conn.InstanceID, conn.SessionID, conn.UserID).
+			Delete(&domain.PresenceConnection{}).Error
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "instance_id"}, {Name: "session_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"connections", "status", "heartbeat_at"}),
+	}).Create(conn).Error
+}
+
+func (r *PresenceRepository) Heartbeat(ctx context.Context, instanceID string, at, staleBefore time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&domain.PresenceConnection{}).Where("instance_id = ?", instanceID).
+			Update("heartbeat_at", at).Error; err != nil {
+			return err
+		}
+		return tx.Where("heartbeat_at < ?", staleBefore).Delete(&domain.PresenceConnection{}).Error
+	})
+}
+
+func (r *PresenceRepository) ListConnections(ctx context.Context, sessionID, userID string, since time.Time) ([]domain.PresenceConnection, error) {
+	q := r.db.WithContext(ctx).Where("session_id = ? AND heartbeat_at >= ?", sessionID, since)
+	if userID != "" {
+		q = q.Where("user_id = ?", userID)
+	}
+	var list []domain.PresenceConnection
+	err := q.Find(&list).Error
+	return list, err
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresenceRepository_Connections(t *testing.T) {
+	ctx := context.Background()
+	dbs := openReplicas(t, 2)
+	require.NoError(t, dbs[0].AutoMigrate(&domain.PresenceConnection{}))
+	a, b := NewPresenceRepository(dbs[0]), NewPresenceRepository(dbs[1])
+	now := time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC)
+
+	require.NoError(t, a.SaveConnection(ctx, &domain.PresenceConnection{
+		InstanceID: "a", SessionID: "s1", UserID: "u1", Connections: 1, Status: domain.PresenceOnline, HeartbeatAt: now,
+	}))
+	require.NoError(t, a.SaveConnection(ctx, &domain.PresenceConnection{
+		InstanceID: "a", SessionID: "s1", UserID: "u1", Connections: 2, Status: domain.PresenceIdle, HeartbeatAt: now,
+	}))
+	require.NoError(t, b.SaveConnection(ctx, &domain.PresenceConnection{
+		InstanceID: "b", SessionID: "s1", UserID: "u2", Connections: 1, Status: domain.PresenceOnline, HeartbeatAt: now.Add(-time.Hour),
+	}))
+
+	list, err := b.ListConnections(ctx, "s1", "", now.Add(-time.Minute))
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, 2, list[0].Connections)
+	assert.Equal(t, domain.PresenceIdle, list[0].Status)
+
+	// 心跳刷新本实例的记录，并清理过期的记录
+	require.NoError(t, b.Heartbeat(ctx, "a", now.Add(time.Minute), now.Add(-time.Minute)))
+	list, err = a.ListConnections(ctx, "s1", "", time.Time{})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "u1", list[0].UserID)
+	assert.True(t, list[0].HeartbeatAt.Equal(now.Add(time.Minute)))
+
+	// 离线时删除记录
+	require.NoError(t, a.SaveConnection(ctx, &domain.PresenceConnection{
+		InstanceID: "a", SessionID: "s1", UserID: "u1", Status: domain.PresenceOffline, HeartbeatAt: now,
+	}))
+	list, err = a.ListConnections(ctx, "s1", "u1", time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"raven/internal/core/domain"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// activeRunStates 尚未结束、需要有实例驱动的执行状态
+var activeRunStates = []string{"running", "paused"}
+
+type ScenarioRepository struct {
+	db *gorm.DB
+}
+
+func NewScenarioRepository(db *gorm.DB) *ScenarioRepository {
+	return &ScenarioRepository{db: db}
+}
+
+func (r *ScenarioRepository) Create(ctx context.Context, scenario *domain.Scenario) error {
+	return r.db.WithContext(ctx).Create(scenario).Error
+}
+
+func (r *ScenarioRepository) GetByID(ctx context.Context, id string) (*domain.Scenario, error) {
+	var scenario domain.Scenario
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&scenario).Error; err != nil {
+		return nil, err
+	}
+	return &scenario, nil
+}
+
+func (r *ScenarioRepository) List(ctx context.Context) ([]domain.Scenario, error) {
+	var scenarios []domain.Scenario
+	err := r.db.WithContext(ctx).Order("created_at desc").Find(&scenarios).Error
+	return scenarios, err
+}
+
+func (r *ScenarioRepository) Delete(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Where("id = ?", id).Delete(&domain.Scenario{}).Error
+}
+
+// CreateRun 以单条 upsert 写入执行记录，已有记录仍在执行时不覆盖，避免两个实例同时启动脚本
+func (r *ScenarioRepository) CreateRun(ctx context.Context, run *domain.ScenarioRun) (bool, error) {
+	res := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "session_id"}},
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "scenario_runs.state NOT IN ?", Vars: []interface{}{activeRunStates}}}},
+		UpdateAll: true,
+	}).Create(run)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *ScenarioRepository) GetRun(ctx context.Context, sessionID string) (*domain.ScenarioRun, error) {
+	var run domain.ScenarioRun
+	if err := r.db.WithContext(ctx).Where("session_id = ?", sessionID).First(&run).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (r *ScenarioRepository) ListRuns(ctx context.Context) ([]domain.ScenarioRun, error) {
+	var runs []domain.ScenarioRun
+	err := r.db.WithContext(ctx).Find(&runs).Error
+	return runs, err
+}
+
+func (r *ScenarioRepository) ClaimRun(ctx context.Context, sessionID, owner string, now, leaseUntil time.Time) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&domain.ScenarioRun{}).
+		Where("session_id = ? AND state IN ? AND (owner = ? OR lease_until < ?)", sessionID, activeRunStates, owner, now).
+		Updates(map[string]interface{}{"owner": owner, "lease_until": leaseUntil})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *ScenarioRepository) UpdateRun(ctx context.Context, run *domain.ScenarioRun) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&domain.ScenarioRun{}).
+		Where("session_id = ? AND owner = ?", run.SessionID, run.Owner).
+		Select("state", "elapsed", "last_sim", "injects", "lease_until", "updated_at").
+		Updates(run)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *ScenarioRepository) SetRunCommand(ctx context.Context, sessionID, command string) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&domain.ScenarioRun{}).
+		Where("session_id = ? AND state IN ?", sessionID, activeRunStates).
+		Update("command", command)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *ScenarioRepository) ClearRunCommand(ctx context.Context, sessionID, command string) error {
+	return r.db.WithContext(ctx).Model(&domain.ScenarioRun{}).
+		Where("session_id = ? AND command = ?", sessionID, command).
+		Update("command", "").Error
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScenarioRepository_RunOwnership(t *testing.T) {
+	ctx := context.Background()
+	dbs := openReplicas(t, 2)
+	require.NoError(t, dbs[0].AutoMigrate(&domain.ScenarioRun{}))
+	a, b := NewScenarioRepository(dbs[0]), NewScenarioRepository(dbs[1])
+	now := time.Now()
+
+	run := &domain.ScenarioRun{SessionID: "s1", ScenarioID: "sc-1", State: "running", Owner: "a", LeaseUntil: now.Add(time.Minute)}
+	ok, err := a.CreateRun(ctx, run)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// 脚本仍在执行时不能重复启动，也不能被接管
+	ok, err = b.CreateRun(ctx, &domain.ScenarioRun{SessionID: "s1", ScenarioID: "sc-2", State: "running", Owner: "b"})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = b.ClaimRun(ctx, "s1", "b", now, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// 其他实例登记的指令在清除前保留最新一条
+	ok, err = b.SetRunCommand(ctx, "s1", "pause")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = b.SetRunCommand(ctx, "s1", "stop")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, a.ClearRunCommand(ctx, "s1", "pause"))
+	saved, err := a.GetRun(ctx, "s1")
+	require.NoError(t, err)
+	assert.Equal(t, "stop", saved.Command)
+	assert.Equal(t, "sc-1", saved.ScenarioID)
+
+	// 租约过期后由 b 接管，a 的进度不再写入
+	ok, err = b.ClaimRun(ctx, "s1", "b", now.Add(2*time.Minute), now.Add(3*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	run.Elapsed = time.Hour
+	ok, err = a.UpdateRun(ctx, run)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	run.Owner = "b"
+	run.State = "stopped"
+	run.Injects = map[string]domain.ScenarioInjectState{"order": {State: "sent", TargetID: "m1"}}
+	ok, err = b.UpdateRun(ctx, run)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	saved, err = a.GetRun(ctx, "s1")
+	require.NoError(t, err)
+	assert.Equal(t, "stopped", saved.State)
+	assert.Equal(t, time.Hour, saved.Elapsed)
+	assert.Equal(t, "m1", saved.Injects["order"].TargetID)
+
+	// 已结束的脚本不再接受指令，可以重新启动
+	ok, err = a.SetRunCommand(ctx, "s1", "resume")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = a.CreateRun(ctx, &domain.ScenarioRun{SessionID: "s1", ScenarioID: "sc-2", State: "running", Owner: "a"})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	saved, err = b.GetRun(ctx, "s1")
+	require.NoError(t, err)
+	assert.Equal(t, "sc-2", saved.ScenarioID)
+	assert.Equal(t, "a", saved.Owner)
+	assert.Empty(t, saved.Command)
+	assert.Empty(t, saved.Injects)
+
+	// 记录删除后原 owner 的写入不会重建记录
+	require.NoError(t, dbs[0].Where("session_id = ?", "s1").Delete(&domain.ScenarioRun{}).Error)
+	ok, err = a.UpdateRun(ctx, &domain.ScenarioRun{SessionID: "s1", Owner: "a", State: "running"})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = a.GetRun(ctx, "s1")
+	assert.Error(t, err)
+}
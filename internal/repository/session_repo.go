@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+
+	"raven/internal/core/domain"
+
+	"gorm.io/gorm"
+)
+
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+func (r *SessionRepository) Create(ctx context.Context, session *domain.Session) error {
+	return r.db.WithContext(ctx).Create(session).Error
+}
+
+func (r *SessionRepository) GetByID(ctx context.Context, id string) (*domain.Session, error) {
+	var session domain.Session
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *SessionRepository) List(ctx context.Context, status string) ([]domain.Session, error) {
+	var sessions []domain.Session
+	query := r.db.WithContext(ctx)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Order("created_at desc").Find(&sessions).Error
+	return sessions, err
+}
+
+// Update 仅写入指定列，避免并发的状态与时钟更新互相覆盖
+func (r *SessionRepository) Update(ctx context.Context, session *domain.Session, columns ...string) error {
+	return r.db.WithContext(ctx).Model(session).Select(columns).Updates(session).Error
+}
+
+// MarkHostManaged 将宿主同步名单中的场次标记为宿主管理
+func (r *SessionRepository) MarkHostManaged(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Model(&domain.Session{}).Where("id IN ?", ids).Update("origin", domain.SessionOriginHost).Error
+}
+
+func (r *SessionRepository) Delete(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Where("id = ?", id).Delete(&domain.Session{}).Error
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"gorm.io/gorm"
+)
+
+type WebhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (r *WebhookRepository) Create(ctx context.Context, hook *domain.Webhook) error {
+	return r.db.WithContext(ctx).Create(hook).Error
+}
+
+func (r *WebhookRepository) GetByID(ctx context.Context, id string) (*domain.Webhook, error) {
+	var hook domain.Webhook
+	if err := r.db.WithContext(ctx).First(&hook, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+func (r *WebhookRepository) List(ctx context.Context, sessionID string) ([]domain.Webhook, error) {
+	var hooks []domain.Webhook
+	db := r.db.WithContext(ctx)
+	if sessionID != "" {
+		db = db.Where("session_id = ?", sessionID)
+	}
+	err := db.Order("created_at asc").Find(&hooks).Error
+	return hooks, err
+}
+
+func (r *WebhookRepository) ListForSession(ctx context.Context, sessionID string) ([]domain.Webhook, error) {
+	var hooks []domain.Webhook
+	err := r.db.WithContext(ctx).Where("session_id = ? OR session_id = ''", sessionID).Find(&hooks).Error
+	return hooks, err
+}
+
+func (r *WebhookRepository) Delete(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&domain.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&domain.Webhook{}, "id = ?", id).Error
+	})
+}
+
+func (r *WebhookRepository) CreateDeliveries(ctx context.Context, deliveries []domain.WebhookDelivery) error {
+	return r.db.WithContext(ctx).Create(&deliveries).Error
+}
+
+func (r *WebhookRepository) GetDelivery(ctx context.Context, id string) (*domain.WebhookDelivery, error) {
+	var delivery domain.WebhookDelivery
+	if err := r.db.WithContext(ctx).First(&delivery, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ClaimDueDeliveries 认领到期待投递的记录，按下次投递时间排序。
+// 与 ClaimDueRelays 相同，以条件更新把 next_at 推迟到 leaseUntil 作为租约，
+// 多副本共用数据库时同一记录只由一个实例投递；认领者崩溃时记录在租约到期后重新可见。
+func (r *WebhookRepository) ClaimDueDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]domain.WebhookDelivery, error) {
+	db := r.db.WithContext(ctx)
+	var candidates []domain.WebhookDelivery
+	err := db.Where("status = ? AND next_at <= ?", domain.DeliveryStatusPending, now).
+		Order("next_at asc").
+		Limit(limit).
+		Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := make([]domain.WebhookDelivery, 0, len(candidates))
+	for _, delivery := range candidates {
+		res := db.Model(&domain.WebhookDelivery{}).
+			Where("id = ? AND status = ? AND next_at <= ?", delivery.ID, domain.DeliveryStatusPending, now).
+			Update("next_at", leaseUntil)
+		if res.Error != nil {
+			return claimed, res.Error
+		}
+		if res.RowsAffected == 1 {
+			delivery.NextAt = &leaseUntil
+			claimed = append(claimed, delivery)
+		}
+	}
+	return claimed, nil
+}
+
+func (r *WebhookRepository) UpdateDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	return r.db.WithContext(ctx).Model(&domain.WebhookDelivery{}).
+		Where("id = ?", delivery.ID).
+		Updates(map[string]interface{}{
+			"status":        delivery.Status,
+			"attempts":      delivery.Attempts,
+			"next_at":       delivery.NextAt,
+			"response_code": delivery.ResponseCode,
+			"last_error":    delivery.LastError,
+			"delivered_at":  delivery.DeliveredAt,
+		}).Error
+}
+
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, filter ports.WebhookDeliveryFilter, page, pageSize int) ([]domain.WebhookDelivery, int64, error) {
+	var deliveries []domain.WebhookDelivery
+	var total int64
+
+	db := r.db.WithContext(ctx).Model(&domain.WebhookDelivery{})
+	if filter.WebhookID != "" {
+		db = db.Where("webhook_id = ?", filter.WebhookID)
+	}
+	if filter.SessionID != "" {
+		db = db.Where("session_id = ?", filter.SessionID)
+	}
+	if filter.Status != "" {
+		db = db.Where("status = ?", filter.Status)
+	}
+	if filter.Event != "" {
+		db = db.Where("event = ?", filter.Event)
+	}
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	err := db.Order("created_at desc").Offset(offset).Limit(pageSize).Find(&deliveries).Error
+	return deliveries, total, err
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookRepository_ClaimDueDeliveries(t *testing.T) {
+	ctx := context.Background()
+	dbs := openReplicas(t, 2)
+	require.NoError(t, dbs[0].AutoMigrate(&domain.WebhookDelivery{}))
+	now := time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC)
+	lease := now.Add(15 * time.Minute)
+	due, later := now.Add(-time.Minute), now.Add(time.Minute)
+	require.NoError(t, dbs[0].Create([]domain.WebhookDelivery{
+		{ID: "d1", WebhookID: "h1", Status: domain.DeliveryStatusPending, NextAt: &due},
+		{ID: "d2", WebhookID: "h1", Status: domain.DeliveryStatusPending, NextAt: &later},
+		{ID: "d3", WebhookID: "h1", Status: domain.DeliveryStatusDelivered, NextAt: &due},
+	}).Error)
+	a, b := NewWebhookRepository(dbs[0]), NewWebhookRepository(dbs[1])
+
+	claimed, err := a.ClaimDueDeliveries(ctx, now, lease, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, "d1", claimed[0].ID)
+	assert.True(t, claimed[0].NextAt.Equal(lease))
+
+	// 另一实例看不到已认领的记录，租约到期后重新可见
+	again, err := b.ClaimDueDeliveries(ctx, now, lease, 10)
+	require.NoError(t, err)
+	assert.Empty(t, again)
+	expired, err := b.ClaimDueDeliveries(ctx, lease, lease.Add(15*time.Minute), 10)
+	require.NoError(t, err)
+	assert.Len(t, expired, 2)
+}
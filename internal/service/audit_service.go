@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+)
+
+// maxAuditPageSize 审计查询单页上限
+const maxAuditPageSize = 500
+
+type AuditService struct {
+	repo ports.AuditRepository
+}
+
+func NewAuditService(repo ports.AuditRepository) *AuditService {
+	return &AuditService{repo: repo}
+}
+
+func (s *AuditService) Record(ctx context.Context, entry domain.AuditLog) error {
+	if entry.Action == "" {
+		return ports.NewInvalidInputError("audit action is required", nil)
+	}
+	entry.ID = ""
+	if err := s.repo.Append(ctx, &entry); err != nil {
+		return ports.NewInternalError("failed to write audit log", err)
+	}
+	return nil
+}
+
+func (s *AuditService) Query(ctx context.Context, filter ports.AuditFilter, page, pageSize int) ([]domain.AuditLog, int64, error) {
+	if err := validateAuditFilter(&filter); err != nil {
+		return nil, 0, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 50
+	}
+	if pageSize > maxAuditPageSize {
+		pageSize = maxAuditPageSize
+	}
+
+	logs, total, err := s.repo.List(ctx, filter, page, pageSize)
+	if err != nil {
+		return nil, 0, ports.NewInternalError("failed to query audit log", err)
+	}
+	return logs, total, nil
+}
+
+func (s *AuditService) Export(ctx context.Context, filter ports.AuditFilter, fn func([]domain.AuditLog) error) error {
+	if err := validateAuditFilter(&filter); err != nil {
+		return err
+	}
+	if err := s.repo.Each(ctx, filter, fn); err != nil {
+		return ports.NewInternalError("failed to export audit log", err)
+	}
+	return nil
+}
+
+func validateAuditFilter(filter *ports.AuditFilter) error {
+	filter.Action = strings.ToUpper(strings.TrimSpace(filter.Action))
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return ports.NewInvalidInputError("from must be before to", nil)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAuditService_Record(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockAuditRepository)
+	svc := NewAuditService(mockRepo)
+
+	mockRepo.On("Append", ctx, mock.MatchedBy(func(e *domain.AuditLog) bool {
+		return e.Action == domain.AuditActionAttachmentDownload && e.ActorID == "alpha" && e.ID == ""
+	})).Return(nil).Once()
+
+	err := svc.Record(ctx, domain.AuditLog{ID: "forged", ActorID: "alpha", Action: domain.AuditActionAttachmentDownload, TargetID: "att-1"})
+	assert.NoError(t, err)
+
+	err = svc.Record(ctx, domain.AuditLog{ActorID: "alpha"})
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAuditService_Query(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockAuditRepository)
+	svc := NewAuditService(mockRepo)
+
+	t.Run("normalizes filter and paging", func(t *testing.T) {
+		filter := ports.AuditFilter{ActorID: "alpha", Action: domain.AuditActionMailRead}
+		mockRepo.On("List", ctx, filter, 1, maxAuditPageSize).Return([]domain.AuditLog{{ID: "a1"}}, int64(1), nil).Once()
+
+		logs, total, err := svc.Query(ctx, ports.AuditFilter{ActorID: "alpha", Action: " mail_read "}, 0, 10000)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), total)
+		assert.Len(t, logs, 1)
+	})
+
+	t.Run("rejects inverted range", func(t *testing.T) {
+		from := time.Now()
+		to := from.Add(-time.Hour)
+		_, _, err := svc.Query(ctx, ports.AuditFilter{From: &from, To: &to}, 1, 20)
+		assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+	})
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"gorm.io/gorm"
+)
+
+// DefaultChatEditWindow 消息发出后允许编辑或撤回的时长 (真实时间)
+const DefaultChatEditWindow = 5 * time.Minute
+
+// SetChatEditWindow 设置编辑与撤回时限，<= 0 表示不限制
+func (s *MailService) SetChatEditWindow(window time.Duration) {
+	s.editWindow = window
+}
+
+// EditChatMessage 发送方在时限内修改消息正文，修改前的正文写入编辑历史。
+// mentions 按新正文重新给出并替换原有的提及，新增的被提及用户收到 MENTION 事件
+func (s *MailService) EditChatMessage(ctx context.Context, sessionID, userID, messageID, content string, mentions []domain.ChatMention) (*domain.ChatMessage, error) {
+	if content == "" {
+		return nil, ports.NewInvalidInputError("content is required", nil)
+	}
+	session, msg, err := s.ownChatMessage(ctx, sessionID, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Content == content && slices.Equal(msg.Mentions, mentions) {
+		return msg, nil
+	}
+	conv, err := s.chatConversation(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMentions(session, conv, content, mentions); err != nil {
+		return nil, err
+	}
+	var added []domain.ChatMention
+	for _, m := range mentions {
+		if !slices.ContainsFunc(msg.Mentions, func(old domain.ChatMention) bool { return old.UserID == m.UserID }) {
+			added = append(added, m)
+		}
+	}
+
+	now := s.clock.Now(ctx, sessionID)
+	edit := &domain.ChatMessageEdit{
+		MessageID:    msg.ID,
+		SessionID:    sessionID,
+		Content:      msg.Content,
+		EditedAt:     now,
+		RealEditedAt: time.Now(),
+	}
+	msg.Content = content
+	msg.Mentions = mentions
+	msg.EditedAt = &now
+	if err := s.repo.EditChatMessage(ctx, msg, edit); err != nil {
+		return nil, ports.NewInternalError("failed to edit message", err)
+	}
+
+	s.emitChatUpdate(ctx, domain.WebhookEventChatEdited, msg)
+	if len(added) > 0 {
+		notify := *msg
+		notify.Mentions = added
+		s.emitMentions(ctx, &notify)
+	}
+	return msg, nil
+}
+
+// RetractChatMessage 发送方在时限内撤回消息: 正文替换为空的撤回标记，附件文件一并删除
+func (s *MailService) RetractChatMessage(ctx context.Context, sessionID, userID, messageID string) (*domain.ChatMessage, error) {
+	_, msg, err := s.ownChatMessage(ctx, sessionID, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now(ctx, sessionID)
+	attachments := msg.Attachments
+	msg.Content = ""
+	msg.RetractedAt = &now
+	msg.Mentions = nil
+	msg.Attachments = []domain.Attachment{}
+	if err := s.repo.RetractChatMessage(ctx, msg); err != nil {
+		return nil, ports.NewInternalError("failed to retract message", err)
+	}
+	// 记录删除后再删除文件，删除失败只留下无引用的文件；已转入文电的文件仍被引用，予以保留
+	for _, att := range attachments {
+		inUse, err := s.repo.AttachmentFileInUse(ctx, att.FilePath)
+		if err != nil {
+			log.Printf("[Chat] Failed to check references of %s: %v", att.FilePath, err)
+			continue
+		}
+		if inUse {
+			continue
+		}
+		if err := s.storage.DeleteFile(ctx, att.FilePath); err != nil {
+			log.Printf("[Chat] Failed to delete attachment %s of retracted message %s: %v", att.FilePath, msg.ID, err)
+		}
+	}
+
+	s.emitChatUpdate(ctx, domain.WebhookEventChatRetracted, msg)
+	return msg, nil
+}
+
+// GetChatMessageEdits 返回消息的编辑历史，仅会话参与者可查看
+func (s *MailService) GetChatMessageEdits(ctx context.Context, sessionID, userID, messageID string) ([]domain.ChatMessageEdit, error) {
+	msg, err := s.loadChatMessage(ctx, sessionID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.chatParticipants(ctx, msg, userID); err != nil {
+		return nil, err
+	}
+	edits, err := s.repo.ListChatMessageEdits(ctx, msg.ID)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to load edit history", err)
+	}
+	return edits, nil
+}
+
+// ownChatMessage 加载用户本人发出、仍可修改的消息及所在场次
+func (s *MailService) ownChatMessage(ctx context.Context, sessionID, userID, messageID string) (*domain.Session, *domain.ChatMessage, error) {
+	session, err := s.requireRunning(ctx, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg, err := s.loadChatMessage(ctx, sessionID, messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if msg.SenderID != userID {
+		return nil, nil, ports.NewForbiddenError("only the sender can modify a message", nil)
+	}
+	if msg.RetractedAt != nil {
+		return nil, nil, ports.NewInvalidInputError("message has been retracted", nil)
+	}
+	if s.editWindow > 0 && time.Since(msg.RealCreatedAt) > s.editWindow {
+		return nil, nil, ports.NewForbiddenError("edit window has expired", nil)
+	}
+	return session, msg, nil
+}
+
+// chatConversation 返回消息所属会话，未登记会话的旧单聊消息按收发双方构造
+func (s *MailService) chatConversation(ctx context.Context, msg *domain.ChatMessage) (*domain.Conversation, error) {
+	if msg.ConversationID == "" {
+		return &domain.Conversation{
+			SessionID: msg.SessionID,
+			Type:      domain.ConversationDirect,
+			Members:   []domain.ConversationMember{{UserID: msg.SenderID}, {UserID: msg.ReceiverID}},
+		}, nil
+	}
+	return s.conversationFor(ctx, msg.SessionID, msg.SenderID, msg.ConversationID)
+}
+
+func (s *MailService) loadChatMessage(ctx context.Context, sessionID, messageID string) (*domain.ChatMessage, error) {
+	msg, err := s.repo.GetChatMessage(ctx, sessionID, messageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ports.NewNotFoundError("message not found", err)
+		}
+		return nil, ports.NewInternalError("failed to load message", err)
+	}
+	return msg, nil
+}
+
+// chatParticipants 校验 userID 可访问该消息 (userID 为空时不校验)，返回推送对象，nil 表示全场次
+func (s *MailService) chatParticipants(ctx context.Context, msg *domain.ChatMessage, userID string) ([]string, error) {
+	if msg.ReceiverID != "" {
+		if userID != "" && userID != msg.SenderID && userID != msg.ReceiverID {
+			return nil, ports.NewForbiddenError("not a participant of this message", nil)
+		}
+		return []string{msg.SenderID, msg.ReceiverID}, nil
+	}
+	viewer := userID
+	if viewer == "" {
+		viewer = msg.SenderID
+	}
+	conv, err := s.conversationFor(ctx, msg.SessionID, viewer, msg.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conv.Type == domain.ConversationChannel {
+		return nil, nil
+	}
+	return conv.MemberIDs(""), nil
+}
+
+// emitChatUpdate 向消息的全部参与者 (含发送方的其他终端) 推送更新后的消息
+func (s *MailService) emitChatUpdate(ctx context.Context, event string, msg *domain.ChatMessage) {
+	payload := map[string]interface{}{
+		"type":       event,
+		"session_id": msg.SessionID,
+		"data":       msg,
+	}
+	targets, err := s.chatParticipants(ctx, msg, "")
+	if err != nil {
+		log.Printf("[Chat] Failed to resolve participants of message %s: %v", msg.ID, err)
+		targets = []string{msg.SenderID}
+	}
+	if targets != nil {
+		payload["targets"] = targets
+	}
+	s.broadcast(ctx, payload)
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+)
+
+// maxEscalateMessages 单次转为文电的聊天记录条数上限
+const maxEscalateMessages = 500
+
+func (s *MailService) EscalateChat(ctx context.Context, sessionID, userID string, req ports.EscalateChatRequest) (*domain.Mail, error) {
+	if (req.PeerID == "") == (req.ConversationID == "") {
+		return nil, ports.NewInvalidInputError("exactly one of peer_id and conversation_id is required", nil)
+	}
+	if req.FromMessageID == "" {
+		return nil, ports.NewInvalidInputError("from_id is required", nil)
+	}
+	if len(req.To) == 0 {
+		return nil, ports.NewInvalidInputError("at least one recipient is required", nil)
+	}
+
+	var conv *domain.Conversation
+	var title string
+	if req.ConversationID != "" {
+		var err error
+		if conv, err = s.conversationFor(ctx, sessionID, userID, req.ConversationID); err != nil {
+			return nil, err
+		}
+		title = conv.Name
+		if conv.Type == domain.ConversationDirect {
+			title = "与 " + conv.Peer(userID) + " 的单聊"
+		} else if title == "" {
+			title = "群聊"
+		}
+	} else {
+		if req.PeerID == userID {
+			return nil, ports.NewInvalidInputError("invalid peer", nil)
+		}
+		// 单聊按双方判断消息归属，无需建立会话
+		conv = &domain.Conversation{SessionID: sessionID, Type: domain.ConversationDirect, DirectKey: domain.DirectKey(userID, req.PeerID)}
+		title = "与 " + req.PeerID + " 的单聊"
+	}
+
+	msgs, err := s.chatRange(ctx, conv, req.FromMessageID, req.ToMessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	var shared []domain.Attachment
+	for _, msg := range msgs {
+		shared = append(shared, msg.Attachments...)
+	}
+	subject := req.Subject
+	if subject == "" {
+		subject = "即时通讯记录：" + title
+	}
+	return s.SendMail(ctx, userID, ports.SendMailRequest{
+		SessionID:         sessionID,
+		Subject:           subject,
+		Content:           renderTranscript(title, req.Note, msgs),
+		ContentType:       "rich",
+		To:                req.To,
+		Cc:                req.Cc,
+		SharedAttachments: shared,
+	})
+}
+
+// chatRange 返回会话中 fromID 至 toID (含两端) 的消息，按时间先后排列
+func (s *MailService) chatRange(ctx context.Context, conv *domain.Conversation, fromID, toID string) ([]domain.ChatMessage, error) {
+	from, err := s.messageInChat(ctx, conv, fromID)
+	if err != nil {
+		return nil, err
+	}
+	if toID != "" && toID != fromID {
+		if _, err := s.messageInChat(ctx, conv, toID); err != nil {
+			return nil, err
+		}
+	}
+	if toID == fromID {
+		return []domain.ChatMessage{*from}, nil
+	}
+
+	after, err := s.chatContext(ctx, from, ports.ChatHistoryPage{After: fromID, Limit: maxEscalateMessages})
+	if err != nil {
+		return nil, err
+	}
+	msgs := append([]domain.ChatMessage{*from}, after...)
+	if toID == "" {
+		if len(after) == maxEscalateMessages {
+			return nil, ports.NewInvalidInputError(fmt.Sprintf("range exceeds %d messages", maxEscalateMessages), nil)
+		}
+		return msgs, nil
+	}
+	for i, msg := range msgs {
+		if msg.ID == toID {
+			return msgs[:i+1], nil
+		}
+	}
+	return nil, ports.NewInvalidInputError(fmt.Sprintf("to_id must follow from_id within %d messages", maxEscalateMessages), nil)
+}
+
+// renderTranscript 将聊天记录渲染为富文本 (HTML) 正文，用户输入一律转义
+func renderTranscript(title, note string, msgs []domain.ChatMessage) string {
+	var b strings.Builder
+	if note != "" {
+		fmt.Fprintf(&b, "<p>%s</p>\n", htmlText(note))
+	}
+	fmt.Fprintf(&b, "<p><strong>即时通讯记录</strong>：%s，共 %d 条，%s 至 %s</p>\n<blockquote>\n",
+		html.EscapeString(title), len(msgs),
+		msgs[0].CreatedAt.Format("2006-01-02 15:04:05"), msgs[len(msgs)-1].CreatedAt.Format("2006-01-02 15:04:05"))
+	for _, msg := range msgs {
+		fmt.Fprintf(&b, "<p><strong>%s</strong> <span>%s</span><br>",
+			html.EscapeString(msg.SenderID), msg.CreatedAt.Format("2006-01-02 15:04:05"))
+		if msg.RetractedAt != nil {
+			b.WriteString("<em>（消息已撤回）</em>")
+		} else {
+			b.WriteString(htmlText(msg.Content))
+			for _, att := range msg.Attachments {
+				fmt.Fprintf(&b, "<br>[附件] %s", html.EscapeString(att.FileName))
+			}
+		}
+		b.WriteString("</p>\n")
+	}
+	b.WriteString("</blockquote>")
+	return b.String()
+}
+
+// htmlText 转义纯文本并保留换行
+func htmlText(s string) string {
+	return strings.ReplaceAll(html.EscapeString(s), "\n", "<br>")
+}
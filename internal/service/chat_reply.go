@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"unicode/utf8"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"gorm.io/gorm"
+)
+
+// replyTarget 校验引用回复的消息: 须属于同一会话且未撤回
+func (s *MailService) replyTarget(ctx context.Context, conv *domain.Conversation, replyToID string) (*domain.ChatMessage, error) {
+	parent, err := s.messageInChat(ctx, conv, replyToID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.RetractedAt != nil {
+		return nil, ports.NewInvalidInputError("cannot reply to a retracted message", nil)
+	}
+	return parent, nil
+}
+
+// messageInChat 加载会话中的一条消息，不属于该会话时视为输入错误
+func (s *MailService) messageInChat(ctx context.Context, conv *domain.Conversation, messageID string) (*domain.ChatMessage, error) {
+	msg, err := s.repo.GetChatMessage(ctx, conv.SessionID, messageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ports.NewInvalidInputError("message not found: "+messageID, err)
+		}
+		return nil, ports.NewInternalError("failed to load message", err)
+	}
+	sameChat := msg.ConversationID == conv.ID
+	if conv.Type == domain.ConversationDirect && msg.ReceiverID != "" {
+		// 建立单聊会话前的消息没有 ConversationID，按双方判断
+		sameChat = domain.DirectKey(msg.SenderID, msg.ReceiverID) == conv.DirectKey
+	}
+	if !sameChat {
+		return nil, ports.NewInvalidInputError("message belongs to another conversation: "+messageID, nil)
+	}
+	return msg, nil
+}
+
+// checkMentions 校验提及的用户与位置。单聊与群聊只能提及成员，频道可提及场次参与者
+// (场次未登记参与者时不限制)
+func checkMentions(session *domain.Session, conv *domain.Conversation, content string, mentions []domain.ChatMention) error {
+	allowed := conv.MemberIDs("")
+	if conv.Type == domain.ConversationChannel {
+		allowed = session.Participants
+	}
+	length := utf8.RuneCountInString(content)
+	for _, m := range mentions {
+		if m.UserID == "" {
+			return ports.NewInvalidInputError("mention requires user_id", nil)
+		}
+		if len(allowed) > 0 && !slices.Contains(allowed, m.UserID) {
+			return ports.NewInvalidInputError("mentioned user is not in this conversation: "+m.UserID, nil)
+		}
+		if m.Offset < 0 || m.Length < 0 || m.Offset+m.Length > length {
+			return ports.NewInvalidInputError("mention range is out of content", nil)
+		}
+	}
+	return nil
+}
+
+// chatMessageRef 生成被引用消息的摘要
+func chatMessageRef(msg *domain.ChatMessage) *domain.ChatMessageRef {
+	return &domain.ChatMessageRef{
+		ID:        msg.ID,
+		SenderID:  msg.SenderID,
+		Content:   truncate(msg.Content, chatPreviewLength),
+		Retracted: msg.RetractedAt != nil,
+		CreatedAt: msg.CreatedAt,
+	}
+}
+
+// attachReplies 为引用了其他消息的记录填入被引用消息的摘要，被引用消息已不存在时留空
+func (s *MailService) attachReplies(ctx context.Context, sessionID string, msgs []domain.ChatMessage) error {
+	var ids []string
+	for _, msg := range msgs {
+		if msg.ReplyToID != "" {
+			ids = append(ids, msg.ReplyToID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	parents, err := s.repo.ListChatMessagesByIDs(ctx, sessionID, ids)
+	if err != nil {
+		return ports.NewInternalError("failed to load replied messages", err)
+	}
+	refs := make(map[string]*domain.ChatMessageRef, len(parents))
+	for i := range parents {
+		refs[parents[i].ID] = chatMessageRef(&parents[i])
+	}
+	for i := range msgs {
+		if msgs[i].ReplyToID != "" {
+			msgs[i].ReplyTo = refs[msgs[i].ReplyToID]
+		}
+	}
+	return nil
+}
+
+// emitMentions 向被提及的用户单独推送 MENTION 事件，群聊与频道中也能据此提醒
+func (s *MailService) emitMentions(ctx context.Context, msg *domain.ChatMessage) {
+	var targets []string
+	for _, m := range msg.Mentions {
+		if m.UserID != msg.SenderID && !slices.Contains(targets, m.UserID) {
+			targets = append(targets, m.UserID)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+	s.broadcast(ctx, map[string]interface{}{
+		"type":       domain.WebhookEventMention,
+		"session_id": msg.SessionID,
+		"targets":    targets,
+		"data":       msg,
+	})
+}
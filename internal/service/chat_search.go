@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+)
+
+// 检索结果条数及每条命中前后附带的上下文条数
+const (
+	chatSearchLimit    = 20
+	maxChatSearchLimit = 100
+	chatSearchContext  = 2
+)
+
+func (s *MailService) SearchChat(ctx context.Context, sessionID, userID string, query ports.ChatSearchQuery) ([]ports.ChatSearchHit, error) {
+	query.Query = strings.TrimSpace(query.Query)
+	if query.Query == "" {
+		return nil, ports.NewInvalidInputError("search query is required", nil)
+	}
+	if query.PeerID != "" && query.ConversationID != "" {
+		return nil, ports.NewInvalidInputError("peer_id and conversation_id cannot be combined", nil)
+	}
+	if query.Limit <= 0 {
+		query.Limit = chatSearchLimit
+	} else if query.Limit > maxChatSearchLimit {
+		query.Limit = maxChatSearchLimit
+	}
+	if query.ConversationID != "" {
+		conv, err := s.conversationFor(ctx, sessionID, userID, query.ConversationID)
+		if err != nil {
+			return nil, err
+		}
+		if conv.Type == domain.ConversationDirect {
+			// 单聊按双方检索，包含建立会话前的消息
+			query.PeerID, query.ConversationID = conv.Peer(userID), ""
+		}
+	}
+
+	msgs, err := s.repo.SearchChatMessages(ctx, sessionID, userID, query)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to search chat", err)
+	}
+	hits := make([]ports.ChatSearchHit, 0, len(msgs))
+	for _, msg := range msgs {
+		before, err := s.chatContext(ctx, &msg, ports.ChatHistoryPage{Before: msg.ID, Limit: chatSearchContext})
+		if err != nil {
+			return nil, err
+		}
+		after, err := s.chatContext(ctx, &msg, ports.ChatHistoryPage{After: msg.ID, Limit: chatSearchContext})
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, ports.ChatSearchHit{Message: msg, Before: before, After: after})
+	}
+	return hits, nil
+}
+
+// chatContext 取消息所在会话中紧邻的一页消息，按时间先后排列
+func (s *MailService) chatContext(ctx context.Context, msg *domain.ChatMessage, page ports.ChatHistoryPage) ([]domain.ChatMessage, error) {
+	var msgs []domain.ChatMessage
+	var err error
+	if msg.ReceiverID != "" {
+		msgs, err = s.repo.GetChatHistory(ctx, msg.SessionID, msg.SenderID, msg.ReceiverID, page)
+	} else {
+		msgs, err = s.repo.GetConversationHistory(ctx, msg.SessionID, msg.ConversationID, page)
+	}
+	if err != nil {
+		return nil, ports.NewInternalError("failed to load search context", err)
+	}
+	slices.Reverse(msgs)
+	return msgs, nil
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+)
+
+// ClockService 管理各场次的演练时钟，时钟状态持久化在场次记录上
+type ClockService struct {
+	sessions ports.SessionRepository
+}
+
+func NewClockService(sessions ports.SessionRepository) *ClockService {
+	return &ClockService{sessions: sessions}
+}
+
+// Now 返回场次当前的演练时间，场次不存在时退化为真实时间
+func (s *ClockService) Now(ctx context.Context, sessionID string) time.Time {
+	now := time.Now()
+	session, err := s.sessions.GetByID(ctx, sessionID)
+	if err != nil {
+		return now
+	}
+	return session.Clock.At(now)
+}
+
+func (s *ClockService) GetClock(ctx context.Context, sessionID string) (*ports.ClockState, error) {
+	session, err := s.sessions.GetByID(ctx, sessionID)
+	if err != nil {
+		return nil, ports.NewNotFoundError("session not found", err)
+	}
+	return clockState(session, time.Now()), nil
+}
+
+// SetClock 以当前真实时间为新锚点，重新设定演练时间和/或倍速
+func (s *ClockService) SetClock(ctx context.Context, sessionID string, req ports.SetClockRequest) (*ports.ClockState, error) {
+	if req.Speed != nil && *req.Speed <= 0 {
+		return nil, ports.NewInvalidInputError("speed must be positive", nil)
+	}
+
+	return s.update(ctx, sessionID, func(clock *domain.SessionClock, now time.Time) error {
+		clock.SimAnchor = clock.At(now)
+		if req.SimulatedTime != nil {
+			clock.SimAnchor = *req.SimulatedTime
+		}
+		if req.Speed != nil {
+			clock.Speed = *req.Speed
+		}
+		if clock.Speed <= 0 {
+			clock.Speed = 1
+		}
+		clock.RealAnchor = now
+		return nil
+	})
+}
+
+func (s *ClockService) PauseClock(ctx context.Context, sessionID string) (*ports.ClockState, error) {
+	return s.update(ctx, sessionID, func(clock *domain.SessionClock, now time.Time) error {
+		if clock.Paused {
+			return ports.NewInvalidInputError("clock is already paused", nil)
+		}
+		clock.SimAnchor = clock.At(now)
+		clock.RealAnchor = now
+		if clock.Speed <= 0 {
+			clock.Speed = 1
+		}
+		clock.Paused = true
+		return nil
+	})
+}
+
+func (s *ClockService) ResumeClock(ctx context.Context, sessionID string) (*ports.ClockState, error) {
+	return s.update(ctx, sessionID, func(clock *domain.SessionClock, now time.Time) error {
+		if !clock.Paused {
+			return ports.NewInvalidInputError("clock is not paused", nil)
+		}
+		clock.RealAnchor = now
+		clock.Paused = false
+		return nil
+	})
+}
+
+func (s *ClockService) update(ctx context.Context, sessionID string, fn func(clock *domain.SessionClock, now time.Time) error) (*ports.ClockState, error) {
+	session, err := s.sessions.GetByID(ctx, sessionID)
+	if err != nil {
+		return nil, ports.NewNotFoundError("session not found", err)
+	}
+
+	now := time.Now()
+	if err := fn(&session.Clock, now); err != nil {
+		return nil, err
+	}
+	if err := s.sessions.Update(ctx, session, "clock_sim_anchor", "clock_real_anchor", "clock_speed", "clock_paused"); err != nil {
+		return nil, ports.NewInternalError("failed to update session clock", err)
+	}
+	return clockState(session, now), nil
+}
+
+func clockState(session *domain.Session, now time.Time) *ports.ClockState {
+	speed := session.Clock.Speed
+	if session.Clock.RealAnchor.IsZero() || speed <= 0 {
+		speed = 1
+	}
+	return &ports.ClockState{
+		SessionID:     session.ID,
+		RealTime:      now,
+		SimulatedTime: session.Clock.At(now),
+		Speed:         speed,
+		Paused:        session.Clock.Paused,
+	}
+}
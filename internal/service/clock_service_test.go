@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSessionClock_At(t *testing.T) {
+	wall := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	scenario := time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, wall, domain.SessionClock{}.At(wall), "unset clock follows wall time")
+
+	clock := domain.SessionClock{SimAnchor: scenario, RealAnchor: wall, Speed: 4}
+	assert.Equal(t, scenario.Add(40*time.Minute), clock.At(wall.Add(10*time.Minute)))
+
+	clock.Paused = true
+	assert.Equal(t, scenario, clock.At(wall.Add(time.Hour)))
+}
+
+func TestClockService_SetAndPause(t *testing.T) {
+	ctx := context.TODO()
+	mockSessions := new(MockSessionRepository)
+	svc := NewClockService(mockSessions)
+
+	session := &domain.Session{ID: "s1", Status: domain.SessionStatusRunning}
+	mockSessions.On("GetByID", ctx, "s1").Return(session, nil)
+	// 时钟更新只写时钟列，不会覆盖并发的状态变更
+	clockColumns := []string{"clock_sim_anchor", "clock_real_anchor", "clock_speed", "clock_paused"}
+	mockSessions.On("Update", ctx, session, clockColumns).Return(nil)
+
+	scenario := time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC)
+	speed := 60.0
+	state, err := svc.SetClock(ctx, "s1", ports.SetClockRequest{SimulatedTime: &scenario, Speed: &speed})
+	assert.NoError(t, err)
+	assert.Equal(t, 60.0, state.Speed)
+	assert.WithinDuration(t, scenario, state.SimulatedTime, time.Second)
+
+	state, err = svc.PauseClock(ctx, "s1")
+	assert.NoError(t, err)
+	assert.True(t, state.Paused)
+	frozen := svc.Now(ctx, "s1")
+	assert.Equal(t, frozen, svc.Now(ctx, "s1"))
+
+	_, err = svc.PauseClock(ctx, "s1")
+	assert.Error(t, err)
+
+	negative := -1.0
+	_, err = svc.SetClock(ctx, "s1", ports.SetClockRequest{Speed: &negative})
+	assert.Error(t, err)
+	mockSessions.AssertNumberOfCalls(t, "Update", 2)
+	mockSessions.AssertCalled(t, "Update", ctx, mock.AnythingOfType("*domain.Session"), clockColumns)
+}
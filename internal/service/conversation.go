@@ -0,0 +1,414 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"gorm.io/gorm"
+)
+
+// 聊天记录每页条数，未指定时取默认值
+const (
+	chatHistoryLimit    = 100
+	maxChatHistoryLimit = 500
+)
+
+// 会话变更事件的 action
+const (
+	conversationCreated       = "created"
+	conversationMembersAdded  = "members_added"
+	conversationMemberRemoved = "member_removed"
+	conversationRoleChanged   = "role_changed"
+)
+
+// CreateConversation 建立会话，创建者为管理员。单聊已存在时直接返回已有会话
+func (s *MailService) CreateConversation(ctx context.Context, sessionID, creatorID string, req ports.CreateConversationRequest) (*domain.Conversation, error) {
+	if err := s.requireOpen(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	members := uniqueMembers(req.Members, creatorID)
+
+	switch req.Type {
+	case domain.ConversationDirect:
+		if len(members) != 1 {
+			return nil, ports.NewInvalidInputError("direct conversation requires exactly one other member", nil)
+		}
+		return s.directConversation(ctx, sessionID, creatorID, members[0])
+	case domain.ConversationGroup:
+		if len(members) == 0 {
+			return nil, ports.NewInvalidInputError("group conversation requires at least one other member", nil)
+		}
+	case domain.ConversationChannel:
+		if req.Name == "" {
+			return nil, ports.NewInvalidInputError("channel name is required", nil)
+		}
+		// 频道对场次内全部用户开放，成员在首次发言或阅读时登记
+		members = nil
+	default:
+		return nil, ports.NewInvalidInputError("invalid conversation type", nil)
+	}
+
+	now := s.clock.Now(ctx, sessionID)
+	conv := &domain.Conversation{
+		SessionID:     sessionID,
+		Type:          req.Type,
+		Name:          req.Name,
+		CreatedBy:     creatorID,
+		CreatedAt:     now,
+		RealCreatedAt: time.Now(),
+		Members:       []domain.ConversationMember{{UserID: creatorID, SessionID: sessionID, Role: domain.MemberRoleAdmin, JoinedAt: now}},
+	}
+	for _, id := range members {
+		conv.Members = append(conv.Members, domain.ConversationMember{UserID: id, SessionID: sessionID, Role: domain.MemberRoleMember, JoinedAt: now})
+	}
+	if err := s.repo.CreateConversation(ctx, conv); err != nil {
+		return nil, ports.NewInternalError("failed to create conversation", err)
+	}
+	s.emitConversation(ctx, conv, conversationCreated)
+	return conv, nil
+}
+
+func (s *MailService) ListConversations(ctx context.Context, sessionID, userID string) ([]domain.Conversation, error) {
+	convs, err := s.repo.ListConversations(ctx, sessionID, userID)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to list conversations", err)
+	}
+	return convs, nil
+}
+
+func (s *MailService) GetConversation(ctx context.Context, sessionID, userID, conversationID string) (*domain.Conversation, error) {
+	return s.conversationFor(ctx, sessionID, userID, conversationID)
+}
+
+func (s *MailService) AddConversationMembers(ctx context.Context, sessionID, actorID, conversationID string, userIDs []string) (*domain.Conversation, error) {
+	if err := s.requireOpen(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	conv, err := s.conversationFor(ctx, sessionID, actorID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conv.Type != domain.ConversationGroup {
+		return nil, ports.NewInvalidInputError("members can only be added to group conversations", nil)
+	}
+	if !conv.IsAdmin(actorID) {
+		return nil, ports.NewForbiddenError("only conversation admins can add members", nil)
+	}
+
+	now := s.clock.Now(ctx, sessionID)
+	var added []domain.ConversationMember
+	for _, id := range uniqueMembers(userIDs, "") {
+		if conv.Member(id) == nil {
+			added = append(added, domain.ConversationMember{ConversationID: conv.ID, UserID: id, SessionID: sessionID, Role: domain.MemberRoleMember, JoinedAt: now})
+		}
+	}
+	if len(added) == 0 {
+		return conv, nil
+	}
+	if err := s.repo.AddConversationMembers(ctx, added); err != nil {
+		return nil, ports.NewInternalError("failed to add members", err)
+	}
+	conv.Members = append(conv.Members, added...)
+	s.emitConversation(ctx, conv, conversationMembersAdded)
+	return conv, nil
+}
+
+func (s *MailService) RemoveConversationMember(ctx context.Context, sessionID, actorID, conversationID, userID string) (*domain.Conversation, error) {
+	if err := s.requireOpen(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	conv, err := s.conversationFor(ctx, sessionID, actorID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conv.Type != domain.ConversationGroup {
+		return nil, ports.NewInvalidInputError("members can only be removed from group conversations", nil)
+	}
+	if actorID != userID && !conv.IsAdmin(actorID) {
+		return nil, ports.NewForbiddenError("only conversation admins can remove other members", nil)
+	}
+	member := conv.Member(userID)
+	if member == nil {
+		return nil, ports.NewNotFoundError("member not found", nil)
+	}
+	if member.Role == domain.MemberRoleAdmin && len(conv.Members) > 1 && countAdmins(conv) == 1 {
+		return nil, ports.NewInvalidInputError("cannot remove the last admin", nil)
+	}
+
+	if err := s.repo.RemoveConversationMember(ctx, conv.ID, userID); err != nil {
+		return nil, ports.NewInternalError("failed to remove member", err)
+	}
+	remaining := conv.Members[:0]
+	for _, m := range conv.Members {
+		if m.UserID != userID {
+			remaining = append(remaining, m)
+		}
+	}
+	conv.Members = remaining
+	// 被移除的用户也需要收到通知
+	s.emitConversation(ctx, conv, conversationMemberRemoved, userID)
+	return conv, nil
+}
+
+func (s *MailService) SetConversationMemberRole(ctx context.Context, sessionID, actorID, conversationID, userID, role string) (*domain.Conversation, error) {
+	if role != domain.MemberRoleAdmin && role != domain.MemberRoleMember {
+		return nil, ports.NewInvalidInputError("invalid role", nil)
+	}
+	if err := s.requireOpen(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	conv, err := s.conversationFor(ctx, sessionID, actorID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conv.Type == domain.ConversationDirect {
+		return nil, ports.NewInvalidInputError("direct conversations have no roles", nil)
+	}
+	if !conv.IsAdmin(actorID) {
+		return nil, ports.NewForbiddenError("only conversation admins can change roles", nil)
+	}
+
+	member := conv.Member(userID)
+	if member == nil {
+		if conv.Type != domain.ConversationGroup {
+			// 尚未登记的频道用户
+			conv.Members = append(conv.Members, domain.ConversationMember{
+				ConversationID: conv.ID, UserID: userID, SessionID: sessionID, JoinedAt: s.clock.Now(ctx, sessionID),
+			})
+			member = &conv.Members[len(conv.Members)-1]
+		} else {
+			return nil, ports.NewNotFoundError("member not found", nil)
+		}
+	}
+	if member.Role == domain.MemberRoleAdmin && role != domain.MemberRoleAdmin && countAdmins(conv) == 1 {
+		return nil, ports.NewInvalidInputError("cannot demote the last admin", nil)
+	}
+	if member.Role == role {
+		return conv, nil
+	}
+
+	member.Role = role
+	if err := s.repo.UpdateConversationMember(ctx, member); err != nil {
+		return nil, ports.NewInternalError("failed to update member", err)
+	}
+	s.emitConversation(ctx, conv, conversationRoleChanged)
+	return conv, nil
+}
+
+func (s *MailService) GetConversationHistory(ctx context.Context, sessionID, userID, conversationID string, page ports.ChatHistoryPage) ([]domain.ChatMessage, error) {
+	page, err := chatHistoryPage(page)
+	if err != nil {
+		return nil, err
+	}
+	conv, err := s.conversationFor(ctx, sessionID, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []domain.ChatMessage
+	if conv.Type == domain.ConversationDirect {
+		// 单聊按双方查询，包含建立会话前的消息
+		msgs, err = s.repo.GetChatHistory(ctx, sessionID, userID, conv.Peer(userID), page)
+	} else {
+		msgs, err = s.repo.GetConversationHistory(ctx, sessionID, conv.ID, page)
+	}
+	if err != nil {
+		return nil, chatHistoryError(err)
+	}
+	return msgs, s.attachReplies(ctx, sessionID, msgs)
+}
+
+// chatHistoryPage 校验游标并规范每页条数
+func chatHistoryPage(page ports.ChatHistoryPage) (ports.ChatHistoryPage, error) {
+	if page.Before != "" && page.After != "" {
+		return page, ports.NewInvalidInputError("before and after cannot be combined", nil)
+	}
+	if page.Limit <= 0 {
+		page.Limit = chatHistoryLimit
+	} else if page.Limit > maxChatHistoryLimit {
+		page.Limit = maxChatHistoryLimit
+	}
+	return page, nil
+}
+
+func chatHistoryError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ports.NewInvalidInputError("cursor message not found in this chat", err)
+	}
+	return ports.NewInternalError("failed to load chat history", err)
+}
+
+// MarkConversationRead 单聊沿用逐条已读标记，群聊与频道移动成员的阅读位置并通知被阅读消息的发送方
+func (s *MailService) MarkConversationRead(ctx context.Context, sessionID, userID, conversationID string) error {
+	conv, err := s.conversationFor(ctx, sessionID, userID, conversationID)
+	if err != nil {
+		return err
+	}
+	if conv.Type == domain.ConversationDirect {
+		return s.MarkChatAsRead(ctx, sessionID, conv.Peer(userID), userID)
+	}
+
+	readAt := s.clock.Now(ctx, sessionID)
+	msgs, err := s.repo.MarkConversationRead(ctx, sessionID, conv.ID, userID, readAt)
+	if err != nil {
+		return ports.NewInternalError("failed to mark conversation as read", err)
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(msgs))
+	var senders []string
+	seen := make(map[string]bool)
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+		if !seen[m.SenderID] {
+			seen[m.SenderID] = true
+			senders = append(senders, m.SenderID)
+		}
+	}
+	s.broadcast(ctx, map[string]interface{}{
+		"type":       domain.WebhookEventChatRead,
+		"session_id": sessionID,
+		"targets":    senders,
+		"data":       map[string]interface{}{"conversation_id": conv.ID, "reader_id": userID, "message_ids": ids, "read_at": readAt},
+	})
+	return nil
+}
+
+// chatRoute 确定消息所属会话、单聊接收方及推送对象 (nil 表示推送给全场次)
+func (s *MailService) chatRoute(ctx context.Context, senderID string, req ports.SendChatMessageRequest) (*domain.Conversation, string, []string, error) {
+	if req.ConversationID == "" {
+		if req.ReceiverID == "" || req.ReceiverID == senderID {
+			return nil, "", nil, ports.NewInvalidInputError("invalid receiver", nil)
+		}
+		conv, err := s.directConversation(ctx, req.SessionID, senderID, req.ReceiverID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return conv, req.ReceiverID, []string{req.ReceiverID}, nil
+	}
+
+	conv, err := s.conversationFor(ctx, req.SessionID, senderID, req.ConversationID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	switch conv.Type {
+	case domain.ConversationDirect:
+		peer := conv.Peer(senderID)
+		return conv, peer, []string{peer}, nil
+	case domain.ConversationChannel:
+		if conv.Member(senderID) == nil {
+			err := s.repo.AddConversationMembers(ctx, []domain.ConversationMember{{
+				ConversationID: conv.ID, UserID: senderID, SessionID: req.SessionID, Role: domain.MemberRoleMember, JoinedAt: s.clock.Now(ctx, req.SessionID),
+			}})
+			if err != nil {
+				return nil, "", nil, ports.NewInternalError("failed to join channel", err)
+			}
+		}
+		return conv, "", nil, nil
+	}
+	return conv, "", conv.MemberIDs(senderID), nil
+}
+
+// directConversation 查找或建立两名用户的单聊。单聊在场次内唯一，并发建立冲突时取已建立的一条
+func (s *MailService) directConversation(ctx context.Context, sessionID, userA, userB string) (*domain.Conversation, error) {
+	key := domain.DirectKey(userA, userB)
+	conv, err := s.repo.FindDirectConversation(ctx, sessionID, key)
+	if err == nil {
+		return conv, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ports.NewInternalError("failed to load conversation", err)
+	}
+
+	now := s.clock.Now(ctx, sessionID)
+	conv = &domain.Conversation{
+		SessionID:     sessionID,
+		Type:          domain.ConversationDirect,
+		DirectKey:     key,
+		CreatedBy:     userA,
+		CreatedAt:     now,
+		RealCreatedAt: time.Now(),
+		Members: []domain.ConversationMember{
+			{UserID: userA, SessionID: sessionID, Role: domain.MemberRoleMember, JoinedAt: now},
+			{UserID: userB, SessionID: sessionID, Role: domain.MemberRoleMember, JoinedAt: now},
+		},
+	}
+	if err := s.repo.CreateConversation(ctx, conv); err != nil {
+		if existing, findErr := s.repo.FindDirectConversation(ctx, sessionID, key); findErr == nil {
+			return existing, nil
+		}
+		return nil, ports.NewInternalError("failed to create conversation", err)
+	}
+	return conv, nil
+}
+
+// conversationFor 加载会话并校验用户可以访问。频道对场次内全部用户开放
+func (s *MailService) conversationFor(ctx context.Context, sessionID, userID, conversationID string) (*domain.Conversation, error) {
+	conv, err := s.repo.GetConversation(ctx, sessionID, conversationID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ports.NewNotFoundError("conversation not found", err)
+		}
+		return nil, ports.NewInternalError("failed to load conversation", err)
+	}
+	if conv.Type != domain.ConversationChannel && conv.Member(userID) == nil {
+		return nil, ports.NewForbiddenError("not a member of this conversation", nil)
+	}
+	return conv, nil
+}
+
+// emitConversation 推送会话变更，频道推送给全场次，其余推送给成员及 extra
+func (s *MailService) emitConversation(ctx context.Context, conv *domain.Conversation, action string, extra ...string) {
+	payload := map[string]interface{}{
+		"type":       domain.WebhookEventConversation,
+		"session_id": conv.SessionID,
+		"data":       map[string]interface{}{"action": action, "conversation": conv},
+	}
+	if conv.Type != domain.ConversationChannel {
+		payload["targets"] = append(conv.MemberIDs(""), extra...)
+	}
+	s.broadcast(ctx, payload)
+}
+
+// requireOpen 会话可在场次开始前预先建立，场次结束后不再变更
+func (s *MailService) requireOpen(ctx context.Context, sessionID string) error {
+	session, err := s.sessions.GetByID(ctx, sessionID)
+	if err != nil {
+		return ports.NewNotFoundError("session not found", err)
+	}
+	if session.Status == domain.SessionStatusEnded || session.Status == domain.SessionStatusArchived {
+		return ports.NewForbiddenError("session is closed", nil)
+	}
+	return nil
+}
+
+// uniqueMembers 去重并排除空 ID 与 exclude
+func uniqueMembers(ids []string, exclude string) []string {
+	seen := make(map[string]bool, len(ids))
+	var out []string
+	for _, id := range ids {
+		if id == "" || id == exclude || seen[id] {
+			continue
+		}
+		seen[id] = true
+		out = append(out, id)
+	}
+	return out
+}
+
+func countAdmins(conv *domain.Conversation) int {
+	n := 0
+	for _, m := range conv.Members {
+		if m.Role == domain.MemberRoleAdmin {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+)
+
+const (
+	// busIngressSize 进程内总线的待分发队列长度，Publish 只在其写满时等待
+	busIngressSize = 1024
+	// DefaultBusPollInterval 共享总线轮询其他实例事件的间隔
+	DefaultBusPollInterval = 500 * time.Millisecond
+	sharedBusBatchSize     = 500
+	// sharedBusGapGrace ID 出现空洞时等待迟到事件提交的时间，超时后跳过 (空洞可能来自回滚或淘汰)
+	sharedBusGapGrace = 2 * time.Second
+)
+
+var errBusClosed = errors.New("event bus is shut down")
+
+// MemoryEventBus 单实例的进程内总线: 事件写入日志后直接交给本进程的订阅者
+type MemoryEventBus struct {
+	events    ports.EventLog
+	in        chan domain.NotificationEvent
+	closeOnce sync.Once
+	closing   chan struct{}
+}
+
+func NewMemoryEventBus(events ports.EventLog) *MemoryEventBus {
+	return &MemoryEventBus{
+		events:  events,
+		in:      make(chan domain.NotificationEvent, busIngressSize),
+		closing: make(chan struct{}),
+	}
+}
+
+func (b *MemoryEventBus) Publish(ctx context.Context, event domain.NotificationEvent) error {
+	select {
+	case <-b.closing:
+		return errBusClosed
+	default:
+	}
+	select {
+	case b.in <- event:
+		return nil
+	case <-b.closing:
+		return errBusClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run ctx 取消后不再接收新事件，分发完已入队的事件后返回
+func (b *MemoryEventBus) Run(ctx context.Context, deliver func(domain.NotificationEvent)) error {
+	dispatch := func(ev domain.NotificationEvent) {
+		// 由单协程写日志，保证事件 ID 与投递顺序一致
+		if err := b.events.Append(context.Background(), &ev); err != nil {
+			log.Printf("[Notify] Failed to log event: %v", err)
+		}
+		deliver(ev)
+	}
+	for {
+		select {
+		case ev := <-b.in:
+			dispatch(ev)
+		case <-ctx.Done():
+			b.closeOnce.Do(func() { close(b.closing) })
+			for {
+				select {
+				case ev := <-b.in:
+					dispatch(ev)
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+func (b *MemoryEventBus) ListSince(ctx context.Context, sessionID string, afterID uint64) ([]domain.NotificationEvent, error) {
+	return b.events.ListSince(ctx, sessionID, afterID)
+}
+
+func (b *MemoryEventBus) LastID(ctx context.Context) (uint64, error) {
+	return b.events.LastID(ctx)
+}
+
+// SharedEventBus 多实例总线: 事件写入共享表，各实例轮询表中新增的事件分发给本进程的订阅者。
+// 本实例发布时立即唤醒轮询，其他实例的事件最迟在一个轮询间隔后送达
+type SharedEventBus struct {
+	events   ports.SharedEventLog
+	interval time.Duration
+	wake     chan struct{}
+	start    uint64 // 创建时的最新事件 ID，只分发此后发布的事件
+}
+
+func NewSharedEventBus(ctx context.Context, events ports.SharedEventLog, interval time.Duration) (*SharedEventBus, error) {
+	if interval <= 0 {
+		interval = DefaultBusPollInterval
+	}
+	start, err := events.LastID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &SharedEventBus{events: events, interval: interval, wake: make(chan struct{}, 1), start: start}, nil
+}
+
+func (b *SharedEventBus) Publish(ctx context.Context, event domain.NotificationEvent) error {
+	if err := b.events.Append(ctx, &event); err != nil {
+		return err
+	}
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run 从总线创建时的最新事件之后开始分发，ctx 取消时再轮询一次后返回
+func (b *SharedEventBus) Run(ctx context.Context, deliver func(domain.NotificationEvent)) error {
+	last := b.start
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			b.poll(context.Background(), &last, deliver)
+			return nil
+		case <-ticker.C:
+		case <-b.wake:
+		}
+		b.poll(ctx, &last, deliver)
+	}
+}
+
+func (b *SharedEventBus) poll(ctx context.Context, last *uint64, deliver func(domain.NotificationEvent)) {
+	for {
+		events, err := b.events.ListAfter(ctx, *last, sharedBusBatchSize)
+		if err != nil {
+			log.Printf("[Notify] Failed to poll shared events: %v", err)
+			return
+		}
+		for _, ev := range events {
+			if ev.ID != *last+1 && time.Since(ev.CreatedAt) < sharedBusGapGrace {
+				// 前面的事件可能尚未提交，下一轮再读
+				return
+			}
+			deliver(ev)
+			*last = ev.ID
+		}
+		if len(events) < sharedBusBatchSize {
+			return
+		}
+	}
+}
+
+func (b *SharedEventBus) ListSince(ctx context.Context, sessionID string, afterID uint64) ([]domain.NotificationEvent, error) {
+	return b.events.ListSince(ctx, sessionID, afterID)
+}
+
+func (b *SharedEventBus) LastID(ctx context.Context) (uint64, error) {
+	return b.events.LastID(ctx)
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"raven/internal/core/domain"
+)
+
+// DefaultEventLogSize 每个场次保留的推送事件数
+const DefaultEventLogSize = 256
+
+// MemoryEventLog 进程内的事件日志，每个场次保留最近 limit 条，重启后清空。
+// 事件 ID 以创建时的 Unix 微秒数为起点递增，重启后新事件的 ID 仍大于重启前发出的 ID，
+// 客户端携带的旧 Last-Event-ID 不会吞掉新事件。
+// 同时实现 SharedEventLog，供同一进程内的多个总线共享 (测试用)
+type MemoryEventLog struct {
+	mu     sync.Mutex
+	limit  int
+	lastID uint64
+	events map[string][]domain.NotificationEvent
+}
+
+func NewMemoryEventLog(limit int) *MemoryEventLog {
+	if limit <= 0 {
+		limit = DefaultEventLogSize
+	}
+	return &MemoryEventLog{
+		limit:  limit,
+		lastID: uint64(time.Now().UnixMicro()),
+		events: make(map[string][]domain.NotificationEvent),
+	}
+}
+
+func (l *MemoryEventLog) Append(ctx context.Context, event *domain.NotificationEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastID++
+	event.ID = l.lastID
+	event.CreatedAt = time.Now()
+
+	events := append(l.events[event.SessionID], *event)
+	if len(events) > l.limit {
+		events = events[len(events)-l.limit:]
+	}
+	l.events[event.SessionID] = events
+	return nil
+}
+
+func (l *MemoryEventLog) ListSince(ctx context.Context, sessionID string, afterID uint64) ([]domain.NotificationEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := l.events[sessionID]
+	i := sort.Search(len(events), func(i int) bool { return events[i].ID > afterID })
+	return append([]domain.NotificationEvent(nil), events[i:]...), nil
+}
+
+func (l *MemoryEventLog) ListAfter(ctx context.Context, afterID uint64, limit int) ([]domain.NotificationEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var result []domain.NotificationEvent
+	for _, events := range l.events {
+		i := sort.Search(len(events), func(i int) bool { return events[i].ID > afterID })
+		result = append(result, events[i:]...)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (l *MemoryEventLog) LastID(ctx context.Context) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastID, nil
+}
@@ -3,75 +3,119 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"os"
 	"path/filepath"
 	"raven/internal/core/domain"
 	"raven/internal/core/ports"
-	"sync"
 	"time"
+
+	"gorm.io/gorm"
 )
 
+// dataDir 在线文档 (ONLYOFFICE) 的场次隔离存储根目录
+const dataDir = "./data"
+
 type MailService struct {
-	repo    ports.MailRepository
-	storage ports.StorageService
-	// Simple Notification Hub
-	mu      sync.RWMutex
-	clients map[chan string]bool
-	msgChan chan string
+	repo       ports.MailRepository
+	sessions   ports.SessionRepository
+	clock      ports.SessionClock
+	storage    ports.StorageService
+	webhooks   ports.WebhookPublisher
+	hub        *notificationHub
+	typing     *typingTracker
+	editWindow time.Duration // 消息可编辑、撤回的时长
 }
 
-func NewMailService(repo ports.MailRepository, storage ports.StorageService) *MailService {
+func NewMailService(repo ports.MailRepository, sessions ports.SessionRepository, clock ports.SessionClock, storage ports.StorageService) *MailService {
 	s := &MailService{
-		repo:    repo,
-		storage: storage,
-		clients: make(map[chan string]bool),
-		msgChan: make(chan string),
+		repo:       repo,
+		sessions:   sessions,
+		clock:      clock,
+		storage:    storage,
+		hub:        newNotificationHub(NewMemoryEventBus(NewMemoryEventLog(DefaultEventLogSize))),
+		editWindow: DefaultChatEditWindow,
 	}
-	go s.runHub()
+	s.typing = newTypingTracker(typingThrottle, typingExpiry, s.emitTyping)
 	return s
 }
 
-func (s *MailService) runHub() {
-	for msg := range s.msgChan {
-		// Acquire read lock to copy current clients safely
-		s.mu.RLock()
-		targets := make([]chan string, 0, len(s.clients))
-		for client := range s.clients {
-			targets = append(targets, client)
-		}
-		s.mu.RUnlock()
-
-		// Send outside lock
-		for _, client := range targets {
-			// Non-blocking send in case client is slow (optional, but good for stability)
-			select {
-			case client <- msg:
-			default:
-				// If channel is full, we might drop the message or handle backlog.
-				// For now, dropping prevents hub blocking.
-			}
-		}
+// SetWebhooks 启用 webhook 投递，广播的事件会同时排入 webhook 队列。需在处理请求前调用
+func (s *MailService) SetWebhooks(webhooks ports.WebhookPublisher) {
+	s.webhooks = webhooks
+}
+
+// SetEventBus 替换默认的进程内总线 (如多实例共享的总线)。需在处理请求前调用
+func (s *MailService) SetEventBus(bus ports.EventBus) {
+	s.hub.start(bus)
+}
+
+// SetHubConfig 设置订阅者缓冲大小与溢出策略，对之后建立的订阅生效
+func (s *MailService) SetHubConfig(cfg ports.HubConfig) {
+	s.hub.configure(cfg)
+}
+
+// Shutdown 停止推送: 分发完已入队的事件后关闭全部订阅，使 SSE/WebSocket 连接结束
+func (s *MailService) Shutdown(ctx context.Context) error {
+	return s.hub.shutdown(ctx)
+}
+
+// Subscribe 以场次和用户身份订阅事件，只会收到该场次中发给该用户或面向全场次的事件
+func (s *MailService) Subscribe(sessionID, userID string) chan domain.NotificationEvent {
+	return s.hub.subscribe(sessionID, userID)
+}
+
+func (s *MailService) Unsubscribe(c chan domain.NotificationEvent) {
+	s.hub.unsubscribe(c)
+}
+
+// PublishEvent 推送自定义事件 (如 PRESENCE)，与文电、消息事件走同一通道
+func (s *MailService) PublishEvent(ctx context.Context, sessionID, event string, targets []string, data interface{}) {
+	payload := map[string]interface{}{
+		"type":       event,
+		"session_id": sessionID,
+		"data":       data,
+	}
+	if targets != nil {
+		payload["targets"] = targets
 	}
+	s.broadcast(ctx, payload)
 }
 
-func (s *MailService) Subscribe() chan string {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	c := make(chan string, 10) // Buffered channel to prevent immediate blocking
-	s.clients[c] = true
-	return c
+func (s *MailService) NotificationStats() ports.HubStats {
+	return s.hub.stats()
 }
 
-func (s *MailService) Unsubscribe(c chan string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, ok := s.clients[c]; ok {
-		delete(s.clients, c)
-		close(c)
+func (s *MailService) ReplayEvents(ctx context.Context, sessionID, userID string, afterID uint64) ([]domain.NotificationEvent, error) {
+	bus := s.hub.currentBus()
+	last, err := bus.LastID(ctx)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to load event log", err)
+	}
+	if afterID > last {
+		// 客户端的 ID 来自已不存在的日志 (如日志被清空)，保留的事件对它都是新的
+		afterID = 0
+	}
+	events, err := bus.ListSince(ctx, sessionID, afterID)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to load event log", err)
+	}
+	visible := events[:0]
+	for _, ev := range events {
+		if ev.VisibleTo(userID) {
+			visible = append(visible, ev)
+		}
 	}
+	return visible, nil
 }
 
 func (s *MailService) SendMail(ctx context.Context, senderID string, req ports.SendMailRequest) (*domain.Mail, error) {
+	session, err := s.requireRunning(ctx, req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Handle Attachments
 	var attachments []domain.Attachment
 	for _, attReq := range req.Attachments {
@@ -87,28 +131,48 @@ func (s *MailService) SendMail(ctx context.Context, senderID string, req ports.S
 			MimeType:  attReq.MimeType,
 		})
 	}
+	uploaded := attachments
+	for _, att := range req.SharedAttachments {
+		attachments = append(attachments, domain.Attachment{
+			SessionID: req.SessionID,
+			FileName:  att.FileName,
+			FilePath:  att.FilePath,
+			FileSize:  att.FileSize,
+			MimeType:  att.MimeType,
+		})
+	}
 
 	mail := &domain.Mail{
-		SessionID:   req.SessionID,
-		SenderID:    senderID,
-		Subject:     req.Subject,
-		Content:     req.Content,
-		ContentType: req.ContentType,
-		Attachments: attachments,
-		CreatedAt:   time.Now(),
+		SessionID:     req.SessionID,
+		SenderID:      senderID,
+		ParentID:      req.ParentID,
+		Subject:       req.Subject,
+		Content:       req.Content,
+		ContentType:   req.ContentType,
+		Attachments:   attachments,
+		CreatedAt:     s.clock.Now(ctx, req.SessionID),
+		RealCreatedAt: time.Now(),
 	}
 
 	// Recipients
 	var recipients []domain.MailRecipient
 
+	now := time.Now()
 	addRecipients := func(ids []string, rType string) {
 		for _, id := range ids {
-			recipients = append(recipients, domain.MailRecipient{
+			recipient := domain.MailRecipient{
 				SessionID:   req.SessionID,
 				RecipientID: id,
 				Type:        rType,
 				Status:      "unread",
-			})
+			}
+			// 映射了外部邮箱的收件人进入转发队列，由 RelayService 异步投递
+			if addr := session.RelayAddresses[id]; addr != "" {
+				recipient.RelayAddress = addr
+				recipient.RelayStatus = domain.RelayStatusPending
+				recipient.RelayNextAt = &now
+			}
+			recipients = append(recipients, recipient)
 		}
 	}
 
@@ -120,7 +184,7 @@ func (s *MailService) SendMail(ctx context.Context, senderID string, req ports.S
 
 	if err := s.repo.Create(ctx, mail); err != nil {
 		// Rollback: Delete uploaded files to prevent orphans
-		for _, att := range attachments {
+		for _, att := range uploaded {
 			_ = s.storage.DeleteFile(ctx, att.FilePath)
 		}
 		return nil, err
@@ -142,7 +206,7 @@ func (s *MailService) SendMail(ctx context.Context, senderID string, req ports.S
 			"sender_id": mail.SenderID,
 		},
 	}
-	s.broadcast(payload)
+	s.broadcast(ctx, payload)
 
 	return mail, nil
 }
@@ -164,7 +228,7 @@ func (s *MailService) ReadMail(ctx context.Context, sessionID, userID, mailID st
 	// 如果当前查看者是收件人之一，且状态还是 unread，则更新为 read
 	for _, r := range mail.Recipients {
 		if r.RecipientID == userID && r.Status == "unread" {
-			_ = s.repo.UpdateStatus(ctx, mailID, userID, "read")
+			s.updateReadStatus(ctx, mail, userID, true)
 			break
 		}
 	}
@@ -172,6 +236,45 @@ func (s *MailService) ReadMail(ctx context.Context, sessionID, userID, mailID st
 	return mail, nil
 }
 
+// SetMailRead 设置收件人的阅读状态 (供 IMAP 等外部客户端同步 \Seen 标记)，
+// 标记为已读时与 ReadMail 一样通知发件人
+func (s *MailService) SetMailRead(ctx context.Context, sessionID, userID, mailID string, read bool) error {
+	mail, err := s.repo.GetByID(ctx, sessionID, mailID)
+	if err != nil {
+		return err
+	}
+	for _, r := range mail.Recipients {
+		if r.RecipientID != userID || r.Status == "deleted" {
+			continue
+		}
+		if (r.Status == "read") == read {
+			return nil
+		}
+		return s.updateReadStatus(ctx, mail, userID, read)
+	}
+	return ports.NewNotFoundError("mail not found", nil)
+}
+
+// updateReadStatus 更新阅读状态，已读时向发件人推送 READ 事件
+func (s *MailService) updateReadStatus(ctx context.Context, mail *domain.Mail, userID string, read bool) error {
+	status := "unread"
+	if read {
+		status = "read"
+	}
+	if err := s.repo.UpdateStatus(ctx, mail.ID, userID, status, s.clock.Now(ctx, mail.SessionID)); err != nil {
+		return ports.NewInternalError("failed to update mail status", err)
+	}
+	if read {
+		s.broadcast(ctx, map[string]interface{}{
+			"type":       domain.WebhookEventRead,
+			"session_id": mail.SessionID,
+			"targets":    []string{mail.SenderID},
+			"data":       map[string]interface{}{"kind": "mail", "id": mail.ID, "reader_id": userID},
+		})
+	}
+	return nil
+}
+
 func (s *MailService) DeleteMail(ctx context.Context, sessionID, userID, mailID string) error {
 	mail, err := s.repo.GetByID(ctx, sessionID, mailID)
 	if err != nil {
@@ -180,11 +283,22 @@ func (s *MailService) DeleteMail(ctx context.Context, sessionID, userID, mailID
 
 	if mail.SenderID == userID {
 		// User is sender -> delete for sender
-		return s.repo.DeleteForSender(ctx, mailID)
+		err = s.repo.DeleteForSender(ctx, mailID, s.clock.Now(ctx, sessionID))
+	} else {
+		// User is recipient -> delete for recipient
+		err = s.repo.UpdateStatus(ctx, mailID, userID, "deleted", s.clock.Now(ctx, sessionID))
+	}
+	if err != nil {
+		return err
 	}
 
-	// User is recipient -> delete for recipient
-	return s.repo.UpdateStatus(ctx, mailID, userID, "deleted")
+	s.broadcast(ctx, map[string]interface{}{
+		"type":       domain.WebhookEventDelete,
+		"session_id": sessionID,
+		"targets":    []string{userID},
+		"data":       map[string]interface{}{"id": mailID, "user_id": userID},
+	})
+	return nil
 }
 
 func (s *MailService) DeleteSession(ctx context.Context, sessionID string) error {
@@ -192,6 +306,35 @@ func (s *MailService) DeleteSession(ctx context.Context, sessionID string) error
 		return nil
 	}
 
+	// 0. Delete snapshots of this session
+	snapshots, err := s.repo.ListSnapshots(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	for i := range snapshots {
+		if err := s.deleteSnapshot(ctx, &snapshots[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := s.deleteSessionData(ctx, sessionID); err != nil {
+		return err
+	}
+
+	// 4. Delete session record
+	if err := s.sessions.Delete(ctx, sessionID); err != nil {
+		return err
+	}
+	s.broadcast(ctx, map[string]interface{}{
+		"type":       domain.WebhookEventSessionDeleted,
+		"session_id": sessionID,
+		"data":       map[string]interface{}{"id": sessionID},
+	})
+	return nil
+}
+
+// deleteSessionData 删除场次的数据库记录及磁盘文件，不涉及快照
+func (s *MailService) deleteSessionData(ctx context.Context, sessionID string) error {
 	// 1. Delete DB records
 	if err := s.repo.DeleteSession(ctx, sessionID); err != nil {
 		return err
@@ -201,7 +344,7 @@ func (s *MailService) DeleteSession(ctx context.Context, sessionID string) error
 	_ = s.storage.DeleteSessionDir(ctx, sessionID)
 
 	// 3. Delete OnlyOffice docs (hardcoded path in handler, but we can handle it here too)
-	_ = os.RemoveAll(filepath.Join("./data", sessionID))
+	_ = os.RemoveAll(filepath.Join(dataDir, sessionID))
 
 	return nil
 }
@@ -211,6 +354,24 @@ func (s *MailService) GetAttachment(ctx context.Context, sessionID, attachmentID
 }
 
 func (s *MailService) SendChatMessage(ctx context.Context, senderID string, req ports.SendChatMessageRequest) (*domain.ChatMessage, error) {
+	session, err := s.requireRunning(ctx, req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	conv, receiverID, targets, err := s.chatRoute(ctx, senderID, req)
+	if err != nil {
+		return nil, err
+	}
+	var parent *domain.ChatMessage
+	if req.ReplyToID != "" {
+		if parent, err = s.replyTarget(ctx, conv, req.ReplyToID); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkMentions(session, conv, req.Content, req.Mentions); err != nil {
+		return nil, err
+	}
+
 	// Handle Attachments
 	var attachments []domain.Attachment
 	for _, attReq := range req.Attachments {
@@ -228,36 +389,94 @@ func (s *MailService) SendChatMessage(ctx context.Context, senderID string, req
 	}
 
 	msg := &domain.ChatMessage{
-		SessionID:   req.SessionID,
-		SenderID:    senderID,
-		ReceiverID:  req.ReceiverID,
-		Content:     req.Content,
-		Attachments: attachments,
-		CreatedAt:   time.Now(),
+		SessionID:      req.SessionID,
+		SenderID:       senderID,
+		ConversationID: conv.ID,
+		ReceiverID:     receiverID,
+		Content:        req.Content,
+		ReplyToID:      req.ReplyToID,
+		Mentions:       req.Mentions,
+		Attachments:    attachments,
+		CreatedAt:      s.clock.Now(ctx, req.SessionID),
+		RealCreatedAt:  time.Now(),
 	}
 
 	if err := s.repo.CreateChatMessage(ctx, msg); err != nil {
 		return nil, err
 	}
+	if parent != nil {
+		msg.ReplyTo = chatMessageRef(parent)
+	}
+
+	if receiverID != "" {
+		s.typing.clear(req.SessionID, senderID, receiverID)
+	}
 
 	// Broadcast
 	payload := map[string]interface{}{
 		"type":       "CHAT",
 		"session_id": req.SessionID,
-		"targets":    []string{req.ReceiverID},
 		"data":       msg,
 	}
-	s.broadcast(payload)
+	if targets != nil {
+		payload["targets"] = targets
+	}
+	s.broadcast(ctx, payload)
+	s.emitMentions(ctx, msg)
 
 	return msg, nil
 }
 
-func (s *MailService) GetChatHistory(ctx context.Context, sessionID, userA, userB string) ([]domain.ChatMessage, error) {
-	return s.repo.GetChatHistory(ctx, sessionID, userA, userB, 100)
+func (s *MailService) GetChatHistory(ctx context.Context, sessionID, userA, userB string, page ports.ChatHistoryPage) ([]domain.ChatMessage, error) {
+	page, err := chatHistoryPage(page)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := s.repo.GetChatHistory(ctx, sessionID, userA, userB, page)
+	if err != nil {
+		return nil, chatHistoryError(err)
+	}
+	return msgs, s.attachReplies(ctx, sessionID, msgs)
 }
 
 func (s *MailService) MarkChatAsRead(ctx context.Context, sessionID, senderID, receiverID string) error {
-	return s.repo.MarkChatAsRead(ctx, sessionID, senderID, receiverID)
+	readAt := s.clock.Now(ctx, sessionID)
+	ids, err := s.repo.MarkChatAsRead(ctx, sessionID, senderID, receiverID, readAt)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	// 通知原发送方哪些消息已被阅读
+	s.broadcast(ctx, map[string]interface{}{
+		"type":       domain.WebhookEventChatRead,
+		"session_id": sessionID,
+		"targets":    []string{senderID},
+		"data":       map[string]interface{}{"sender_id": senderID, "reader_id": receiverID, "message_ids": ids, "read_at": readAt},
+	})
+	return nil
+}
+
+func (s *MailService) NotifyTyping(ctx context.Context, sessionID, senderID, receiverID string, typing bool) error {
+	if receiverID == "" || receiverID == senderID {
+		return ports.NewInvalidInputError("invalid receiver", nil)
+	}
+	if _, err := s.requireRunning(ctx, sessionID); err != nil {
+		return err
+	}
+	s.typing.update(sessionID, senderID, receiverID, typing)
+	return nil
+}
+
+// emitTyping 只推送给会话对方。节流与过期由 typingTracker 处理，过期时不在请求上下文中
+func (s *MailService) emitTyping(sessionID, senderID, receiverID string, typing bool) {
+	s.broadcast(context.Background(), map[string]interface{}{
+		"type":       "TYPING",
+		"session_id": sessionID,
+		"targets":    []string{receiverID},
+		"data":       map[string]interface{}{"sender_id": senderID, "typing": typing},
+	})
 }
 
 func (s *MailService) GetUserSummary(ctx context.Context, sessionID, userID string) (*ports.UserSummary, error) {
@@ -271,14 +490,42 @@ func (s *MailService) GetUserSummary(ctx context.Context, sessionID, userID stri
 		return nil, err
 	}
 
+	convCounts, err := s.repo.GetConversationUnreadCounts(ctx, sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ports.UserSummary{
-		UnreadMailCount: mailCount,
-		IMUnreadCounts:  imCounts,
+		UnreadMailCount:          mailCount,
+		IMUnreadCounts:           imCounts,
+		ConversationUnreadCounts: convCounts,
 	}, nil
 }
 
+// chatPreviewLength 最近会话列表中消息预览的字数
+const chatPreviewLength = 60
+
+func (s *MailService) ListChatThreads(ctx context.Context, sessionID, userID string) ([]ports.ChatThread, error) {
+	threads, err := s.repo.ListChatThreads(ctx, sessionID, userID)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to list chat threads", err)
+	}
+	for i := range threads {
+		threads[i].LastMessage = truncate(threads[i].LastMessage, chatPreviewLength)
+	}
+	if threads == nil {
+		threads = []ports.ChatThread{}
+	}
+	return threads, nil
+}
+
 func (s *MailService) SyncSessions(ctx context.Context, activeSessionIDs []string) (int64, error) {
-	// 1. Get List of Orphans
+	// 1. 名单中的场次此后由宿主管理，未再出现时按孤儿清理
+	if err := s.sessions.MarkHostManaged(ctx, activeSessionIDs); err != nil {
+		return 0, err
+	}
+
+	// 2. Get List of Orphans
 	orphans, err := s.repo.GetOrphanSessionIDs(ctx, activeSessionIDs)
 	if err != nil {
 		return 0, err
@@ -288,7 +535,7 @@ func (s *MailService) SyncSessions(ctx context.Context, activeSessionIDs []strin
 		return 0, nil
 	}
 
-	// 2. Iterate and Delete (Reusing existing DeleteSession logic which handles BOTH DB and Files)
+	// 3. Iterate and Delete (Reusing existing DeleteSession logic which handles BOTH DB and Files)
 	var deletedCount int64
 	for _, id := range orphans {
 		// Log error but continue
@@ -300,10 +547,45 @@ func (s *MailService) SyncSessions(ctx context.Context, activeSessionIDs []strin
 	return deletedCount, nil
 }
 
-func (s *MailService) broadcast(payload interface{}) {
+// RequireRunningSession 场次不存在或未在进行中时返回错误，供网关在投递前预先校验
+func (s *MailService) RequireRunningSession(ctx context.Context, sessionID string) error {
+	_, err := s.requireRunning(ctx, sessionID)
+	return err
+}
+
+// requireRunning 仅允许向进行中的场次写入文电和消息
+func (s *MailService) requireRunning(ctx context.Context, sessionID string) (*domain.Session, error) {
+	session, err := s.sessions.GetByID(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ports.NewNotFoundError("session not found", err)
+		}
+		return nil, ports.NewInternalError("failed to load session", err)
+	}
+	if session.Status != domain.SessionStatusRunning {
+		return nil, ports.NewForbiddenError("session is not running", nil)
+	}
+	return session, nil
+}
+
+func (s *MailService) broadcast(ctx context.Context, payload map[string]interface{}) {
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return
 	}
-	s.msgChan <- string(data)
+	sessionID, _ := payload["session_id"].(string)
+	if s.webhooks != nil {
+		event, _ := payload["type"].(string)
+		if err := s.webhooks.Publish(ctx, event, sessionID, data); err != nil {
+			log.Printf("[Webhook] Failed to enqueue %s event: %v", event, err)
+		}
+	}
+	ev := domain.NotificationEvent{SessionID: sessionID, Data: string(data)}
+	if targets, ok := payload["targets"].([]string); ok {
+		// 指定了 targets (即使为空) 就只投递给这些用户
+		ev.Targets = append([]string{}, targets...)
+	}
+	if err := s.hub.publish(ctx, ev); err != nil {
+		log.Printf("[Notify] Dropped %s event: %v", payload["type"], err)
+	}
 }
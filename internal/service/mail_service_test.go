@@ -3,23 +3,30 @@ package service
 import (
 	"context"
 	"errors"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"raven/internal/core/domain"
 	"raven/internal/core/ports"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
 )
 
+// simTime 测试使用的演练时间 (想定 D+2 06:00)
+var simTime = time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC)
+
 func TestMailService_SendMail(t *testing.T) {
 	ctx := context.TODO()
 
 	t.Run("Success without attachments", func(t *testing.T) {
 		mockRepo := new(MockMailRepository)
 		mockStorage := new(MockStorageService)
-		svc := NewMailService(mockRepo, mockStorage)
+		svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
 
 		req := ports.SendMailRequest{
 			SessionID:   "session-1",
@@ -30,7 +37,8 @@ func TestMailService_SendMail(t *testing.T) {
 		}
 
 		mockRepo.On("Create", ctx, mock.MatchedBy(func(m *domain.Mail) bool {
-			return m.Subject == "Hello" && m.SessionID == "session-1" && len(m.Recipients) == 1
+			return m.Subject == "Hello" && m.SessionID == "session-1" && len(m.Recipients) == 1 &&
+				m.CreatedAt.Equal(simTime) && !m.RealCreatedAt.IsZero()
 		})).Return(nil)
 
 		mail, err := svc.SendMail(ctx, "user-1", req)
@@ -44,7 +52,7 @@ func TestMailService_SendMail(t *testing.T) {
 	t.Run("Success with attachments", func(t *testing.T) {
 		mockRepo := new(MockMailRepository)
 		mockStorage := new(MockStorageService)
-		svc := NewMailService(mockRepo, mockStorage)
+		svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
 
 		fileContent := strings.NewReader("fake content")
 		req := ports.SendMailRequest{
@@ -74,7 +82,7 @@ func TestMailService_SendMail(t *testing.T) {
 	t.Run("Rollback on DB Failure", func(t *testing.T) {
 		mockRepo := new(MockMailRepository)
 		mockStorage := new(MockStorageService)
-		svc := NewMailService(mockRepo, mockStorage)
+		svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
 
 		fileContent := strings.NewReader("fake content")
 		req := ports.SendMailRequest{
@@ -108,7 +116,7 @@ func TestMailService_SendMail(t *testing.T) {
 	t.Run("Upload Failure", func(t *testing.T) {
 		mockRepo := new(MockMailRepository)
 		mockStorage := new(MockStorageService)
-		svc := NewMailService(mockRepo, mockStorage)
+		svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
 
 		fileContent := strings.NewReader("fake")
 		req := ports.SendMailRequest{
@@ -140,7 +148,8 @@ func TestMailService_SyncSessions(t *testing.T) {
 	t.Run("Sync and Clean", func(t *testing.T) {
 		mockRepo := new(MockMailRepository)
 		mockStorage := new(MockStorageService)
-		svc := NewMailService(mockRepo, mockStorage)
+		mockSessions := newRunningSessions()
+		svc := NewMailService(mockRepo, mockSessions, fixedClock{simTime}, mockStorage)
 
 		activeIDs := []string{"active-1"}
 		orphans := []string{"orphan-1", "orphan-2"}
@@ -150,9 +159,11 @@ func TestMailService_SyncSessions(t *testing.T) {
 
 		// 2. Delete each orphan (Repo + Storage)
 		// Orphan-1
+		mockRepo.On("ListSnapshots", ctx, "orphan-1").Return([]domain.SessionSnapshot{}, nil)
 		mockRepo.On("DeleteSession", ctx, "orphan-1").Return(nil)
 		mockStorage.On("DeleteSessionDir", ctx, "orphan-1").Return(nil)
 		// Orphan-2
+		mockRepo.On("ListSnapshots", ctx, "orphan-2").Return([]domain.SessionSnapshot{}, nil)
 		mockRepo.On("DeleteSession", ctx, "orphan-2").Return(nil)
 		mockStorage.On("DeleteSessionDir", ctx, "orphan-2").Return(nil)
 
@@ -160,8 +171,911 @@ func TestMailService_SyncSessions(t *testing.T) {
 
 		assert.NoError(t, err)
 		assert.Equal(t, int64(2), count)
+		mockSessions.AssertCalled(t, "MarkHostManaged", ctx, activeIDs)
+
+		mockRepo.AssertExpectations(t)
+		mockStorage.AssertExpectations(t)
+	})
+}
+
+func TestMailService_CloneSession(t *testing.T) {
+	ctx := context.TODO()
 
+	t.Run("Clone into empty target", func(t *testing.T) {
+		mockRepo := new(MockMailRepository)
+		mockStorage := new(MockStorageService)
+		svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
+
+		mockRepo.On("SessionHasData", ctx, "team-b").Return(false, nil)
+		mockStorage.On("SessionDirExists", ctx, "team-b").Return(false, nil)
+		mockStorage.On("CopySessionDir", ctx, "baseline", "team-b").Return(nil)
+
+		var opts ports.CloneOptions
+		mockRepo.On("CloneSession", ctx, "baseline", "team-b", mock.AnythingOfType("ports.CloneOptions")).
+			Run(func(args mock.Arguments) { opts = args.Get(3).(ports.CloneOptions) }).
+			Return(nil)
+
+		err := svc.CloneSession(ctx, "baseline", ports.CloneSessionRequest{TargetSessionID: "team-b", ResetRead: true})
+
+		assert.NoError(t, err)
+		assert.True(t, opts.ResetRead)
+		assert.Equal(t, filepath.Join("team-b", "2025", "01", "02", "a.txt"), opts.MapFilePath(filepath.Join("baseline", "2025", "01", "02", "a.txt")))
 		mockRepo.AssertExpectations(t)
 		mockStorage.AssertExpectations(t)
 	})
+
+	t.Run("Reject non-empty target", func(t *testing.T) {
+		mockRepo := new(MockMailRepository)
+		mockStorage := new(MockStorageService)
+		svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
+
+		mockRepo.On("SessionHasData", ctx, "team-b").Return(true, nil)
+
+		err := svc.CloneSession(ctx, "baseline", ports.CloneSessionRequest{TargetSessionID: "team-b"})
+
+		assert.Error(t, err)
+		mockStorage.AssertNotCalled(t, "CopySessionDir")
+		mockRepo.AssertNotCalled(t, "CloneSession")
+	})
+
+	t.Run("Reject target with leftover files", func(t *testing.T) {
+		mockRepo := new(MockMailRepository)
+		mockStorage := new(MockStorageService)
+		svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
+
+		mockRepo.On("SessionHasData", ctx, "team-b").Return(false, nil)
+		mockStorage.On("SessionDirExists", ctx, "team-b").Return(true, nil)
+
+		err := svc.CloneSession(ctx, "baseline", ports.CloneSessionRequest{TargetSessionID: "team-b"})
+
+		assert.Error(t, err)
+		assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+		mockStorage.AssertNotCalled(t, "CopySessionDir")
+	})
+
+	t.Run("Reject unsafe target ID", func(t *testing.T) {
+		mockRepo := new(MockMailRepository)
+		mockStorage := new(MockStorageService)
+		svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
+
+		for _, target := range []string{"..", "../etc", "a/b", `a\b`, ".hidden", "team b"} {
+			err := svc.CloneSession(ctx, "baseline", ports.CloneSessionRequest{TargetSessionID: target})
+			assert.Error(t, err, target)
+			assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type, target)
+		}
+		mockRepo.AssertNotCalled(t, "SessionHasData")
+		mockStorage.AssertNotCalled(t, "CopySessionDir")
+	})
+
+	t.Run("Unknown source", func(t *testing.T) {
+		mockRepo := new(MockMailRepository)
+		mockSessions := new(MockSessionRepository)
+		mockStorage := new(MockStorageService)
+		svc := NewMailService(mockRepo, mockSessions, fixedClock{simTime}, mockStorage)
+
+		mockSessions.On("GetByID", ctx, "missing").Return(nil, gorm.ErrRecordNotFound)
+		mockRepo.On("SessionHasData", ctx, "missing").Return(false, nil)
+		mockStorage.On("SessionDirExists", ctx, "missing").Return(false, nil)
+
+		err := svc.CloneSession(ctx, "missing", ports.CloneSessionRequest{TargetSessionID: "team-b"})
+
+		assert.Error(t, err)
+		assert.Equal(t, ports.ErrorTypeNotFound, err.(*ports.AppError).Type)
+		mockStorage.AssertNotCalled(t, "CopySessionDir")
+	})
+}
+
+func TestMailService_RestoreSnapshot(t *testing.T) {
+	ctx := context.TODO()
+	snapshot := &domain.SessionSnapshot{ID: "snap-1", SessionID: "session-1", BackupSessionID: "snapshot-backup"}
+
+	t.Run("Swap in restored data", func(t *testing.T) {
+		mockRepo := new(MockMailRepository)
+		mockStorage := new(MockStorageService)
+		svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
+
+		var staging string
+		mockRepo.On("GetSnapshot", ctx, "session-1", "snap-1").Return(snapshot, nil)
+		mockStorage.On("CopySessionDir", ctx, "snapshot-backup", mock.AnythingOfType("string")).
+			Run(func(args mock.Arguments) { staging = args.String(2) }).Return(nil)
+		var opts ports.CloneOptions
+		var swaps [][2]string
+		mockStorage.On("ReplaceSessionDir", ctx, mock.AnythingOfType("string"), mock.AnythingOfType("string")).
+			Run(func(args mock.Arguments) { swaps = append(swaps, [2]string{args.String(1), args.String(2)}) }).Return(nil)
+		mockRepo.On("CloneSession", ctx, "snapshot-backup", "session-1", mock.AnythingOfType("ports.CloneOptions")).
+			Run(func(args mock.Arguments) {
+				opts = args.Get(3).(ports.CloneOptions)
+				// 文件在事务提交前已换入
+				assert.Len(t, swaps, 2)
+			}).Return(nil)
+		mockStorage.On("DeleteSessionDir", ctx, mock.AnythingOfType("string")).Return(nil)
+
+		err := svc.RestoreSnapshot(ctx, "session-1", "snap-1")
+
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(staging, restoreStagingPrefix))
+		assert.True(t, opts.Replace)
+		// 附件路径直接指向正式场次，而非临时目录
+		assert.Equal(t, filepath.Join("session-1", "a.txt"), opts.MapFilePath(filepath.Join("snapshot-backup", "a.txt")))
+		previous := swaps[0][1]
+		assert.Equal(t, [][2]string{{"session-1", previous}, {staging, "session-1"}}, swaps)
+		// 原目录在还原成功后删除
+		mockStorage.AssertCalled(t, "DeleteSessionDir", ctx, previous)
+		mockStorage.AssertNotCalled(t, "DeleteSessionDir", ctx, "session-1")
+		mockRepo.AssertNotCalled(t, "DeleteSession", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Swap files back when clone fails", func(t *testing.T) {
+		mockRepo := new(MockMailRepository)
+		mockStorage := new(MockStorageService)
+		svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
+
+		var staging string
+		mockRepo.On("GetSnapshot", ctx, "session-1", "snap-1").Return(snapshot, nil)
+		mockStorage.On("CopySessionDir", ctx, "snapshot-backup", mock.AnythingOfType("string")).
+			Run(func(args mock.Arguments) { staging = args.String(2) }).Return(nil)
+		var swaps [][2]string
+		mockStorage.On("ReplaceSessionDir", ctx, mock.AnythingOfType("string"), mock.AnythingOfType("string")).
+			Run(func(args mock.Arguments) { swaps = append(swaps, [2]string{args.String(1), args.String(2)}) }).Return(nil)
+		mockRepo.On("CloneSession", ctx, "snapshot-backup", "session-1", mock.AnythingOfType("ports.CloneOptions")).
+			Return(errors.New("disk full"))
+		mockStorage.On("DeleteSessionDir", ctx, mock.AnythingOfType("string")).Return(nil)
+
+		err := svc.RestoreSnapshot(ctx, "session-1", "snap-1")
+
+		assert.Error(t, err)
+		assert.NotContains(t, err.Error(), "partially")
+		previous := swaps[0][1]
+		assert.Equal(t, [][2]string{{"session-1", previous}, {staging, "session-1"}, {previous, "session-1"}}, swaps)
+		mockStorage.AssertCalled(t, "DeleteSessionDir", ctx, staging)
+		mockStorage.AssertNotCalled(t, "DeleteSessionDir", ctx, "session-1")
+		mockRepo.AssertNotCalled(t, "DeleteSession", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Report partial restore when files cannot be swapped back", func(t *testing.T) {
+		mockRepo := new(MockMailRepository)
+		mockStorage := new(MockStorageService)
+		svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
+
+		mockRepo.On("GetSnapshot", ctx, "session-1", "snap-1").Return(snapshot, nil)
+		mockStorage.On("CopySessionDir", ctx, "snapshot-backup", mock.AnythingOfType("string")).Return(nil)
+		mockStorage.On("ReplaceSessionDir", ctx, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil).Twice()
+		mockStorage.On("ReplaceSessionDir", ctx, mock.AnythingOfType("string"), "session-1").Return(errors.New("permission denied")).Once()
+		mockRepo.On("CloneSession", ctx, "snapshot-backup", "session-1", mock.AnythingOfType("ports.CloneOptions")).
+			Return(errors.New("disk full"))
+		mockStorage.On("DeleteSessionDir", ctx, mock.AnythingOfType("string")).Return(nil)
+
+		err := svc.RestoreSnapshot(ctx, "session-1", "snap-1")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "partially restored")
+	})
+
+	t.Run("Keep current data when files cannot be swapped in", func(t *testing.T) {
+		mockRepo := new(MockMailRepository)
+		mockStorage := new(MockStorageService)
+		svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
+
+		var staging string
+		mockRepo.On("GetSnapshot", ctx, "session-1", "snap-1").Return(snapshot, nil)
+		mockStorage.On("CopySessionDir", ctx, "snapshot-backup", mock.AnythingOfType("string")).
+			Run(func(args mock.Arguments) { staging = args.String(2) }).Return(nil)
+		var swaps [][2]string
+		mockStorage.On("ReplaceSessionDir", ctx, "session-1", mock.AnythingOfType("string")).
+			Run(func(args mock.Arguments) { swaps = append(swaps, [2]string{args.String(1), args.String(2)}) }).Return(nil).Once()
+		mockStorage.On("ReplaceSessionDir", ctx, mock.AnythingOfType("string"), "session-1").
+			Run(func(args mock.Arguments) { swaps = append(swaps, [2]string{args.String(1), args.String(2)}) }).Return(errors.New("busy")).Once()
+		mockStorage.On("ReplaceSessionDir", ctx, mock.AnythingOfType("string"), "session-1").
+			Run(func(args mock.Arguments) { swaps = append(swaps, [2]string{args.String(1), args.String(2)}) }).Return(nil).Once()
+		mockStorage.On("DeleteSessionDir", ctx, mock.AnythingOfType("string")).Return(nil)
+
+		err := svc.RestoreSnapshot(ctx, "session-1", "snap-1")
+
+		assert.Error(t, err)
+		assert.NotContains(t, err.Error(), "partially")
+		previous := swaps[0][1]
+		assert.Equal(t, [][2]string{{"session-1", previous}, {staging, "session-1"}, {previous, "session-1"}}, swaps)
+		mockRepo.AssertNotCalled(t, "CloneSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestMailService_RequireRunningSession(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("Reject paused session", func(t *testing.T) {
+		mockRepo := new(MockMailRepository)
+		mockSessions := new(MockSessionRepository)
+		svc := NewMailService(mockRepo, mockSessions, fixedClock{simTime}, new(MockStorageService))
+
+		mockSessions.On("GetByID", ctx, "session-1").Return(&domain.Session{ID: "session-1", Status: domain.SessionStatusPaused}, nil)
+
+		_, err := svc.SendMail(ctx, "user-1", ports.SendMailRequest{SessionID: "session-1", To: []string{"user-2"}})
+		assert.Error(t, err)
+		assert.Equal(t, ports.ErrorTypeForbidden, err.(*ports.AppError).Type)
+
+		_, err = svc.SendChatMessage(ctx, "user-1", ports.SendChatMessageRequest{SessionID: "session-1", ReceiverID: "user-2"})
+		assert.Error(t, err)
+
+		mockRepo.AssertNotCalled(t, "Create")
+		mockRepo.AssertNotCalled(t, "CreateChatMessage")
+	})
+
+	t.Run("Reject unknown session", func(t *testing.T) {
+		mockRepo := new(MockMailRepository)
+		mockSessions := new(MockSessionRepository)
+		svc := NewMailService(mockRepo, mockSessions, fixedClock{simTime}, new(MockStorageService))
+
+		mockSessions.On("GetByID", ctx, "ghost").Return(nil, gorm.ErrRecordNotFound)
+
+		_, err := svc.SendMail(ctx, "user-1", ports.SendMailRequest{SessionID: "ghost", To: []string{"user-2"}})
+		assert.Error(t, err)
+		assert.Equal(t, ports.ErrorTypeNotFound, err.(*ports.AppError).Type)
+	})
+
+	t.Run("Database failure is an internal error", func(t *testing.T) {
+		mockRepo := new(MockMailRepository)
+		mockSessions := new(MockSessionRepository)
+		svc := NewMailService(mockRepo, mockSessions, fixedClock{simTime}, new(MockStorageService))
+
+		mockSessions.On("GetByID", ctx, "session-1").Return(nil, errors.New("database is locked"))
+
+		err := svc.RequireRunningSession(ctx, "session-1")
+		assert.Error(t, err)
+		assert.Equal(t, ports.ErrorTypeInternal, err.(*ports.AppError).Type)
+	})
+}
+
+func TestMailService_SetMailRead(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+	events := svc.Subscribe("session-1", "user-1")
+	defer svc.Unsubscribe(events)
+
+	mockRepo.On("GetByID", ctx, "session-1", "m1").Return(&domain.Mail{
+		ID: "m1", SessionID: "session-1", SenderID: "user-1",
+		Recipients: []domain.MailRecipient{{RecipientID: "user-2", Status: "unread"}, {RecipientID: "user-3", Status: "read"}},
+	}, nil)
+	mockRepo.On("UpdateStatus", ctx, "m1", "user-2", "read", simTime).Return(nil).Once()
+	mockRepo.On("UpdateStatus", ctx, "m1", "user-3", "unread", simTime).Return(nil).Once()
+
+	assert.NoError(t, svc.SetMailRead(ctx, "session-1", "user-2", "m1", true))
+	// 状态未变化时不更新
+	assert.NoError(t, svc.SetMailRead(ctx, "session-1", "user-3", "m1", true))
+	// 标记未读不通知发件人
+	assert.NoError(t, svc.SetMailRead(ctx, "session-1", "user-3", "m1", false))
+	err := svc.SetMailRead(ctx, "session-1", "user-4", "m1", true)
+	assert.Equal(t, ports.ErrorTypeNotFound, err.(*ports.AppError).Type)
+	svc.PublishEvent(ctx, "session-1", "TEST", nil, nil)
+
+	assert.JSONEq(t, `{"type":"READ","session_id":"session-1","targets":["user-1"],"data":{"kind":"mail","id":"m1","reader_id":"user-2"}}`, (<-events).Data)
+	assert.Contains(t, (<-events).Data, `"TEST"`)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMailService_NotifyTyping(t *testing.T) {
+	ctx := context.TODO()
+	svc := NewMailService(new(MockMailRepository), newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+	events := svc.Subscribe("session-1", "user-2")
+	defer svc.Unsubscribe(events)
+
+	err := svc.NotifyTyping(ctx, "session-1", "user-1", "user-1", true)
+	assert.Error(t, err)
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+
+	assert.NoError(t, svc.NotifyTyping(ctx, "session-1", "user-1", "user-2", true))
+	assert.JSONEq(t, `{"type":"TYPING","session_id":"session-1","targets":["user-2"],"data":{"sender_id":"user-1","typing":true}}`, (<-events).Data)
+}
+
+func TestMailService_SubscribeRouting(t *testing.T) {
+	ctx := context.TODO()
+	svc := NewMailService(new(MockMailRepository), newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+
+	receiver := svc.Subscribe("session-1", "user-2")
+	defer svc.Unsubscribe(receiver)
+	bystander := svc.Subscribe("session-1", "user-3")
+	defer svc.Unsubscribe(bystander)
+	otherSession := svc.Subscribe("session-2", "user-2")
+	defer svc.Unsubscribe(otherSession)
+
+	// 定向事件只投递给目标用户
+	assert.NoError(t, svc.NotifyTyping(ctx, "session-1", "user-1", "user-2", true))
+	assert.Contains(t, (<-receiver).Data, `"TYPING"`)
+
+	// 无 targets 的场次事件投递给该场次全部订阅者
+	svc.broadcast(ctx, map[string]interface{}{"type": "SESSION_DELETED", "session_id": "session-1", "data": map[string]string{"id": "session-1"}})
+	assert.Contains(t, (<-receiver).Data, `"SESSION_DELETED"`)
+	assert.Contains(t, (<-bystander).Data, `"SESSION_DELETED"`)
+
+	// hub 顺序分发，bystander 与其他场次此前均未收到 TYPING
+	assert.Empty(t, bystander)
+	assert.Empty(t, otherSession)
+
+	// 退订后从索引中移除
+	svc.Unsubscribe(bystander)
+	svc.hub.mu.RLock()
+	assert.NotContains(t, svc.hub.index["session-1"], "user-3")
+	svc.hub.mu.RUnlock()
+}
+
+func TestMailService_ReplayEvents(t *testing.T) {
+	ctx := context.TODO()
+	svc := NewMailService(new(MockMailRepository), newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+	svc.SetEventBus(NewMemoryEventBus(NewMemoryEventLog(2)))
+	events := svc.Subscribe("session-1", "user-2")
+	defer svc.Unsubscribe(events)
+
+	for i, receiver := range []string{"user-2", "user-2", "user-3", "user-2"} {
+		svc.PublishEvent(ctx, "session-1", "TEST", []string{receiver}, i)
+	}
+	first := <-events
+	<-events
+	last := <-events
+	assert.Less(t, first.ID, last.ID)
+
+	// 日志每个场次只保留最近 2 条，其中 1 条发给 user-2
+	replay, err := svc.ReplayEvents(ctx, "session-1", "user-2", first.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, replay, 1) {
+		assert.Equal(t, last.ID, replay[0].ID)
+	}
+
+	replay, err = svc.ReplayEvents(ctx, "session-1", "user-2", last.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, replay)
+}
+
+func TestMailService_ReplayAfterRestart(t *testing.T) {
+	ctx := context.TODO()
+	start := func() *MailService {
+		svc := NewMailService(new(MockMailRepository), newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+		svc.SetEventBus(NewMemoryEventBus(NewMemoryEventLog(DefaultEventLogSize)))
+		return svc
+	}
+	publish := func(svc *MailService, n int) []domain.NotificationEvent {
+		events := svc.Subscribe("session-1", "user-2")
+		defer svc.Unsubscribe(events)
+		for i := 0; i < n; i++ {
+			svc.PublishEvent(ctx, "session-1", "TEST", []string{"user-2"}, i)
+		}
+		var got []domain.NotificationEvent
+		for i := 0; i < n; i++ {
+			got = append(got, <-events)
+		}
+		return got
+	}
+
+	before := start()
+	old := publish(before, 3)
+	assert.NoError(t, before.Shutdown(ctx))
+	time.Sleep(time.Millisecond)
+
+	// 重启后内存日志清空，新事件的 ID 仍大于客户端持有的 Last-Event-ID
+	after := start()
+	defer after.Shutdown(ctx)
+	fresh := publish(after, 2)
+	assert.Greater(t, fresh[0].ID, old[2].ID)
+
+	replay, err := after.ReplayEvents(ctx, "session-1", "user-2", old[2].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, fresh, replay)
+
+	// Last-Event-ID 大于日志中最大 ID (如日志被清空或时钟回拨) 时视为重置，重放全部保留的事件
+	replay, err = after.ReplayEvents(ctx, "session-1", "user-2", fresh[1].ID+1000)
+	assert.NoError(t, err)
+	assert.Equal(t, fresh, replay)
+}
+
+func TestMailService_DisconnectLaggingSubscriber(t *testing.T) {
+	ctx := context.TODO()
+	svc := NewMailService(new(MockMailRepository), newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+	svc.SetHubConfig(ports.HubConfig{QueueSize: 10, Overflow: ports.OverflowDisconnect})
+	events := svc.Subscribe("session-1", "user-2")
+	base, _ := svc.hub.currentBus().LastID(ctx)
+
+	// 缓冲 10 条，第 11 条溢出时断开订阅，由客户端凭 Last-Event-ID 重连补齐
+	for i := 0; i < 11; i++ {
+		svc.PublishEvent(ctx, "session-1", "TEST", []string{"user-2"}, i)
+	}
+	assert.Eventually(t, func() bool { return svc.NotificationStats().Published == 11 }, time.Second, time.Millisecond)
+	received := 0
+	for range events {
+		received++
+	}
+	assert.Equal(t, 10, received)
+
+	replay, err := svc.ReplayEvents(ctx, "session-1", "user-2", base+10)
+	assert.NoError(t, err)
+	assert.Len(t, replay, 1)
+	assert.Equal(t, uint64(1), svc.NotificationStats().Disconnected)
+}
+
+func TestMailService_DropOldestOverflow(t *testing.T) {
+	ctx := context.TODO()
+	svc := NewMailService(new(MockMailRepository), newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+	svc.SetHubConfig(ports.HubConfig{QueueSize: 2, Overflow: ports.OverflowDropOldest})
+	events := svc.Subscribe("session-1", "user-2")
+	defer svc.Unsubscribe(events)
+	base, _ := svc.hub.currentBus().LastID(ctx)
+
+	for i := 0; i < 5; i++ {
+		svc.PublishEvent(ctx, "session-1", "TEST", []string{"user-2"}, i)
+	}
+	assert.Eventually(t, func() bool { return svc.NotificationStats().Published == 5 }, time.Second, time.Millisecond)
+
+	// 只保留最新的 2 条
+	assert.Equal(t, base+4, (<-events).ID)
+	assert.Equal(t, base+5, (<-events).ID)
+	stats := svc.NotificationStats()
+	assert.Equal(t, uint64(3), stats.Dropped)
+	assert.Equal(t, 1, stats.Subscribers)
+}
+
+func TestMailService_ShutdownClosesSubscribers(t *testing.T) {
+	ctx := context.TODO()
+	svc := NewMailService(new(MockMailRepository), newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+	events := svc.Subscribe("session-1", "user-2")
+	assert.NoError(t, svc.NotifyTyping(ctx, "session-1", "user-1", "user-2", true))
+
+	assert.NoError(t, svc.Shutdown(ctx))
+	// 已入队的事件在关闭前送达
+	ev, ok := <-events
+	assert.True(t, ok)
+	assert.Contains(t, ev.Data, `"TYPING"`)
+	_, ok = <-events
+	assert.False(t, ok)
+
+	_, ok = <-svc.Subscribe("session-1", "user-2")
+	assert.False(t, ok)
+	// 关闭后广播不再阻塞请求
+	assert.NoError(t, svc.NotifyTyping(ctx, "session-1", "user-1", "user-2", false))
+}
+
+func TestMailService_SharedEventBus(t *testing.T) {
+	ctx := context.TODO()
+	// 两个实例共用同一份事件日志，模拟共享数据库表
+	shared := NewMemoryEventLog(DefaultEventLogSize)
+	base, _ := shared.LastID(ctx)
+	var instances []*MailService
+	for i := 0; i < 2; i++ {
+		bus, err := NewSharedEventBus(ctx, shared, 5*time.Millisecond)
+		assert.NoError(t, err)
+		svc := NewMailService(new(MockMailRepository), newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+		svc.SetEventBus(bus)
+		instances = append(instances, svc)
+	}
+	instanceA, instanceB := instances[0], instances[1]
+	defer instanceA.Shutdown(ctx)
+	defer instanceB.Shutdown(ctx)
+
+	onA := instanceA.Subscribe("session-1", "user-2")
+	onB := instanceB.Subscribe("session-1", "user-2")
+
+	assert.NoError(t, instanceB.NotifyTyping(ctx, "session-1", "user-1", "user-2", true))
+	for _, events := range []chan domain.NotificationEvent{onA, onB} {
+		select {
+		case ev := <-events:
+			assert.Equal(t, base+1, ev.ID)
+			assert.Contains(t, ev.Data, `"TYPING"`)
+		case <-time.After(time.Second):
+			t.Fatal("event not delivered")
+		}
+	}
+
+	replay, err := instanceA.ReplayEvents(ctx, "session-1", "user-2", 0)
+	assert.NoError(t, err)
+	assert.Len(t, replay, 1)
+}
+
+func TestTypingTracker_ThrottleAndExpiry(t *testing.T) {
+	var mu sync.Mutex
+	var emitted []bool
+	tracker := newTypingTracker(time.Hour, 30*time.Millisecond, func(sessionID, senderID, receiverID string, typing bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		emitted = append(emitted, typing)
+	})
+	snapshot := func() []bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]bool(nil), emitted...)
+	}
+
+	// 节流间隔内重复上报只转发一次
+	tracker.update("session-1", "user-1", "user-2", true)
+	tracker.update("session-1", "user-1", "user-2", true)
+	assert.Equal(t, []bool{true}, snapshot())
+
+	// 未再上报则自动推送停止输入
+	assert.Eventually(t, func() bool { return len(snapshot()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []bool{true, false}, snapshot())
+
+	// 未处于输入状态时的停止上报不转发；发出消息后静默结束
+	tracker.update("session-1", "user-1", "user-2", false)
+	tracker.update("session-1", "user-1", "user-2", true)
+	tracker.clear("session-1", "user-1", "user-2")
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, []bool{true, false, true}, snapshot())
+}
+
+func TestMailService_MarkChatAsRead(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+	events := svc.Subscribe("session-1", "user-1")
+	defer svc.Unsubscribe(events)
+
+	mockRepo.On("MarkChatAsRead", ctx, "session-1", "user-1", "user-2", simTime).Return([]string{"m1", "m2"}, nil).Once()
+	mockRepo.On("MarkChatAsRead", ctx, "session-1", "user-1", "user-2", simTime).Return([]string{}, nil).Once()
+
+	assert.NoError(t, svc.MarkChatAsRead(ctx, "session-1", "user-1", "user-2"))
+	// 没有新的已读消息时不推送
+	assert.NoError(t, svc.MarkChatAsRead(ctx, "session-1", "user-1", "user-2"))
+	svc.PublishEvent(ctx, "session-1", "TEST", nil, nil)
+
+	assert.JSONEq(t, `{"type":"CHAT_READ","session_id":"session-1","targets":["user-1"],"data":{"sender_id":"user-1","reader_id":"user-2","message_ids":["m1","m2"],"read_at":"`+simTime.Format(time.RFC3339Nano)+`"}}`, (<-events).Data)
+	assert.Contains(t, (<-events).Data, `"TEST"`)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMailService_GroupConversation(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+	member := svc.Subscribe("session-1", "user-2")
+	defer svc.Unsubscribe(member)
+	outsider := svc.Subscribe("session-1", "user-4")
+	defer svc.Unsubscribe(outsider)
+
+	mockRepo.On("CreateConversation", ctx, mock.AnythingOfType("*domain.Conversation")).Run(func(args mock.Arguments) {
+		args.Get(1).(*domain.Conversation).ID = "conv-1"
+	}).Return(nil).Once()
+	conv, err := svc.CreateConversation(ctx, "session-1", "user-1", ports.CreateConversationRequest{
+		Type: domain.ConversationGroup, Name: "火力组", Members: []string{"user-2", "user-3", "user-2", "user-1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user-1", "user-2", "user-3"}, conv.MemberIDs(""))
+	assert.True(t, conv.IsAdmin("user-1"))
+	assert.False(t, conv.IsAdmin("user-2"))
+	assert.Contains(t, (<-member).Data, `"CONVERSATION"`)
+
+	mockRepo.On("GetConversation", ctx, "session-1", "conv-1").Return(conv, nil)
+	mockRepo.On("CreateChatMessage", ctx, mock.AnythingOfType("*domain.ChatMessage")).Return(nil).Once()
+
+	// 非成员不能发言
+	_, err = svc.SendChatMessage(ctx, "user-4", ports.SendChatMessageRequest{SessionID: "session-1", ConversationID: "conv-1", Content: "hi"})
+	assert.Equal(t, ports.ErrorTypeForbidden, err.(*ports.AppError).Type)
+
+	msg, err := svc.SendChatMessage(ctx, "user-1", ports.SendChatMessageRequest{SessionID: "session-1", ConversationID: "conv-1", Content: "坐标 123456"})
+	assert.NoError(t, err)
+	assert.Equal(t, "conv-1", msg.ConversationID)
+	assert.Empty(t, msg.ReceiverID)
+	ev := <-member
+	assert.Equal(t, []string{"user-2", "user-3"}, ev.Targets)
+	assert.Contains(t, ev.Data, `"CHAT"`)
+	assert.Empty(t, outsider)
+
+	// 非管理员不能添加成员
+	_, err = svc.AddConversationMembers(ctx, "session-1", "user-2", "conv-1", []string{"user-4"})
+	assert.Equal(t, ports.ErrorTypeForbidden, err.(*ports.AppError).Type)
+	// 唯一的管理员不能退出
+	_, err = svc.RemoveConversationMember(ctx, "session-1", "user-1", "conv-1", "user-1")
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+
+	// 阅读后通知被阅读消息的发送方
+	sender := svc.Subscribe("session-1", "user-1")
+	defer svc.Unsubscribe(sender)
+	mockRepo.On("MarkConversationRead", ctx, "session-1", "conv-1", "user-2", simTime).Return([]domain.ChatMessage{*msg}, nil).Once()
+	assert.NoError(t, svc.MarkConversationRead(ctx, "session-1", "user-2", "conv-1"))
+	assert.JSONEq(t, `{"type":"CHAT_READ","session_id":"session-1","targets":["user-1"],"data":{"conversation_id":"conv-1","reader_id":"user-2","message_ids":["`+msg.ID+`"],"read_at":"`+simTime.Format(time.RFC3339Nano)+`"}}`, (<-sender).Data)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMailService_SendChatMessageUsesDirectConversation(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+
+	direct := &domain.Conversation{ID: "conv-d", SessionID: "session-1", Type: domain.ConversationDirect}
+	key := domain.DirectKey("user-2", "user-1")
+	mockRepo.On("FindDirectConversation", ctx, "session-1", key).Return(nil, gorm.ErrRecordNotFound).Once()
+	mockRepo.On("CreateConversation", ctx, mock.AnythingOfType("*domain.Conversation")).Run(func(args mock.Arguments) {
+		conv := args.Get(1).(*domain.Conversation)
+		assert.Equal(t, key, conv.DirectKey)
+		conv.ID = "conv-d"
+	}).Return(nil).Once()
+	mockRepo.On("FindDirectConversation", ctx, "session-1", key).Return(direct, nil).Once()
+	mockRepo.On("CreateChatMessage", ctx, mock.AnythingOfType("*domain.ChatMessage")).Return(nil).Twice()
+
+	first, err := svc.SendChatMessage(ctx, "user-1", ports.SendChatMessageRequest{SessionID: "session-1", ReceiverID: "user-2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "conv-d", first.ConversationID)
+	assert.Equal(t, "user-2", first.ReceiverID)
+
+	reply, err := svc.SendChatMessage(ctx, "user-2", ports.SendChatMessageRequest{SessionID: "session-1", ReceiverID: "user-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "conv-d", reply.ConversationID)
+
+	_, err = svc.SendChatMessage(ctx, "user-1", ports.SendChatMessageRequest{SessionID: "session-1", ReceiverID: "user-1"})
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMailService_DirectConversationCreateConflict(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+
+	// 另一请求抢先建立了单聊，唯一索引冲突后取已有的一条
+	existing := &domain.Conversation{ID: "conv-d", SessionID: "session-1", Type: domain.ConversationDirect}
+	key := domain.DirectKey("user-1", "user-2")
+	mockRepo.On("FindDirectConversation", ctx, "session-1", key).Return(nil, gorm.ErrRecordNotFound).Once()
+	mockRepo.On("CreateConversation", ctx, mock.AnythingOfType("*domain.Conversation")).Return(errors.New("UNIQUE constraint failed")).Once()
+	mockRepo.On("FindDirectConversation", ctx, "session-1", key).Return(existing, nil).Once()
+
+	conv, err := svc.directConversation(ctx, "session-1", "user-1", "user-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "conv-d", conv.ID)
+
+	// 建立失败且查不到已有单聊时返回错误
+	mockRepo.On("FindDirectConversation", ctx, "session-1", key).Return(nil, gorm.ErrRecordNotFound).Twice()
+	mockRepo.On("CreateConversation", ctx, mock.AnythingOfType("*domain.Conversation")).Return(errors.New("disk I/O error")).Once()
+	_, err = svc.directConversation(ctx, "session-1", "user-1", "user-2")
+	assert.Equal(t, ports.ErrorTypeInternal, err.(*ports.AppError).Type)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMailService_EditAndRetractChatMessage(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	mockStorage := new(MockStorageService)
+	svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, mockStorage)
+	events := svc.Subscribe("session-1", "user-2")
+	defer svc.Unsubscribe(events)
+
+	msg := &domain.ChatMessage{
+		ID: "m1", SessionID: "session-1", SenderID: "user-1", ReceiverID: "user-2", Content: "网格 1234",
+		RealCreatedAt: time.Now(), Attachments: []domain.Attachment{{ID: "a1", FilePath: "session-1/map.png"}},
+	}
+	mockRepo.On("GetChatMessage", ctx, "session-1", "m1").Return(msg, nil)
+
+	_, err := svc.EditChatMessage(ctx, "session-1", "user-2", "m1", "网格 1243", nil)
+	assert.Equal(t, ports.ErrorTypeForbidden, err.(*ports.AppError).Type)
+
+	mockRepo.On("EditChatMessage", ctx, msg, mock.MatchedBy(func(e *domain.ChatMessageEdit) bool {
+		return e.MessageID == "m1" && e.Content == "网格 1234" && e.EditedAt.Equal(simTime)
+	})).Return(nil).Once()
+	edited, err := svc.EditChatMessage(ctx, "session-1", "user-1", "m1", "网格 1243", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "网格 1243", edited.Content)
+	ev := <-events
+	assert.Equal(t, []string{"user-1", "user-2"}, ev.Targets)
+	assert.Contains(t, ev.Data, `"CHAT_EDITED"`)
+
+	mockRepo.On("RetractChatMessage", ctx, msg).Return(nil).Once()
+	mockRepo.On("AttachmentFileInUse", ctx, "session-1/map.png").Return(false, nil).Once()
+	mockStorage.On("DeleteFile", ctx, "session-1/map.png").Return(nil).Once()
+	retracted, err := svc.RetractChatMessage(ctx, "session-1", "user-1", "m1")
+	assert.NoError(t, err)
+	assert.Empty(t, retracted.Content)
+	assert.Empty(t, retracted.Attachments)
+	assert.Equal(t, simTime, *retracted.RetractedAt)
+	assert.Contains(t, (<-events).Data, `"CHAT_RETRACTED"`)
+
+	_, err = svc.EditChatMessage(ctx, "session-1", "user-1", "m1", "again", nil)
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+
+	// 超过编辑时限
+	old := &domain.ChatMessage{ID: "m0", SessionID: "session-1", SenderID: "user-1", ReceiverID: "user-2", Content: "x", RealCreatedAt: time.Now().Add(-time.Hour)}
+	mockRepo.On("GetChatMessage", ctx, "session-1", "m0").Return(old, nil)
+	_, err = svc.RetractChatMessage(ctx, "session-1", "user-1", "m0")
+	assert.Equal(t, ports.ErrorTypeForbidden, err.(*ports.AppError).Type)
+	svc.SetChatEditWindow(0)
+	mockRepo.On("EditChatMessage", ctx, old, mock.Anything).Return(nil).Once()
+	_, err = svc.EditChatMessage(ctx, "session-1", "user-1", "m0", "y", nil)
+	assert.NoError(t, err)
+
+	mockRepo.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestMailService_GetChatHistoryPaging(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, nil)
+
+	page := []domain.ChatMessage{{ID: "m3"}, {ID: "m2"}}
+	mockRepo.On("GetChatHistory", ctx, "session-1", "user-1", "user-2", ports.ChatHistoryPage{Limit: chatHistoryLimit}).Return(page, nil).Once()
+	msgs, err := svc.GetChatHistory(ctx, "session-1", "user-1", "user-2", ports.ChatHistoryPage{})
+	assert.NoError(t, err)
+	assert.Equal(t, page, msgs)
+
+	mockRepo.On("GetChatHistory", ctx, "session-1", "user-1", "user-2", ports.ChatHistoryPage{Before: "m2", Limit: maxChatHistoryLimit}).Return(page[:0], nil).Once()
+	_, err = svc.GetChatHistory(ctx, "session-1", "user-1", "user-2", ports.ChatHistoryPage{Before: "m2", Limit: 10000})
+	assert.NoError(t, err)
+
+	mockRepo.On("GetChatHistory", ctx, "session-1", "user-1", "user-2", ports.ChatHistoryPage{After: "other", Limit: 20}).Return([]domain.ChatMessage(nil), gorm.ErrRecordNotFound).Once()
+	_, err = svc.GetChatHistory(ctx, "session-1", "user-1", "user-2", ports.ChatHistoryPage{After: "other", Limit: 20})
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+
+	_, err = svc.GetChatHistory(ctx, "session-1", "user-1", "user-2", ports.ChatHistoryPage{Before: "m2", After: "m1"})
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMailService_ListChatThreads(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, nil)
+
+	long := strings.Repeat("态势", 40)
+	mockRepo.On("ListChatThreads", ctx, "session-1", "user-1").Return([]ports.ChatThread{
+		{Type: domain.ConversationDirect, PeerID: "user-2", LastMessage: long, UnreadCount: 3},
+	}, nil).Once()
+	threads, err := svc.ListChatThreads(ctx, "session-1", "user-1")
+	assert.NoError(t, err)
+	assert.Len(t, []rune(threads[0].LastMessage), chatPreviewLength+1)
+	assert.Equal(t, 3, threads[0].UnreadCount)
+
+	mockRepo.On("ListChatThreads", ctx, "session-1", "user-9").Return([]ports.ChatThread(nil), nil).Once()
+	threads, err = svc.ListChatThreads(ctx, "session-1", "user-9")
+	assert.NoError(t, err)
+	assert.NotNil(t, threads)
+}
+
+func TestMailService_ReplyAndMention(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+	mentioned := svc.Subscribe("session-1", "user-3")
+	defer svc.Unsubscribe(mentioned)
+
+	group := &domain.Conversation{ID: "conv-1", SessionID: "session-1", Type: domain.ConversationGroup, Members: []domain.ConversationMember{
+		{UserID: "user-1", Role: domain.MemberRoleAdmin}, {UserID: "user-2"}, {UserID: "user-3"},
+	}}
+	parent := &domain.ChatMessage{ID: "m1", SessionID: "session-1", SenderID: "user-2", ConversationID: "conv-1", Content: "请求火力支援", CreatedAt: simTime}
+	other := &domain.ChatMessage{ID: "m9", SessionID: "session-1", SenderID: "user-2", ConversationID: "conv-9"}
+	mockRepo.On("GetConversation", ctx, "session-1", "conv-1").Return(group, nil)
+	mockRepo.On("GetChatMessage", ctx, "session-1", "m1").Return(parent, nil)
+	mockRepo.On("GetChatMessage", ctx, "session-1", "m9").Return(other, nil)
+
+	send := func(req ports.SendChatMessageRequest) (*domain.ChatMessage, error) {
+		req.SessionID, req.ConversationID = "session-1", "conv-1"
+		return svc.SendChatMessage(ctx, "user-1", req)
+	}
+	_, err := send(ports.SendChatMessageRequest{Content: "收到", ReplyToID: "m9"})
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+	_, err = send(ports.SendChatMessageRequest{Content: "@外人", Mentions: []domain.ChatMention{{UserID: "user-4"}}})
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+	_, err = send(ports.SendChatMessageRequest{Content: "@张三", Mentions: []domain.ChatMention{{UserID: "user-3", Offset: 2, Length: 3}}})
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+
+	mockRepo.On("CreateChatMessage", ctx, mock.AnythingOfType("*domain.ChatMessage")).Run(func(args mock.Arguments) {
+		args.Get(1).(*domain.ChatMessage).ID = "m2"
+	}).Return(nil).Once()
+	msg, err := send(ports.SendChatMessageRequest{Content: "@张三 收到", ReplyToID: "m1", Mentions: []domain.ChatMention{{UserID: "user-3", Offset: 0, Length: 3}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "请求火力支援", msg.ReplyTo.Content)
+	assert.Contains(t, (<-mentioned).Data, `"CHAT"`)
+	ev := <-mentioned
+	assert.Equal(t, []string{"user-3"}, ev.Targets)
+	assert.Contains(t, ev.Data, `"MENTION"`)
+
+	// 历史记录附带被引用消息的摘要
+	page := ports.ChatHistoryPage{Limit: chatHistoryLimit}
+	mockRepo.On("GetConversationHistory", ctx, "session-1", "conv-1", page).Return([]domain.ChatMessage{{ID: "m2", ReplyToID: "m1"}, *parent}, nil).Once()
+	mockRepo.On("ListChatMessagesByIDs", ctx, "session-1", []string{"m1"}).Return([]domain.ChatMessage{*parent}, nil).Once()
+	history, err := svc.GetConversationHistory(ctx, "session-1", "user-3", "conv-1", ports.ChatHistoryPage{})
+	assert.NoError(t, err)
+	assert.Equal(t, &domain.ChatMessageRef{ID: "m1", SenderID: "user-2", Content: "请求火力支援", CreatedAt: simTime}, history[0].ReplyTo)
+	assert.Nil(t, history[1].ReplyTo)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMailService_EditChatMessageMentions(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+	events := svc.Subscribe("session-1", "user-2")
+	defer svc.Unsubscribe(events)
+
+	group := &domain.Conversation{ID: "conv-1", SessionID: "session-1", Type: domain.ConversationGroup, Members: []domain.ConversationMember{
+		{UserID: "user-1", Role: domain.MemberRoleAdmin}, {UserID: "user-2"}, {UserID: "user-3"},
+	}}
+	msg := &domain.ChatMessage{
+		ID: "m2", SessionID: "session-1", SenderID: "user-1", ConversationID: "conv-1", Content: "@张三 收到",
+		Mentions: []domain.ChatMention{{UserID: "user-3", Offset: 0, Length: 3}}, RealCreatedAt: time.Now(),
+	}
+	mockRepo.On("GetConversation", ctx, "session-1", "conv-1").Return(group, nil)
+	mockRepo.On("GetChatMessage", ctx, "session-1", "m2").Return(msg, nil)
+
+	// 原有提及超出新正文，或提及非成员
+	_, err := svc.EditChatMessage(ctx, "session-1", "user-1", "m2", "收到", msg.Mentions)
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+	_, err = svc.EditChatMessage(ctx, "session-1", "user-1", "m2", "@外人 收到", []domain.ChatMention{{UserID: "user-4", Offset: 0, Length: 3}})
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+
+	// 新增的被提及用户收到 MENTION 事件
+	mockRepo.On("EditChatMessage", ctx, msg, mock.Anything).Return(nil).Twice()
+	mentions := []domain.ChatMention{{UserID: "user-3", Offset: 0, Length: 3}, {UserID: "user-2", Offset: 4, Length: 3}}
+	edited, err := svc.EditChatMessage(ctx, "session-1", "user-1", "m2", "@张三 @李四 收到", mentions)
+	assert.NoError(t, err)
+	assert.Equal(t, mentions, edited.Mentions)
+	assert.Contains(t, (<-events).Data, `"CHAT_EDITED"`)
+	ev := <-events
+	assert.Equal(t, []string{"user-2"}, ev.Targets)
+	assert.Contains(t, ev.Data, `"MENTION"`)
+
+	// 不再提及时清除
+	edited, err = svc.EditChatMessage(ctx, "session-1", "user-1", "m2", "收到", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, edited.Mentions)
+	assert.Contains(t, (<-events).Data, `"CHAT_EDITED"`)
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event %s", ev.Data)
+	case <-time.After(20 * time.Millisecond):
+	}
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMailService_SearchChat(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, nil)
+
+	_, err := svc.SearchChat(ctx, "session-1", "user-1", ports.ChatSearchQuery{Query: "  "})
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+
+	// 指定单聊会话时按对方检索
+	direct := &domain.Conversation{ID: "conv-d", SessionID: "session-1", Type: domain.ConversationDirect, Members: []domain.ConversationMember{{UserID: "user-1"}, {UserID: "user-2"}}}
+	mockRepo.On("GetConversation", ctx, "session-1", "conv-d").Return(direct, nil)
+	hit := domain.ChatMessage{ID: "m2", SessionID: "session-1", SenderID: "user-2", ReceiverID: "user-1", Content: "请求火力支援"}
+	mockRepo.On("SearchChatMessages", ctx, "session-1", "user-1", ports.ChatSearchQuery{Query: "火力支援", PeerID: "user-2", Limit: chatSearchLimit}).
+		Return([]domain.ChatMessage{hit}, nil).Once()
+	mockRepo.On("GetChatHistory", ctx, "session-1", "user-2", "user-1", ports.ChatHistoryPage{Before: "m2", Limit: chatSearchContext}).
+		Return([]domain.ChatMessage{{ID: "m1b"}, {ID: "m1a"}}, nil).Once()
+	mockRepo.On("GetChatHistory", ctx, "session-1", "user-2", "user-1", ports.ChatHistoryPage{After: "m2", Limit: chatSearchContext}).
+		Return([]domain.ChatMessage{{ID: "m3"}}, nil).Once()
+
+	hits, err := svc.SearchChat(ctx, "session-1", "user-1", ports.ChatSearchQuery{Query: " 火力支援 ", ConversationID: "conv-d"})
+	assert.NoError(t, err)
+	assert.Len(t, hits, 1)
+	assert.Equal(t, "m2", hits[0].Message.ID)
+	assert.Equal(t, []domain.ChatMessage{{ID: "m1a"}, {ID: "m1b"}}, hits[0].Before)
+	assert.Equal(t, []domain.ChatMessage{{ID: "m3"}}, hits[0].After)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMailService_EscalateChat(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewMailService(mockRepo, newRunningSessions(), fixedClock{simTime}, new(MockStorageService))
+
+	att := domain.Attachment{ID: "a1", FileName: "map<1>.png", FilePath: "session-1/map.png", FileSize: 10}
+	m1 := &domain.ChatMessage{ID: "m1", SessionID: "session-1", SenderID: "user-1", ReceiverID: "user-2", Content: "同意 14:00 撤离", CreatedAt: simTime}
+	m2 := domain.ChatMessage{ID: "m2", SessionID: "session-1", SenderID: "user-2", ReceiverID: "user-1", Content: "确认\n见附图", Attachments: []domain.Attachment{att}, CreatedAt: simTime}
+	m3 := domain.ChatMessage{ID: "m3", SessionID: "session-1", SenderID: "user-1", ReceiverID: "user-2", Content: "后续", CreatedAt: simTime}
+	mockRepo.On("GetChatMessage", ctx, "session-1", "m1").Return(m1, nil)
+	mockRepo.On("GetChatMessage", ctx, "session-1", "m2").Return(&m2, nil)
+	mockRepo.On("GetChatMessage", ctx, "session-1", "x9").Return(&domain.ChatMessage{ID: "x9", SenderID: "user-3", ReceiverID: "user-1"}, nil)
+
+	req := ports.EscalateChatRequest{PeerID: "user-2", FromMessageID: "m1", ToMessageID: "x9", To: []string{"user-5"}}
+	_, err := svc.EscalateChat(ctx, "session-1", "user-1", req)
+	assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+
+	mockRepo.On("GetChatHistory", ctx, "session-1", "user-1", "user-2", ports.ChatHistoryPage{After: "m1", Limit: maxEscalateMessages}).
+		Return([]domain.ChatMessage{m3, m2}, nil).Once()
+	mockRepo.On("Create", ctx, mock.MatchedBy(func(mail *domain.Mail) bool {
+		return mail.ContentType == "rich" && mail.Subject == "即时通讯记录：与 user-2 的单聊" &&
+			strings.Contains(mail.Content, "确认<br>见附图") && strings.Contains(mail.Content, "[附件] map&lt;1&gt;.png") &&
+			!strings.Contains(mail.Content, "后续") &&
+			len(mail.Attachments) == 1 && mail.Attachments[0].FilePath == "session-1/map.png" && mail.Attachments[0].ID == "" &&
+			len(mail.Recipients) == 1 && mail.Recipients[0].RecipientID == "user-5"
+	})).Return(nil).Once()
+	req.ToMessageID = "m2"
+	mail, err := svc.EscalateChat(ctx, "session-1", "user-1", req)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", mail.SenderID)
+	mockRepo.AssertExpectations(t)
 }
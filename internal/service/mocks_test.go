@@ -5,6 +5,7 @@ import (
 	"io"
 	"raven/internal/core/domain"
 	"raven/internal/core/ports"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 )
@@ -37,13 +38,13 @@ func (m *MockMailRepository) GetSent(ctx context.Context, sessionID, senderID st
 	return args.Get(0).([]domain.Mail), args.Get(1).(int64), args.Error(2)
 }
 
-func (m *MockMailRepository) UpdateStatus(ctx context.Context, mailID, recipientID, status string) error {
-	args := m.Called(ctx, mailID, recipientID, status)
+func (m *MockMailRepository) UpdateStatus(ctx context.Context, mailID, recipientID, status string, at time.Time) error {
+	args := m.Called(ctx, mailID, recipientID, status, at)
 	return args.Error(0)
 }
 
-func (m *MockMailRepository) DeleteForSender(ctx context.Context, mailID string) error {
-	args := m.Called(ctx, mailID)
+func (m *MockMailRepository) DeleteForSender(ctx context.Context, mailID string, at time.Time) error {
+	args := m.Called(ctx, mailID, at)
 	return args.Error(0)
 }
 
@@ -65,16 +66,102 @@ func (m *MockMailRepository) CreateChatMessage(ctx context.Context, msg *domain.
 	return args.Error(0)
 }
 
-func (m *MockMailRepository) GetChatHistory(ctx context.Context, sessionID, userA, userB string, limit int) ([]domain.ChatMessage, error) {
-	args := m.Called(ctx, sessionID, userA, userB, limit)
+func (m *MockMailRepository) GetChatHistory(ctx context.Context, sessionID, userA, userB string, page ports.ChatHistoryPage) ([]domain.ChatMessage, error) {
+	args := m.Called(ctx, sessionID, userA, userB, page)
 	return args.Get(0).([]domain.ChatMessage), args.Error(1)
 }
 
-func (m *MockMailRepository) MarkChatAsRead(ctx context.Context, sessionID, senderID, receiverID string) error {
-	args := m.Called(ctx, sessionID, senderID, receiverID)
+func (m *MockMailRepository) MarkChatAsRead(ctx context.Context, sessionID, senderID, receiverID string, at time.Time) ([]string, error) {
+	args := m.Called(ctx, sessionID, senderID, receiverID, at)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockMailRepository) GetChatMessage(ctx context.Context, sessionID, id string) (*domain.ChatMessage, error) {
+	args := m.Called(ctx, sessionID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.ChatMessage), args.Error(1)
+}
+
+func (m *MockMailRepository) EditChatMessage(ctx context.Context, msg *domain.ChatMessage, edit *domain.ChatMessageEdit) error {
+	args := m.Called(ctx, msg, edit)
+	return args.Error(0)
+}
+
+func (m *MockMailRepository) RetractChatMessage(ctx context.Context, msg *domain.ChatMessage) error {
+	args := m.Called(ctx, msg)
 	return args.Error(0)
 }
 
+func (m *MockMailRepository) ListChatMessageEdits(ctx context.Context, messageID string) ([]domain.ChatMessageEdit, error) {
+	args := m.Called(ctx, messageID)
+	return args.Get(0).([]domain.ChatMessageEdit), args.Error(1)
+}
+
+func (m *MockMailRepository) ListSessionMails(ctx context.Context, sessionID string) ([]domain.Mail, error) {
+	args := m.Called(ctx, sessionID)
+	return args.Get(0).([]domain.Mail), args.Error(1)
+}
+
+func (m *MockMailRepository) ListSessionChats(ctx context.Context, sessionID string) ([]domain.ChatMessage, error) {
+	args := m.Called(ctx, sessionID)
+	return args.Get(0).([]domain.ChatMessage), args.Error(1)
+}
+
+func (m *MockMailRepository) ClaimDueRelays(ctx context.Context, now, leaseUntil time.Time, limit int) ([]domain.MailRecipient, error) {
+	args := m.Called(ctx, now, leaseUntil, limit)
+	return args.Get(0).([]domain.MailRecipient), args.Error(1)
+}
+
+func (m *MockMailRepository) UpdateRelayState(ctx context.Context, recipient *domain.MailRecipient) error {
+	args := m.Called(ctx, recipient)
+	return args.Error(0)
+}
+
+func (m *MockMailRepository) GetVolumeStats(ctx context.Context, sessionID string, bucketSeconds int64) ([]ports.VolumeBucket, error) {
+	args := m.Called(ctx, sessionID, bucketSeconds)
+	return args.Get(0).([]ports.VolumeBucket), args.Error(1)
+}
+
+func (m *MockMailRepository) GetCommunicationMatrix(ctx context.Context, sessionID string) ([]ports.MatrixCell, error) {
+	args := m.Called(ctx, sessionID)
+	return args.Get(0).([]ports.MatrixCell), args.Error(1)
+}
+
+func (m *MockMailRepository) GetAttachmentStats(ctx context.Context, sessionID string) ([]ports.AttachmentStat, error) {
+	args := m.Called(ctx, sessionID)
+	return args.Get(0).([]ports.AttachmentStat), args.Error(1)
+}
+
+func (m *MockMailRepository) GetUnreadBacklog(ctx context.Context, sessionID string) ([]ports.BacklogEntry, error) {
+	args := m.Called(ctx, sessionID)
+	return args.Get(0).([]ports.BacklogEntry), args.Error(1)
+}
+
+func (m *MockMailRepository) GetReplyLatencyBuckets(ctx context.Context, sessionID string, bounds []int) ([]ports.LatencyBucket, error) {
+	args := m.Called(ctx, sessionID, bounds)
+	return args.Get(0).([]ports.LatencyBucket), args.Error(1)
+}
+
+func (m *MockMailRepository) GetReadLatencyBuckets(ctx context.Context, sessionID string, bounds []int) ([]ports.LatencyBucket, error) {
+	args := m.Called(ctx, sessionID, bounds)
+	return args.Get(0).([]ports.LatencyBucket), args.Error(1)
+}
+
+func (m *MockMailRepository) HasReply(ctx context.Context, sessionID, parentID string) (bool, error) {
+	args := m.Called(ctx, sessionID, parentID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMailRepository) HasChatReply(ctx context.Context, sessionID, fromID, toID string, since time.Time) (bool, error) {
+	args := m.Called(ctx, sessionID, fromID, toID, since)
+	return args.Bool(0), args.Error(1)
+}
+
 func (m *MockMailRepository) GetUnreadMailCount(ctx context.Context, sessionID, userID string) (int64, error) {
 	args := m.Called(ctx, sessionID, userID)
 	return args.Get(0).(int64), args.Error(1)
@@ -85,11 +172,130 @@ func (m *MockMailRepository) GetIMUnreadCounts(ctx context.Context, sessionID, u
 	return args.Get(0).(map[string]int), args.Error(1)
 }
 
+func (m *MockMailRepository) ListChatMessagesByIDs(ctx context.Context, sessionID string, ids []string) ([]domain.ChatMessage, error) {
+	args := m.Called(ctx, sessionID, ids)
+	return args.Get(0).([]domain.ChatMessage), args.Error(1)
+}
+
+func (m *MockMailRepository) SearchChatMessages(ctx context.Context, sessionID, userID string, query ports.ChatSearchQuery) ([]domain.ChatMessage, error) {
+	args := m.Called(ctx, sessionID, userID, query)
+	return args.Get(0).([]domain.ChatMessage), args.Error(1)
+}
+
+func (m *MockMailRepository) AttachmentFileInUse(ctx context.Context, filePath string) (bool, error) {
+	args := m.Called(ctx, filePath)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMailRepository) ListChatThreads(ctx context.Context, sessionID, userID string) ([]ports.ChatThread, error) {
+	args := m.Called(ctx, sessionID, userID)
+	return args.Get(0).([]ports.ChatThread), args.Error(1)
+}
+
+func (m *MockMailRepository) ListDataSessionIDs(ctx context.Context) ([]string, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]string), args.Error(1)
+}
+
 func (m *MockMailRepository) GetOrphanSessionIDs(ctx context.Context, activeSessionIDs []string) ([]string, error) {
 	args := m.Called(ctx, activeSessionIDs)
 	return args.Get(0).([]string), args.Error(1)
 }
 
+func (m *MockMailRepository) SessionHasData(ctx context.Context, sessionID string) (bool, error) {
+	args := m.Called(ctx, sessionID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMailRepository) CloneSession(ctx context.Context, srcSessionID, dstSessionID string, opts ports.CloneOptions) error {
+	args := m.Called(ctx, srcSessionID, dstSessionID, opts)
+	return args.Error(0)
+}
+
+func (m *MockMailRepository) CreateSnapshot(ctx context.Context, snapshot *domain.SessionSnapshot) error {
+	args := m.Called(ctx, snapshot)
+	return args.Error(0)
+}
+
+func (m *MockMailRepository) GetSnapshot(ctx context.Context, sessionID, id string) (*domain.SessionSnapshot, error) {
+	args := m.Called(ctx, sessionID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.SessionSnapshot), args.Error(1)
+}
+
+func (m *MockMailRepository) ListSnapshots(ctx context.Context, sessionID string) ([]domain.SessionSnapshot, error) {
+	args := m.Called(ctx, sessionID)
+	return args.Get(0).([]domain.SessionSnapshot), args.Error(1)
+}
+
+func (m *MockMailRepository) DeleteSnapshot(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockMailRepository) CreateConversation(ctx context.Context, conv *domain.Conversation) error {
+	args := m.Called(ctx, conv)
+	return args.Error(0)
+}
+
+func (m *MockMailRepository) GetConversation(ctx context.Context, sessionID, id string) (*domain.Conversation, error) {
+	args := m.Called(ctx, sessionID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Conversation), args.Error(1)
+}
+
+func (m *MockMailRepository) FindDirectConversation(ctx context.Context, sessionID, directKey string) (*domain.Conversation, error) {
+	args := m.Called(ctx, sessionID, directKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Conversation), args.Error(1)
+}
+
+func (m *MockMailRepository) ListConversations(ctx context.Context, sessionID, userID string) ([]domain.Conversation, error) {
+	args := m.Called(ctx, sessionID, userID)
+	return args.Get(0).([]domain.Conversation), args.Error(1)
+}
+
+func (m *MockMailRepository) ListSessionConversations(ctx context.Context, sessionID string) ([]domain.Conversation, error) {
+	args := m.Called(ctx, sessionID)
+	return args.Get(0).([]domain.Conversation), args.Error(1)
+}
+
+func (m *MockMailRepository) AddConversationMembers(ctx context.Context, members []domain.ConversationMember) error {
+	args := m.Called(ctx, members)
+	return args.Error(0)
+}
+
+func (m *MockMailRepository) UpdateConversationMember(ctx context.Context, member *domain.ConversationMember) error {
+	args := m.Called(ctx, member)
+	return args.Error(0)
+}
+
+func (m *MockMailRepository) RemoveConversationMember(ctx context.Context, conversationID, userID string) error {
+	args := m.Called(ctx, conversationID, userID)
+	return args.Error(0)
+}
+
+func (m *MockMailRepository) GetConversationHistory(ctx context.Context, sessionID, conversationID string, page ports.ChatHistoryPage) ([]domain.ChatMessage, error) {
+	args := m.Called(ctx, sessionID, conversationID, page)
+	return args.Get(0).([]domain.ChatMessage), args.Error(1)
+}
+
+func (m *MockMailRepository) MarkConversationRead(ctx context.Context, sessionID, conversationID, userID string, at time.Time) ([]domain.ChatMessage, error) {
+	args := m.Called(ctx, sessionID, conversationID, userID, at)
+	return args.Get(0).([]domain.ChatMessage), args.Error(1)
+}
+
+func (m *MockMailRepository) GetConversationUnreadCounts(ctx context.Context, sessionID, userID string) (map[string]int, error) {
+	args := m.Called(ctx, sessionID, userID)
+	return args.Get(0).(map[string]int), args.Error(1)
+}
+
 // MockStorageService
 type MockStorageService struct {
 	mock.Mock
@@ -113,11 +319,264 @@ func (m *MockStorageService) DeleteSessionDir(ctx context.Context, sessionID str
 	return args.Error(0)
 }
 
+func (m *MockStorageService) CopySessionDir(ctx context.Context, srcSessionID, dstSessionID string) error {
+	args := m.Called(ctx, srcSessionID, dstSessionID)
+	return args.Error(0)
+}
+
+func (m *MockStorageService) ReplaceSessionDir(ctx context.Context, srcSessionID, dstSessionID string) error {
+	args := m.Called(ctx, srcSessionID, dstSessionID)
+	return args.Error(0)
+}
+
+func (m *MockStorageService) SessionDirExists(ctx context.Context, sessionID string) (bool, error) {
+	args := m.Called(ctx, sessionID)
+	return args.Bool(0), args.Error(1)
+}
+
 func (m *MockStorageService) DeleteFile(ctx context.Context, path string) error {
 	args := m.Called(ctx, path)
 	return args.Error(0)
 }
 
+// MockSessionRepository
+type MockSessionRepository struct {
+	mock.Mock
+}
+
+func (m *MockSessionRepository) Create(ctx context.Context, session *domain.Session) error {
+	args := m.Called(ctx, session)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepository) GetByID(ctx context.Context, id string) (*domain.Session, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Session), args.Error(1)
+}
+
+func (m *MockSessionRepository) List(ctx context.Context, status string) ([]domain.Session, error) {
+	args := m.Called(ctx, status)
+	return args.Get(0).([]domain.Session), args.Error(1)
+}
+
+func (m *MockSessionRepository) Update(ctx context.Context, session *domain.Session, columns ...string) error {
+	args := m.Called(ctx, session, columns)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepository) MarkHostManaged(ctx context.Context, ids []string) error {
+	args := m.Called(ctx, ids)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepository) Delete(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// newRunningSessions 返回一个所有场次均处于进行中状态的场次仓储
+func newRunningSessions() *MockSessionRepository {
+	m := new(MockSessionRepository)
+	m.On("GetByID", mock.Anything, mock.Anything).Return(&domain.Session{Status: domain.SessionStatusRunning}, nil).Maybe()
+	m.On("Delete", mock.Anything, mock.Anything).Return(nil).Maybe()
+	m.On("MarkHostManaged", mock.Anything, mock.Anything).Return(nil).Maybe()
+	return m
+}
+
+// fixedClock 始终返回固定演练时间的时钟
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now(ctx context.Context, sessionID string) time.Time {
+	return c.now
+}
+
+// MockScenarioRepository
+type MockScenarioRepository struct {
+	mock.Mock
+}
+
+func (m *MockScenarioRepository) Create(ctx context.Context, scenario *domain.Scenario) error {
+	args := m.Called(ctx, scenario)
+	return args.Error(0)
+}
+
+func (m *MockScenarioRepository) GetByID(ctx context.Context, id string) (*domain.Scenario, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Scenario), args.Error(1)
+}
+
+func (m *MockScenarioRepository) List(ctx context.Context) ([]domain.Scenario, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.Scenario), args.Error(1)
+}
+
+func (m *MockScenarioRepository) Delete(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockScenarioRepository) CreateRun(ctx context.Context, run *domain.ScenarioRun) (bool, error) {
+	args := m.Called(ctx, run)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockScenarioRepository) GetRun(ctx context.Context, sessionID string) (*domain.ScenarioRun, error) {
+	args := m.Called(ctx, sessionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.ScenarioRun), args.Error(1)
+}
+
+func (m *MockScenarioRepository) ListRuns(ctx context.Context) ([]domain.ScenarioRun, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.ScenarioRun), args.Error(1)
+}
+
+func (m *MockScenarioRepository) ClaimRun(ctx context.Context, sessionID, owner string, now, leaseUntil time.Time) (bool, error) {
+	args := m.Called(ctx, sessionID, owner, now, leaseUntil)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockScenarioRepository) UpdateRun(ctx context.Context, run *domain.ScenarioRun) (bool, error) {
+	args := m.Called(ctx, run)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockScenarioRepository) SetRunCommand(ctx context.Context, sessionID, command string) (bool, error) {
+	args := m.Called(ctx, sessionID, command)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockScenarioRepository) ClearRunCommand(ctx context.Context, sessionID, command string) error {
+	args := m.Called(ctx, sessionID, command)
+	return args.Error(0)
+}
+
+// MockAuditRepository
+type MockAuditRepository struct {
+	mock.Mock
+}
+
+func (m *MockAuditRepository) Append(ctx context.Context, entry *domain.AuditLog) error {
+	args := m.Called(ctx, entry)
+	return args.Error(0)
+}
+
+func (m *MockAuditRepository) List(ctx context.Context, filter ports.AuditFilter, page, pageSize int) ([]domain.AuditLog, int64, error) {
+	args := m.Called(ctx, filter, page, pageSize)
+	return args.Get(0).([]domain.AuditLog), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *MockAuditRepository) Each(ctx context.Context, filter ports.AuditFilter, fn func([]domain.AuditLog) error) error {
+	args := m.Called(ctx, filter, fn)
+	return args.Error(0)
+}
+
+// MockWebhookRepository
+type MockWebhookRepository struct {
+	mock.Mock
+}
+
+func (m *MockWebhookRepository) Create(ctx context.Context, hook *domain.Webhook) error {
+	args := m.Called(ctx, hook)
+	return args.Error(0)
+}
+
+func (m *MockWebhookRepository) GetByID(ctx context.Context, id string) (*domain.Webhook, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Webhook), args.Error(1)
+}
+
+func (m *MockWebhookRepository) List(ctx context.Context, sessionID string) ([]domain.Webhook, error) {
+	args := m.Called(ctx, sessionID)
+	return args.Get(0).([]domain.Webhook), args.Error(1)
+}
+
+func (m *MockWebhookRepository) ListForSession(ctx context.Context, sessionID string) ([]domain.Webhook, error) {
+	args := m.Called(ctx, sessionID)
+	return args.Get(0).([]domain.Webhook), args.Error(1)
+}
+
+func (m *MockWebhookRepository) Delete(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockWebhookRepository) CreateDeliveries(ctx context.Context, deliveries []domain.WebhookDelivery) error {
+	args := m.Called(ctx, deliveries)
+	return args.Error(0)
+}
+
+func (m *MockWebhookRepository) GetDelivery(ctx context.Context, id string) (*domain.WebhookDelivery, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockWebhookRepository) ClaimDueDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]domain.WebhookDelivery, error) {
+	args := m.Called(ctx, now, leaseUntil, limit)
+	return args.Get(0).([]domain.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockWebhookRepository) UpdateDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	args := m.Called(ctx, delivery)
+	return args.Error(0)
+}
+
+func (m *MockWebhookRepository) ListDeliveries(ctx context.Context, filter ports.WebhookDeliveryFilter, page, pageSize int) ([]domain.WebhookDelivery, int64, error) {
+	args := m.Called(ctx, filter, page, pageSize)
+	return args.Get(0).([]domain.WebhookDelivery), args.Get(1).(int64), args.Error(2)
+}
+
+// MockPresenceRepository
+type MockPresenceRepository struct {
+	mock.Mock
+}
+
+func (m *MockPresenceRepository) Touch(ctx context.Context, sessionID, userID string, at time.Time) error {
+	args := m.Called(ctx, sessionID, userID, at)
+	return args.Error(0)
+}
+
+func (m *MockPresenceRepository) List(ctx context.Context, sessionID string) ([]domain.UserPresence, error) {
+	args := m.Called(ctx, sessionID)
+	return args.Get(0).([]domain.UserPresence), args.Error(1)
+}
+
+func (m *MockPresenceRepository) SaveConnection(ctx context.Context, conn *domain.PresenceConnection) error {
+	args := m.Called(ctx, conn)
+	return args.Error(0)
+}
+
+func (m *MockPresenceRepository) Heartbeat(ctx context.Context, instanceID string, at, staleBefore time.Time) error {
+	args := m.Called(ctx, instanceID, at, staleBefore)
+	return args.Error(0)
+}
+
+func (m *MockPresenceRepository) ListConnections(ctx context.Context, sessionID, userID string, since time.Time) ([]domain.PresenceConnection, error) {
+	args := m.Called(ctx, sessionID, userID, since)
+	return args.Get(0).([]domain.PresenceConnection), args.Error(1)
+}
+
 // Ensure interfaces are implemented
 var _ ports.MailRepository = (*MockMailRepository)(nil)
 var _ ports.StorageService = (*MockStorageService)(nil)
+var _ ports.ScenarioRepository = (*MockScenarioRepository)(nil)
+var _ ports.SessionRepository = (*MockSessionRepository)(nil)
+var _ ports.AuditRepository = (*MockAuditRepository)(nil)
+var _ ports.WebhookRepository = (*MockWebhookRepository)(nil)
+var _ ports.PresenceRepository = (*MockPresenceRepository)(nil)
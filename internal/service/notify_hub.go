@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+)
+
+// DefaultSubscriberQueue 每个订阅者的缓冲事件数
+const DefaultSubscriberQueue = 64
+
+// subscriber 订阅者身份
+type subscriber struct {
+	sessionID string
+	userID    string
+}
+
+// notificationHub 按场次/用户索引本进程订阅者的事件分发器。
+// 事件经 EventBus 发布，总线的 Run 协程负责扇出，请求耗时与订阅者数量无关
+type notificationHub struct {
+	mu      sync.RWMutex
+	cfg     ports.HubConfig
+	bus     ports.EventBus
+	cancel  context.CancelFunc
+	done    chan struct{}
+	closed  bool
+	clients map[chan domain.NotificationEvent]subscriber
+	// 场次 -> 用户 -> 订阅通道，事件只投递给匹配的订阅者
+	index map[string]map[string]map[chan domain.NotificationEvent]struct{}
+
+	published    atomic.Uint64
+	delivered    atomic.Uint64
+	dropped      atomic.Uint64
+	disconnected atomic.Uint64
+}
+
+func newNotificationHub(bus ports.EventBus) *notificationHub {
+	h := &notificationHub{
+		cfg:     ports.HubConfig{QueueSize: DefaultSubscriberQueue, Overflow: ports.OverflowDisconnect},
+		clients: make(map[chan domain.NotificationEvent]subscriber),
+		index:   make(map[string]map[string]map[chan domain.NotificationEvent]struct{}),
+	}
+	h.start(bus)
+	return h
+}
+
+// start 切换到新的总线并开始分发，原总线先停止
+func (h *notificationHub) start(bus ports.EventBus) {
+	h.stop(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	h.mu.Lock()
+	h.bus, h.cancel, h.done = bus, cancel, done
+	h.mu.Unlock()
+	go func() {
+		defer close(done)
+		if err := bus.Run(ctx, h.dispatch); err != nil {
+			log.Printf("[Notify] Event bus stopped: %v", err)
+		}
+	}()
+}
+
+// stop 停止当前总线的分发协程，等待其处理完已入队的事件
+func (h *notificationHub) stop(ctx context.Context) error {
+	h.mu.RLock()
+	cancel, done := h.cancel, h.done
+	h.mu.RUnlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *notificationHub) currentBus() ports.EventBus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.bus
+}
+
+func (h *notificationHub) configure(cfg ports.HubConfig) {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultSubscriberQueue
+	}
+	if cfg.Overflow == "" {
+		cfg.Overflow = ports.OverflowDisconnect
+	}
+	h.mu.Lock()
+	h.cfg = cfg
+	h.mu.Unlock()
+}
+
+func (h *notificationHub) publish(ctx context.Context, ev domain.NotificationEvent) error {
+	return h.currentBus().Publish(ctx, ev)
+}
+
+func (h *notificationHub) dispatch(ev domain.NotificationEvent) {
+	defer h.published.Add(1)
+
+	// 持有读锁完成投递，避免与 Unsubscribe 关闭通道竞争
+	var lagging []chan domain.NotificationEvent
+	h.mu.RLock()
+	policy := h.cfg.Overflow
+	send := func(client chan domain.NotificationEvent) {
+		select {
+		case client <- ev:
+			h.delivered.Add(1)
+			return
+		default:
+		}
+		if policy == ports.OverflowDisconnect {
+			// 断开慢速订阅者，客户端重连后凭 Last-Event-ID 补齐
+			lagging = append(lagging, client)
+			return
+		}
+		// 丢弃最旧的一条再入队
+		select {
+		case <-client:
+			h.dropped.Add(1)
+		default:
+		}
+		select {
+		case client <- ev:
+			h.delivered.Add(1)
+		default:
+			h.dropped.Add(1)
+		}
+	}
+	users := h.index[ev.SessionID]
+	if ev.Targets == nil {
+		for _, chans := range users {
+			for client := range chans {
+				send(client)
+			}
+		}
+	} else {
+		seen := make(map[string]bool, len(ev.Targets))
+		for _, userID := range ev.Targets {
+			if seen[userID] {
+				continue
+			}
+			seen[userID] = true
+			for client := range users[userID] {
+				send(client)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range lagging {
+		if h.unsubscribe(client) {
+			h.disconnected.Add(1)
+		}
+	}
+}
+
+func (h *notificationHub) subscribe(sessionID, userID string) chan domain.NotificationEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := make(chan domain.NotificationEvent, h.cfg.QueueSize)
+	if h.closed {
+		// 已关闭: 返回关闭的通道，调用方随即结束推送
+		close(c)
+		return c
+	}
+	h.clients[c] = subscriber{sessionID: sessionID, userID: userID}
+	users, ok := h.index[sessionID]
+	if !ok {
+		users = make(map[string]map[chan domain.NotificationEvent]struct{})
+		h.index[sessionID] = users
+	}
+	if users[userID] == nil {
+		users[userID] = make(map[chan domain.NotificationEvent]struct{})
+	}
+	users[userID][c] = struct{}{}
+	return c
+}
+
+// unsubscribe 移除订阅并关闭通道，通道已移除时返回 false
+func (h *notificationHub) unsubscribe(c chan domain.NotificationEvent) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub, ok := h.clients[c]
+	if !ok {
+		return false
+	}
+	delete(h.clients, c)
+	users := h.index[sub.sessionID]
+	delete(users[sub.userID], c)
+	if len(users[sub.userID]) == 0 {
+		delete(users, sub.userID)
+	}
+	if len(users) == 0 {
+		delete(h.index, sub.sessionID)
+	}
+	close(c)
+	return true
+}
+
+// shutdown 停止分发: 处理完已入队的事件后关闭全部订阅
+func (h *notificationHub) shutdown(ctx context.Context) error {
+	err := h.stop(ctx)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		close(c)
+	}
+	h.clients = make(map[chan domain.NotificationEvent]subscriber)
+	h.index = make(map[string]map[string]map[chan domain.NotificationEvent]struct{})
+	return err
+}
+
+func (h *notificationHub) stats() ports.HubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return ports.HubStats{
+		Subscribers:  len(h.clients),
+		QueueSize:    h.cfg.QueueSize,
+		Overflow:     h.cfg.Overflow,
+		Published:    h.published.Load(),
+		Delivered:    h.delivered.Load(),
+		Dropped:      h.dropped.Load(),
+		Disconnected: h.disconnected.Load(),
+	}
+}
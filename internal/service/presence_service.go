@@ -0,0 +1,342 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultPresenceGrace 最后一条连接断开后，等待重连的时间，超时才视为离线
+	DefaultPresenceGrace = 15 * time.Second
+	// DefaultPresenceIdle 有连接但无操作超过该时间视为空闲
+	DefaultPresenceIdle = 5 * time.Minute
+	// presencePersistInterval 操作引起的最后在线时间写库间隔
+	presencePersistInterval = time.Minute
+	// presenceHeartbeatInterval 刷新本实例连接记录心跳的间隔
+	presenceHeartbeatInterval = 10 * time.Second
+	// presenceHeartbeatTTL 其他实例的连接记录超过该时间未刷新即视为该实例已退出
+	presenceHeartbeatTTL = 3 * presenceHeartbeatInterval
+	// presenceStaleAfter 心跳超过该时间的连接记录被清理
+	presenceStaleAfter = 10 * presenceHeartbeatTTL
+)
+
+// presenceRank 状态的合并优先级：任一实例在线即在线，其次空闲
+var presenceRank = map[string]int{domain.PresenceOffline: 0, domain.PresenceIdle: 1, domain.PresenceOnline: 2}
+
+func mergePresence(a, b string) string {
+	if presenceRank[b] > presenceRank[a] {
+		return b
+	}
+	return a
+}
+
+type presenceState struct {
+	conns      int
+	status     string
+	lastActive time.Time
+	persisted  time.Time
+	offline    *time.Timer // 重连宽限计时
+	gen        uint64      // 宽限计时的代次，重连或重新计时后旧计时器到期不再生效
+}
+
+// PresenceService 跟踪本实例上的推送连接，得出用户在线/空闲/离线状态，状态变化时推送 PRESENCE 事件。
+// 本实例的连接数与状态写入 presence_connections 表并定期刷新心跳，
+// 多实例共用数据库时与其他实例心跳有效的记录合并，任一实例在线即视为在线
+type PresenceService struct {
+	repo       ports.PresenceRepository
+	instanceID string
+	events     ports.EventPublisher
+	grace      time.Duration
+	idleAfter  time.Duration
+	now        func() time.Time
+
+	mu     sync.Mutex
+	states map[string]map[string]*presenceState // 场次 -> 用户
+}
+
+func NewPresenceService(repo ports.PresenceRepository, events ports.EventPublisher, grace, idleAfter time.Duration) *PresenceService {
+	if grace <= 0 {
+		grace = DefaultPresenceGrace
+	}
+	if idleAfter <= 0 {
+		idleAfter = DefaultPresenceIdle
+	}
+	return &PresenceService{
+		repo:       repo,
+		instanceID: uuid.NewString(),
+		events:     events,
+		grace:      grace,
+		idleAfter:  idleAfter,
+		now:        time.Now,
+		states:     make(map[string]map[string]*presenceState),
+	}
+}
+
+// presenceChange 本实例上的状态变化，在锁外写库并发布
+type presenceChange struct {
+	sessionID, userID string
+	prev, status      string
+	conns             int
+	at                time.Time
+}
+
+func (s *PresenceService) state(sessionID, userID string) *presenceState {
+	users, ok := s.states[sessionID]
+	if !ok {
+		users = make(map[string]*presenceState)
+		s.states[sessionID] = users
+	}
+	st, ok := users[userID]
+	if !ok {
+		st = &presenceState{status: domain.PresenceOffline}
+		users[userID] = st
+	}
+	return st
+}
+
+func (s *PresenceService) Connect(ctx context.Context, sessionID, userID string) func() {
+	now := s.now()
+	s.mu.Lock()
+	st := s.state(sessionID, userID)
+	st.conns++
+	if st.offline != nil {
+		// 宽限期内重连，不产生离线事件
+		st.offline.Stop()
+		st.offline = nil
+		st.gen++
+	}
+	st.lastActive, st.persisted = now, now
+	change := &presenceChange{sessionID, userID, st.status, domain.PresenceOnline, st.conns, now}
+	st.status = domain.PresenceOnline
+	s.mu.Unlock()
+
+	s.persist(ctx, sessionID, userID, now)
+	s.saveConnection(ctx, change)
+	s.publish(ctx, change)
+
+	var once sync.Once
+	return func() { once.Do(func() { s.disconnect(sessionID, userID) }) }
+}
+
+func (s *PresenceService) disconnect(sessionID, userID string) {
+	now := s.now()
+	s.mu.Lock()
+	st := s.state(sessionID, userID)
+	st.conns--
+	st.persisted = now
+	if st.conns == 0 {
+		st.gen++
+		gen := st.gen
+		st.offline = time.AfterFunc(s.grace, func() { s.expire(sessionID, userID, gen) })
+	}
+	change := &presenceChange{sessionID, userID, st.status, st.status, st.conns, now}
+	s.mu.Unlock()
+
+	s.persist(context.Background(), sessionID, userID, now)
+	s.saveConnection(context.Background(), change)
+}
+
+// expire 宽限期结束仍无连接，标记离线
+func (s *PresenceService) expire(sessionID, userID string, gen uint64) {
+	s.mu.Lock()
+	st := s.state(sessionID, userID)
+	if st.gen != gen || st.conns > 0 {
+		s.mu.Unlock()
+		return
+	}
+	st.offline = nil
+	change := &presenceChange{sessionID, userID, st.status, domain.PresenceOffline, 0, st.persisted}
+	st.status = domain.PresenceOffline
+	s.mu.Unlock()
+
+	s.saveConnection(context.Background(), change)
+	s.publish(context.Background(), change)
+}
+
+func (s *PresenceService) Touch(ctx context.Context, sessionID, userID string) {
+	now := s.now()
+	s.mu.Lock()
+	st := s.state(sessionID, userID)
+	st.lastActive = now
+	var change *presenceChange
+	if st.status == domain.PresenceIdle {
+		change = &presenceChange{sessionID, userID, st.status, domain.PresenceOnline, st.conns, now}
+		st.status = domain.PresenceOnline
+	}
+	persist := now.Sub(st.persisted) >= presencePersistInterval
+	if persist {
+		st.persisted = now
+	}
+	s.mu.Unlock()
+
+	if persist {
+		s.persist(ctx, sessionID, userID, now)
+	}
+	if change != nil {
+		s.saveConnection(ctx, change)
+		s.publish(ctx, change)
+	}
+}
+
+// Run 定期把长时间无操作的在线用户标记为空闲并刷新本实例的心跳，直到 ctx 取消
+func (s *PresenceService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.idleAfter / 4)
+	defer ticker.Stop()
+	heartbeat := time.NewTicker(presenceHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweep(ctx)
+		case <-heartbeat.C:
+			s.heartbeat(ctx)
+		}
+	}
+}
+
+func (s *PresenceService) heartbeat(ctx context.Context) {
+	now := s.now()
+	if err := s.repo.Heartbeat(ctx, s.instanceID, now, now.Add(-presenceStaleAfter)); err != nil {
+		log.Printf("[Presence] Failed to refresh heartbeat: %v", err)
+	}
+}
+
+func (s *PresenceService) sweep(ctx context.Context) {
+	now := s.now()
+	var changes []*presenceChange
+	s.mu.Lock()
+	for sessionID, users := range s.states {
+		for userID, st := range users {
+			if st.status == domain.PresenceOnline && st.conns > 0 && now.Sub(st.lastActive) >= s.idleAfter {
+				st.status = domain.PresenceIdle
+				changes = append(changes, &presenceChange{sessionID, userID, domain.PresenceOnline, domain.PresenceIdle, st.conns, st.lastActive})
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, change := range changes {
+		s.saveConnection(ctx, change)
+		s.publish(ctx, change)
+	}
+}
+
+// List 场次内所有出现过的用户及其状态，按用户 ID 排序
+func (s *PresenceService) List(ctx context.Context, sessionID string) ([]ports.PresenceInfo, error) {
+	records, err := s.repo.List(ctx, sessionID)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to load presence", err)
+	}
+	byUser := make(map[string]*ports.PresenceInfo, len(records))
+	for _, r := range records {
+		lastSeen := r.LastSeenAt
+		byUser[r.UserID] = &ports.PresenceInfo{UserID: r.UserID, Status: domain.PresenceOffline, LastSeenAt: &lastSeen}
+	}
+
+	s.mu.Lock()
+	for userID, st := range s.states[sessionID] {
+		info, ok := byUser[userID]
+		if !ok {
+			info = &ports.PresenceInfo{UserID: userID}
+			byUser[userID] = info
+		}
+		info.Status = st.status
+		info.Connections = st.conns
+		if !st.persisted.IsZero() && (info.LastSeenAt == nil || st.persisted.After(*info.LastSeenAt)) {
+			lastSeen := st.persisted
+			info.LastSeenAt = &lastSeen
+		}
+	}
+	s.mu.Unlock()
+
+	conns, err := s.repo.ListConnections(ctx, sessionID, "", s.now().Add(-presenceHeartbeatTTL))
+	if err != nil {
+		return nil, ports.NewInternalError("failed to load presence", err)
+	}
+	for _, c := range conns {
+		if c.InstanceID == s.instanceID {
+			continue
+		}
+		info, ok := byUser[c.UserID]
+		if !ok {
+			info = &ports.PresenceInfo{UserID: c.UserID}
+			byUser[c.UserID] = info
+		}
+		info.Status = mergePresence(info.Status, c.Status)
+		info.Connections += c.Connections
+	}
+
+	list := make([]ports.PresenceInfo, 0, len(byUser))
+	for _, info := range byUser {
+		if info.Status == "" {
+			info.Status = domain.PresenceOffline
+		}
+		list = append(list, *info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UserID < list[j].UserID })
+	return list, nil
+}
+
+func (s *PresenceService) persist(ctx context.Context, sessionID, userID string, at time.Time) {
+	if err := s.repo.Touch(ctx, sessionID, userID, at); err != nil {
+		log.Printf("[Presence] Failed to store last seen for %s/%s: %v", sessionID, userID, err)
+	}
+}
+
+// saveConnection 把本实例上的连接数与状态写入共享表，供其他实例合并
+func (s *PresenceService) saveConnection(ctx context.Context, change *presenceChange) {
+	conn := &domain.PresenceConnection{
+		InstanceID:  s.instanceID,
+		SessionID:   change.sessionID,
+		UserID:      change.userID,
+		Connections: change.conns,
+		Status:      change.status,
+		HeartbeatAt: s.now(),
+	}
+	if err := s.repo.SaveConnection(ctx, conn); err != nil {
+		log.Printf("[Presence] Failed to store connections for %s/%s: %v", change.sessionID, change.userID, err)
+	}
+}
+
+// elsewhere 用户在其他实例上的合并状态，查询失败时按仅本实例处理
+func (s *PresenceService) elsewhere(ctx context.Context, sessionID, userID string) string {
+	conns, err := s.repo.ListConnections(ctx, sessionID, userID, s.now().Add(-presenceHeartbeatTTL))
+	if err != nil {
+		log.Printf("[Presence] Failed to load connections for %s/%s: %v", sessionID, userID, err)
+		return domain.PresenceOffline
+	}
+	status := domain.PresenceOffline
+	for _, c := range conns {
+		if c.InstanceID != s.instanceID {
+			status = mergePresence(status, c.Status)
+		}
+	}
+	return status
+}
+
+// publish 合并其他实例的状态后，只在用户的整体状态变化时推送
+func (s *PresenceService) publish(ctx context.Context, change *presenceChange) {
+	if change == nil || change.prev == change.status || s.events == nil {
+		return
+	}
+	others := s.elsewhere(ctx, change.sessionID, change.userID)
+	prev, status := mergePresence(change.prev, others), mergePresence(change.status, others)
+	if prev == status {
+		return
+	}
+	s.events.PublishEvent(ctx, change.sessionID, domain.PresenceEvent, nil, map[string]interface{}{
+		"user_id":      change.userID,
+		"status":       status,
+		"last_seen_at": change.at,
+	})
+}
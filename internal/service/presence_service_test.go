@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// recordingPublisher 记录推送的 PRESENCE 事件状态
+type recordingPublisher struct {
+	mu       sync.Mutex
+	statuses []string
+}
+
+func (p *recordingPublisher) PublishEvent(ctx context.Context, sessionID, event string, targets []string, data interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statuses = append(p.statuses, data.(map[string]interface{})["status"].(string))
+}
+
+func (p *recordingPublisher) list() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.statuses...)
+}
+
+func TestPresenceService_ConnectAndGrace(t *testing.T) {
+	ctx := context.TODO()
+	repo := new(MockPresenceRepository)
+	repo.On("Touch", mock.Anything, "session-1", "user-1", mock.Anything).Return(nil)
+	repo.On("SaveConnection", mock.Anything, mock.Anything).Return(nil)
+	repo.On("ListConnections", mock.Anything, "session-1", "user-1", mock.Anything).Return([]domain.PresenceConnection{}, nil)
+	events := &recordingPublisher{}
+	svc := NewPresenceService(repo, events, 20*time.Millisecond, time.Minute)
+
+	release := svc.Connect(ctx, "session-1", "user-1")
+	assert.Equal(t, []string{domain.PresenceOnline}, events.list())
+
+	// 宽限期内重连不产生离线事件
+	release()
+	release() // 重复调用无效
+	release = svc.Connect(ctx, "session-1", "user-1")
+	time.Sleep(40 * time.Millisecond)
+	assert.Equal(t, []string{domain.PresenceOnline}, events.list())
+
+	release()
+	assert.Eventually(t, func() bool { return len(events.list()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, domain.PresenceOffline, events.list()[1])
+}
+
+func TestPresenceService_IdleAndTouch(t *testing.T) {
+	ctx := context.TODO()
+	repo := new(MockPresenceRepository)
+	repo.On("Touch", mock.Anything, "session-1", "user-1", mock.Anything).Return(nil)
+	repo.On("SaveConnection", mock.Anything, mock.Anything).Return(nil)
+	repo.On("ListConnections", mock.Anything, "session-1", "user-1", mock.Anything).Return([]domain.PresenceConnection{}, nil)
+	events := &recordingPublisher{}
+	svc := NewPresenceService(repo, events, time.Minute, 5*time.Minute)
+	now := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	defer svc.Connect(ctx, "session-1", "user-1")()
+	now = now.Add(6 * time.Minute)
+	svc.sweep(ctx)
+	svc.sweep(ctx)
+	assert.Equal(t, []string{domain.PresenceOnline, domain.PresenceIdle}, events.list())
+
+	svc.Touch(ctx, "session-1", "user-1")
+	assert.Equal(t, []string{domain.PresenceOnline, domain.PresenceIdle, domain.PresenceOnline}, events.list())
+}
+
+func TestPresenceService_List(t *testing.T) {
+	ctx := context.TODO()
+	repo := new(MockPresenceRepository)
+	lastSeen := time.Date(2026, 1, 1, 7, 0, 0, 0, time.UTC)
+	repo.On("Touch", mock.Anything, "session-1", "user-2", mock.Anything).Return(nil)
+	repo.On("SaveConnection", mock.Anything, mock.Anything).Return(nil)
+	repo.On("List", ctx, "session-1").Return([]domain.UserPresence{
+		{SessionID: "session-1", UserID: "user-1", LastSeenAt: lastSeen},
+	}, nil)
+	svc := NewPresenceService(repo, nil, time.Minute, time.Minute)
+	// 其他实例上的连接计入；本实例自身的记录与心跳过期的记录不计入
+	repo.On("ListConnections", ctx, "session-1", "", mock.Anything).Return([]domain.PresenceConnection{
+		{InstanceID: "other", SessionID: "session-1", UserID: "user-1", Connections: 2, Status: domain.PresenceIdle},
+		{InstanceID: "other", SessionID: "session-1", UserID: "user-3", Connections: 1, Status: domain.PresenceOnline},
+		{InstanceID: svc.instanceID, SessionID: "session-1", UserID: "user-2", Connections: 1, Status: domain.PresenceOnline},
+	}, nil)
+	defer svc.Connect(ctx, "session-1", "user-2")()
+
+	list, err := svc.List(ctx, "session-1")
+	assert.NoError(t, err)
+	if assert.Len(t, list, 3) {
+		assert.Equal(t, "user-1", list[0].UserID)
+		assert.Equal(t, domain.PresenceIdle, list[0].Status)
+		assert.Equal(t, 2, list[0].Connections)
+		assert.Equal(t, lastSeen, *list[0].LastSeenAt)
+		assert.Equal(t, domain.PresenceOnline, list[1].Status)
+		assert.Equal(t, 1, list[1].Connections)
+		assert.Equal(t, "user-3", list[2].UserID)
+		assert.Equal(t, domain.PresenceOnline, list[2].Status)
+	}
+}
+
+// sharedPresenceRepo 多个实例共用的连接记录表
+type sharedPresenceRepo struct {
+	ports.PresenceRepository
+	mu    sync.Mutex
+	conns map[[3]string]domain.PresenceConnection
+}
+
+func (r *sharedPresenceRepo) Touch(ctx context.Context, sessionID, userID string, at time.Time) error {
+	return nil
+}
+
+func (r *sharedPresenceRepo) List(ctx context.Context, sessionID string) ([]domain.UserPresence, error) {
+	return nil, nil
+}
+
+func (r *sharedPresenceRepo) SaveConnection(ctx context.Context, conn *domain.PresenceConnection) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := [3]string{conn.InstanceID, conn.SessionID, conn.UserID}
+	if conn.Status == domain.PresenceOffline {
+		delete(r.conns, key)
+	} else {
+		r.conns[key] = *conn
+	}
+	return nil
+}
+
+func (r *sharedPresenceRepo) ListConnections(ctx context.Context, sessionID, userID string, since time.Time) ([]domain.PresenceConnection, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var list []domain.PresenceConnection
+	for _, c := range r.conns {
+		if c.SessionID == sessionID && (userID == "" || c.UserID == userID) && !c.HeartbeatAt.Before(since) {
+			list = append(list, c)
+		}
+	}
+	return list, nil
+}
+
+func TestPresenceService_MultipleInstances(t *testing.T) {
+	ctx := context.TODO()
+	repo := &sharedPresenceRepo{conns: map[[3]string]domain.PresenceConnection{}}
+	events := &recordingPublisher{}
+	a := NewPresenceService(repo, events, 10*time.Millisecond, 5*time.Minute)
+	b := NewPresenceService(repo, events, 10*time.Millisecond, 5*time.Minute)
+	now := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+	b.now = a.now
+
+	releaseA := a.Connect(ctx, "session-1", "user-1")
+	releaseB := b.Connect(ctx, "session-1", "user-1")
+	assert.Equal(t, []string{domain.PresenceOnline}, events.list())
+
+	// 另一实例看到的状态与连接数包含两个实例
+	list, err := b.List(ctx, "session-1")
+	assert.NoError(t, err)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, domain.PresenceOnline, list[0].Status)
+		assert.Equal(t, 2, list[0].Connections)
+	}
+
+	// 实例 A 上断开，用户仍经实例 B 在线，不推送离线
+	releaseA()
+	time.Sleep(40 * time.Millisecond)
+	assert.Equal(t, []string{domain.PresenceOnline}, events.list())
+
+	// 实例 A 上空闲不影响整体在线，实例 B 断开后整体转为空闲
+	releaseA = a.Connect(ctx, "session-1", "user-1")
+	now = now.Add(6 * time.Minute)
+	repo.mu.Lock()
+	for key, c := range repo.conns {
+		c.HeartbeatAt = now // 模拟两个实例的心跳
+		repo.conns[key] = c
+	}
+	repo.mu.Unlock()
+	a.sweep(ctx)
+	assert.Equal(t, []string{domain.PresenceOnline}, events.list())
+	releaseB()
+	assert.Eventually(t, func() bool { return len(events.list()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, domain.PresenceIdle, events.list()[1])
+
+	// 实例 A 心跳过期后其记录不再计入
+	now = now.Add(presenceHeartbeatTTL + time.Second)
+	list, err = b.List(ctx, "session-1")
+	assert.NoError(t, err)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, domain.PresenceOffline, list[0].Status)
+		assert.Equal(t, 0, list[0].Connections)
+	}
+	releaseA()
+}
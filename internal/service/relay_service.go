@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+)
+
+const (
+	relayBatchSize   = 20
+	relayMaxAttempts = 8
+	relayBaseBackoff = 30 * time.Second
+	relayMaxBackoff  = time.Hour
+	// relayClaimLease 认领后的租约时长，需覆盖一批投递的耗时
+	relayClaimLease = 10 * time.Minute
+)
+
+// RelayService 轮询转发队列，将文电副本投递到收件人映射的外部邮箱。
+// 队列即 mail_recipients 表中 relay_status = pending 的记录，进程重启后继续投递；
+// 记录先经条件更新认领再投递，多个实例共用数据库时不会重复发送。
+type RelayService struct {
+	mails    ports.MailRepository
+	storage  ports.StorageService
+	relay    ports.MailRelay
+	interval time.Duration
+	now      func() time.Time
+}
+
+func NewRelayService(mails ports.MailRepository, storage ports.StorageService, relay ports.MailRelay, interval time.Duration) *RelayService {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &RelayService{mails: mails, storage: storage, relay: relay, interval: interval, now: time.Now}
+}
+
+// Run 周期性处理到期的转发任务，直到 ctx 结束
+func (s *RelayService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.ProcessDue(ctx); err != nil {
+			log.Printf("[Relay] Processing failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue 投递一批到期的转发任务，返回处理的条数
+func (s *RelayService) ProcessDue(ctx context.Context) (int, error) {
+	now := s.now()
+	due, err := s.mails.ClaimDueRelays(ctx, now, now.Add(relayClaimLease), relayBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	mails := make(map[string]*domain.Mail)
+	for i := range due {
+		recipient := &due[i]
+		mail, ok := mails[recipient.MailID]
+		if !ok {
+			// 加载失败 (如文电已被删除) 时 mail 为 nil，按投递失败处理
+			mail, _ = s.mails.GetByID(ctx, recipient.SessionID, recipient.MailID)
+			mails[recipient.MailID] = mail
+		}
+
+		err := s.deliver(ctx, mail, recipient)
+		s.record(recipient, err)
+		if err := s.mails.UpdateRelayState(ctx, recipient); err != nil {
+			log.Printf("[Relay] Failed to save state for recipient %s: %v", recipient.ID, err)
+		}
+	}
+	return len(due), nil
+}
+
+func (s *RelayService) deliver(ctx context.Context, mail *domain.Mail, recipient *domain.MailRecipient) error {
+	if mail == nil {
+		return fmt.Errorf("%w: mail %s no longer exists", ports.ErrRelayRejected, recipient.MailID)
+	}
+
+	msg := ports.RelayMessage{
+		MessageID:   mail.ID + "." + recipient.ID,
+		SessionID:   mail.SessionID,
+		SenderID:    mail.SenderID,
+		To:          recipient.RelayAddress,
+		Subject:     mail.Subject,
+		Content:     mail.Content,
+		ContentType: mail.ContentType,
+		Date:        mail.RealCreatedAt,
+	}
+	for _, att := range mail.Attachments {
+		f, err := s.storage.GetFile(ctx, att.FilePath)
+		if err != nil {
+			return fmt.Errorf("open attachment %s: %w", att.FileName, err)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("read attachment %s: %w", att.FileName, err)
+		}
+		msg.Attachments = append(msg.Attachments, ports.RelayAttachment{FileName: att.FileName, MimeType: att.MimeType, Data: data})
+	}
+
+	return s.relay.Relay(ctx, msg)
+}
+
+// record 根据投递结果更新转发状态：成功标记 sent；永久拒收或超过重试次数标记 failed；否则指数退避后重试
+func (s *RelayService) record(recipient *domain.MailRecipient, err error) {
+	now := s.now()
+	recipient.RelayAttempts++
+
+	if err == nil {
+		recipient.RelayStatus = domain.RelayStatusSent
+		recipient.RelayedAt = &now
+		recipient.RelayNextAt = nil
+		recipient.RelayError = ""
+		return
+	}
+
+	recipient.RelayError = err.Error()
+	if errors.Is(err, ports.ErrRelayRejected) || recipient.RelayAttempts >= relayMaxAttempts {
+		log.Printf("[Relay] Giving up on recipient %s after %d attempts: %v", recipient.ID, recipient.RelayAttempts, err)
+		recipient.RelayStatus = domain.RelayStatusFailed
+		recipient.RelayNextAt = nil
+		return
+	}
+
+	next := now.Add(relayBackoff(recipient.RelayAttempts))
+	recipient.RelayNextAt = &next
+}
+
+// relayBackoff 第 n 次失败后的等待时间: 30s, 1m, 2m, ... 最长 1h
+func relayBackoff(attempts int) time.Duration {
+	return expBackoff(attempts, relayBaseBackoff, relayMaxBackoff)
+}
+
+// expBackoff 第 n 次失败后的等待时间，从 base 开始逐次翻倍，不超过 max
+func expBackoff(attempts int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
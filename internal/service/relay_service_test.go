@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// stubRelay 记录投递的报文，并按队列依次返回预设错误
+type stubRelay struct {
+	sent []ports.RelayMessage
+	errs []error
+}
+
+func (r *stubRelay) Relay(ctx context.Context, msg ports.RelayMessage) error {
+	r.sent = append(r.sent, msg)
+	if len(r.errs) == 0 {
+		return nil
+	}
+	err := r.errs[0]
+	r.errs = r.errs[1:]
+	return err
+}
+
+func TestMailService_SendMailQueuesRelay(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	sessions := new(MockSessionRepository)
+	sessions.On("GetByID", ctx, "s1").Return(&domain.Session{
+		ID: "s1", Status: domain.SessionStatusRunning, RelayAddresses: map[string]string{"observer": "obs@example.org"},
+	}, nil)
+	svc := NewMailService(mockRepo, sessions, fixedClock{simTime}, new(MockStorageService))
+
+	mockRepo.On("Create", ctx, mock.MatchedBy(func(m *domain.Mail) bool {
+		return m.Recipients[0].RelayStatus == "" &&
+			m.Recipients[1].RelayStatus == domain.RelayStatusPending &&
+			m.Recipients[1].RelayAddress == "obs@example.org" &&
+			m.Recipients[1].RelayNextAt != nil
+	})).Return(nil)
+
+	_, err := svc.SendMail(ctx, "hq", ports.SendMailRequest{SessionID: "s1", Subject: "Sitrep", To: []string{"alpha"}, Cc: []string{"observer"}})
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestRelayService_ProcessDue(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC)
+	mail := &domain.Mail{
+		ID: "m1", SessionID: "s1", SenderID: "hq", Subject: "Sitrep", Content: "body",
+		Attachments: []domain.Attachment{{FileName: "map.png", FilePath: "s1/map.png", MimeType: "image/png"}},
+	}
+
+	newService := func(relay *stubRelay, recipients []domain.MailRecipient) (*RelayService, *MockMailRepository) {
+		mockRepo := new(MockMailRepository)
+		mockStorage := new(MockStorageService)
+		mockRepo.On("ClaimDueRelays", ctx, now, now.Add(relayClaimLease), relayBatchSize).Return(recipients, nil)
+		mockRepo.On("GetByID", ctx, "s1", "m1").Return(mail, nil)
+		mockStorage.On("GetFile", ctx, "s1/map.png").Return(io.NopCloser(strings.NewReader("png")), nil)
+		svc := NewRelayService(mockRepo, mockStorage, relay, time.Second)
+		svc.now = func() time.Time { return now }
+		return svc, mockRepo
+	}
+	pending := func() []domain.MailRecipient {
+		return []domain.MailRecipient{{ID: "r1", MailID: "m1", SessionID: "s1", RelayAddress: "obs@example.org", RelayStatus: domain.RelayStatusPending}}
+	}
+
+	t.Run("Delivered", func(t *testing.T) {
+		relay := &stubRelay{}
+		svc, mockRepo := newService(relay, pending())
+		mockRepo.On("UpdateRelayState", ctx, mock.MatchedBy(func(r *domain.MailRecipient) bool {
+			return r.RelayStatus == domain.RelayStatusSent && r.RelayAttempts == 1 && r.RelayedAt != nil && r.RelayNextAt == nil
+		})).Return(nil).Once()
+
+		n, err := svc.ProcessDue(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Len(t, relay.sent, 1)
+		assert.Equal(t, "obs@example.org", relay.sent[0].To)
+		assert.Equal(t, []byte("png"), relay.sent[0].Attachments[0].Data)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("Transient failure backs off", func(t *testing.T) {
+		recipients := pending()
+		recipients[0].RelayAttempts = 2
+		svc, mockRepo := newService(&stubRelay{errs: []error{errors.New("connection refused")}}, recipients)
+		mockRepo.On("UpdateRelayState", ctx, mock.MatchedBy(func(r *domain.MailRecipient) bool {
+			return r.RelayStatus == domain.RelayStatusPending && r.RelayAttempts == 3 &&
+				r.RelayNextAt.Equal(now.Add(2*time.Minute)) && r.RelayError == "connection refused"
+		})).Return(nil).Once()
+
+		_, err := svc.ProcessDue(ctx)
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("Rejected gives up", func(t *testing.T) {
+		rejected := fmt.Errorf("%w: 550 mailbox unavailable", ports.ErrRelayRejected)
+		svc, mockRepo := newService(&stubRelay{errs: []error{rejected}}, pending())
+		mockRepo.On("UpdateRelayState", ctx, mock.MatchedBy(func(r *domain.MailRecipient) bool {
+			return r.RelayStatus == domain.RelayStatusFailed && r.RelayNextAt == nil
+		})).Return(nil).Once()
+
+		_, err := svc.ProcessDue(ctx)
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestRelayBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, relayBackoff(1))
+	assert.Equal(t, time.Minute, relayBackoff(2))
+	assert.Equal(t, relayMaxBackoff, relayBackoff(20))
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+)
+
+// summaryLength 时间线中消息摘要的最大字符数
+const summaryLength = 80
+
+// ReportService 汇总场次内的文电、阅办及消息记录，生成复盘报告
+type ReportService struct {
+	mails    ports.MailRepository
+	sessions ports.SessionRepository
+}
+
+func NewReportService(mails ports.MailRepository, sessions ports.SessionRepository) *ReportService {
+	return &ReportService{mails: mails, sessions: sessions}
+}
+
+func (s *ReportService) BuildSessionReport(ctx context.Context, sessionID string) (*ports.SessionReport, error) {
+	session, err := s.sessions.GetByID(ctx, sessionID)
+	if err != nil {
+		return nil, ports.NewNotFoundError("session not found", err)
+	}
+
+	mails, err := s.mails.ListSessionMails(ctx, sessionID)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to load mails", err)
+	}
+	chats, err := s.mails.ListSessionChats(ctx, sessionID)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to load chat messages", err)
+	}
+	convs, err := s.mails.ListSessionConversations(ctx, sessionID)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to load conversations", err)
+	}
+	members := make(map[string][]string, len(convs))
+	for i := range convs {
+		members[convs[i].ID] = convs[i].MemberIDs("")
+	}
+
+	return &ports.SessionReport{
+		Session:     session,
+		GeneratedAt: time.Now(),
+		Timeline:    buildTimeline(mails, chats, members),
+		Users:       buildUserMetrics(mails, chats, members),
+	}, nil
+}
+
+// chatRecipients 单聊为接收方，群聊与频道为发送方以外的已登记成员
+func chatRecipients(cm domain.ChatMessage, members map[string][]string) []string {
+	if cm.ReceiverID != "" {
+		return []string{cm.ReceiverID}
+	}
+	var ids []string
+	for _, id := range members[cm.ConversationID] {
+		if id != cm.SenderID {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func buildTimeline(mails []domain.Mail, chats []domain.ChatMessage, members map[string][]string) []ports.TimelineEvent {
+	var events []ports.TimelineEvent
+
+	for _, m := range mails {
+		var targets []string
+		for _, r := range m.Recipients {
+			targets = append(targets, r.RecipientID)
+		}
+		events = append(events, ports.TimelineEvent{
+			Time:     m.CreatedAt,
+			RealTime: realTime(m.RealCreatedAt),
+			Type:     "MAIL_SENT",
+			ActorID:  m.SenderID,
+			Targets:  targets,
+			RefID:    m.ID,
+			Summary:  m.Subject,
+		})
+
+		for _, r := range m.Recipients {
+			if r.ReadAt != nil {
+				events = append(events, ports.TimelineEvent{
+					Time: *r.ReadAt, Type: "MAIL_READ", ActorID: r.RecipientID, RefID: m.ID, Summary: m.Subject,
+				})
+			}
+			if r.RemovedAt != nil {
+				events = append(events, ports.TimelineEvent{
+					Time: *r.RemovedAt, Type: "MAIL_DELETED", ActorID: r.RecipientID, RefID: m.ID, Summary: m.Subject,
+				})
+			}
+		}
+		if m.SenderDeletedAt != nil {
+			events = append(events, ports.TimelineEvent{
+				Time: *m.SenderDeletedAt, Type: "MAIL_DELETED", ActorID: m.SenderID, RefID: m.ID, Summary: m.Subject,
+			})
+		}
+	}
+
+	for _, cm := range chats {
+		events = append(events, ports.TimelineEvent{
+			Time:     cm.CreatedAt,
+			RealTime: realTime(cm.RealCreatedAt),
+			Type:     "CHAT_SENT",
+			ActorID:  cm.SenderID,
+			Targets:  chatRecipients(cm, members),
+			RefID:    cm.ID,
+			Summary:  truncate(cm.Content, summaryLength),
+		})
+		if cm.ReadAt != nil {
+			events = append(events, ports.TimelineEvent{
+				Time: *cm.ReadAt, Type: "CHAT_READ", ActorID: cm.ReceiverID, RefID: cm.ID, Summary: truncate(cm.Content, summaryLength),
+			})
+		}
+		if cm.RetractedAt != nil {
+			events = append(events, ports.TimelineEvent{
+				Time: *cm.RetractedAt, Type: "CHAT_RETRACTED", ActorID: cm.SenderID, RefID: cm.ID,
+			})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events
+}
+
+func buildUserMetrics(mails []domain.Mail, chats []domain.ChatMessage, members map[string][]string) []ports.UserMetrics {
+	metrics := make(map[string]*ports.UserMetrics)
+	readDurations := make(map[string][]float64)
+	get := func(userID string) *ports.UserMetrics {
+		if m, ok := metrics[userID]; ok {
+			return m
+		}
+		m := &ports.UserMetrics{UserID: userID, UnansweredItems: []ports.UnansweredItem{}}
+		metrics[userID] = m
+		return m
+	}
+
+	// 回复索引: 原文电 ID -> 回复者集合
+	replied := make(map[string]map[string]bool)
+	for _, m := range mails {
+		if m.ParentID == nil {
+			continue
+		}
+		if replied[*m.ParentID] == nil {
+			replied[*m.ParentID] = make(map[string]bool)
+		}
+		replied[*m.ParentID][m.SenderID] = true
+	}
+
+	for _, m := range mails {
+		get(m.SenderID).MailsSent++
+		for _, r := range m.Recipients {
+			um := get(r.RecipientID)
+			um.MailsReceived++
+			if r.ReadAt != nil {
+				readDurations[r.RecipientID] = append(readDurations[r.RecipientID], r.ReadAt.Sub(m.CreatedAt).Seconds())
+			}
+			if r.Type == "to" && r.RecipientID != m.SenderID && !replied[m.ID][r.RecipientID] {
+				um.UnansweredItems = append(um.UnansweredItems, ports.UnansweredItem{
+					Type: "mail", ID: m.ID, FromID: m.SenderID, ReceivedAt: m.CreatedAt, Summary: m.Subject,
+				})
+			}
+		}
+	}
+
+	// 即时消息: 对方最后一次回复之后收到的消息均视为未回复。群聊与频道消息只计收发数量
+	lastReply := make(map[[2]string]time.Time) // [回复者, 对方] -> 最近回复时间
+	for _, cm := range chats {
+		key := [2]string{cm.SenderID, cm.ReceiverID}
+		if cm.ReceiverID != "" && cm.CreatedAt.After(lastReply[key]) {
+			lastReply[key] = cm.CreatedAt
+		}
+	}
+	for _, cm := range chats {
+		get(cm.SenderID).ChatsSent++
+		if cm.ReceiverID == "" {
+			for _, id := range chatRecipients(cm, members) {
+				get(id).ChatsReceived++
+			}
+			continue
+		}
+		um := get(cm.ReceiverID)
+		um.ChatsReceived++
+		if cm.ReadAt != nil {
+			readDurations[cm.ReceiverID] = append(readDurations[cm.ReceiverID], cm.ReadAt.Sub(cm.CreatedAt).Seconds())
+		}
+		if !lastReply[[2]string{cm.ReceiverID, cm.SenderID}].After(cm.CreatedAt) {
+			um.UnansweredItems = append(um.UnansweredItems, ports.UnansweredItem{
+				Type: "chat", ID: cm.ID, FromID: cm.SenderID, ReceivedAt: cm.CreatedAt, Summary: truncate(cm.Content, summaryLength),
+			})
+		}
+	}
+
+	result := make([]ports.UserMetrics, 0, len(metrics))
+	for userID, um := range metrics {
+		um.MedianTimeToRead = median(readDurations[userID])
+		um.UnansweredCount = len(um.UnansweredItems)
+		result = append(result, *um)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserID < result[j].UserID })
+	return result
+}
+
+func median(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	m := sorted[mid]
+	if len(sorted)%2 == 0 {
+		m = (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return &m
+}
+
+func realTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
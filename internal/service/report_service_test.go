@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportService_BuildSessionReport(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	mockSessions := new(MockSessionRepository)
+	svc := NewReportService(mockRepo, mockSessions)
+
+	t0 := time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC)
+	at := func(min int) *time.Time {
+		v := t0.Add(time.Duration(min) * time.Minute)
+		return &v
+	}
+	orderID := "order"
+
+	mails := []domain.Mail{
+		{ID: "order", SenderID: "hq", Subject: "Move", CreatedAt: t0, Recipients: []domain.MailRecipient{
+			{RecipientID: "alpha", Type: "to", ReadAt: at(2)},
+			{RecipientID: "bravo", Type: "to", ReadAt: at(10)},
+		}},
+		{ID: "reply", SenderID: "alpha", Subject: "Re: Move", CreatedAt: *at(5), ParentID: &orderID, Recipients: []domain.MailRecipient{
+			{RecipientID: "hq", Type: "to", ReadAt: at(6), RemovedAt: at(7)},
+		}},
+	}
+	chats := []domain.ChatMessage{
+		{ID: "c1", SenderID: "bravo", ReceiverID: "hq", Content: "ready", CreatedAt: *at(1), ReadAt: at(3)},
+	}
+
+	mockSessions.On("GetByID", ctx, "s1").Return(&domain.Session{ID: "s1", Name: "drill"}, nil)
+	mockRepo.On("ListSessionMails", ctx, "s1").Return(mails, nil)
+	mockRepo.On("ListSessionChats", ctx, "s1").Return(chats, nil)
+	mockRepo.On("ListSessionConversations", ctx, "s1").Return([]domain.Conversation{}, nil)
+
+	report, err := svc.BuildSessionReport(ctx, "s1")
+	assert.NoError(t, err)
+
+	var types []string
+	for _, ev := range report.Timeline {
+		types = append(types, ev.Type)
+	}
+	assert.Equal(t, []string{"MAIL_SENT", "CHAT_SENT", "MAIL_READ", "CHAT_READ", "MAIL_SENT", "MAIL_READ", "MAIL_DELETED", "MAIL_READ"}, types)
+
+	byUser := map[string]int{}
+	for i, u := range report.Users {
+		byUser[u.UserID] = i
+	}
+	alpha := report.Users[byUser["alpha"]]
+	bravo := report.Users[byUser["bravo"]]
+	hq := report.Users[byUser["hq"]]
+
+	assert.Equal(t, 1, alpha.MailsSent)
+	assert.Equal(t, 0, alpha.UnansweredCount)
+	assert.Equal(t, 1, bravo.UnansweredCount)
+	assert.Equal(t, 600.0, *bravo.MedianTimeToRead)
+	// hq: 回复邮件 (to) 未回复 + bravo 的消息未回复；阅读耗时 60s 与 120s 的中位数
+	assert.Equal(t, 2, hq.UnansweredCount)
+	assert.Equal(t, 90.0, *hq.MedianTimeToRead)
+}
@@ -0,0 +1,764 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// scenarioRunLease 驱动脚本的实例持有执行记录的租约时长，实例停机后由其他实例在租约过期时接管
+const scenarioRunLease = 30 * time.Second
+
+// scenarioSender 脚本引擎发送注入所需的最小能力，由 MailService 实现
+type scenarioSender interface {
+	SendMail(ctx context.Context, senderID string, req ports.SendMailRequest) (*domain.Mail, error)
+	SendChatMessage(ctx context.Context, senderID string, req ports.SendChatMessageRequest) (*domain.ChatMessage, error)
+	RequireRunningSession(ctx context.Context, sessionID string) error
+}
+
+// ScenarioService 管理演练脚本并按场次驱动脚本执行
+//
+// 执行记录是各实例共享的状态：每个脚本只由持有租约的实例驱动，
+// 其他实例查询时读取数据库中的记录，控制请求以指令形式转交给驱动实例。
+type ScenarioService struct {
+	scenarios  ports.ScenarioRepository
+	mails      ports.MailRepository
+	sender     scenarioSender
+	clock      ports.SessionClock
+	assetDir   string
+	instanceID string
+	tick       time.Duration
+	now        func() time.Time
+
+	mu   sync.Mutex
+	runs map[string]*scenarioRun // 本实例驱动的脚本
+}
+
+func NewScenarioService(scenarios ports.ScenarioRepository, mails ports.MailRepository, sender scenarioSender, clock ports.SessionClock, assetDir string) *ScenarioService {
+	return &ScenarioService{
+		scenarios:  scenarios,
+		mails:      mails,
+		sender:     sender,
+		clock:      clock,
+		assetDir:   assetDir,
+		instanceID: uuid.NewString(),
+		tick:       time.Second,
+		now:        time.Now,
+		runs:       make(map[string]*scenarioRun),
+	}
+}
+
+func (s *ScenarioService) CreateScenario(ctx context.Context, definition []byte) (*domain.Scenario, error) {
+	var def domain.ScenarioDefinition
+	if err := json.Unmarshal(definition, &def); err != nil {
+		return nil, ports.NewInvalidInputError("invalid scenario definition", err)
+	}
+	if err := validateScenario(&def); err != nil {
+		return nil, err
+	}
+
+	scenario := &domain.Scenario{
+		Name:        def.Name,
+		Description: def.Description,
+		Definition:  string(definition),
+	}
+	if err := s.scenarios.Create(ctx, scenario); err != nil {
+		return nil, ports.NewInternalError("failed to save scenario", err)
+	}
+	return scenario, nil
+}
+
+func (s *ScenarioService) GetScenario(ctx context.Context, id string) (*domain.Scenario, *domain.ScenarioDefinition, error) {
+	scenario, err := s.scenarios.GetByID(ctx, id)
+	if err != nil {
+		return nil, nil, ports.NewNotFoundError("scenario not found", err)
+	}
+	var def domain.ScenarioDefinition
+	if err := json.Unmarshal([]byte(scenario.Definition), &def); err != nil {
+		return nil, nil, ports.NewInternalError("stored scenario definition is corrupted", err)
+	}
+	return scenario, &def, nil
+}
+
+func (s *ScenarioService) ListScenarios(ctx context.Context) ([]domain.Scenario, error) {
+	return s.scenarios.List(ctx)
+}
+
+func (s *ScenarioService) DeleteScenario(ctx context.Context, id string) error {
+	runs, err := s.scenarios.ListRuns(ctx)
+	if err != nil {
+		return ports.NewInternalError("failed to load scenario runs", err)
+	}
+	for _, run := range runs {
+		if run.ScenarioID == id && (run.State == "running" || run.State == "paused") {
+			return ports.NewInvalidInputError("scenario is running", nil)
+		}
+	}
+
+	return s.scenarios.Delete(ctx, id)
+}
+
+func (s *ScenarioService) StartRun(ctx context.Context, sessionID, scenarioID string) (*ports.ScenarioRunStatus, error) {
+	scenario, def, err := s.GetScenario(ctx, scenarioID)
+	if err != nil {
+		return nil, err
+	}
+
+	run := newScenarioRun(s, sessionID, scenarioID, def)
+	run.definition = scenario.Definition
+	run.leaseUntil = s.now().Add(scenarioRunLease)
+	created, err := s.scenarios.CreateRun(ctx, run.record())
+	if err != nil {
+		run.cancel()
+		return nil, ports.NewInternalError("failed to save scenario run", err)
+	}
+	if !created {
+		run.cancel()
+		return nil, ports.NewInvalidInputError("a scenario is already running in this session", nil)
+	}
+
+	s.mu.Lock()
+	s.runs[sessionID] = run
+	s.mu.Unlock()
+	go run.loop()
+
+	return run.status(), nil
+}
+
+func (s *ScenarioService) PauseRun(ctx context.Context, sessionID string) (*ports.ScenarioRunStatus, error) {
+	return s.control(ctx, sessionID, "pause")
+}
+
+func (s *ScenarioService) ResumeRun(ctx context.Context, sessionID string) (*ports.ScenarioRunStatus, error) {
+	return s.control(ctx, sessionID, "resume")
+}
+
+func (s *ScenarioService) StopRun(ctx context.Context, sessionID string) (*ports.ScenarioRunStatus, error) {
+	return s.control(ctx, sessionID, "stop")
+}
+
+func (s *ScenarioService) GetRunStatus(ctx context.Context, sessionID string) (*ports.ScenarioRunStatus, error) {
+	if run := s.localRun(sessionID); run != nil {
+		return run.status(), nil
+	}
+	run, err := s.loadRun(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return run.status(), nil
+}
+
+// control 执行暂停、继续或停止。脚本由本实例驱动时直接生效；
+// 否则校验数据库中的状态后登记指令，由驱动实例执行，返回指令生效后的状态
+func (s *ScenarioService) control(ctx context.Context, sessionID, command string) (*ports.ScenarioRunStatus, error) {
+	if run := s.localRun(sessionID); run != nil {
+		if err := run.apply(command, s.clock.Now(ctx, sessionID)); err != nil {
+			if command == "stop" {
+				return run.status(), nil
+			}
+			return nil, err
+		}
+		// 保存失败说明执行记录已被删除或接管，按其他实例驱动的脚本处理
+		if run.persist() {
+			if command == "stop" {
+				run.cancel()
+			}
+			return run.status(), nil
+		}
+	}
+
+	run, err := s.loadRun(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := run.apply(command, s.clock.Now(ctx, sessionID)); err != nil {
+		if command == "stop" {
+			return run.status(), nil // 脚本已结束，停止请求无需转交
+		}
+		return nil, err
+	}
+	ok, err := s.scenarios.SetRunCommand(ctx, sessionID, command)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to save scenario command", err)
+	}
+	if !ok {
+		return nil, ports.NewInvalidInputError("scenario is not running", nil)
+	}
+	return run.status(), nil
+}
+
+// RestoreRuns 接管租约已过期的未结束脚本并继续执行，启动时及运行期间定期调用。
+// 停机期间演练时钟照常走动，恢复后已到期的注入会立即补发
+func (s *ScenarioService) RestoreRuns(ctx context.Context) error {
+	saved, err := s.scenarios.ListRuns(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	for i := range saved {
+		rec := &saved[i]
+		if rec.State != "running" && rec.State != "paused" {
+			continue
+		}
+		if (rec.Owner != s.instanceID && now.Before(rec.LeaseUntil)) || s.localRun(rec.SessionID) != nil {
+			continue
+		}
+		var def domain.ScenarioDefinition
+		if err := json.Unmarshal([]byte(rec.Definition), &def); err != nil {
+			log.Printf("[Scenario] Skip run of session %s: corrupted definition: %v", rec.SessionID, err)
+			continue
+		}
+		leaseUntil := now.Add(scenarioRunLease)
+		claimed, err := s.scenarios.ClaimRun(ctx, rec.SessionID, s.instanceID, now, leaseUntil)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		run := restoreScenarioRun(s, rec, &def)
+		run.leaseUntil = leaseUntil
+		s.mu.Lock()
+		s.runs[run.sessionID] = run
+		s.mu.Unlock()
+		go run.loop()
+	}
+	return nil
+}
+
+// Run 定期接管其他实例停机后遗留的脚本，直到 ctx 结束
+func (s *ScenarioService) Run(ctx context.Context) {
+	ticker := time.NewTicker(scenarioRunLease / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.RestoreRuns(ctx); err != nil {
+				log.Printf("[Scenario] Failed to restore runs: %v", err)
+			}
+		}
+	}
+}
+
+func (s *ScenarioService) localRun(sessionID string) *scenarioRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runs[sessionID]
+}
+
+// release 脚本不再由本实例驱动时移出
+func (s *ScenarioService) release(run *scenarioRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.runs[run.sessionID] == run {
+		delete(s.runs, run.sessionID)
+	}
+}
+
+// loadRun 读取数据库中的执行记录，仅用于查询和校验，不驱动执行；已登记未执行的指令视为已生效
+func (s *ScenarioService) loadRun(ctx context.Context, sessionID string) (*scenarioRun, error) {
+	saved, err := s.scenarios.GetRun(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ports.NewNotFoundError("no scenario run for this session", nil)
+		}
+		return nil, ports.NewInternalError("failed to load scenario run", err)
+	}
+	var def domain.ScenarioDefinition
+	if err := json.Unmarshal([]byte(saved.Definition), &def); err != nil {
+		return nil, ports.NewInternalError("stored scenario definition is corrupted", err)
+	}
+	run := restoreScenarioRun(s, saved, &def)
+	run.cancel()
+	if saved.Command != "" {
+		run.apply(saved.Command, s.clock.Now(ctx, sessionID))
+	}
+	return run, nil
+}
+
+// fire 发出单条注入，返回生成的文电或消息 ID 及其演练时间
+func (s *ScenarioService) fire(ctx context.Context, sessionID string, inj *domain.ScenarioInject) (string, time.Time, error) {
+	var attachments []ports.AttachmentRequest
+	for _, a := range inj.Attachments {
+		f, err := os.Open(filepath.Join(s.assetDir, filepath.Clean("/"+a.Path)))
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		name := a.FileName
+		if name == "" {
+			name = filepath.Base(a.Path)
+		}
+		attachments = append(attachments, ports.AttachmentRequest{
+			FileName: name,
+			Content:  f,
+			Size:     info.Size(),
+			MimeType: mime.TypeByExtension(filepath.Ext(name)),
+		})
+	}
+
+	if inj.Type == "chat" {
+		msg, err := s.sender.SendChatMessage(ctx, inj.SenderID, ports.SendChatMessageRequest{
+			SessionID:   sessionID,
+			ReceiverID:  inj.To[0],
+			Content:     inj.Content,
+			Attachments: attachments,
+		})
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return msg.ID, msg.CreatedAt, nil
+	}
+
+	contentType := inj.ContentType
+	if contentType == "" {
+		contentType = "text"
+	}
+	mail, err := s.sender.SendMail(ctx, inj.SenderID, ports.SendMailRequest{
+		SessionID:   sessionID,
+		Subject:     inj.Subject,
+		Content:     inj.Content,
+		ContentType: contentType,
+		To:          inj.To,
+		Cc:          inj.Cc,
+		Attachments: attachments,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return mail.ID, mail.CreatedAt, nil
+}
+
+// answered 判断已发出的注入是否得到回复：文电以 ParentID 关联回复，即时消息以接收方回发消息为准
+func (s *ScenarioService) answered(ctx context.Context, sessionID string, inj *domain.ScenarioInject, fired *injectState) (bool, error) {
+	if inj.Type == "chat" {
+		return s.mails.HasChatReply(ctx, sessionID, inj.To[0], inj.SenderID, fired.firedAt)
+	}
+	return s.mails.HasReply(ctx, sessionID, fired.targetID)
+}
+
+func validateScenario(def *domain.ScenarioDefinition) error {
+	if strings.TrimSpace(def.Name) == "" {
+		return ports.NewInvalidInputError("scenario name is required", nil)
+	}
+	if len(def.Injects) == 0 {
+		return ports.NewInvalidInputError("scenario has no injects", nil)
+	}
+
+	seen := make(map[string]bool)
+	for i, inj := range def.Injects {
+		if inj.ID == "" {
+			return ports.NewInvalidInputError(fmt.Sprintf("inject #%d has no id", i+1), nil)
+		}
+		if seen[inj.ID] {
+			return ports.NewInvalidInputError(fmt.Sprintf("duplicate inject id %q", inj.ID), nil)
+		}
+		if inj.Type != "mail" && inj.Type != "chat" {
+			return ports.NewInvalidInputError(fmt.Sprintf("inject %q has unknown type %q", inj.ID, inj.Type), nil)
+		}
+		if inj.SenderID == "" || len(inj.To) == 0 {
+			return ports.NewInvalidInputError(fmt.Sprintf("inject %q requires sender_id and to", inj.ID), nil)
+		}
+		if inj.Type == "chat" && len(inj.To) != 1 {
+			return ports.NewInvalidInputError(fmt.Sprintf("chat inject %q must have exactly one recipient", inj.ID), nil)
+		}
+		if inj.Offset < 0 {
+			return ports.NewInvalidInputError(fmt.Sprintf("inject %q has negative offset", inj.ID), nil)
+		}
+		// 只允许引用前面已定义的注入，避免循环依赖
+		if inj.After != "" && !seen[inj.After] {
+			return ports.NewInvalidInputError(fmt.Sprintf("inject %q references unknown or later inject %q", inj.ID, inj.After), nil)
+		}
+		if inj.IfUnanswered && inj.After == "" {
+			return ports.NewInvalidInputError(fmt.Sprintf("inject %q uses if_unanswered without after", inj.ID), nil)
+		}
+		seen[inj.ID] = true
+	}
+	return nil
+}
+
+// scenarioRun 单个场次中正在执行的脚本实例
+//
+// 运行时长 (elapsed) 按场次演练时钟计算，仅在 running 状态下累计；
+// 脚本暂停或演练时钟暂停期间注入不会到期，时钟加速时注入同步提前。
+type scenarioRun struct {
+	svc        *ScenarioService
+	sessionID  string
+	scenarioID string
+	def        *domain.ScenarioDefinition
+	definition string
+	startedAt  time.Time
+
+	mu      sync.Mutex
+	state   string
+	elapsed time.Duration
+	lastSim time.Time
+	injects map[string]*injectState
+	cancel  context.CancelFunc
+	ctx     context.Context
+
+	leaseUntil time.Time // 受 mu 保护
+
+	saveMu sync.Mutex // 保证状态按变化顺序写入
+}
+
+type injectState struct {
+	state    string // pending, sent, skipped, failed
+	at       time.Duration
+	firedAt  time.Time
+	targetID string
+	err      string
+}
+
+func newScenarioRun(svc *ScenarioService, sessionID, scenarioID string, def *domain.ScenarioDefinition) *scenarioRun {
+	ctx, cancel := context.WithCancel(context.Background())
+	now := svc.clock.Now(ctx, sessionID)
+	run := &scenarioRun{
+		svc:        svc,
+		sessionID:  sessionID,
+		scenarioID: scenarioID,
+		def:        def,
+		startedAt:  now,
+		state:      "running",
+		lastSim:    now,
+		injects:    make(map[string]*injectState, len(def.Injects)),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	for _, inj := range def.Injects {
+		run.injects[inj.ID] = &injectState{state: "pending"}
+	}
+	return run
+}
+
+func restoreScenarioRun(svc *ScenarioService, saved *domain.ScenarioRun, def *domain.ScenarioDefinition) *scenarioRun {
+	run := newScenarioRun(svc, saved.SessionID, saved.ScenarioID, def)
+	run.definition = saved.Definition
+	run.startedAt = saved.StartedAt
+	run.state = saved.State
+	run.elapsed = saved.Elapsed
+	run.lastSim = saved.LastSim
+	for id, st := range saved.Injects {
+		if cur, ok := run.injects[id]; ok {
+			*cur = injectState{state: st.State, at: st.At, firedAt: st.FiredAt, targetID: st.TargetID, err: st.Error}
+		}
+	}
+	return run
+}
+
+// record 生成当前执行状态的数据库记录，调用方不得持有 mu
+func (r *scenarioRun) record() *domain.ScenarioRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved := &domain.ScenarioRun{
+		SessionID:  r.sessionID,
+		ScenarioID: r.scenarioID,
+		Definition: r.definition,
+		State:      r.state,
+		StartedAt:  r.startedAt,
+		Elapsed:    r.elapsed,
+		LastSim:    r.lastSim,
+		Injects:    make(map[string]domain.ScenarioInjectState, len(r.injects)),
+		Owner:      r.svc.instanceID,
+		LeaseUntil: r.leaseUntil,
+	}
+	for id, st := range r.injects {
+		saved.Injects[id] = domain.ScenarioInjectState{State: st.state, At: st.at, FiredAt: st.firedAt, TargetID: st.targetID, Error: st.err}
+	}
+	return saved
+}
+
+// persist 保存当前执行状态并续租。注入发出后、保存前停机时，恢复后该注入会再次发出。
+// 执行记录已被删除 (场次删除) 或被其他实例接管时停止驱动并返回 false
+func (r *scenarioRun) persist() bool {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	r.mu.Lock()
+	r.leaseUntil = r.svc.now().Add(scenarioRunLease)
+	r.mu.Unlock()
+
+	ok, err := r.svc.scenarios.UpdateRun(context.Background(), r.record())
+	if err != nil {
+		// 写库失败时继续执行，租约过期前会再次保存
+		log.Printf("[Scenario] Failed to save run of session %s: %v", r.sessionID, err)
+		return true
+	}
+	if !ok {
+		log.Printf("[Scenario] Run of session %s was deleted or taken over, stop driving it", r.sessionID)
+		r.cancel()
+	}
+	return ok
+}
+
+// sync 读取数据库中的执行记录：记录已被删除或接管时停止驱动，有登记的指令时执行，租约过半时续租。
+// 返回 false 表示本实例不再驱动该脚本
+func (r *scenarioRun) sync() bool {
+	saved, err := r.svc.scenarios.GetRun(r.ctx, r.sessionID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("[Scenario] Failed to load run of session %s: %v", r.sessionID, err)
+			return true
+		}
+		log.Printf("[Scenario] Run of session %s was deleted, stop driving it", r.sessionID)
+		r.cancel()
+		return false
+	}
+	if saved.Owner != r.svc.instanceID {
+		log.Printf("[Scenario] Run of session %s was taken over by another instance", r.sessionID)
+		r.cancel()
+		return false
+	}
+
+	if saved.Command != "" {
+		if err := r.apply(saved.Command, r.svc.clock.Now(r.ctx, r.sessionID)); err != nil {
+			log.Printf("[Scenario] Ignore %s command for session %s: %v", saved.Command, r.sessionID, err)
+		}
+		if !r.persist() {
+			return false
+		}
+		if err := r.svc.scenarios.ClearRunCommand(r.ctx, r.sessionID, saved.Command); err != nil {
+			log.Printf("[Scenario] Failed to clear command of session %s: %v", r.sessionID, err)
+		}
+		if saved.Command == "stop" {
+			r.cancel()
+			return false
+		}
+		return true
+	}
+
+	r.mu.Lock()
+	renew := r.svc.now().After(r.leaseUntil.Add(-scenarioRunLease / 2))
+	r.mu.Unlock()
+	if renew {
+		return r.persist()
+	}
+	return true
+}
+
+func (r *scenarioRun) loop() {
+	defer r.svc.release(r)
+	ticker := time.NewTicker(r.svc.tick)
+	defer ticker.Stop()
+
+	r.step()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			if r.step() {
+				return
+			}
+		}
+	}
+}
+
+// step 推进运行时长并发出所有到期的注入，脚本执行完毕或不再由本实例驱动时返回 true。
+// 场次未在进行中时运行时长不累计，注入顺延到场次继续后发出
+func (r *scenarioRun) step() bool {
+	if !r.sync() {
+		return true
+	}
+	now := r.svc.clock.Now(r.ctx, r.sessionID)
+	if r.svc.sender.RequireRunningSession(r.ctx, r.sessionID) != nil {
+		r.mu.Lock()
+		r.lastSim = now
+		r.mu.Unlock()
+		return false
+	}
+	r.mu.Lock()
+	r.advance(now)
+	if r.state != "running" {
+		r.mu.Unlock()
+		return false
+	}
+	elapsed := r.elapsed
+	r.mu.Unlock()
+
+	done := true
+	for i := range r.def.Injects {
+		if r.ctx.Err() != nil {
+			return true // 执行中被停止或失去记录
+		}
+		inj := &r.def.Injects[i]
+		st := r.injects[inj.ID]
+		if st.state != "pending" {
+			continue
+		}
+		done = false
+
+		due := time.Duration(inj.Offset)
+		var ref *injectState
+		if inj.After != "" {
+			ref = r.injects[inj.After]
+			if ref.state == "pending" {
+				continue
+			}
+			if ref.state != "sent" {
+				// 被引用注入未成功发出，依赖它的注入一并跳过
+				r.finish(st, "skipped", elapsed, "", "")
+				continue
+			}
+			due += ref.at
+		}
+		if due > elapsed {
+			continue
+		}
+
+		if inj.IfUnanswered {
+			refInj := r.findInject(inj.After)
+			ok, err := r.svc.answered(r.ctx, r.sessionID, refInj, ref)
+			if err != nil {
+				r.finish(st, "failed", elapsed, "", err.Error())
+				continue
+			}
+			if ok {
+				r.finish(st, "skipped", elapsed, "", "")
+				continue
+			}
+		}
+
+		targetID, firedAt, err := r.svc.fire(r.ctx, r.sessionID, inj)
+		if err != nil && r.svc.sender.RequireRunningSession(r.ctx, r.sessionID) != nil {
+			return false // 发出时场次已暂停或结束，保留待发，下次推进时重试
+		}
+		if err != nil {
+			log.Printf("[Scenario] Inject %s in session %s failed: %v", inj.ID, r.sessionID, err)
+			r.finish(st, "failed", elapsed, "", err.Error())
+			continue
+		}
+		r.finishAt(st, "sent", elapsed, firedAt, targetID, "")
+	}
+
+	if done {
+		r.mu.Lock()
+		r.state = "completed"
+		r.mu.Unlock()
+		r.persist()
+		r.cancel()
+	}
+	return done
+}
+
+func (r *scenarioRun) finish(st *injectState, state string, at time.Duration, targetID, errMsg string) {
+	r.finishAt(st, state, at, time.Time{}, targetID, errMsg)
+}
+
+// finishAt 记录注入结果，firedAt 为零值时取最近一次推进时的演练时间
+func (r *scenarioRun) finishAt(st *injectState, state string, at time.Duration, firedAt time.Time, targetID, errMsg string) {
+	r.mu.Lock()
+	if firedAt.IsZero() {
+		firedAt = r.lastSim
+	}
+	st.state = state
+	st.at = at
+	st.firedAt = firedAt
+	st.targetID = targetID
+	st.err = errMsg
+	r.mu.Unlock()
+	r.persist()
+}
+
+func (r *scenarioRun) findInject(id string) *domain.ScenarioInject {
+	for i := range r.def.Injects {
+		if r.def.Injects[i].ID == id {
+			return &r.def.Injects[i]
+		}
+	}
+	return nil
+}
+
+func (r *scenarioRun) active() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state == "running" || r.state == "paused"
+}
+
+// advance 按演练时钟推进运行时长，调用方需持有锁；演练时间回拨时不倒退
+func (r *scenarioRun) advance(now time.Time) {
+	if r.state == "running" && now.After(r.lastSim) {
+		r.elapsed += now.Sub(r.lastSim)
+	}
+	r.lastSim = now
+}
+
+// apply 按指令 (pause, resume, stop) 切换状态，不保存
+func (r *scenarioRun) apply(command string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch command {
+	case "pause":
+		if r.state != "running" {
+			return ports.NewInvalidInputError("scenario is not running", nil)
+		}
+		r.advance(now)
+		r.state = "paused"
+	case "resume":
+		if r.state != "paused" {
+			return ports.NewInvalidInputError("scenario is not paused", nil)
+		}
+		r.lastSim = now
+		r.state = "running"
+	case "stop":
+		if r.state != "running" && r.state != "paused" {
+			return ports.NewInvalidInputError("scenario is not running", nil)
+		}
+		r.state = "stopped"
+	default:
+		return ports.NewInvalidInputError(fmt.Sprintf("unknown scenario command %q", command), nil)
+	}
+	return nil
+}
+
+func (r *scenarioRun) status() *ports.ScenarioRunStatus {
+	now := r.svc.clock.Now(r.ctx, r.sessionID)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elapsed := r.elapsed
+	if r.state == "running" && now.After(r.lastSim) {
+		elapsed += now.Sub(r.lastSim)
+	}
+
+	status := &ports.ScenarioRunStatus{
+		SessionID:  r.sessionID,
+		ScenarioID: r.scenarioID,
+		Status:     r.state,
+		StartedAt:  r.startedAt,
+		Elapsed:    elapsed.Truncate(time.Second).String(),
+	}
+	for _, inj := range r.def.Injects {
+		st := r.injects[inj.ID]
+		stat := ports.ScenarioInjectStat{ID: inj.ID, State: st.state, TargetID: st.targetID, Error: st.err}
+		if st.state != "pending" {
+			firedAt := st.firedAt
+			stat.FiredAt = &firedAt
+		}
+		status.Injects = append(status.Injects, stat)
+	}
+	return status
+}
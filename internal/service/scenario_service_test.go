@@ -0,0 +1,374 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// stubSender 记录脚本引擎发出的注入
+type stubSender struct {
+	mu      sync.Mutex
+	mails   []ports.SendMailRequest
+	chats   []ports.SendChatMessageRequest
+	stopped bool // 模拟场次未在进行中
+}
+
+func (s *stubSender) RequireRunningSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return ports.NewForbiddenError("session is not running", nil)
+	}
+	return nil
+}
+
+func (s *stubSender) setStopped(stopped bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = stopped
+}
+
+func (s *stubSender) sent() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.mails) + len(s.chats)
+}
+
+func (s *stubSender) SendMail(ctx context.Context, senderID string, req ports.SendMailRequest) (*domain.Mail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mails = append(s.mails, req)
+	return &domain.Mail{ID: "mail-" + req.Subject, SessionID: req.SessionID, SenderID: senderID}, nil
+}
+
+func (s *stubSender) SendChatMessage(ctx context.Context, senderID string, req ports.SendChatMessageRequest) (*domain.ChatMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chats = append(s.chats, req)
+	return &domain.ChatMessage{ID: "chat-1", SessionID: req.SessionID, SenderID: senderID}, nil
+}
+
+// memScenarioRuns 以内存模拟共享的执行记录表，语义与 repository.ScenarioRepository 一致
+type memScenarioRuns struct {
+	*MockScenarioRepository
+	mu   sync.Mutex
+	runs map[string]domain.ScenarioRun
+}
+
+func newMemScenarioRuns(runs ...domain.ScenarioRun) *memScenarioRuns {
+	m := &memScenarioRuns{MockScenarioRepository: new(MockScenarioRepository), runs: make(map[string]domain.ScenarioRun)}
+	for _, run := range runs {
+		m.runs[run.SessionID] = run
+	}
+	return m
+}
+
+func activeRun(state string) bool {
+	return state == "running" || state == "paused"
+}
+
+func (m *memScenarioRuns) CreateRun(ctx context.Context, run *domain.ScenarioRun) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cur, ok := m.runs[run.SessionID]; ok && activeRun(cur.State) {
+		return false, nil
+	}
+	m.runs[run.SessionID] = *run
+	return true, nil
+}
+
+func (m *memScenarioRuns) GetRun(ctx context.Context, sessionID string) (*domain.ScenarioRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run, ok := m.runs[sessionID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &run, nil
+}
+
+func (m *memScenarioRuns) ListRuns(ctx context.Context) ([]domain.ScenarioRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var runs []domain.ScenarioRun
+	for _, run := range m.runs {
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+func (m *memScenarioRuns) ClaimRun(ctx context.Context, sessionID, owner string, now, leaseUntil time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run, ok := m.runs[sessionID]
+	if !ok || !activeRun(run.State) || (run.Owner != owner && !run.LeaseUntil.Before(now)) {
+		return false, nil
+	}
+	run.Owner, run.LeaseUntil = owner, leaseUntil
+	m.runs[sessionID] = run
+	return true, nil
+}
+
+func (m *memScenarioRuns) UpdateRun(ctx context.Context, run *domain.ScenarioRun) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.runs[run.SessionID]
+	if !ok || cur.Owner != run.Owner {
+		return false, nil
+	}
+	cur.State, cur.Elapsed, cur.LastSim, cur.Injects, cur.LeaseUntil = run.State, run.Elapsed, run.LastSim, run.Injects, run.LeaseUntil
+	m.runs[run.SessionID] = cur
+	return true, nil
+}
+
+func (m *memScenarioRuns) SetRunCommand(ctx context.Context, sessionID, command string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run, ok := m.runs[sessionID]
+	if !ok || !activeRun(run.State) {
+		return false, nil
+	}
+	run.Command = command
+	m.runs[sessionID] = run
+	return true, nil
+}
+
+func (m *memScenarioRuns) ClearRunCommand(ctx context.Context, sessionID, command string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if run, ok := m.runs[sessionID]; ok && run.Command == command {
+		run.Command = ""
+		m.runs[sessionID] = run
+	}
+	return nil
+}
+
+func (m *memScenarioRuns) get(sessionID string) domain.ScenarioRun {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.runs[sessionID]
+}
+
+func (m *memScenarioRuns) delete(sessionID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.runs, sessionID)
+}
+
+const testScenario = `{
+	"name": "baseline",
+	"injects": [
+		{"id": "order", "type": "mail", "offset": "0s", "sender_id": "hq", "to": ["team"], "subject": "order", "content": "move"},
+		{"id": "ping", "type": "chat", "offset": 0, "sender_id": "hq", "to": ["team"], "content": "ack?"},
+		{"id": "followup", "type": "mail", "after": "order", "offset": "0s", "if_unanswered": true, "sender_id": "hq", "to": ["team"], "subject": "followup", "content": "status?"},
+		{"id": "nudge", "type": "chat", "after": "ping", "offset": "0s", "if_unanswered": true, "sender_id": "hq", "to": ["team"], "content": "?"}
+	]
+}`
+
+func TestScenarioService_Validate(t *testing.T) {
+	ctx := context.TODO()
+	svc := NewScenarioService(new(MockScenarioRepository), new(MockMailRepository), &stubSender{}, NewClockService(newRunningSessions()), t.TempDir())
+
+	_, err := svc.CreateScenario(ctx, []byte(`{"name":"x","injects":[{"id":"a","type":"mail","after":"b","sender_id":"hq","to":["t"]}]}`))
+	assert.Error(t, err)
+
+	_, err = svc.CreateScenario(ctx, []byte(`{"name":"x","injects":[{"id":"a","type":"fax","sender_id":"hq","to":["t"]}]}`))
+	assert.Error(t, err)
+}
+
+func TestScenarioService_Run(t *testing.T) {
+	ctx := context.TODO()
+	scenarioRepo := newMemScenarioRuns()
+	mailRepo := new(MockMailRepository)
+	sender := &stubSender{}
+	svc := NewScenarioService(scenarioRepo, mailRepo, sender, NewClockService(newRunningSessions()), t.TempDir())
+	svc.tick = 5 * time.Millisecond
+
+	scenarioRepo.On("GetByID", ctx, "sc-1").Return(&domain.Scenario{ID: "sc-1", Definition: testScenario}, nil)
+	// 文电已被回复 -> 跳过催办；即时消息未回复 -> 发出跟进
+	mailRepo.On("HasReply", mock.Anything, "session-1", "mail-order").Return(true, nil)
+	mailRepo.On("HasChatReply", mock.Anything, "session-1", "team", "hq", mock.AnythingOfType("time.Time")).Return(false, nil)
+
+	_, err := svc.StartRun(ctx, "session-1", "sc-1")
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		status, _ := svc.GetRunStatus(ctx, "session-1")
+		return status.Status == "completed"
+	}, time.Second, 5*time.Millisecond)
+
+	status, _ := svc.GetRunStatus(ctx, "session-1")
+	states := map[string]string{}
+	for _, inj := range status.Injects {
+		states[inj.ID] = inj.State
+	}
+	assert.Equal(t, map[string]string{"order": "sent", "ping": "sent", "followup": "skipped", "nudge": "sent"}, states)
+	assert.Len(t, sender.mails, 1)
+	assert.Len(t, sender.chats, 2)
+
+	// 最终状态已落库，可在重启后恢复
+	saved := scenarioRepo.get("session-1")
+	assert.Equal(t, "completed", saved.State)
+	assert.Equal(t, testScenario, saved.Definition)
+	assert.Equal(t, "mail-order", saved.Injects["order"].TargetID)
+}
+
+func TestScenarioService_RestoreRuns(t *testing.T) {
+	ctx := context.TODO()
+	mailRepo := new(MockMailRepository)
+	sender := &stubSender{}
+
+	// 重启前 order 与 ping 已发出，followup 与 nudge 尚未到期
+	now := time.Now()
+	scenarioRepo := newMemScenarioRuns(
+		domain.ScenarioRun{
+			SessionID: "session-1", ScenarioID: "sc-1", Definition: testScenario, State: "running",
+			StartedAt: now, LastSim: now,
+			Injects: map[string]domain.ScenarioInjectState{
+				"order":    {State: "sent", FiredAt: now, TargetID: "mail-order"},
+				"ping":     {State: "sent", FiredAt: now, TargetID: "chat-1"},
+				"followup": {State: "pending"},
+				"nudge":    {State: "pending"},
+			},
+		},
+		domain.ScenarioRun{SessionID: "session-2", ScenarioID: "sc-1", Definition: testScenario, State: "stopped", StartedAt: now, LastSim: now},
+	)
+	svc := NewScenarioService(scenarioRepo, mailRepo, sender, NewClockService(newRunningSessions()), t.TempDir())
+	svc.tick = 5 * time.Millisecond
+	mailRepo.On("HasReply", mock.Anything, "session-1", "mail-order").Return(false, nil)
+	mailRepo.On("HasChatReply", mock.Anything, "session-1", "team", "hq", mock.AnythingOfType("time.Time")).Return(true, nil)
+
+	assert.NoError(t, svc.RestoreRuns(ctx))
+
+	assert.Eventually(t, func() bool {
+		status, _ := svc.GetRunStatus(ctx, "session-1")
+		return status.Status == "completed"
+	}, time.Second, 5*time.Millisecond)
+
+	// 已发出的注入不会重发
+	assert.Len(t, sender.mails, 1)
+	assert.Equal(t, "followup", sender.mails[0].Subject)
+	assert.Empty(t, sender.chats)
+
+	status, err := svc.GetRunStatus(ctx, "session-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "stopped", status.Status)
+}
+
+func TestScenarioService_MultipleInstances(t *testing.T) {
+	ctx := context.TODO()
+	scenarioRepo := newMemScenarioRuns()
+	clock := NewClockService(newRunningSessions())
+	scenarioRepo.On("GetByID", ctx, "sc-1").Return(&domain.Scenario{ID: "sc-1", Definition: testScenario}, nil)
+	mailRepo := new(MockMailRepository)
+	mailRepo.On("HasReply", mock.Anything, "session-1", "mail-order").Return(true, nil)
+	mailRepo.On("HasChatReply", mock.Anything, "session-1", "team", "hq", mock.AnythingOfType("time.Time")).Return(false, nil)
+
+	// 后两条注入在一小时后才到期，脚本保持执行中
+	def := strings.Replace(testScenario, `"after": "order", "offset": "0s"`, `"after": "order", "offset": "1h"`, 1)
+	def = strings.Replace(def, `"after": "ping", "offset": "0s"`, `"after": "ping", "offset": "1h"`, 1)
+	scenarioRepo.On("GetByID", ctx, "sc-long").Return(&domain.Scenario{ID: "sc-long", Definition: def}, nil)
+
+	a := NewScenarioService(scenarioRepo, mailRepo, &stubSender{}, clock, t.TempDir())
+	b := NewScenarioService(scenarioRepo, mailRepo, &stubSender{}, clock, t.TempDir())
+	a.tick, b.tick = 5*time.Millisecond, 5*time.Millisecond
+
+	_, err := a.StartRun(ctx, "session-1", "sc-long")
+	assert.NoError(t, err)
+	_, err = b.StartRun(ctx, "session-1", "sc-1")
+	assert.Error(t, err)
+
+	// b 不会接管仍在租约内的脚本
+	assert.NoError(t, b.RestoreRuns(ctx))
+	assert.Nil(t, b.localRun("session-1"))
+
+	// 其他实例上的暂停、继续、停止由驱动实例执行
+	status, err := b.PauseRun(ctx, "session-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "paused", status.Status)
+	_, err = b.PauseRun(ctx, "session-1")
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool {
+		status, _ := a.GetRunStatus(ctx, "session-1")
+		return status.Status == "paused" && scenarioRepo.get("session-1").Command == ""
+	}, time.Second, 5*time.Millisecond)
+
+	status, err = b.ResumeRun(ctx, "session-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "running", status.Status)
+	assert.Eventually(t, func() bool {
+		status, _ := a.GetRunStatus(ctx, "session-1")
+		return status.Status == "running"
+	}, time.Second, 5*time.Millisecond)
+
+	_, err = b.StopRun(ctx, "session-1")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return a.localRun("session-1") == nil
+	}, time.Second, 5*time.Millisecond)
+	status, err = b.GetRunStatus(ctx, "session-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "stopped", status.Status)
+	assert.Equal(t, "stopped", scenarioRepo.get("session-1").State)
+
+	// 驱动实例停机、租约过期后由其他实例接管，原实例不再驱动
+	_, err = a.StartRun(ctx, "session-1", "sc-long")
+	assert.NoError(t, err)
+	b.now = func() time.Time { return time.Now().Add(2 * scenarioRunLease) }
+	assert.NoError(t, b.RestoreRuns(ctx))
+	assert.NotNil(t, b.localRun("session-1"))
+	assert.Eventually(t, func() bool {
+		return a.localRun("session-1") == nil
+	}, time.Second, 5*time.Millisecond)
+
+	// 场次删除时执行记录一并删除，驱动实例随之停止
+	scenarioRepo.delete("session-1")
+	assert.Eventually(t, func() bool {
+		return b.localRun("session-1") == nil
+	}, time.Second, 5*time.Millisecond)
+	_, err = b.GetRunStatus(ctx, "session-1")
+	assert.Error(t, err)
+}
+
+func TestScenarioService_DefersWhileSessionNotRunning(t *testing.T) {
+	ctx := context.TODO()
+	scenarioRepo := newMemScenarioRuns()
+	mailRepo := new(MockMailRepository)
+	sender := &stubSender{stopped: true}
+	svc := NewScenarioService(scenarioRepo, mailRepo, sender, NewClockService(newRunningSessions()), t.TempDir())
+	svc.tick = 5 * time.Millisecond
+
+	scenarioRepo.On("GetByID", ctx, "sc-1").Return(&domain.Scenario{ID: "sc-1", Definition: testScenario}, nil)
+	mailRepo.On("HasReply", mock.Anything, "session-1", "mail-order").Return(true, nil)
+	mailRepo.On("HasChatReply", mock.Anything, "session-1", "team", "hq", mock.AnythingOfType("time.Time")).Return(false, nil)
+
+	_, err := svc.StartRun(ctx, "session-1", "sc-1")
+	assert.NoError(t, err)
+
+	// 场次暂停期间注入保持待发，不会被记为失败
+	time.Sleep(50 * time.Millisecond)
+	status, err := svc.GetRunStatus(ctx, "session-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "running", status.Status)
+	for _, inj := range status.Injects {
+		assert.Equal(t, "pending", inj.State, inj.ID)
+	}
+	assert.Zero(t, sender.sent())
+
+	sender.setStopped(false)
+	assert.Eventually(t, func() bool {
+		status, _ := svc.GetRunStatus(ctx, "session-1")
+		return status.Status == "completed"
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 3, sender.sent())
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"gorm.io/gorm"
+)
+
+// DefaultSessionID 未指定场次时使用的默认场次
+const DefaultSessionID = "default"
+
+// sessionTransitions 场次生命周期允许的状态迁移
+var sessionTransitions = map[string][]string{
+	domain.SessionStatusDraft:   {domain.SessionStatusRunning, domain.SessionStatusEnded},
+	domain.SessionStatusRunning: {domain.SessionStatusPaused, domain.SessionStatusEnded},
+	domain.SessionStatusPaused:  {domain.SessionStatusRunning, domain.SessionStatusEnded},
+	domain.SessionStatusEnded:   {domain.SessionStatusArchived},
+}
+
+type SessionService struct {
+	sessions ports.SessionRepository
+	mails    ports.MailRepository
+}
+
+func NewSessionService(sessions ports.SessionRepository, mails ports.MailRepository) *SessionService {
+	return &SessionService{sessions: sessions, mails: mails}
+}
+
+func (s *SessionService) CreateSession(ctx context.Context, req ports.SessionRequest) (*domain.Session, error) {
+	id := strings.TrimSpace(req.ID)
+	if id != "" && !sessionIDPattern.MatchString(id) {
+		return nil, ports.NewInvalidInputError("session ID may only contain letters, digits, '-' and '_'", nil)
+	}
+	if strings.HasPrefix(id, snapshotSessionPrefix) {
+		return nil, ports.NewInvalidInputError("session ID is reserved", nil)
+	}
+	if id != "" {
+		if _, err := s.sessions.GetByID(ctx, id); err == nil {
+			return nil, ports.NewInvalidInputError("session already exists", nil)
+		}
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = id
+	}
+	relay, err := normalizeRelayAddresses(req.RelayAddresses)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &domain.Session{
+		ID:             id,
+		Name:           name,
+		Status:         domain.SessionStatusDraft,
+		Participants:   req.Participants,
+		RelayAddresses: relay,
+	}
+	if req.Description != nil {
+		session.Description = *req.Description
+	}
+	if err := s.sessions.Create(ctx, session); err != nil {
+		return nil, ports.NewInternalError("failed to create session", err)
+	}
+	return session, nil
+}
+
+func (s *SessionService) GetSession(ctx context.Context, id string) (*domain.Session, error) {
+	session, err := s.sessions.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ports.NewNotFoundError("session not found", err)
+		}
+		return nil, ports.NewInternalError("failed to load session", err)
+	}
+	return session, nil
+}
+
+func (s *SessionService) ListSessions(ctx context.Context, status string) ([]domain.Session, error) {
+	return s.sessions.List(ctx, status)
+}
+
+func (s *SessionService) UpdateSession(ctx context.Context, id string, req ports.SessionRequest) (*domain.Session, error) {
+	session, err := s.GetSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	if name := strings.TrimSpace(req.Name); name != "" {
+		session.Name = name
+		columns = append(columns, "name")
+	}
+	if req.Description != nil {
+		session.Description = *req.Description
+		columns = append(columns, "description")
+	}
+	if req.Participants != nil {
+		session.Participants = req.Participants
+		columns = append(columns, "participants")
+	}
+	if req.RelayAddresses != nil {
+		relay, err := normalizeRelayAddresses(req.RelayAddresses)
+		if err != nil {
+			return nil, err
+		}
+		session.RelayAddresses = relay
+		columns = append(columns, "relay_addresses")
+	}
+	if len(columns) == 0 {
+		return session, nil
+	}
+
+	if err := s.sessions.Update(ctx, session, columns...); err != nil {
+		return nil, ports.NewInternalError("failed to update session", err)
+	}
+	return session, nil
+}
+
+// normalizeRelayAddresses 校验外部邮箱映射，只保留纯地址部分
+func normalizeRelayAddresses(in map[string]string) (map[string]string, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string, len(in))
+	for userID, raw := range in {
+		addr, err := mail.ParseAddress(raw)
+		if err != nil || strings.TrimSpace(userID) == "" {
+			return nil, ports.NewInvalidInputError("invalid relay address for "+userID, err)
+		}
+		out[strings.TrimSpace(userID)] = addr.Address
+	}
+	return out, nil
+}
+
+// ChangeStatus 按生命周期规则迁移场次状态，并记录开始/结束时间
+func (s *SessionService) ChangeStatus(ctx context.Context, id, status string) (*domain.Session, error) {
+	session, err := s.GetSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := false
+	for _, next := range sessionTransitions[session.Status] {
+		if next == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, ports.NewInvalidInputError("cannot change session status from "+session.Status+" to "+status, nil)
+	}
+
+	now := time.Now()
+	switch status {
+	case domain.SessionStatusRunning:
+		if session.StartedAt == nil {
+			session.StartedAt = &now
+		}
+	case domain.SessionStatusEnded:
+		session.EndedAt = &now
+	}
+	session.Status = status
+
+	if err := s.sessions.Update(ctx, session, "status", "started_at", "ended_at"); err != nil {
+		return nil, ports.NewInternalError("failed to update session", err)
+	}
+	return session, nil
+}
+
+// Bootstrap 为历史数据中仅以 session_id 字符串存在的场次补建场次记录，
+// 并确保默认场次存在。补建的场次均视为进行中，保持升级前的可写行为；
+// 历史场次仍由宿主名单管理，默认场次为本地场次。
+func (s *SessionService) Bootstrap(ctx context.Context) error {
+	ids, err := s.mails.ListDataSessionIDs(ctx)
+	if err != nil {
+		return err
+	}
+	ids = append(ids, DefaultSessionID)
+
+	for _, id := range ids {
+		if _, err := s.sessions.GetByID(ctx, id); err == nil {
+			continue
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		now := time.Now()
+		session := &domain.Session{
+			ID:        id,
+			Name:      id,
+			Status:    domain.SessionStatusRunning,
+			Origin:    domain.SessionOriginHost,
+			StartedAt: &now,
+		}
+		if id == DefaultSessionID {
+			session.Origin = domain.SessionOriginLocal
+		}
+		if err := s.sessions.Create(ctx, session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestSessionService_CreateSessionValidatesID(t *testing.T) {
+	ctx := context.TODO()
+	mockSessions := new(MockSessionRepository)
+	svc := NewSessionService(mockSessions, new(MockMailRepository))
+
+	// 场次 ID 用作目录名，不允许路径分隔符等字符
+	for _, id := range []string{"../etc", "a/b", "a b", "-x", "场次"} {
+		_, err := svc.CreateSession(ctx, ports.SessionRequest{ID: id})
+		assert.Error(t, err, id)
+	}
+	mockSessions.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+
+	mockSessions.On("GetByID", ctx, "drill-1").Return(nil, gorm.ErrRecordNotFound)
+	mockSessions.On("Create", ctx, mock.AnythingOfType("*domain.Session")).Return(nil)
+	session, err := svc.CreateSession(ctx, ports.SessionRequest{ID: " drill-1 "})
+	assert.NoError(t, err)
+	assert.Equal(t, "drill-1", session.ID)
+	assert.Equal(t, "drill-1", session.Name)
+}
+
+func TestSessionService_UpdateSessionKeepsOmittedFields(t *testing.T) {
+	ctx := context.TODO()
+	mockSessions := new(MockSessionRepository)
+	svc := NewSessionService(mockSessions, new(MockMailRepository))
+
+	mockSessions.On("GetByID", ctx, "s1").Return(&domain.Session{ID: "s1", Name: "old", Description: "brief", Participants: []string{"u1"}}, nil)
+	mockSessions.On("Update", ctx, mock.AnythingOfType("*domain.Session"), []string{"name"}).Return(nil)
+
+	session, err := svc.UpdateSession(ctx, "s1", ports.SessionRequest{Name: "new"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "new", session.Name)
+	assert.Equal(t, "brief", session.Description)
+	assert.Equal(t, []string{"u1"}, session.Participants)
+	mockSessions.AssertExpectations(t)
+}
+
+func TestSessionService_ChangeStatus(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("Start draft session", func(t *testing.T) {
+		mockSessions := new(MockSessionRepository)
+		svc := NewSessionService(mockSessions, new(MockMailRepository))
+
+		mockSessions.On("GetByID", ctx, "s1").Return(&domain.Session{ID: "s1", Status: domain.SessionStatusDraft}, nil)
+		mockSessions.On("Update", ctx, mock.AnythingOfType("*domain.Session"), []string{"status", "started_at", "ended_at"}).Return(nil)
+
+		session, err := svc.ChangeStatus(ctx, "s1", domain.SessionStatusRunning)
+
+		assert.NoError(t, err)
+		assert.Equal(t, domain.SessionStatusRunning, session.Status)
+		assert.NotNil(t, session.StartedAt)
+	})
+
+	t.Run("Reject invalid transition", func(t *testing.T) {
+		mockSessions := new(MockSessionRepository)
+		svc := NewSessionService(mockSessions, new(MockMailRepository))
+
+		mockSessions.On("GetByID", ctx, "s1").Return(&domain.Session{ID: "s1", Status: domain.SessionStatusArchived}, nil)
+
+		_, err := svc.ChangeStatus(ctx, "s1", domain.SessionStatusRunning)
+
+		assert.Error(t, err)
+		mockSessions.AssertNotCalled(t, "Update")
+	})
+}
+
+func TestSessionService_Bootstrap(t *testing.T) {
+	ctx := context.TODO()
+	mockSessions := new(MockSessionRepository)
+	mockRepo := new(MockMailRepository)
+	svc := NewSessionService(mockSessions, mockRepo)
+
+	mockRepo.On("ListDataSessionIDs", ctx).Return([]string{"legacy"}, nil)
+	mockSessions.On("GetByID", ctx, "legacy").Return(nil, gorm.ErrRecordNotFound)
+	mockSessions.On("GetByID", ctx, DefaultSessionID).Return(&domain.Session{ID: DefaultSessionID}, nil)
+	mockSessions.On("Create", ctx, mock.MatchedBy(func(s *domain.Session) bool {
+		return s.ID == "legacy" && s.Status == domain.SessionStatusRunning && s.Origin == domain.SessionOriginHost
+	})).Return(nil)
+
+	assert.NoError(t, svc.Bootstrap(ctx))
+	mockSessions.AssertExpectations(t)
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+	"raven/internal/fsutil"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// snapshotSessionPrefix 快照备份场次 ID 前缀，此类场次仅用于保存还原点数据
+const snapshotSessionPrefix = "snapshot-"
+
+// errFilesNotRolledBack 还原失败后未能换回场次原目录，场次处于部分还原状态
+var errFilesNotRolledBack = errors.New("session files could not be rolled back")
+
+// restoreStagingPrefix 还原快照时暂存文件的临时场次 ID 前缀
+const restoreStagingPrefix = snapshotSessionPrefix + "restore-"
+
+// sessionIDPattern 场次 ID 同时用作 uploads / data 下的目录名，仅允许安全字符
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// CloneSession 将场次完整复制为一个新场次（数据库记录 + 上传附件 + 在线文档）
+func (s *MailService) CloneSession(ctx context.Context, sessionID string, req ports.CloneSessionRequest) error {
+	target := strings.TrimSpace(req.TargetSessionID)
+	if sessionID == "" || target == "" {
+		return ports.NewInvalidInputError("source and target session ID are required", nil)
+	}
+	if !sessionIDPattern.MatchString(target) {
+		return ports.NewInvalidInputError("target session ID may only contain letters, digits, '-' and '_'", nil)
+	}
+	if sessionID == target {
+		return ports.NewInvalidInputError("target session must differ from source session", nil)
+	}
+	if strings.HasPrefix(target, snapshotSessionPrefix) {
+		return ports.NewInvalidInputError("target session ID is reserved", nil)
+	}
+
+	found, err := s.sessionExists(ctx, sessionID)
+	if err != nil {
+		return ports.NewInternalError("failed to check source session", err)
+	}
+	if !found {
+		return ports.NewNotFoundError("source session not found", nil)
+	}
+	exists, err := s.sessionHasData(ctx, target)
+	if err != nil {
+		return ports.NewInternalError("failed to check target session", err)
+	}
+	if exists {
+		return ports.NewInvalidInputError("target session already contains data", nil)
+	}
+
+	if err := s.cloneSession(ctx, sessionID, target, req.ResetRead); err != nil {
+		return err
+	}
+
+	// 目标场次可由宿主预先创建；未创建时按源场次信息生成草稿场次
+	if _, err := s.sessions.GetByID(ctx, target); err == nil {
+		return nil
+	}
+	cloned := &domain.Session{ID: target, Name: target, Status: domain.SessionStatusDraft}
+	if src, err := s.sessions.GetByID(ctx, sessionID); err == nil {
+		cloned.Name = src.Name + " (" + target + ")"
+		cloned.Description = src.Description
+		cloned.Participants = src.Participants
+	}
+	if err := s.sessions.Create(ctx, cloned); err != nil {
+		return ports.NewInternalError("failed to create cloned session", err)
+	}
+	return nil
+}
+
+// sessionExists 判断场次是否存在: 已登记在场次表中，或是仅有数据的历史场次
+func (s *MailService) sessionExists(ctx context.Context, sessionID string) (bool, error) {
+	_, err := s.sessions.GetByID(ctx, sessionID)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	return s.sessionHasData(ctx, sessionID)
+}
+
+// sessionHasData 判断场次是否已有数据库记录、上传附件或在线文档
+func (s *MailService) sessionHasData(ctx context.Context, sessionID string) (bool, error) {
+	if has, err := s.repo.SessionHasData(ctx, sessionID); err != nil || has {
+		return has, err
+	}
+	if has, err := s.storage.SessionDirExists(ctx, sessionID); err != nil || has {
+		return has, err
+	}
+	return fsutil.DirExists(filepath.Join(dataDir, sessionID))
+}
+
+// cloneSession 先复制文件再复制数据库记录，数据库失败时清理已复制的文件
+func (s *MailService) cloneSession(ctx context.Context, src, dst string, resetRead bool) error {
+	if err := s.copySessionFiles(ctx, src, dst); err != nil {
+		return err
+	}
+	opts := ports.CloneOptions{ResetRead: resetRead, MapFilePath: sessionPathMapper(src, dst)}
+	if err := s.repo.CloneSession(ctx, src, dst, opts); err != nil {
+		s.removeSessionFiles(ctx, dst)
+		return ports.NewInternalError("failed to clone session records", err)
+	}
+	return nil
+}
+
+// copySessionFiles 复制场次的上传附件与在线文档，失败时清理已复制的部分
+func (s *MailService) copySessionFiles(ctx context.Context, src, dst string) error {
+	if err := s.storage.CopySessionDir(ctx, src, dst); err != nil {
+		_ = s.storage.DeleteSessionDir(ctx, dst)
+		return ports.NewInternalError("failed to copy session files", err)
+	}
+	if err := fsutil.CopyDir(filepath.Join(dataDir, src), filepath.Join(dataDir, dst)); err != nil {
+		s.removeSessionFiles(ctx, dst)
+		return ports.NewInternalError("failed to copy session documents", err)
+	}
+	return nil
+}
+
+func (s *MailService) removeSessionFiles(ctx context.Context, sessionID string) {
+	_ = s.storage.DeleteSessionDir(ctx, sessionID)
+	_ = os.RemoveAll(filepath.Join(dataDir, sessionID))
+}
+
+// sessionPathMapper 将附件路径中的源场次目录替换为目标场次目录
+func sessionPathMapper(src, dst string) func(string) string {
+	return func(path string) string {
+		// 附件路径结构: {session_id}/YYYY/MM/DD/uuid-filename
+		if rest, ok := strings.CutPrefix(path, src+string(filepath.Separator)); ok {
+			return filepath.Join(dst, rest)
+		}
+		return path
+	}
+}
+
+// CreateSnapshot 为场次创建命名还原点
+func (s *MailService) CreateSnapshot(ctx context.Context, sessionID, name string) (*domain.SessionSnapshot, error) {
+	name = strings.TrimSpace(name)
+	if sessionID == "" || name == "" {
+		return nil, ports.NewInvalidInputError("session ID and snapshot name are required", nil)
+	}
+
+	backupID := snapshotSessionPrefix + uuid.New().String()
+	if err := s.cloneSession(ctx, sessionID, backupID, false); err != nil {
+		return nil, err
+	}
+
+	snapshot := &domain.SessionSnapshot{
+		SessionID:       sessionID,
+		Name:            name,
+		BackupSessionID: backupID,
+	}
+	if err := s.repo.CreateSnapshot(ctx, snapshot); err != nil {
+		_ = s.deleteSessionData(ctx, backupID)
+		return nil, ports.NewInternalError("failed to save snapshot", err)
+	}
+	return snapshot, nil
+}
+
+func (s *MailService) ListSnapshots(ctx context.Context, sessionID string) ([]domain.SessionSnapshot, error) {
+	return s.repo.ListSnapshots(ctx, sessionID)
+}
+
+// RestoreSnapshot 以快照内容替换场次当前数据。快照文件先复制到临时目录，
+// 以改名方式换入场次目录 (原目录改名保留)，再在同一事务内清空并重建数据库记录；
+// 事务失败时换回原目录。只有换回原目录也失败时场次才处于部分还原状态，返回的错误会注明
+func (s *MailService) RestoreSnapshot(ctx context.Context, sessionID, snapshotID string) error {
+	snapshot, err := s.repo.GetSnapshot(ctx, sessionID, snapshotID)
+	if err != nil {
+		return ports.NewNotFoundError("snapshot not found", err)
+	}
+
+	staging := restoreStagingPrefix + uuid.New().String()
+	previous := restoreStagingPrefix + uuid.New().String()
+	if err := s.copySessionFiles(ctx, snapshot.BackupSessionID, staging); err != nil {
+		return err
+	}
+	defer s.removeSessionFiles(ctx, staging)
+
+	undo, err := s.swapSessionFiles(ctx, staging, sessionID, previous)
+	if errors.Is(err, errFilesNotRolledBack) {
+		log.Printf("[Snapshot] Failed to roll back files of session %s, previous files kept as %s: %v", sessionID, previous, err)
+		return ports.NewInternalError("failed to restore session files; session files are partially restored", err)
+	}
+	if err != nil {
+		s.removeSessionFiles(ctx, previous)
+		return ports.NewInternalError("failed to restore session files", err)
+	}
+	// 附件路径直接映射到正式场次目录
+	opts := ports.CloneOptions{Replace: true, MapFilePath: sessionPathMapper(snapshot.BackupSessionID, sessionID)}
+	if err := s.repo.CloneSession(ctx, snapshot.BackupSessionID, sessionID, opts); err != nil {
+		if uerr := undo(); uerr != nil {
+			log.Printf("[Snapshot] Failed to roll back files of session %s, previous files kept as %s: %v", sessionID, previous, uerr)
+			return ports.NewInternalError("failed to restore session records; session files are partially restored", errors.Join(err, errFilesNotRolledBack, uerr))
+		}
+		s.removeSessionFiles(ctx, previous)
+		return ports.NewInternalError("failed to restore session records", err)
+	}
+	s.removeSessionFiles(ctx, previous)
+	return nil
+}
+
+// swapSessionFiles 以 staging 的附件与在线文档目录替换场次目录，原目录改名为 previous 保留，
+// 返回的 undo 将原目录换回。替换中途失败时已替换的目录立即换回
+func (s *MailService) swapSessionFiles(ctx context.Context, staging, sessionID, previous string) (func() error, error) {
+	moves := []func(src, dst string) error{
+		func(src, dst string) error { return s.storage.ReplaceSessionDir(ctx, src, dst) },
+		func(src, dst string) error {
+			return fsutil.ReplaceDir(filepath.Join(dataDir, src), filepath.Join(dataDir, dst))
+		},
+	}
+
+	var done []func(src, dst string) error
+	undo := func() error {
+		var errs []error
+		for i := len(done) - 1; i >= 0; i-- {
+			errs = append(errs, done[i](previous, sessionID))
+		}
+		return errors.Join(errs...)
+	}
+	for _, move := range moves {
+		if err := move(sessionID, previous); err != nil {
+			if uerr := undo(); uerr != nil {
+				return nil, errors.Join(err, errFilesNotRolledBack, uerr)
+			}
+			return nil, err
+		}
+		if err := move(staging, sessionID); err != nil {
+			if uerr := errors.Join(move(previous, sessionID), undo()); uerr != nil {
+				return nil, errors.Join(err, errFilesNotRolledBack, uerr)
+			}
+			return nil, err
+		}
+		done = append(done, move)
+	}
+	return undo, nil
+}
+
+func (s *MailService) DeleteSnapshot(ctx context.Context, sessionID, snapshotID string) error {
+	snapshot, err := s.repo.GetSnapshot(ctx, sessionID, snapshotID)
+	if err != nil {
+		return ports.NewNotFoundError("snapshot not found", err)
+	}
+	return s.deleteSnapshot(ctx, snapshot)
+}
+
+func (s *MailService) deleteSnapshot(ctx context.Context, snapshot *domain.SessionSnapshot) error {
+	if err := s.deleteSessionData(ctx, snapshot.BackupSessionID); err != nil {
+		return err
+	}
+	return s.repo.DeleteSnapshot(ctx, snapshot.ID)
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"raven/internal/core/ports"
+)
+
+// DefaultVolumeBucket 通信量统计默认的时间桶宽度
+const DefaultVolumeBucket = 5 * time.Minute
+
+// DefaultLatencyBounds 响应耗时分布默认的桶上界 (秒)
+var DefaultLatencyBounds = []int{60, 300, 900, 1800, 3600, 14400}
+
+// StatsService 场次通信量统计，聚合计算均在 MailRepository 中以分组 SQL 完成
+type StatsService struct {
+	mails    ports.MailRepository
+	sessions ports.SessionRepository
+}
+
+func NewStatsService(mails ports.MailRepository, sessions ports.SessionRepository) *StatsService {
+	return &StatsService{mails: mails, sessions: sessions}
+}
+
+func (s *StatsService) GetVolume(ctx context.Context, sessionID string, bucket time.Duration) ([]ports.VolumeBucket, error) {
+	if err := s.requireSession(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	if bucket == 0 {
+		bucket = DefaultVolumeBucket
+	}
+	if bucket < time.Second {
+		return nil, ports.NewInvalidInputError("bucket must be at least 1s", nil)
+	}
+
+	buckets, err := s.mails.GetVolumeStats(ctx, sessionID, int64(bucket/time.Second))
+	if err != nil {
+		return nil, ports.NewInternalError("failed to compute volume stats", err)
+	}
+	return buckets, nil
+}
+
+// GetCommunicationMatrix 返回 发送方 -> 接收方 通信矩阵，以及按总量排序的前 top 名发送方/接收方
+func (s *StatsService) GetCommunicationMatrix(ctx context.Context, sessionID string, top int) (*ports.CommunicationStats, error) {
+	if err := s.requireSession(ctx, sessionID); err != nil {
+		return nil, err
+	}
+
+	cells, err := s.mails.GetCommunicationMatrix(ctx, sessionID)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to compute communication matrix", err)
+	}
+
+	sent := make(map[string]int64)
+	received := make(map[string]int64)
+	for _, cell := range cells {
+		sent[cell.SenderID] += cell.Mails + cell.Chats
+		received[cell.RecipientID] += cell.Mails + cell.Chats
+	}
+
+	return &ports.CommunicationStats{
+		Matrix:        cells,
+		TopSenders:    rankUsers(sent, top),
+		TopRecipients: rankUsers(received, top),
+	}, nil
+}
+
+func (s *StatsService) GetAttachmentStats(ctx context.Context, sessionID string) ([]ports.AttachmentStat, error) {
+	if err := s.requireSession(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	stats, err := s.mails.GetAttachmentStats(ctx, sessionID)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to compute attachment stats", err)
+	}
+	return stats, nil
+}
+
+func (s *StatsService) GetUnreadBacklog(ctx context.Context, sessionID string) ([]ports.BacklogEntry, error) {
+	if err := s.requireSession(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	backlog, err := s.mails.GetUnreadBacklog(ctx, sessionID)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to compute unread backlog", err)
+	}
+	return backlog, nil
+}
+
+// GetLatencyDistribution 返回回复耗时与阅读耗时分布，每个上界都会出现在结果中 (包括数量为 0 的桶)
+func (s *StatsService) GetLatencyDistribution(ctx context.Context, sessionID string, bounds []int) (*ports.LatencyDistribution, error) {
+	if err := s.requireSession(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBounds
+	}
+	for i, b := range bounds {
+		if b <= 0 || (i > 0 && b <= bounds[i-1]) {
+			return nil, ports.NewInvalidInputError("bounds must be positive and strictly ascending", nil)
+		}
+	}
+
+	reply, err := s.mails.GetReplyLatencyBuckets(ctx, sessionID, bounds)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to compute reply latency distribution", err)
+	}
+	read, err := s.mails.GetReadLatencyBuckets(ctx, sessionID, bounds)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to compute read latency distribution", err)
+	}
+	return &ports.LatencyDistribution{
+		Reply: fillLatencyBuckets(reply, bounds),
+		Read:  fillLatencyBuckets(read, bounds),
+	}, nil
+}
+
+// fillLatencyBuckets 按上界顺序排列分组结果，并补齐数量为 0 的桶
+func fillLatencyBuckets(rows []ports.LatencyBucket, bounds []int) []ports.LatencyBucket {
+	counts := make(map[int]ports.LatencyBucket, len(rows))
+	for _, row := range rows {
+		counts[row.LeSeconds] = row
+	}
+	buckets := make([]ports.LatencyBucket, 0, len(bounds)+1)
+	for _, le := range append(append([]int(nil), bounds...), -1) {
+		bucket := counts[le]
+		bucket.LeSeconds = le
+		buckets = append(buckets, bucket)
+	}
+	return buckets
+}
+
+func (s *StatsService) requireSession(ctx context.Context, sessionID string) error {
+	if _, err := s.sessions.GetByID(ctx, sessionID); err != nil {
+		return ports.NewNotFoundError("session not found", err)
+	}
+	return nil
+}
+
+func rankUsers(totals map[string]int64, top int) []ports.UserVolume {
+	ranked := make([]ports.UserVolume, 0, len(totals))
+	for userID, total := range totals {
+		ranked = append(ranked, ports.UserVolume{UserID: userID, Total: total})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Total != ranked[j].Total {
+			return ranked[i].Total > ranked[j].Total
+		}
+		return ranked[i].UserID < ranked[j].UserID
+	})
+	if top > 0 && len(ranked) > top {
+		ranked = ranked[:top]
+	}
+	return ranked
+}
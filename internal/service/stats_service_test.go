@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"raven/internal/core/ports"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsService_CommunicationMatrix(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewStatsService(mockRepo, newRunningSessions())
+
+	mockRepo.On("GetCommunicationMatrix", ctx, "s1").Return([]ports.MatrixCell{
+		{SenderID: "hq", RecipientID: "alpha", Mails: 3, Chats: 1},
+		{SenderID: "hq", RecipientID: "bravo", Mails: 2},
+		{SenderID: "alpha", RecipientID: "hq", Chats: 5},
+	}, nil)
+
+	stats, err := svc.GetCommunicationMatrix(ctx, "s1", 2)
+	assert.NoError(t, err)
+	assert.Len(t, stats.Matrix, 3)
+	assert.Equal(t, []ports.UserVolume{{UserID: "hq", Total: 6}, {UserID: "alpha", Total: 5}}, stats.TopSenders)
+	assert.Equal(t, []ports.UserVolume{{UserID: "hq", Total: 5}, {UserID: "alpha", Total: 4}}, stats.TopRecipients)
+}
+
+func TestStatsService_LatencyDistribution(t *testing.T) {
+	ctx := context.TODO()
+	mockRepo := new(MockMailRepository)
+	svc := NewStatsService(mockRepo, newRunningSessions())
+
+	t.Run("fills empty buckets", func(t *testing.T) {
+		bounds := []int{60, 300}
+		mockRepo.On("GetReplyLatencyBuckets", ctx, "s1", bounds).Return([]ports.LatencyBucket{
+			{LeSeconds: 60, Chats: 3},
+		}, nil).Once()
+		mockRepo.On("GetReadLatencyBuckets", ctx, "s1", bounds).Return([]ports.LatencyBucket{
+			{LeSeconds: 300, Mails: 2},
+			{LeSeconds: -1, Chats: 1},
+		}, nil).Once()
+
+		dist, err := svc.GetLatencyDistribution(ctx, "s1", bounds)
+		assert.NoError(t, err)
+		assert.Equal(t, []ports.LatencyBucket{
+			{LeSeconds: 60, Chats: 3},
+			{LeSeconds: 300},
+			{LeSeconds: -1},
+		}, dist.Reply)
+		assert.Equal(t, []ports.LatencyBucket{
+			{LeSeconds: 60},
+			{LeSeconds: 300, Mails: 2},
+			{LeSeconds: -1, Chats: 1},
+		}, dist.Read)
+	})
+
+	t.Run("rejects unordered bounds", func(t *testing.T) {
+		_, err := svc.GetLatencyDistribution(ctx, "s1", []int{300, 60})
+		assert.Equal(t, ports.ErrorTypeInvalidInput, err.(*ports.AppError).Type)
+	})
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// typingThrottle 同一会话方向上重复的“正在输入”在该间隔内只转发一次
+	typingThrottle = 3 * time.Second
+	// typingExpiry 超过该时间未再收到“正在输入”时自动推送停止输入
+	typingExpiry = 8 * time.Second
+)
+
+type typingKey struct {
+	sessionID, senderID, receiverID string
+}
+
+type typingState struct {
+	sentAt time.Time
+	expiry *time.Timer
+	gen    uint64 // 过期计时的代次，重新计时或状态重建后旧计时器到期不再生效
+}
+
+// typingTracker 对输入状态做节流与过期处理，只在状态变化或节流间隔到期时调用 emit
+type typingTracker struct {
+	throttle time.Duration
+	expiry   time.Duration
+	emit     func(sessionID, senderID, receiverID string, typing bool)
+
+	mu     sync.Mutex
+	states map[typingKey]*typingState
+	gen    uint64
+}
+
+func newTypingTracker(throttle, expiry time.Duration, emit func(sessionID, senderID, receiverID string, typing bool)) *typingTracker {
+	return &typingTracker{throttle: throttle, expiry: expiry, emit: emit, states: make(map[typingKey]*typingState)}
+}
+
+func (t *typingTracker) update(sessionID, senderID, receiverID string, typing bool) {
+	key := typingKey{sessionID, senderID, receiverID}
+	t.mu.Lock()
+	st, active := t.states[key]
+	if !typing {
+		if !active {
+			t.mu.Unlock()
+			return
+		}
+		st.expiry.Stop()
+		delete(t.states, key)
+		t.mu.Unlock()
+		t.emit(sessionID, senderID, receiverID, false)
+		return
+	}
+
+	now := time.Now()
+	if active {
+		st.expiry.Stop()
+	} else {
+		st = &typingState{}
+		t.states[key] = st
+	}
+	t.gen++
+	gen := t.gen
+	st.gen = gen
+	st.expiry = time.AfterFunc(t.expiry, func() { t.expire(key, gen) })
+	send := !active || now.Sub(st.sentAt) >= t.throttle
+	if send {
+		st.sentAt = now
+	}
+	t.mu.Unlock()
+
+	if send {
+		t.emit(sessionID, senderID, receiverID, true)
+	}
+}
+
+func (t *typingTracker) expire(key typingKey, gen uint64) {
+	t.mu.Lock()
+	st, ok := t.states[key]
+	if !ok || st.gen != gen {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.states, key)
+	t.mu.Unlock()
+	t.emit(key.sessionID, key.senderID, key.receiverID, false)
+}
+
+// clear 消息已发出，静默结束输入状态 (接收方收到 CHAT 事件即可清除提示)
+func (t *typingTracker) clear(sessionID, senderID, receiverID string) {
+	key := typingKey{sessionID, senderID, receiverID}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st, ok := t.states[key]; ok {
+		st.expiry.Stop()
+		delete(t.states, key)
+	}
+}
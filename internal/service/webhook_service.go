@@ -0,0 +1,397 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"gorm.io/gorm"
+)
+
+const (
+	webhookBatchSize   = 50
+	webhookMaxAttempts = 10
+	webhookBaseBackoff = 10 * time.Second
+	webhookMaxBackoff  = time.Hour
+	webhookTimeout     = 10 * time.Second
+	maxWebhookPageSize = 200
+	// webhookClaimLease 认领的租约，须长于一批投递的最长耗时 (webhookBatchSize × webhookTimeout)
+	webhookClaimLease = 15 * time.Minute
+	// webhookQueueSize 待写入投递队列的事件缓冲，写满时在调用方同步写入
+	webhookQueueSize = 1024
+)
+
+// webhookEvent 待生成投递记录的事件
+type webhookEvent struct {
+	event, sessionID string
+	payload          []byte
+}
+
+// WebhookService 管理 webhook 订阅，并将事件以 HMAC 签名的 HTTP POST 投递给订阅方。
+// Publish 只把事件放入内存缓冲，由 Run 在后台写入 webhook_deliveries 队列并投递，
+// 失败按指数退避重试，超过重试次数进入死信。多副本共用数据库时每条记录只由认领到的实例投递。
+type WebhookService struct {
+	repo     ports.WebhookRepository
+	sessions ports.SessionRepository
+	client   *http.Client
+	interval time.Duration
+	now      func() time.Time
+	wake     chan struct{}
+	events   chan webhookEvent
+}
+
+func NewWebhookService(repo ports.WebhookRepository, sessions ports.SessionRepository, interval time.Duration) *WebhookService {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &WebhookService{
+		repo:     repo,
+		sessions: sessions,
+		client:   &http.Client{Timeout: webhookTimeout},
+		interval: interval,
+		now:      time.Now,
+		wake:     make(chan struct{}, 1),
+		events:   make(chan webhookEvent, webhookQueueSize),
+	}
+}
+
+func (s *WebhookService) CreateWebhook(ctx context.Context, req ports.WebhookRequest) (*domain.Webhook, error) {
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ports.NewInvalidInputError("url must be an absolute http(s) URL", err)
+	}
+
+	events := make([]string, 0, len(req.Events))
+	for _, e := range req.Events {
+		e = strings.ToUpper(strings.TrimSpace(e))
+		if !isWebhookEvent(e) {
+			return nil, ports.NewInvalidInputError(fmt.Sprintf("unknown event %q", e), nil)
+		}
+		events = append(events, e)
+	}
+
+	if req.SessionID != "" {
+		if _, err := s.sessions.GetByID(ctx, req.SessionID); err != nil {
+			return nil, ports.NewNotFoundError("session not found", err)
+		}
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, ports.NewInternalError("failed to generate secret", err)
+		}
+		secret = hex.EncodeToString(buf)
+	}
+
+	hook := &domain.Webhook{SessionID: req.SessionID, URL: u.String(), Events: events, Secret: secret}
+	if err := s.repo.Create(ctx, hook); err != nil {
+		return nil, ports.NewInternalError("failed to create webhook", err)
+	}
+	return hook, nil
+}
+
+// ListWebhooks 列出订阅，不返回签名密钥
+func (s *WebhookService) ListWebhooks(ctx context.Context, sessionID string) ([]domain.Webhook, error) {
+	hooks, err := s.repo.List(ctx, sessionID)
+	if err != nil {
+		return nil, ports.NewInternalError("failed to list webhooks", err)
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return hooks, nil
+}
+
+func (s *WebhookService) DeleteWebhook(ctx context.Context, id string) error {
+	if _, err := s.repo.GetByID(ctx, id); err != nil {
+		return ports.NewNotFoundError("webhook not found", err)
+	}
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return ports.NewInternalError("failed to delete webhook", err)
+	}
+	return nil
+}
+
+func (s *WebhookService) ListDeliveries(ctx context.Context, filter ports.WebhookDeliveryFilter, page, pageSize int) ([]domain.WebhookDelivery, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 50
+	}
+	if pageSize > maxWebhookPageSize {
+		pageSize = maxWebhookPageSize
+	}
+	filter.Event = strings.ToUpper(filter.Event)
+
+	deliveries, total, err := s.repo.ListDeliveries(ctx, filter, page, pageSize)
+	if err != nil {
+		return nil, 0, ports.NewInternalError("failed to list deliveries", err)
+	}
+	return deliveries, total, nil
+}
+
+func (s *WebhookService) Redeliver(ctx context.Context, deliveryID string) (*domain.WebhookDelivery, error) {
+	delivery, err := s.repo.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ports.NewNotFoundError("delivery not found", err)
+		}
+		return nil, ports.NewInternalError("failed to load delivery", err)
+	}
+	if delivery.Status != domain.DeliveryStatusDead {
+		return nil, ports.NewInvalidInputError("only dead deliveries can be redelivered", nil)
+	}
+
+	now := s.now()
+	delivery.Status = domain.DeliveryStatusPending
+	delivery.Attempts = 0
+	delivery.NextAt = &now
+	if err := s.repo.UpdateDelivery(ctx, delivery); err != nil {
+		return nil, ports.NewInternalError("failed to requeue delivery", err)
+	}
+	s.notify()
+	return delivery, nil
+}
+
+// Publish 把事件放入缓冲，由 Run 在后台生成投递记录，不在请求路径上读写数据库。
+// 缓冲已满 (如 Run 未启动或数据库阻塞) 时同步写入，不丢弃事件。输入状态等瞬时事件不投递
+func (s *WebhookService) Publish(ctx context.Context, event, sessionID string, payload []byte) error {
+	if !isWebhookEvent(event) {
+		return nil
+	}
+	ev := webhookEvent{event: event, sessionID: sessionID, payload: payload}
+	select {
+	case s.events <- ev:
+		return nil
+	default:
+		return s.enqueue(ctx, ev)
+	}
+}
+
+// enqueue 为匹配的订阅各生成一条投递记录
+func (s *WebhookService) enqueue(ctx context.Context, ev webhookEvent) error {
+	event, sessionID, payload := ev.event, ev.sessionID, ev.payload
+	hooks, err := s.repo.ListForSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	var deliveries []domain.WebhookDelivery
+	for i := range hooks {
+		if !hooks[i].Matches(event) {
+			continue
+		}
+		deliveries = append(deliveries, domain.WebhookDelivery{
+			WebhookID: hooks[i].ID,
+			SessionID: sessionID,
+			Event:     event,
+			Payload:   payload,
+			Status:    domain.DeliveryStatusPending,
+			NextAt:    &now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := s.repo.CreateDeliveries(ctx, deliveries); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// notify 唤醒投递循环，避免新事件等待一个轮询周期
+func (s *WebhookService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run 在后台写入 Publish 缓冲的事件，并周期性投递到期的事件，直到 ctx 结束
+func (s *WebhookService) Run(ctx context.Context) {
+	go s.consume(ctx)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := s.ProcessDue(ctx)
+			if err != nil {
+				log.Printf("[Webhook] Processing failed: %v", err)
+			}
+			if n < webhookBatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// consume 把缓冲的事件写入投递队列；ctx 结束时写完已缓冲的事件再退出
+func (s *WebhookService) consume(ctx context.Context) {
+	for {
+		select {
+		case ev := <-s.events:
+			s.store(ev)
+		case <-ctx.Done():
+			for {
+				select {
+				case ev := <-s.events:
+					s.store(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *WebhookService) store(ev webhookEvent) {
+	if err := s.enqueue(context.Background(), ev); err != nil {
+		log.Printf("[Webhook] Failed to enqueue %s event: %v", ev.event, err)
+	}
+}
+
+// ProcessDue 认领并投递一批到期的事件，返回处理的条数
+func (s *WebhookService) ProcessDue(ctx context.Context) (int, error) {
+	now := s.now()
+	due, err := s.repo.ClaimDueDeliveries(ctx, now, now.Add(webhookClaimLease), webhookBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	hooks := make(map[string]*domain.Webhook)
+	for i := range due {
+		delivery := &due[i]
+		hook, ok := hooks[delivery.WebhookID]
+		if !ok {
+			hook, err = s.repo.GetByID(ctx, delivery.WebhookID)
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				// 查询失败 (如数据库暂不可用) 时释放本批剩余事件的认领，下一轮重试，不计入投递次数
+				s.release(ctx, due[i:], now)
+				return i, fmt.Errorf("load webhook %s: %w", delivery.WebhookID, err)
+			}
+			hooks[delivery.WebhookID] = hook
+		}
+
+		if hook == nil {
+			// 订阅已删除 (删除与投递并发)，直接进入死信
+			delivery.Status = domain.DeliveryStatusDead
+			delivery.NextAt = nil
+			delivery.LastError = "webhook no longer exists"
+		} else {
+			code, err := s.deliver(ctx, hook, delivery)
+			s.record(delivery, code, err)
+		}
+		if err := s.repo.UpdateDelivery(ctx, delivery); err != nil {
+			log.Printf("[Webhook] Failed to save state for delivery %s: %v", delivery.ID, err)
+		}
+	}
+	return len(due), nil
+}
+
+// release 放弃认领，记录恢复为 at 时到期
+func (s *WebhookService) release(ctx context.Context, deliveries []domain.WebhookDelivery, at time.Time) {
+	for i := range deliveries {
+		deliveries[i].NextAt = &at
+		if err := s.repo.UpdateDelivery(ctx, &deliveries[i]); err != nil {
+			log.Printf("[Webhook] Failed to release delivery %s: %v", deliveries[i].ID, err)
+		}
+	}
+}
+
+func (s *WebhookService) deliver(ctx context.Context, hook *domain.Webhook, delivery *domain.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Raven-Webhook/1.0")
+	req.Header.Set("X-Raven-Event", delivery.Event)
+	req.Header.Set("X-Raven-Delivery", delivery.ID)
+	req.Header.Set("X-Raven-Timestamp", timestamp)
+	req.Header.Set("X-Raven-Signature", "sha256="+signWebhook(hook.Secret, timestamp, delivery.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record 根据投递结果更新状态：成功标记 delivered；超过重试次数标记 dead；否则指数退避后重试
+func (s *WebhookService) record(delivery *domain.WebhookDelivery, code int, err error) {
+	now := s.now()
+	delivery.Attempts++
+	delivery.ResponseCode = code
+
+	if err == nil {
+		delivery.Status = domain.DeliveryStatusDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAt = nil
+		delivery.LastError = ""
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= webhookMaxAttempts {
+		log.Printf("[Webhook] Delivery %s dead after %d attempts: %v", delivery.ID, delivery.Attempts, err)
+		delivery.Status = domain.DeliveryStatusDead
+		delivery.NextAt = nil
+		return
+	}
+
+	next := now.Add(expBackoff(delivery.Attempts, webhookBaseBackoff, webhookMaxBackoff))
+	delivery.NextAt = &next
+}
+
+// signWebhook 计算签名 HMAC-SHA256(secret, timestamp + "." + body)，
+// 接收方应校验签名并拒绝时间戳过旧的请求以防重放
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range domain.WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"raven/internal/core/domain"
+	"raven/internal/core/ports"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestWebhookService_CreateWebhookValidates(t *testing.T) {
+	ctx := context.TODO()
+	repo := new(MockWebhookRepository)
+	svc := NewWebhookService(repo, newRunningSessions(), 0)
+
+	_, err := svc.CreateWebhook(ctx, ports.WebhookRequest{URL: "ftp://example.org"})
+	assert.Error(t, err)
+	_, err = svc.CreateWebhook(ctx, ports.WebhookRequest{URL: "https://example.org/hook", Events: []string{"BOGUS"}})
+	assert.Error(t, err)
+
+	repo.On("Create", ctx, mock.Anything).Return(nil)
+	hook, err := svc.CreateWebhook(ctx, ports.WebhookRequest{URL: "https://example.org/hook", Events: []string{"mail", " read "}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"MAIL", "READ"}, hook.Events)
+	assert.Len(t, hook.Secret, 48)
+}
+
+func TestWebhookService_PublishFiltersByEvent(t *testing.T) {
+	ctx := context.TODO()
+	repo := new(MockWebhookRepository)
+	svc := NewWebhookService(repo, newRunningSessions(), 0)
+
+	repo.On("ListForSession", mock.Anything, "s1").Return([]domain.Webhook{
+		{ID: "all"},
+		{ID: "chat-only", Events: []string{domain.WebhookEventChat}},
+		{ID: "mail-only", SessionID: "s1", Events: []string{domain.WebhookEventMail}},
+	}, nil)
+	repo.On("CreateDeliveries", mock.Anything, mock.MatchedBy(func(d []domain.WebhookDelivery) bool {
+		return len(d) == 2 && d[0].WebhookID == "all" && d[1].WebhookID == "mail-only" &&
+			d[1].Status == domain.DeliveryStatusPending && d[1].NextAt != nil && string(d[1].Payload) == `{"type":"MAIL"}`
+	})).Return(nil)
+
+	// Publish 只放入缓冲，投递记录由后台写入
+	assert.NoError(t, svc.Publish(ctx, domain.WebhookEventMail, "s1", []byte(`{"type":"MAIL"}`)))
+	assert.NoError(t, svc.Publish(ctx, "TYPING", "s1", []byte(`{"type":"TYPING"}`)))
+	repo.AssertNotCalled(t, "ListForSession", mock.Anything, mock.Anything)
+	done, cancel := context.WithCancel(ctx)
+	cancel()
+	svc.consume(done)
+	repo.AssertExpectations(t)
+
+	// 缓冲写满时同步写入
+	svc.events = make(chan webhookEvent)
+	assert.NoError(t, svc.Publish(ctx, domain.WebhookEventMail, "s1", []byte(`{"type":"MAIL"}`)))
+	repo.AssertNumberOfCalls(t, "CreateDeliveries", 2)
+}
+
+func TestWebhookService_ProcessDueSignsRequest(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Unix(1900000000, 0)
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	repo := new(MockWebhookRepository)
+	svc := NewWebhookService(repo, newRunningSessions(), 0)
+	svc.now = func() time.Time { return now }
+
+	payload := []byte(`{"type":"CHAT"}`)
+	repo.On("ClaimDueDeliveries", ctx, now, now.Add(webhookClaimLease), webhookBatchSize).Return([]domain.WebhookDelivery{
+		{ID: "d1", WebhookID: "h1", Event: domain.WebhookEventChat, Payload: payload, Status: domain.DeliveryStatusPending, NextAt: &now},
+	}, nil)
+	repo.On("GetByID", ctx, "h1").Return(&domain.Webhook{ID: "h1", URL: server.URL, Secret: "s3cret"}, nil)
+	repo.On("UpdateDelivery", ctx, mock.MatchedBy(func(d *domain.WebhookDelivery) bool {
+		return d.Status == domain.DeliveryStatusDelivered && d.Attempts == 1 && d.ResponseCode == 200 && d.NextAt == nil
+	})).Return(nil)
+
+	n, err := svc.ProcessDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, payload, body)
+	assert.Equal(t, "CHAT", got.Header.Get("X-Raven-Event"))
+	assert.Equal(t, "1900000000", got.Header.Get("X-Raven-Timestamp"))
+	assert.Equal(t, "sha256="+signWebhook("s3cret", "1900000000", payload), got.Header.Get("X-Raven-Signature"))
+	repo.AssertExpectations(t)
+}
+
+func TestWebhookService_ProcessDueWebhookLookup(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Unix(1900000000, 0)
+	due := func() []domain.WebhookDelivery {
+		return []domain.WebhookDelivery{
+			{ID: "d1", WebhookID: "h1", Status: domain.DeliveryStatusPending, NextAt: &now},
+			{ID: "d2", WebhookID: "h2", Status: domain.DeliveryStatusPending, NextAt: &now},
+		}
+	}
+
+	t.Run("Deleted webhook is dead-lettered", func(t *testing.T) {
+		repo := new(MockWebhookRepository)
+		svc := NewWebhookService(repo, newRunningSessions(), 0)
+		svc.now = func() time.Time { return now }
+		repo.On("ClaimDueDeliveries", ctx, now, now.Add(webhookClaimLease), webhookBatchSize).Return(due()[:1], nil)
+		repo.On("GetByID", ctx, "h1").Return(nil, gorm.ErrRecordNotFound)
+		repo.On("UpdateDelivery", ctx, mock.MatchedBy(func(d *domain.WebhookDelivery) bool {
+			return d.Status == domain.DeliveryStatusDead && d.NextAt == nil && d.Attempts == 0
+		})).Return(nil)
+
+		n, err := svc.ProcessDue(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("Lookup failure releases the claimed deliveries", func(t *testing.T) {
+		repo := new(MockWebhookRepository)
+		svc := NewWebhookService(repo, newRunningSessions(), 0)
+		svc.now = func() time.Time { return now }
+		repo.On("ClaimDueDeliveries", ctx, now, now.Add(webhookClaimLease), webhookBatchSize).Return(due(), nil)
+		repo.On("GetByID", ctx, "h1").Return(nil, errors.New("database is locked"))
+		repo.On("UpdateDelivery", ctx, mock.MatchedBy(func(d *domain.WebhookDelivery) bool {
+			return d.Status == domain.DeliveryStatusPending && d.Attempts == 0 && d.NextAt.Equal(now)
+		})).Return(nil).Twice()
+
+		n, err := svc.ProcessDue(ctx)
+		assert.Error(t, err)
+		assert.Equal(t, 0, n)
+		repo.AssertNotCalled(t, "GetByID", ctx, "h2")
+		repo.AssertExpectations(t)
+	})
+}
+
+func TestWebhookService_RecordBacksOffThenDeadLetters(t *testing.T) {
+	now := time.Date(2030, 5, 3, 6, 0, 0, 0, time.UTC)
+	svc := NewWebhookService(new(MockWebhookRepository), newRunningSessions(), 0)
+	svc.now = func() time.Time { return now }
+
+	d := &domain.WebhookDelivery{Status: domain.DeliveryStatusPending}
+	svc.record(d, 503, assert.AnError)
+	assert.Equal(t, domain.DeliveryStatusPending, d.Status)
+	assert.Equal(t, now.Add(webhookBaseBackoff), *d.NextAt)
+	assert.Equal(t, 503, d.ResponseCode)
+
+	d.Attempts = webhookMaxAttempts - 1
+	svc.record(d, 0, assert.AnError)
+	assert.Equal(t, domain.DeliveryStatusDead, d.Status)
+	assert.Nil(t, d.NextAt)
+}
+
+func TestWebhookService_RedeliverOnlyDead(t *testing.T) {
+	ctx := context.TODO()
+	repo := new(MockWebhookRepository)
+	svc := NewWebhookService(repo, newRunningSessions(), 0)
+
+	repo.On("GetDelivery", ctx, "ok").Return(&domain.WebhookDelivery{ID: "ok", Status: domain.DeliveryStatusDelivered}, nil)
+	_, err := svc.Redeliver(ctx, "ok")
+	assert.Error(t, err)
+
+	repo.On("GetDelivery", ctx, "dead").Return(&domain.WebhookDelivery{ID: "dead", Status: domain.DeliveryStatusDead, Attempts: webhookMaxAttempts}, nil)
+	repo.On("UpdateDelivery", ctx, mock.MatchedBy(func(d *domain.WebhookDelivery) bool {
+		return d.Status == domain.DeliveryStatusPending && d.Attempts == 0 && d.NextAt != nil
+	})).Return(nil)
+	d, err := svc.Redeliver(ctx, "dead")
+	assert.NoError(t, err)
+	assert.Equal(t, domain.DeliveryStatusPending, d.Status)
+}
@@ -30,10 +30,16 @@ export const getDownloadUrl = (att) => `${API_BASE_URL}/mails/download?id=${att.
 export const getPreviewUrl = (att) => `${API_BASE_URL}/mails/download?id=${att.id}&user_id=${getUserID()}&disposition=inline`;
 
 // Chat APIs
-export const sendChatMessage = (receiverId, content, files = []) => {
+// 对话键: 单聊为对方用户 ID，群聊与频道为 conv:<会话 ID>
+export const conversationKey = (conversationId) => `conv:${conversationId}`;
+const chatParam = (key, userParam) => key.startsWith('conv:') ? ['conversation_id', key.slice(5)] : [userParam, key];
+
+export const sendChatMessage = (chatKey, content, files = [], { replyToId, mentions } = {}) => {
     const formData = new FormData();
-    formData.append('receiver_id', receiverId);
+    formData.append(...chatParam(chatKey, 'receiver_id'));
     formData.append('content', content);
+    if (replyToId) formData.append('reply_to_id', replyToId);
+    if (mentions && mentions.length) formData.append('mentions', JSON.stringify(mentions));
     files.forEach(file => {
         formData.append('attachments', file.raw || file);
     });
@@ -41,6 +47,28 @@ export const sendChatMessage = (receiverId, content, files = []) => {
         headers: { 'Content-Type': 'multipart/form-data' }
     });
 };
-export const getChatHistory = (otherId) => api.get(`/im/history?user_id=${getUserID()}&other_id=${otherId}`);
-export const markChatAsRead = (senderId) => api.post(`/im/read?user_id=${getUserID()}&sender_id=${senderId}`);
+// 每页由新到旧排列，before / after 为消息 ID 游标
+export const getChatHistory = (chatKey, { before, after, limit } = {}) => {
+    const [name, value] = chatParam(chatKey, 'other_id');
+    return api.get(`/im/history?user_id=${getUserID()}&${name}=${encodeURIComponent(value)}`, { params: { before, after, limit } });
+};
+export const sendTyping = (receiverId, typing = true) => api.post(`/im/typing?user_id=${getUserID()}&receiver_id=${receiverId}&typing=${typing}`);
+export const markChatAsRead = (chatKey) => {
+    const [name, value] = chatParam(chatKey, 'sender_id');
+    return api.post(`/im/read?user_id=${getUserID()}&${name}=${encodeURIComponent(value)}`);
+};
+export const editChatMessage = (id, content, mentions = []) => api.put(`/im/messages/${id}?user_id=${getUserID()}`, { content, mentions });
+export const retractChatMessage = (id) => api.delete(`/im/messages/${id}?user_id=${getUserID()}`);
+export const searchChat = (q, { peerId, conversationId, from, to, limit } = {}) => api.get(`/im/search?user_id=${getUserID()}`, {
+    params: { q, peer_id: peerId, conversation_id: conversationId, from, to, limit }
+});
+// 将 fromId 至 toId 的聊天记录转为文电，chatKey 为对话键
+export const escalateChat = (chatKey, { fromId, toId, subject, note, to = [], cc = [] }) => {
+    const [name, value] = chatParam(chatKey, 'peer_id');
+    return api.post(`/im/escalate?user_id=${getUserID()}`, { [name]: value, from_id: fromId, to_id: toId, subject, note, to, cc });
+};
+export const getChatThreads = () => api.get(`/im/conversations?user_id=${getUserID()}`);
+export const getConversations = () => api.get(`/conversations?user_id=${getUserID()}`);
+export const createConversation = (type, name, members = []) => api.post(`/conversations?user_id=${getUserID()}`, { type, name, members });
 export const getUserSummary = () => api.get(`/user/summary?user_id=${getUserID()}`);
+export const getPresence = () => api.get(`/presence`);
@@ -6,6 +6,7 @@ export const userStore = reactive({
   sessionId: 'default',
   unreadCount: 0,
   eventSource: null,
+  lastEventId: '', // 最近收到的推送事件 ID，重连时用于补齐
   fetchUsers: null, // Global user directory helper
   qiankunActions: null, // Qiankun state actions
   config: {
@@ -14,17 +15,24 @@ export const userStore = reactive({
     primaryColor: '#409EFF' // 主题色
   },
   modules: ['mail', 'im'], // Enabled modules
-  chats: {}, // { userId: [messages] }
-  chatUnreads: {}, // { userId: count }
+  chats: {}, // { chatKey: [messages] }，chatKey 为对方用户 ID 或 conv:<会话 ID>
+  chatUnreads: {}, // { chatKey: count }
+  conversations: {}, // { conversationId: conversation } 群聊与频道
   totalIMUnread: 0,
+  presence: {}, // { userId: 'online' | 'idle' | 'offline' }
+  typing: {}, // { userId: true } 正在给我输入的用户
 
   setUser(id, name) {
+    const changed = this.id !== id
     this.id = id
     this.name = name
+    if (changed) this.reconnectNotifications()
   },
 
   setSession(sid) {
+    const changed = this.sessionId !== sid
     this.sessionId = sid
+    if (changed) this.reconnectNotifications()
   },
 
   setModules(modules) {
@@ -74,12 +82,40 @@ export const userStore = reactive({
       this.unreadCount = summary.unread_mail_count
     }
     if (summary.im_unread_counts) {
-      this.chatUnreads = summary.im_unread_counts
+      const unreads = { ...summary.im_unread_counts }
+      Object.entries(summary.conversation_unread_counts || {}).forEach(([id, count]) => {
+        unreads[`conv:${id}`] = count
+      })
+      this.chatUnreads = unreads
       this.recalculateIMUnread()
     }
     this.notifyHost()
   },
 
+  applyPresence(list) {
+    if (!Array.isArray(list)) return
+    const presence = {}
+    list.forEach(p => { presence[p.user_id] = p.status })
+    this.presence = presence
+  },
+
+  applyConversations(list) {
+    if (!Array.isArray(list)) return
+    const conversations = {}
+    list.filter(c => c.type !== 'direct').forEach(c => { conversations[c.id] = c })
+    this.conversations = conversations
+  },
+
+  // chatKeyOf 消息所属对话: 单聊为对方用户，群聊与频道为会话
+  chatKeyOf(msg) {
+    if (!msg.receiver_id) return `conv:${msg.conversation_id}`
+    return msg.sender_id === this.id ? msg.receiver_id : msg.sender_id
+  },
+
+  presenceOf(userId) {
+    return this.presence[userId] || 'offline'
+  },
+
   // SSE Notifications
   initNotifications() {
     if (this.eventSource) return;
@@ -87,9 +123,13 @@ export const userStore = reactive({
     console.log('[raven-mail] Initializing notifications...')
     const isDev = import.meta.env.DEV
     const backendUrl = import.meta.env.VITE_BACKEND_URL || (isDev ? 'http://localhost:8080' : '')
-    this.eventSource = new EventSource(`${backendUrl}/api/v1/mails/events`)
+    // 服务端按场次与用户过滤事件
+    const query = new URLSearchParams({ user_id: this.id, session_id: this.sessionId })
+    if (this.lastEventId) query.set('last_event_id', this.lastEventId)
+    this.eventSource = new EventSource(`${backendUrl}/api/v1/mails/events?${query}`)
     
     this.eventSource.onmessage = (event) => {
+      if (event.lastEventId) this.lastEventId = event.lastEventId
       try {
         const payload = JSON.parse(event.data)
         console.log('[raven-mail] Notification received:', payload)
@@ -107,18 +147,52 @@ export const userStore = reactive({
           window.dispatchEvent(new CustomEvent('raven-mail-updated', { detail: payload.data }))
         } else if (payload.type === 'CHAT') {
           const msg = payload.data
-          const chatPartner = msg.sender_id === this.id ? msg.receiver_id : msg.sender_id
-          if (!this.chats[chatPartner]) this.chats[chatPartner] = []
-          this.chats[chatPartner].push(msg)
+          const chatKey = this.chatKeyOf(msg)
+          this.typing[msg.sender_id] = false
+          if (!this.chats[chatKey]) this.chats[chatKey] = []
+          // 频道消息推送给全场次，自己发出的消息已在发送时加入
+          if (this.chats[chatKey].some(m => m.id === msg.id)) return
+          this.chats[chatKey].push(msg)
           
           // 如果消息是别人发给我的，且不在当前对话中（由 UI 层决定是否标记），累加未读
           if (msg.sender_id !== this.id) {
-            this.chatUnreads[msg.sender_id] = (this.chatUnreads[msg.sender_id] || 0) + 1
+            this.chatUnreads[chatKey] = (this.chatUnreads[chatKey] || 0) + 1
             this.recalculateIMUnread()
           }
 
           // 触发 IM 事件
           window.dispatchEvent(new CustomEvent('raven-im-received', { detail: msg }))
+        } else if (payload.type === 'CHAT_EDITED' || payload.type === 'CHAT_RETRACTED') {
+          // 编辑或撤回后的消息整体替换
+          const msg = payload.data
+          const list = this.chats[this.chatKeyOf(msg)] || []
+          const idx = list.findIndex(m => m.id === msg.id)
+          if (idx !== -1) list[idx] = { ...list[idx], ...msg }
+        } else if (payload.type === 'MENTION') {
+          // 被提及时单独提醒，消息本身仍通过 CHAT 事件送达
+          window.dispatchEvent(new CustomEvent('raven-im-mention', { detail: payload.data }))
+        } else if (payload.type === 'TYPING') {
+          this.typing[payload.data.sender_id] = payload.data.typing
+        } else if (payload.type === 'CHAT_READ') {
+          // 对方已阅读我发出的消息。群聊与频道只标记为已有人阅读
+          const { reader_id: readerId, conversation_id: convId, message_ids: ids, read_at: readAt } = payload.data
+          ;(this.chats[convId ? `conv:${convId}` : readerId] || []).forEach(m => {
+            if (ids.includes(m.id)) {
+              m.is_read = true
+              m.read_at = readAt
+            }
+          })
+        } else if (payload.type === 'CONVERSATION') {
+          const conv = payload.data.conversation
+          const isMember = conv.type === 'channel' || conv.members.some(m => m.user_id === this.id)
+          if (conv.type === 'direct') return
+          if (isMember) {
+            this.conversations[conv.id] = conv
+          } else {
+            delete this.conversations[conv.id]
+          }
+        } else if (payload.type === 'PRESENCE') {
+          this.presence[payload.data.user_id] = payload.data.status
         }
       } catch (e) {
         // 兼容旧版或心跳及原始字符串
@@ -146,6 +220,17 @@ export const userStore = reactive({
     }
   },
 
+  // 身份变化后以新的场次/用户重新订阅
+  reconnectNotifications() {
+    if (!this.eventSource) return
+    this.eventSource.close()
+    this.eventSource = null
+    this.lastEventId = ''
+    this.presence = {}
+    this.conversations = {}
+    this.initNotifications()
+  },
+
   notifyHost() {
     console.log(`[raven-mail] Notifying host, unreadCount: ${this.unreadCount}`)
     